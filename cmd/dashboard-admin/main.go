@@ -5,13 +5,19 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dapperAuteur/dashboard-go-api/environment"
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/conf"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
@@ -35,6 +41,7 @@ func run() error {
 		DB struct {
 			// AtlasURI string `conf:"default:"`
 			AtlasURI string `conf:"default:"`
+			Name     string `conf:"default:palabras-express-api"`
 		}
 		Args conf.Args
 	}
@@ -59,22 +66,34 @@ func run() error {
 		AtlasURI: environment.MongoDBURI,
 	}
 
-	var err error
+	// print config values when app starts
+	out, err := conf.String(&cfg)
+	if err != nil {
+		return errors.Wrap(err, "generating config for output")
+	}
+	log.Printf("main : Config :\n%v\n", out)
+
 	switch cfg.Args.Num(0) {
 	case "useradd":
 		err = useradd(dbConfig, cfg.Args.Num(1), cfg.Args.Num(2))
 	case "keygen":
 		err = keygen(cfg.Args.Num(1))
+	case "migrate-occurrence":
+		err = migrateOccurrence(dbConfig, cfg.DB.Name, cfg.Args.Num(1))
+	case "migrate-money":
+		err = migrateMoney(dbConfig, cfg.DB.Name, cfg.Args.Num(1))
+	case "migrate-participants":
+		err = migrateParticipants(dbConfig, cfg.DB.Name)
+	case "import-rates":
+		err = importRates(dbConfig, cfg.DB.Name, cfg.Args.Num(1))
+	case "import":
+		err = importStatement(dbConfig, cfg.DB.Name, cfg.Args.Num(1), cfg.Args.Num(2))
 	default:
-		err = errors.New("Must specify a command from the list: 'adduser', 'keygen'")
+		err = errors.New("Must specify a command from the list: 'adduser', 'keygen', 'migrate-occurrence', 'migrate-money', 'migrate-participants', 'import-rates', 'import'")
 	}
-
-	// print config values when app starts
-	out, err := conf.String(&cfg)
 	if err != nil {
-		return errors.Wrap(err, "generating config for output")
+		return err
 	}
-	log.Printf("main : Config :\n%v\n", out)
 
 	// ==
 	// Start Database
@@ -91,7 +110,8 @@ func run() error {
 }
 
 func useradd(cfg database.Config, email, password string) error {
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
 	client, err := database.Open(cfg)
 	if err != nil {
@@ -138,6 +158,188 @@ func useradd(cfg database.Config, email, password string) error {
 	return nil
 }
 
+// migrateOccurrence fills in the occurrence date of transactions that only have an occurrence_string.
+// Values like "3/1" carry no year so the year to place them in must be provided.
+func migrateOccurrence(cfg database.Config, dbName, year string) error {
+
+	if year == "" {
+		return errors.New("migrate-occurrence command must be called with an additional argument for the default year")
+	}
+
+	defaultYear, err := strconv.Atoi(year)
+	if err != nil {
+		return errors.Wrapf(err, "parsing default year %q", year)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	client, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(ctx)
+
+	transactionsCollection := client.Database(dbName).Collection("transactions")
+
+	result, err := budget.MigrateOccurrences(ctx, transactionsCollection, defaultYear)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Transactions migrated:", result.Migrated)
+	if len(result.Failed) > 0 {
+		fmt.Println("Transactions with an occurrence_string that could NOT be parsed:", result.Failed)
+	}
+
+	return nil
+}
+
+// migrateMoney converts amounts stored as plain numbers into exact decimal amounts.
+// The optional scale is the number of decimal places the stored numbers carry, e.g. 2 when cents were stored.
+func migrateMoney(cfg database.Config, dbName, scale string) error {
+
+	decimalPlaces := 0
+	if scale != "" {
+		var err error
+		if decimalPlaces, err = strconv.Atoi(scale); err != nil {
+			return errors.Wrapf(err, "parsing scale %q", scale)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	client, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(ctx)
+
+	result, err := budget.MigrateMoney(ctx, client.Database(dbName), decimalPlaces)
+	if err != nil {
+		return err
+	}
+
+	for field, migrated := range result.Migrated {
+		fmt.Printf("%s migrated: %d\n", field, migrated)
+	}
+
+	return nil
+}
+
+// migrateParticipants creates a participant for every user that transactions name as a participant.
+func migrateParticipants(cfg database.Config, dbName string) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	client, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(ctx)
+
+	result, err := budget.MigrateParticipants(ctx, client.Database(dbName), time.Now())
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Participants created:", result.Created)
+	if len(result.Unknown) > 0 {
+		fmt.Println("participant_id values that are NOT users:", result.Unknown)
+	}
+
+	return nil
+}
+
+// importRates stores the exchange rates of a CSV file with the header "base,quote,date,rate".
+func importRates(cfg database.Config, dbName, path string) error {
+
+	if path == "" {
+		return errors.New("import-rates command must be called with an additional argument for the file path")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "opening exchange rate file %q", path)
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	client, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(ctx)
+
+	result, err := budget.ImportExchangeRates(ctx, client.Database(dbName), file, time.Now())
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Exchange rates imported:", result.Imported)
+	for _, failed := range result.Failed {
+		fmt.Printf("line %d: %s\n", failed.Line, failed.Error)
+	}
+
+	return nil
+}
+
+// importStatement creates transactions from a CSV, OFX or QFX bank statement.
+// The format is taken from the file extension. The optional profile is a JSON file describing the columns of a CSV statement.
+func importStatement(cfg database.Config, dbName, path, profilePath string) error {
+
+	if path == "" {
+		return errors.New("import command must be called with an additional argument for the statement path")
+	}
+
+	var profile budget.ImportProfile
+	if profilePath != "" {
+		data, err := ioutil.ReadFile(profilePath)
+		if err != nil {
+			return errors.Wrapf(err, "reading import profile %q", profilePath)
+		}
+		if err := json.Unmarshal(data, &profile); err != nil {
+			return errors.Wrapf(err, "decoding import profile %q", profilePath)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "opening statement %q", path)
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	client, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(ctx)
+
+	claims := auth.NewClaims("dashboard-admin", []string{auth.RoleAdmin}, time.Now(), time.Hour)
+	format := strings.TrimPrefix(filepath.Ext(path), ".")
+
+	report, err := budget.ImportTransactions(ctx, client.Database(dbName), claims, format, file, profile, time.Now())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Transactions created: %d, skipped: %d, failed: %d\n", report.Created, report.Skipped, report.Failed)
+	for _, row := range report.Rows {
+		if row.Status != budget.ImportCreated {
+			fmt.Printf("line %d: %s %s\n", row.Line, row.Status, row.Reason)
+		}
+	}
+
+	return nil
+}
+
 // keygen creates an x509 private key for signing auth tokens.
 func keygen(path string) error {
 
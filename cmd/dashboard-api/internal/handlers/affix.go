@@ -29,11 +29,18 @@ func (a Affix) AffixList(ctx context.Context, w http.ResponseWriter, r *http.Req
 	ctx, span := trace.StartSpan(ctx, "handlers.Affix.AffixList")
 	defer span.End()
 
-	affixList, err := word.AffixList(ctx, a.DB)
+	page, err := parsePage(r)
 	if err != nil {
 		return err
 	}
 
+	affixList, info, err := word.AffixList(ctx, a.DB, page)
+	if err != nil {
+		return pageError(err)
+	}
+
+	setPageHeaders(w, r, info)
+
 	return web.Respond(ctx, w, affixList, http.StatusOK)
 }
 
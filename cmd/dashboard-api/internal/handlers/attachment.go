@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/blob"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/web"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opencensus.io/trace"
+)
+
+// Attachment defines all of the handlers related to the attachments of transactions.
+// It holds the application state needed by the handler methods.
+type Attachment struct {
+	DB      *mongo.Collection
+	Files   blob.Store
+	MaxSize int64
+	Log     *log.Logger
+}
+
+// ListAttachments gets a page of the attachments of the transaction identified by an _id in the request URL.
+func (a Attachment) ListAttachments(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Attachment.ListAttachments")
+	defer span.End()
+
+	tranxID := chi.URLParam(r, "_id")
+
+	page, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+
+	list, info, err := budget.ListAttachments(ctx, a.DB.Database(), tranxID, page)
+	if err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(pageError(err), "listing attachments of transaction %q", tranxID)
+		}
+	}
+
+	setPageHeaders(w, r, info)
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// AddAttachment stores the "file" part of a multipart/form-data request with the transaction identified by an _id in the request URL.
+// The file is streamed into the blob store, so it is never held in memory. Files larger than MaxSize are rejected.
+func (a Attachment) AddAttachment(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claims missing from context")
+	}
+
+	tranxID := chi.URLParam(r, "_id")
+
+	// Leave room for the multipart boundaries and headers around the file.
+	r.Body = http.MaxBytesReader(w, r.Body, a.MaxSize+1<<20)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return web.NewRequestError(errors.Wrap(err, "reading multipart/form-data upload"), http.StatusBadRequest)
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return web.NewRequestError(errors.New(`upload has no "file" part`), http.StatusBadRequest)
+		}
+		if err != nil {
+			return web.NewRequestError(errors.Wrap(err, "reading uploaded file"), http.StatusBadRequest)
+		}
+
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+		defer part.Close()
+
+		upload := budget.NewAttachment{
+			FileName:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+			Content:     part,
+		}
+
+		attachment, err := budget.AddAttachment(ctx, a.DB.Database(), a.Files, claims, tranxID, upload, a.MaxSize, time.Now())
+		if err != nil {
+			if verr, ok := err.(*apierror.ValidationError); ok {
+				return validationError(verr)
+			}
+			switch err {
+			case budget.ErrAttachmentTooLarge:
+				return web.NewRequestError(errors.Errorf("attachment is larger than %d bytes", a.MaxSize), http.StatusRequestEntityTooLarge)
+			case apierror.ErrNotFound:
+				return web.NewRequestError(err, http.StatusNotFound)
+			case apierror.ErrInvalidID:
+				return web.NewRequestError(err, http.StatusBadRequest)
+			case apierror.ErrForbidden:
+				return web.NewRequestError(err, http.StatusForbidden)
+			default:
+				return errors.Wrapf(err, "adding attachment to transaction %q", tranxID)
+			}
+		}
+
+		return web.Respond(ctx, w, attachment, http.StatusCreated)
+	}
+}
+
+// DownloadAttachment sends the content of the attachment identified by an attachmentID in the request URL.
+func (a Attachment) DownloadAttachment(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Attachment.DownloadAttachment")
+	defer span.End()
+
+	tranxID := chi.URLParam(r, "_id")
+	attachmentID := chi.URLParam(r, "attachmentID")
+
+	attachment, content, err := budget.OpenAttachment(ctx, a.DB.Database(), a.Files, tranxID, attachmentID)
+	if err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "looking for attachment %q", attachmentID)
+		}
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName}))
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if err := web.RespondStream(ctx, w, attachment.ContentType, http.StatusOK); err != nil {
+		return err
+	}
+
+	if n, err := io.Copy(w, content); err != nil {
+		a.Log.Printf("ERROR : downloading attachment %s, stopped after %d bytes : %+v", attachmentID, n, err)
+	}
+
+	return nil
+}
+
+// DeleteAttachment removes the attachment identified by an attachmentID in the request URL, along with its content.
+func (a Attachment) DeleteAttachment(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	tranxID := chi.URLParam(r, "_id")
+	attachmentID := chi.URLParam(r, "attachmentID")
+
+	if err := budget.DeleteAttachment(ctx, a.DB.Database(), a.Files, claims, tranxID, attachmentID); err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "deleting attachment %q", attachmentID)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
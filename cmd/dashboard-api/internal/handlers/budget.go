@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
@@ -29,14 +30,133 @@ func (b Budget) List(ctx context.Context, w http.ResponseWriter, r *http.Request
 	ctx, span := trace.StartSpan(ctx, "handlers.Budget.List")
 	defer span.End()
 
-	list, err := budget.List(ctx, b.DB)
+	page, err := parsePage(r)
 	if err != nil {
 		return err
 	}
 
+	list, info, err := budget.List(ctx, b.DB, page)
+	if err != nil {
+		return pageError(err)
+	}
+
+	setPageHeaders(w, r, info)
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// SummaryList compares the planned value of a page of budgets with their transactions.
+// The optional from and to query parameters limit the transactions to a date range.
+// The optional currency_id query parameter converts every amount into that currency.
+func (b Budget) SummaryList(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Budget.SummaryList")
+	defer span.End()
+
+	window, err := decodeSummaryWindow(r.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+
+	list, info, err := budget.SummarizeList(ctx, b.DB.Database(), window, r.URL.Query().Get("currency_id"), page, time.Now())
+	if err != nil {
+		return conversionError(pageError(err))
+	}
+
+	setPageHeaders(w, r, info)
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Summary compares the planned value of the Budget identified by an _id in the request URL with its transactions.
+// The optional from and to query parameters limit the transactions to a date range.
+// The optional currency_id query parameter converts every amount into that currency.
+func (b Budget) Summary(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Budget.Summary")
+	defer span.End()
+
+	_id := chi.URLParam(r, "_id")
+
+	window, err := decodeSummaryWindow(r.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	summary, err := budget.Summarize(ctx, b.DB.Database(), _id, window, r.URL.Query().Get("currency_id"), time.Now())
+	if err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(conversionError(err), "summarizing budget %q", _id)
+		}
+	}
+
+	return web.Respond(ctx, w, summary, http.StatusOK)
+}
+
+// Alerts gets a page of the alerts raised for the Budget identified by an _id in the request URL.
+func (b Budget) Alerts(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Budget.Alerts")
+	defer span.End()
+
+	_id := chi.URLParam(r, "_id")
+
+	page, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+
+	list, info, err := budget.ListBudgetAlerts(ctx, b.DB.Database(), _id, page)
+	if err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(pageError(err), "listing alerts of budget %q", _id)
+		}
+	}
+
+	setPageHeaders(w, r, info)
+
 	return web.Respond(ctx, w, list, http.StatusOK)
 }
 
+// decodeSummaryWindow reads the from and to dates of a summary from a query string.
+// A YYYY-MM-DD to date includes the whole day: the window ends on its last nanosecond.
+func decodeSummaryWindow(q url.Values) (budget.SummaryWindow, error) {
+
+	var fields []web.FieldError
+
+	window := budget.SummaryWindow{
+		From: queryDate(q, "from", &fields),
+	}
+
+	to, before := queryDateTo(q, "to", &fields)
+	if before != nil {
+		last := before.Add(-time.Nanosecond)
+		to = &last
+	}
+	window.To = to
+
+	if len(fields) > 0 {
+		return window, queryError(fields)
+	}
+
+	return window, nil
+}
+
 // Retrieve get the Budget from the db identified by an _id in the request URL, then encodes it in a response client.
 func (b Budget) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 
@@ -93,11 +213,14 @@ func (b Budget) Create(ctx context.Context, w http.ResponseWriter, r *http.Reque
 
 	budgetCreated, err := budget.Create(ctx, b.DB, claims, newBudget, time.Now())
 	if err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
 		switch err {
 		case apierror.ErrForbidden:
 			return web.NewRequestError(err, http.StatusForbidden)
 		default:
-			return errors.Wrapf(err, "creating budget %q", newBudget)
+			return errors.Wrapf(err, "creating budget %+v", newBudget)
 		}
 	}
 
@@ -121,6 +244,9 @@ func (b *Budget) UpdateOne(ctx context.Context, w http.ResponseWriter, r *http.R
 	}
 
 	if err := budget.UpdateOne(ctx, b.DB, claims, budgetID, budgetUpdate, time.Now()); err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
 		switch err {
 		case apierror.ErrNotFound:
 			return web.NewRequestError(err, http.StatusNotFound)
@@ -145,7 +271,18 @@ func (b *Budget) Delete(ctx context.Context, w http.ResponseWriter, r *http.Requ
 
 	budgetID := chi.URLParam(r, "_id")
 
-	if err := budget.Delete(ctx, b.DB, claims, budgetID); err != nil {
+	rule, err := parseDeleteRule(r)
+	if err != nil {
+		return err
+	}
+
+	if err := budget.Delete(ctx, b.DB.Database(), claims, budgetID, rule, time.Now()); err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		if cerr, ok := err.(*apierror.ConflictError); ok {
+			return conflictError(cerr)
+		}
 		switch err {
 		case apierror.ErrNotFound:
 			return web.NewRequestError(err, http.StatusNotFound)
@@ -153,6 +290,8 @@ func (b *Budget) Delete(ctx context.Context, w http.ResponseWriter, r *http.Requ
 			return web.NewRequestError(err, http.StatusBadRequest)
 		case apierror.ErrForbidden:
 			return web.NewRequestError(err, http.StatusForbidden)
+		case apierror.ErrLocked:
+			return web.NewRequestError(err, http.StatusConflict)
 		default:
 			return errors.Wrapf(err, "deleting budget %q", budgetID)
 		}
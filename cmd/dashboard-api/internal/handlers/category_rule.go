@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/web"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opencensus.io/trace"
+)
+
+// CategoryRule defines all of the handlers related to the rules that categorize transactions.
+// It holds the application state needed by the handler methods.
+type CategoryRule struct {
+	DB  *mongo.Collection
+	Log *log.Logger
+}
+
+// ListCategoryRules gets a page of category rules from the service layer.
+func (x CategoryRule) ListCategoryRules(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.CategoryRule.ListCategoryRules")
+	defer span.End()
+
+	page, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+
+	list, info, err := budget.ListCategoryRules(ctx, x.DB, page)
+	if err != nil {
+		return pageError(err)
+	}
+
+	setPageHeaders(w, r, info)
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// RetrieveCategoryRule gets the category rule identified by an _id in the request URL.
+func (x CategoryRule) RetrieveCategoryRule(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	_id := chi.URLParam(r, "_id")
+
+	rule, err := budget.RetrieveCategoryRule(ctx, x.DB, _id)
+	if err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "looking for category rule %q", _id)
+		}
+	}
+
+	return web.Respond(ctx, w, rule, http.StatusOK)
+}
+
+// CreateCategoryRule decodes the body of a request to create a new category rule.
+// The stored rule is sent back in the response.
+func (x CategoryRule) CreateCategoryRule(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claims missing from context")
+	}
+
+	var newRule budget.NewCategoryRule
+	if err := web.Decode(r, &newRule); err != nil {
+		return err
+	}
+
+	rule, err := budget.CreateCategoryRule(ctx, x.DB.Database(), claims, newRule, time.Now())
+	if err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		switch err {
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "creating category rule %+v", newRule)
+		}
+	}
+
+	return web.Respond(ctx, w, rule, http.StatusCreated)
+}
+
+// UpdateOneCategoryRule decodes the body of a request to update an existing category rule.
+// The _id of the rule is part of the request URL.
+func (x CategoryRule) UpdateOneCategoryRule(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	ruleID := chi.URLParam(r, "_id")
+
+	var ruleUpdate budget.UpdateCategoryRule
+	if err := web.Decode(r, &ruleUpdate); err != nil {
+		return errors.Wrap(err, "decoding category rule update")
+	}
+
+	if err := budget.UpdateOneCategoryRule(ctx, x.DB.Database(), claims, ruleID, ruleUpdate, time.Now()); err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "updating category rule %q", ruleID)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusOK)
+}
+
+// DeleteCategoryRule removes the category rule identified by an _id in the request URL.
+func (x CategoryRule) DeleteCategoryRule(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	ruleID := chi.URLParam(r, "_id")
+
+	if err := budget.DeleteCategoryRule(ctx, x.DB, claims, ruleID); err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "deleting category rule %q", ruleID)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// ReapplyCategoryRules applies the category rules to the existing transactions that fit the criteria of the query string.
+// It is a dry run unless ?dry_run=false is given. ?overwrite=true replaces budgets that are already set.
+func (x CategoryRule) ReapplyCategoryRules(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.CategoryRule.ReapplyCategoryRules")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claims missing from context")
+	}
+
+	q := r.URL.Query()
+
+	filterTranx, err := decodeTransactionFilter(q)
+	if err != nil {
+		return err
+	}
+
+	opts := budget.ReapplyOptions{DryRun: true}
+	var fields []web.FieldError
+
+	if v := q.Get("dry_run"); v != "" {
+		dryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			fields = append(fields, web.FieldError{Field: "dry_run", Error: "must be true or false"})
+		}
+		opts.DryRun = dryRun
+	}
+
+	if v := q.Get("overwrite"); v != "" {
+		overwrite, err := strconv.ParseBool(v)
+		if err != nil {
+			fields = append(fields, web.FieldError{Field: "overwrite", Error: "must be true or false"})
+		}
+		opts.Overwrite = overwrite
+	}
+
+	if len(fields) > 0 {
+		return queryError(fields)
+	}
+
+	report, err := budget.ReapplyCategoryRules(ctx, x.DB.Database(), claims, filterTranx, opts, time.Now())
+	if err != nil {
+		switch err {
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "applying category rules to transactions %+v", filterTranx)
+		}
+	}
+
+	return web.Respond(ctx, w, report, http.StatusOK)
+}
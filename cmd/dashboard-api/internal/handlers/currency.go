@@ -29,11 +29,18 @@ func (c Currency) CurrencyList(ctx context.Context, w http.ResponseWriter, r *ht
 	ctx, span := trace.StartSpan(ctx, "handlers.Currency.CurrencyList")
 	defer span.End()
 
-	currencyList, err := budget.CurrencyList(ctx, c.DB)
+	page, err := parsePage(r)
 	if err != nil {
 		return err
 	}
 
+	currencyList, info, err := budget.CurrencyList(ctx, c.DB, page)
+	if err != nil {
+		return pageError(err)
+	}
+
+	setPageHeaders(w, r, info)
+
 	return web.Respond(ctx, w, currencyList, http.StatusOK)
 }
 
@@ -123,7 +130,18 @@ func (c *Currency) DeleteCurrencyByID(ctx context.Context, w http.ResponseWriter
 
 	currencyID := chi.URLParam(r, "_id")
 
-	if err := budget.DeleteCurrencyByID(ctx, c.DB, claims, currencyID); err != nil {
+	rule, err := parseDeleteRule(r)
+	if err != nil {
+		return err
+	}
+
+	if err := budget.DeleteCurrencyByID(ctx, c.DB.Database(), claims, currencyID, rule, time.Now()); err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		if cerr, ok := err.(*apierror.ConflictError); ok {
+			return conflictError(cerr)
+		}
 		switch err {
 		case apierror.ErrNotFound:
 			return web.NewRequestError(err, http.StatusNotFound)
@@ -131,6 +149,8 @@ func (c *Currency) DeleteCurrencyByID(ctx context.Context, w http.ResponseWriter
 			return web.NewRequestError(err, http.StatusBadRequest)
 		case apierror.ErrForbidden:
 			return web.NewRequestError(err, http.StatusForbidden)
+		case apierror.ErrLocked:
+			return web.NewRequestError(err, http.StatusConflict)
 		default:
 			return errors.Wrapf(err, "deleting currency %q", currencyID)
 		}
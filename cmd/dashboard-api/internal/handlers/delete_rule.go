@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/web"
+)
+
+// parseDeleteRule reads what happens to the transactions of a document being deleted from the request URL.
+// ?cascade=true deletes them and ?reassign_to=<_id> moves them to another document of the same kind.
+func parseDeleteRule(r *http.Request) (budget.DeleteRule, error) {
+
+	q := r.URL.Query()
+
+	rule := budget.DeleteRule{ReassignTo: q.Get("reassign_to")}
+
+	if v := q.Get("cascade"); v != "" {
+		cascade, err := strconv.ParseBool(v)
+		if err != nil {
+			return rule, queryError([]web.FieldError{{Field: "cascade", Error: "must be true or false"}})
+		}
+		rule.Cascade = cascade
+	}
+
+	return rule, nil
+}
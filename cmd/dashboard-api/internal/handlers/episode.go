@@ -25,11 +25,18 @@ type Episode struct {
 // Then encodes them in a response client.
 func (e Episode) EpisodeList(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 
-	episodeList, err := podcast.EpisodeList(r.Context(), e.DB)
+	page, err := parsePage(r)
 	if err != nil {
 		return err
 	}
 
+	episodeList, info, err := podcast.EpisodeList(r.Context(), e.DB, page)
+	if err != nil {
+		return pageError(err)
+	}
+
+	setPageHeaders(w, r, info)
+
 	return web.Respond(ctx, w, episodeList, http.StatusOK)
 }
 
@@ -39,11 +46,18 @@ func (e Episode) PodcastEpisodeList(ctx context.Context, w http.ResponseWriter,
 
 	podcastID := chi.URLParam(r, "_id")
 
-	episodeList, err := podcast.PodcastEpisodeList(ctx, e.DB, podcastID)
+	page, err := parsePage(r)
 	if err != nil {
 		return err
 	}
 
+	episodeList, info, err := podcast.PodcastEpisodeList(ctx, e.DB, podcastID, page)
+	if err != nil {
+		return pageError(err)
+	}
+
+	setPageHeaders(w, r, info)
+
 	return web.Respond(ctx, w, episodeList, http.StatusOK)
 }
 
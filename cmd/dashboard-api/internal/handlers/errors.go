@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// validationError converts the business rule failures found by the service layer
+// into the same response clients get for malformed requests.
+func validationError(verr *apierror.ValidationError) error {
+
+	fields := make([]web.FieldError, len(verr.Fields))
+	for i, f := range verr.Fields {
+		fields[i] = web.FieldError{Field: f.Field, Error: f.Error}
+	}
+
+	return &web.Error{
+		Err:    verr,
+		Status: http.StatusBadRequest,
+		Fields: fields,
+	}
+}
+
+// conflictError reports a document that can NOT be removed, with the documents that still refer to it.
+func conflictError(cerr *apierror.ConflictError) error {
+	return &web.Error{
+		Err:     cerr,
+		Status:  http.StatusConflict,
+		Details: cerr,
+	}
+}
+
+// queryError builds the response for query parameters that failed validation.
+func queryError(fields []web.FieldError) error {
+	return &web.Error{
+		Err:    errors.New("field validation error"),
+		Status: http.StatusBadRequest,
+		Fields: fields,
+	}
+}
+
+// conversionError reports an amount that could NOT be converted into the requested currency,
+// and amounts in more than one currency when no currency was requested. Other errors are returned as they are.
+func conversionError(err error) error {
+
+	switch errors.Cause(err) {
+	case budget.ErrNoExchangeRate:
+		return web.NewRequestError(err, http.StatusUnprocessableEntity)
+	case budget.ErrMixedCurrencies:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	return err
+}
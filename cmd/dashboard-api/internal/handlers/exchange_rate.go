@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/web"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opencensus.io/trace"
+)
+
+// maxImportSize is the largest file accepted by an import endpoint.
+const maxImportSize = 10 << 20
+
+// ExchangeRate defines all of the handlers related to exchange rates.
+// It holds the application state needed by the handler methods.
+type ExchangeRate struct {
+	DB  *mongo.Collection
+	Log *log.Logger
+}
+
+// ListExchangeRates gets a page of exchange rates, newest first.
+// The optional base_currency_id and quote_currency_id query parameters limit the rates to a currency pair.
+func (x ExchangeRate) ListExchangeRates(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.ExchangeRate.ListExchangeRates")
+	defer span.End()
+
+	page, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+
+	q := r.URL.Query()
+
+	list, info, err := budget.ListExchangeRates(ctx, x.DB, q.Get("base_currency_id"), q.Get("quote_currency_id"), page)
+	if err != nil {
+		return pageError(err)
+	}
+
+	setPageHeaders(w, r, info)
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// RetrieveExchangeRate gets the exchange rate identified by an _id in the request URL.
+func (x ExchangeRate) RetrieveExchangeRate(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	_id := chi.URLParam(r, "_id")
+
+	rate, err := budget.RetrieveExchangeRate(ctx, x.DB, _id)
+	if err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "looking for exchange rate %q", _id)
+		}
+	}
+
+	return web.Respond(ctx, w, rate, http.StatusOK)
+}
+
+// CreateExchangeRate decodes the body of a request to store the rate of a currency pair on a date.
+// The stored exchange rate is sent back in the response.
+func (x ExchangeRate) CreateExchangeRate(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claims missing from context")
+	}
+
+	var newRate budget.NewExchangeRate
+	if err := web.Decode(r, &newRate); err != nil {
+		return err
+	}
+
+	rate, err := budget.CreateExchangeRate(ctx, x.DB, claims, newRate, time.Now())
+	if err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		switch err {
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "creating exchange rate %+v", newRate)
+		}
+	}
+
+	return web.Respond(ctx, w, rate, http.StatusCreated)
+}
+
+// UpdateOneExchangeRate decodes the body of a request to update an existing exchange rate.
+// The _id of the exchange rate is part of the request URL.
+func (x ExchangeRate) UpdateOneExchangeRate(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	rateID := chi.URLParam(r, "_id")
+
+	var rateUpdate budget.UpdateExchangeRate
+	if err := web.Decode(r, &rateUpdate); err != nil {
+		return errors.Wrap(err, "decoding exchange rate update")
+	}
+
+	if err := budget.UpdateOneExchangeRate(ctx, x.DB, claims, rateID, rateUpdate, time.Now()); err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "updating exchange rate %q", rateID)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusOK)
+}
+
+// DeleteExchangeRate removes the exchange rate identified by an _id in the request URL.
+func (x ExchangeRate) DeleteExchangeRate(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	rateID := chi.URLParam(r, "_id")
+
+	if err := budget.DeleteExchangeRate(ctx, x.DB, claims, rateID); err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "deleting exchange rate %q", rateID)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// ImportExchangeRates stores the exchange rates of a CSV file with the header "base,quote,date,rate".
+// The file is either the body of the request or the "file" part of a multipart form.
+// A report of the lines imported and the lines that failed is sent back in the response.
+func (x ExchangeRate) ImportExchangeRates(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.ExchangeRate.ImportExchangeRates")
+	defer span.End()
+
+	file, err := uploadedFile(w, r)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	result, err := budget.ImportExchangeRates(ctx, x.DB.Database(), file, time.Now())
+	if err != nil {
+		if errors.Cause(err) == budget.ErrInvalidImport {
+			return web.NewRequestError(err, http.StatusBadRequest)
+		}
+		return errors.Wrap(err, "importing exchange rates")
+	}
+
+	return web.Respond(ctx, w, result, http.StatusOK)
+}
+
+// uploadedFile returns the file sent with a request, either as the "file" part of a multipart form or as the body.
+// Files larger than maxImportSize are rejected.
+func uploadedFile(w http.ResponseWriter, r *http.Request) (io.ReadCloser, error) {
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return r.Body, nil
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, web.NewRequestError(errors.Wrap(err, "reading uploaded file"), http.StatusBadRequest)
+	}
+
+	return file, nil
+}
@@ -29,11 +29,18 @@ func (fA FinancialAccount) ListFinancialAccounts(ctx context.Context, w http.Res
 	ctx, span := trace.StartSpan(ctx, "handlers.FinancialAccount.ListFinancialAccounts")
 	defer span.End()
 
-	list, err := budget.ListFinancialAccounts(ctx, fA.DB)
+	page, err := parsePage(r)
 	if err != nil {
 		return err
 	}
 
+	list, info, err := budget.ListFinancialAccounts(ctx, fA.DB, page)
+	if err != nil {
+		return pageError(err)
+	}
+
+	setPageHeaders(w, r, info)
+
 	return web.Respond(ctx, w, list, http.StatusOK)
 }
 
@@ -75,11 +82,14 @@ func (fA FinancialAccount) CreateFinancialAccount(ctx context.Context, w http.Re
 
 	faCreated, err := budget.CreateFinancialAccount(ctx, fA.DB, claims, newFA, time.Now())
 	if err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
 		switch err {
 		case apierror.ErrForbidden:
 			return web.NewRequestError(err, http.StatusForbidden)
 		default:
-			return errors.Wrapf(err, "creating financial account %q", newFA)
+			return errors.Wrapf(err, "creating financial account %+v", newFA)
 		}
 	}
 
@@ -102,7 +112,10 @@ func (fA *FinancialAccount) UpdateOneFinancialAccount(ctx context.Context, w htt
 		return errors.Wrap(err, "decoding financial account update")
 	}
 
-	if err := budget.UpdateOneFinancialAccount(ctx, fA.DB, claims, finAccID, finAccUpdate, time.Now()); err != nil {
+	if err := budget.UpdateOneFinancialAccount(ctx, fA.DB.Database(), claims, finAccID, finAccUpdate, time.Now()); err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
 		switch err {
 		case apierror.ErrNotFound:
 			return web.NewRequestError(err, http.StatusNotFound)
@@ -127,7 +140,18 @@ func (fA *FinancialAccount) DeleteFinancialAccount(ctx context.Context, w http.R
 
 	finAccID := chi.URLParam(r, "_id")
 
-	if err := budget.DeleteFinancialAccount(ctx, fA.DB, claims, finAccID); err != nil {
+	rule, err := parseDeleteRule(r)
+	if err != nil {
+		return err
+	}
+
+	if err := budget.DeleteFinancialAccount(ctx, fA.DB.Database(), claims, finAccID, rule, time.Now()); err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		if cerr, ok := err.(*apierror.ConflictError); ok {
+			return conflictError(cerr)
+		}
 		switch err {
 		case apierror.ErrNotFound:
 			return web.NewRequestError(err, http.StatusNotFound)
@@ -135,6 +159,8 @@ func (fA *FinancialAccount) DeleteFinancialAccount(ctx context.Context, w http.R
 			return web.NewRequestError(err, http.StatusBadRequest)
 		case apierror.ErrForbidden:
 			return web.NewRequestError(err, http.StatusForbidden)
+		case apierror.ErrLocked:
+			return web.NewRequestError(err, http.StatusConflict)
 		default:
 			return errors.Wrapf(err, "deleting financial account %q", finAccID)
 		}
@@ -142,3 +168,61 @@ func (fA *FinancialAccount) DeleteFinancialAccount(ctx context.Context, w http.R
 
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
 }
+
+// RecomputeBalance repairs the current value of a financial account from the transactions recorded against it.
+// The _id of the financial account is part of the request URL.
+func (fA *FinancialAccount) RecomputeBalance(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	finAccID := chi.URLParam(r, "_id")
+
+	faRecomputed, err := budget.RecomputeBalance(ctx, fA.DB.Database(), claims, finAccID, time.Now())
+	if err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(conversionError(err), "recomputing balance of financial account %q", finAccID)
+		}
+	}
+
+	return web.Respond(ctx, w, faRecomputed, http.StatusOK)
+}
+
+// Summary totals the transactions of the financial account identified by an _id in the request URL.
+// The optional from and to query parameters limit the transactions to a date range.
+// The optional currency_id query parameter converts every amount into that currency.
+func (fA *FinancialAccount) Summary(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.FinancialAccount.Summary")
+	defer span.End()
+
+	finAccID := chi.URLParam(r, "_id")
+
+	window, err := decodeSummaryWindow(r.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	summary, err := budget.SummarizeAccount(ctx, fA.DB.Database(), finAccID, window, r.URL.Query().Get("currency_id"))
+	if err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(conversionError(err), "summarizing financial account %q", finAccID)
+		}
+	}
+
+	return web.Respond(ctx, w, summary, http.StatusOK)
+}
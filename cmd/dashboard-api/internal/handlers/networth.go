@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/web"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opencensus.io/trace"
+)
+
+// NetWorth defines the handlers of net worth snapshots.
+type NetWorth struct {
+	DB  *mongo.Database
+	Log *log.Logger
+}
+
+// List gets the net worth snapshots in the currency given by currency_id, oldest first.
+// The optional from and to dates limit the days of the snapshots.
+func (n NetWorth) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.NetWorth.List")
+	defer span.End()
+
+	q := r.URL.Query()
+
+	window, err := decodeSummaryWindow(q)
+	if err != nil {
+		return err
+	}
+
+	history, err := budget.ListNetWorth(ctx, n.DB, q.Get("currency_id"), window)
+	if err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		return errors.Wrap(err, "listing net worth snapshots")
+	}
+
+	return web.Respond(ctx, w, history, http.StatusOK)
+}
+
+// Snapshot takes the net worth snapshot of today in the currency given by currency_id.
+func (n NetWorth) Snapshot(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.NetWorth.Snapshot")
+	defer span.End()
+
+	currencyID := r.URL.Query().Get("currency_id")
+
+	snapshot, err := budget.TakeNetWorthSnapshot(ctx, n.DB, currencyID, time.Now())
+	if err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		return errors.Wrapf(conversionError(err), "taking net worth snapshot in %q", currencyID)
+	}
+
+	return web.Respond(ctx, w, snapshot, http.StatusCreated)
+}
@@ -29,11 +29,18 @@ func (n Note) ListNotes(ctx context.Context, w http.ResponseWriter, r *http.Requ
 	ctx, span := trace.StartSpan(ctx, "handlers.Note.ListNotes")
 	defer span.End()
 
-	list, err := blog.ListNotes(ctx, n.DB)
+	page, err := parsePage(r)
 	if err != nil {
 		return err
 	}
 
+	list, info, err := blog.ListNotes(ctx, n.DB, page)
+	if err != nil {
+		return pageError(err)
+	}
+
+	setPageHeaders(w, r, info)
+
 	return web.Respond(ctx, w, list, http.StatusOK)
 }
 
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/web"
+	"github.com/pkg/errors"
+)
+
+// parsePage reads the pagination parameters (limit, cursor, sort, order) from the request URL.
+func parsePage(r *http.Request) (database.Page, error) {
+
+	page, err := database.ParsePage(r.URL.Query())
+	if err != nil {
+		return page, web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	return page, nil
+}
+
+// pageError converts an error from a paged list query into a response error.
+// A cursor that does not fit the query is the client's fault.
+func pageError(err error) error {
+
+	if errors.Cause(err) == database.ErrInvalidCursor {
+		return web.NewRequestError(database.ErrInvalidCursor, http.StatusBadRequest)
+	}
+
+	return err
+}
+
+// setPageHeaders tells the client how many documents match in total and
+// how to request the following page, if there is one.
+func setPageHeaders(w http.ResponseWriter, r *http.Request, info *database.PageInfo) {
+
+	w.Header().Set("X-Total-Count", strconv.FormatInt(info.Total, 10))
+
+	if info.Next == "" {
+		return
+	}
+
+	q := r.URL.Query()
+	q.Set("cursor", info.Next)
+
+	next := *r.URL
+	next.RawQuery = q.Encode()
+
+	w.Header().Set("X-Next-Cursor", info.Next)
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/web"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opencensus.io/trace"
+)
+
+// Participant defines all of the handlers related to participants.
+// It holds the application state needed by the handler methods.
+type Participant struct {
+	DB  *mongo.Collection
+	Log *log.Logger
+}
+
+// ListParticipants gets all participants from the service layer.
+func (p Participant) ListParticipants(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Participant.ListParticipants")
+	defer span.End()
+
+	page, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+
+	list, info, err := budget.ListParticipants(ctx, p.DB, page)
+	if err != nil {
+		return pageError(err)
+	}
+
+	setPageHeaders(w, r, info)
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// RetrieveParticipant gets the participant identified by an _id in the request URL.
+func (p Participant) RetrieveParticipant(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	_id := chi.URLParam(r, "_id")
+
+	participant, err := budget.RetrieveParticipant(ctx, p.DB, _id)
+	if err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "looking for participant %q", _id)
+		}
+	}
+
+	return web.Respond(ctx, w, participant, http.StatusOK)
+}
+
+// CreateParticipant decodes the body of a request to create a new participant.
+// The full participant with generated fields is sent back in the response.
+func (p Participant) CreateParticipant(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claims missing from context")
+	}
+
+	var newParticipant budget.NewParticipant
+
+	if err := web.Decode(r, &newParticipant); err != nil {
+		return err
+	}
+
+	participant, err := budget.CreateParticipant(ctx, p.DB.Database(), claims, newParticipant, time.Now())
+	if err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		switch err {
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "creating participant %+v", newParticipant)
+		}
+	}
+
+	return web.Respond(ctx, w, participant, http.StatusCreated)
+}
+
+// UpdateOneParticipant decodes the body of a request to update an existing participant.
+// The _id of the participant is part of the request URL.
+func (p Participant) UpdateOneParticipant(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	participantID := chi.URLParam(r, "_id")
+
+	var participantUpdate budget.UpdateParticipant
+	if err := web.Decode(r, &participantUpdate); err != nil {
+		return errors.Wrap(err, "decoding participant update")
+	}
+
+	if err := budget.UpdateOneParticipant(ctx, p.DB.Database(), claims, participantID, participantUpdate, time.Now()); err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "updating participant %q", participantID)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusOK)
+}
+
+// DeleteParticipant removes a single participant identified by an _id in the request URL.
+// The cascade and reassign_to query parameters say what happens to the transactions of the participant.
+func (p Participant) DeleteParticipant(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	participantID := chi.URLParam(r, "_id")
+
+	rule, err := parseDeleteRule(r)
+	if err != nil {
+		return err
+	}
+
+	if err := budget.DeleteParticipant(ctx, p.DB.Database(), claims, participantID, rule, time.Now()); err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		if cerr, ok := err.(*apierror.ConflictError); ok {
+			return conflictError(cerr)
+		}
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		case apierror.ErrLocked:
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrapf(err, "deleting participant %q", participantID)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// SettleUp reports who owes whom for the shared expenses between the optional from and to dates.
+// currency_id converts every amount into a single currency.
+func (p Participant) SettleUp(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Participant.SettleUp")
+	defer span.End()
+
+	q := r.URL.Query()
+
+	window, err := decodeSummaryWindow(q)
+	if err != nil {
+		return err
+	}
+
+	settlement, err := budget.SettleUp(ctx, p.DB.Database(), window, q.Get("currency_id"))
+	if err != nil {
+		return errors.Wrap(conversionError(err), "settling up shared expenses")
+	}
+
+	return web.Respond(ctx, w, settlement, http.StatusOK)
+}
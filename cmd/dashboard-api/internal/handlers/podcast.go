@@ -32,11 +32,18 @@ func (p Podcast) PodcastList(ctx context.Context, w http.ResponseWriter, r *http
 	ctx, span := trace.StartSpan(ctx, "handlers.Podcast.PodcastList")
 	defer span.End()
 
-	podcastList, err := podcast.List(ctx, p.DB)
+	page, err := parsePage(r)
 	if err != nil {
 		return err
 	}
 
+	podcastList, info, err := podcast.List(ctx, p.DB, page)
+	if err != nil {
+		return pageError(err)
+	}
+
+	setPageHeaders(w, r, info)
+
 	return web.Respond(ctx, w, podcastList, http.StatusOK)
 }
 
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/web"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opencensus.io/trace"
+)
+
+// Reconciliation defines all of the handlers related to reconciling financial accounts against bank statements.
+// It holds the application state needed by the handler methods.
+type Reconciliation struct {
+	DB  *mongo.Collection
+	Log *log.Logger
+}
+
+// ListReconciliations gets a page of the reconciliations of the financial account identified by an _id in the request URL.
+func (x Reconciliation) ListReconciliations(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Reconciliation.ListReconciliations")
+	defer span.End()
+
+	finAccID := chi.URLParam(r, "_id")
+
+	page, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+
+	list, info, err := budget.ListReconciliations(ctx, x.DB.Database(), finAccID, page)
+	if err != nil {
+		return reconciliationError(pageError(err), "listing reconciliations of financial account %q", finAccID)
+	}
+
+	setPageHeaders(w, r, info)
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// CreateReconciliation decodes the body of a request to start reconciling a financial account against a statement.
+func (x Reconciliation) CreateReconciliation(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claims missing from context")
+	}
+
+	finAccID := chi.URLParam(r, "_id")
+
+	var newRec budget.NewReconciliation
+	if err := web.Decode(r, &newRec); err != nil {
+		return err
+	}
+
+	rec, err := budget.CreateReconciliation(ctx, x.DB.Database(), claims, finAccID, newRec, time.Now())
+	if err != nil {
+		return reconciliationError(err, "creating reconciliation of financial account %q", finAccID)
+	}
+
+	return web.Respond(ctx, w, rec, http.StatusCreated)
+}
+
+// RetrieveReconciliation gets the reconciliation identified by a reconciliationID in the request URL.
+func (x Reconciliation) RetrieveReconciliation(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	finAccID := chi.URLParam(r, "_id")
+	recID := chi.URLParam(r, "reconciliationID")
+
+	rec, err := budget.RetrieveReconciliation(ctx, x.DB.Database(), finAccID, recID)
+	if err != nil {
+		return reconciliationError(err, "looking for reconciliation %q", recID)
+	}
+
+	return web.Respond(ctx, w, rec, http.StatusOK)
+}
+
+// UnclearedTransactions gets a page of the transactions of the account that are NOT cleared yet, up to the statement date.
+func (x Reconciliation) UnclearedTransactions(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Reconciliation.UnclearedTransactions")
+	defer span.End()
+
+	finAccID := chi.URLParam(r, "_id")
+	recID := chi.URLParam(r, "reconciliationID")
+
+	page, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+
+	list, info, err := budget.UnclearedTransactions(ctx, x.DB.Database(), finAccID, recID, page)
+	if err != nil {
+		return reconciliationError(pageError(err), "listing uncleared transactions of reconciliation %q", recID)
+	}
+
+	setPageHeaders(w, r, info)
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// ClearTransactions decodes the body of a request marking transactions as found, or NOT found, on the statement.
+// The reconciliation with its new balances is sent back in the response.
+func (x Reconciliation) ClearTransactions(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	finAccID := chi.URLParam(r, "_id")
+	recID := chi.URLParam(r, "reconciliationID")
+
+	var clear budget.ClearTransactions
+	if err := web.Decode(r, &clear); err != nil {
+		return err
+	}
+
+	rec, err := budget.ClearReconciliationTransactions(ctx, x.DB.Database(), claims, finAccID, recID, clear, time.Now())
+	if err != nil {
+		return reconciliationError(err, "clearing transactions of reconciliation %q", recID)
+	}
+
+	return web.Respond(ctx, w, rec, http.StatusOK)
+}
+
+// FinishReconciliation closes the reconciliation identified by a reconciliationID in the request URL and locks its transactions.
+func (x Reconciliation) FinishReconciliation(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	finAccID := chi.URLParam(r, "_id")
+	recID := chi.URLParam(r, "reconciliationID")
+
+	rec, err := budget.FinishReconciliation(ctx, x.DB.Database(), claims, finAccID, recID, time.Now())
+	if err != nil {
+		return reconciliationError(err, "finishing reconciliation %q", recID)
+	}
+
+	return web.Respond(ctx, w, rec, http.StatusOK)
+}
+
+// DeleteReconciliation removes the open reconciliation identified by a reconciliationID in the request URL.
+func (x Reconciliation) DeleteReconciliation(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	finAccID := chi.URLParam(r, "_id")
+	recID := chi.URLParam(r, "reconciliationID")
+
+	if err := budget.DeleteReconciliation(ctx, x.DB.Database(), claims, finAccID, recID); err != nil {
+		return reconciliationError(err, "deleting reconciliation %q", recID)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// reconciliationError maps the errors of the reconciliation service functions to responses.
+// Unexpected errors are wrapped with the formatted message.
+func reconciliationError(err error, format string, args ...interface{}) error {
+
+	if verr, ok := err.(*apierror.ValidationError); ok {
+		return validationError(verr)
+	}
+
+	switch err {
+	case apierror.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case apierror.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case apierror.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case apierror.ErrLocked:
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrapf(err, format, args...)
+	}
+}
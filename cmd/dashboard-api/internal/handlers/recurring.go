@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/web"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opencensus.io/trace"
+)
+
+// defaultPreview is the number of occurrences previewed when the request does NOT say.
+const defaultPreview = 5
+
+// RecurringTransaction defines all of the handlers related to recurring transactions.
+// It holds the application state needed by the handler methods.
+type RecurringTransaction struct {
+	DB  *mongo.Collection
+	Log *log.Logger
+}
+
+// ListRecurringTransactions gets a page of recurring transactions from the service layer.
+func (x RecurringTransaction) ListRecurringTransactions(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.RecurringTransaction.ListRecurringTransactions")
+	defer span.End()
+
+	page, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+
+	list, info, err := budget.ListRecurringTransactions(ctx, x.DB, page)
+	if err != nil {
+		return pageError(err)
+	}
+
+	setPageHeaders(w, r, info)
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// RetrieveRecurringTransaction gets the recurring transaction identified by an _id in the request URL.
+func (x RecurringTransaction) RetrieveRecurringTransaction(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	_id := chi.URLParam(r, "_id")
+
+	rt, err := budget.RetrieveRecurringTransaction(ctx, x.DB, _id)
+	if err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "looking for recurring transaction %q", _id)
+		}
+	}
+
+	return web.Respond(ctx, w, rt, http.StatusOK)
+}
+
+// PreviewRecurringTransaction gets the next transactions a recurring transaction will post, without posting them.
+// The optional count query parameter says how many, 5 by default.
+func (x RecurringTransaction) PreviewRecurringTransaction(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.RecurringTransaction.PreviewRecurringTransaction")
+	defer span.End()
+
+	_id := chi.URLParam(r, "_id")
+
+	n := defaultPreview
+	if v := r.URL.Query().Get("count"); v != "" {
+		count, err := strconv.Atoi(v)
+		if err != nil {
+			return queryError([]web.FieldError{{Field: "count", Error: "must be a number"}})
+		}
+		n = count
+	}
+
+	list, err := budget.PreviewRecurringTransaction(ctx, x.DB, _id, n, time.Now())
+	if err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "previewing recurring transaction %q", _id)
+		}
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// CreateRecurringTransaction decodes the body of a request to create a new recurring transaction.
+// The stored recurring transaction is sent back in the response.
+func (x RecurringTransaction) CreateRecurringTransaction(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claims missing from context")
+	}
+
+	var newRT budget.NewRecurringTransaction
+	if err := web.Decode(r, &newRT); err != nil {
+		return err
+	}
+
+	rt, err := budget.CreateRecurringTransaction(ctx, x.DB.Database(), claims, newRT, time.Now())
+	if err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		switch err {
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "creating recurring transaction %+v", newRT)
+		}
+	}
+
+	return web.Respond(ctx, w, rt, http.StatusCreated)
+}
+
+// UpdateOneRecurringTransaction decodes the body of a request to update an existing recurring transaction.
+// The _id of the recurring transaction is part of the request URL.
+func (x RecurringTransaction) UpdateOneRecurringTransaction(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	rtID := chi.URLParam(r, "_id")
+
+	var rtUpdate budget.UpdateRecurringTransaction
+	if err := web.Decode(r, &rtUpdate); err != nil {
+		return errors.Wrap(err, "decoding recurring transaction update")
+	}
+
+	if err := budget.UpdateOneRecurringTransaction(ctx, x.DB.Database(), claims, rtID, rtUpdate, time.Now()); err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "updating recurring transaction %q", rtID)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusOK)
+}
+
+// DeleteRecurringTransaction removes the recurring transaction identified by an _id in the request URL.
+// Transactions it already posted are kept.
+func (x RecurringTransaction) DeleteRecurringTransaction(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	rtID := chi.URLParam(r, "_id")
+
+	if err := budget.DeleteRecurringTransaction(ctx, x.DB, claims, rtID); err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "deleting recurring transaction %q", rtID)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/web"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opencensus.io/trace"
+)
+
+// Report defines the handlers of reports over the transactions.
+type Report struct {
+	DB  *mongo.Database
+	Log *log.Logger
+}
+
+// CashFlow reports income, expenses and net per month or year.
+// The query string takes interval (month or year), group_by (budget, vendor, account, participant or tag),
+// currency_id, the from and to dates, and tag, which may be repeated, to report only transactions with those tags.
+func (x Report) CashFlow(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Report.CashFlow")
+	defer span.End()
+
+	q := r.URL.Query()
+
+	window, err := decodeSummaryWindow(q)
+	if err != nil {
+		return err
+	}
+
+	query := budget.CashFlowQuery{
+		Interval:   q.Get("interval"),
+		GroupBy:    q.Get("group_by"),
+		CurrencyID: q.Get("currency_id"),
+		Window:     window,
+		Tags:       q["tag"],
+	}
+
+	report, err := budget.CashFlow(ctx, x.DB, query)
+	if err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		return errors.Wrapf(conversionError(err), "reporting cash flow %+v", query)
+	}
+
+	return web.Respond(ctx, w, report, http.StatusOK)
+}
+
+// Forecast projects the balances of the financial accounts and the spending of the budgets over the next months
+// from the recurring transactions and the monthly averages of past transactions.
+// The query string takes months (1 to 24, 3 by default), history (months of past transactions to average, 6 by default)
+// and min_balance, the balance below which a low-balance warning is raised, 0 by default.
+func (x Report) Forecast(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Report.Forecast")
+	defer span.End()
+
+	q := r.URL.Query()
+
+	var fields []web.FieldError
+	query := budget.ForecastQuery{}
+
+	for _, p := range []struct {
+		key string
+		n   *int
+	}{{"months", &query.Months}, {"history", &query.History}} {
+		if v := q.Get(p.key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				fields = append(fields, web.FieldError{Field: p.key, Error: "must be a number"})
+				continue
+			}
+			*p.n = n
+		}
+	}
+
+	if m := queryMoney(q, "min_balance", &fields); m != nil {
+		query.MinBalance = *m
+	}
+
+	if len(fields) > 0 {
+		return queryError(fields)
+	}
+
+	forecast, err := budget.ForecastCashFlow(ctx, x.DB, query, time.Now())
+	if err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		return errors.Wrapf(err, "forecasting cash flow %+v", query)
+	}
+
+	return web.Respond(ctx, w, forecast, http.StatusOK)
+}
@@ -5,14 +5,17 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
 	"github.com/dapperAuteur/dashboard-go-api/internal/mid"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/blob"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/web"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // API constructs a handler that knows about all API routes.
-func API(shutdown chan os.Signal, logger *log.Logger, db *mongo.Database, authenticator *auth.Authenticator) http.Handler {
+// Attachments of transactions are kept in files, and may be at most maxFileSize bytes.
+func API(shutdown chan os.Signal, logger *log.Logger, db *mongo.Database, authenticator *auth.Authenticator, files blob.Store, maxFileSize int64) http.Handler {
 
 	app := web.NewApp(shutdown, logger, mid.Logger(logger), mid.Errors(logger), mid.Metrics(), mid.Panics(logger))
 
@@ -29,11 +32,18 @@ func API(shutdown chan os.Signal, logger *log.Logger, db *mongo.Database, authen
 	notesCollection := db.Collection("notes")
 
 	// Finance Related
-	budgetsCollection := db.Collection("budgets")
-	financialAccountsCollection := db.Collection("financialaccounts")
-	vendorsCollection := db.Collection("vendors")
-	transactionsCollection := db.Collection("transactions")
-	currenciesCollection := db.Collection("allowedCurrency")
+	attachmentsCollection := db.Collection(budget.AttachmentCollection)
+	budgetsCollection := db.Collection(budget.BudgetCollection)
+	categoryRulesCollection := db.Collection(budget.CategoryRuleCollection)
+	financialAccountsCollection := db.Collection(budget.FinancialAccountCollection)
+	participantsCollection := db.Collection(budget.ParticipantCollection)
+	reconciliationsCollection := db.Collection(budget.ReconciliationCollection)
+	vendorsCollection := db.Collection(budget.VendorCollection)
+	webhooksCollection := db.Collection(budget.WebhookCollection)
+	transactionsCollection := db.Collection(budget.TransactionCollection)
+	currenciesCollection := db.Collection(budget.CurrencyCollection)
+	exchangeRatesCollection := db.Collection(budget.ExchangeRateCollection)
+	recurringCollection := db.Collection(budget.RecurringCollection)
 
 	// Podcast Related
 	episodesCollection := db.Collection("episodes")
@@ -51,31 +61,85 @@ func API(shutdown chan os.Signal, logger *log.Logger, db *mongo.Database, authen
 	}
 
 	// Finance Related
+	attachment := Attachment{
+		DB:      attachmentsCollection,
+		Files:   files,
+		MaxSize: maxFileSize,
+		Log:     logger,
+	}
+
 	budget := Budget{
 		DB:  budgetsCollection,
 		Log: logger,
 	}
 
+	categoryRule := CategoryRule{
+		DB:  categoryRulesCollection,
+		Log: logger,
+	}
+
 	currency := Currency{
 		DB:  currenciesCollection,
 		Log: logger,
 	}
 
+	exchangeRate := ExchangeRate{
+		DB:  exchangeRatesCollection,
+		Log: logger,
+	}
+
 	financialAccount := FinancialAccount{
 		DB:  financialAccountsCollection,
 		Log: logger,
 	}
 
-	transaction := Transaction{
-		DB:  transactionsCollection,
+	netWorth := NetWorth{
+		DB:  db,
 		Log: logger,
 	}
 
+	participant := Participant{
+		DB:  participantsCollection,
+		Log: logger,
+	}
+
+	reconciliation := Reconciliation{
+		DB:  reconciliationsCollection,
+		Log: logger,
+	}
+
+	recurringTransaction := RecurringTransaction{
+		DB:  recurringCollection,
+		Log: logger,
+	}
+
+	report := Report{
+		DB:  db,
+		Log: logger,
+	}
+
+	transaction := Transaction{
+		DB:    transactionsCollection,
+		Files: files,
+		Log:   logger,
+	}
+
+	transfer := Transfer{
+		DB:    transactionsCollection,
+		Files: files,
+		Log:   logger,
+	}
+
 	vendor := Vendor{
 		DB:  vendorsCollection,
 		Log: logger,
 	}
 
+	webhook := Webhook{
+		DB:  webhooksCollection,
+		Log: logger,
+	}
+
 	// Content Creation
 
 	podcast := Podcast{
@@ -106,12 +170,23 @@ func API(shutdown chan os.Signal, logger *log.Logger, db *mongo.Database, authen
 
 	// Budget Routes
 	app.Handle(http.MethodGet, "/v1/budgets", budget.List)
+	app.Handle(http.MethodGet, "/v1/budgets/summary", budget.SummaryList)
+	app.Handle(http.MethodGet, "/v1/budgets/{_id}/summary", budget.Summary)
+	app.Handle(http.MethodGet, "/v1/budgets/{_id}/alerts", budget.Alerts)
 	app.Handle(http.MethodGet, "/v1/budgets/{_id}", budget.Retrieve)
 	app.Handle(http.MethodGet, "/v1/budgets/{name}", budget.RetrieveByName)
 	app.Handle(http.MethodPost, "/v1/budgets", budget.Create, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
 	app.Handle(http.MethodPut, "/v1/budgets/{_id}", budget.UpdateOne, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
 	app.Handle(http.MethodDelete, "/v1/budgets/{_id}", budget.Delete, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
 
+	// CategoryRule Routes
+	app.Handle(http.MethodGet, "/v1/rules", categoryRule.ListCategoryRules)
+	app.Handle(http.MethodPost, "/v1/rules", categoryRule.CreateCategoryRule, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPost, "/v1/rules/reapply", categoryRule.ReapplyCategoryRules, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodGet, "/v1/rules/{_id}", categoryRule.RetrieveCategoryRule)
+	app.Handle(http.MethodPut, "/v1/rules/{_id}", categoryRule.UpdateOneCategoryRule, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodDelete, "/v1/rules/{_id}", categoryRule.DeleteCategoryRule, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+
 	// Currency Routes
 	app.Handle(http.MethodGet, "/v1/currencies", currency.CurrencyList)
 	app.Handle(http.MethodGet, "/v1/currencies/{_id}", currency.RetrieveCurrencyByID)
@@ -119,12 +194,33 @@ func API(shutdown chan os.Signal, logger *log.Logger, db *mongo.Database, authen
 	app.Handle(http.MethodPut, "/v1/currencies/{_id}", currency.UpdateOneCurrency, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
 	app.Handle(http.MethodDelete, "/v1/currencies/{_id}", currency.DeleteCurrencyByID, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
 
+	// ExchangeRate Routes
+	app.Handle(http.MethodGet, "/v1/exchange-rates", exchangeRate.ListExchangeRates)
+	app.Handle(http.MethodPost, "/v1/exchange-rates", exchangeRate.CreateExchangeRate, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPost, "/v1/exchange-rates/import", exchangeRate.ImportExchangeRates, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodGet, "/v1/exchange-rates/{_id}", exchangeRate.RetrieveExchangeRate)
+	app.Handle(http.MethodPut, "/v1/exchange-rates/{_id}", exchangeRate.UpdateOneExchangeRate, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodDelete, "/v1/exchange-rates/{_id}", exchangeRate.DeleteExchangeRate, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+
 	// FinancialAccount Routes
 	app.Handle(http.MethodGet, "/v1/financial-accounts", financialAccount.ListFinancialAccounts)
 	app.Handle(http.MethodPost, "/v1/financial-accounts", financialAccount.CreateFinancialAccount, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
 	app.Handle(http.MethodGet, "/v1/financial-accounts/{_id}", financialAccount.RetrieveFinancialAccount)
+	app.Handle(http.MethodGet, "/v1/financial-accounts/{_id}/summary", financialAccount.Summary)
 	app.Handle(http.MethodPut, "/v1/financial-accounts/{_id}", financialAccount.UpdateOneFinancialAccount, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
 	app.Handle(http.MethodDelete, "/v1/financial-accounts/{_id}", financialAccount.DeleteFinancialAccount, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPost, "/v1/financial-accounts/{_id}/recompute", financialAccount.RecomputeBalance, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodGet, "/v1/financial-accounts/{_id}/reconciliations", reconciliation.ListReconciliations)
+	app.Handle(http.MethodPost, "/v1/financial-accounts/{_id}/reconciliations", reconciliation.CreateReconciliation, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodGet, "/v1/financial-accounts/{_id}/reconciliations/{reconciliationID}", reconciliation.RetrieveReconciliation)
+	app.Handle(http.MethodDelete, "/v1/financial-accounts/{_id}/reconciliations/{reconciliationID}", reconciliation.DeleteReconciliation, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodGet, "/v1/financial-accounts/{_id}/reconciliations/{reconciliationID}/transactions", reconciliation.UnclearedTransactions)
+	app.Handle(http.MethodPost, "/v1/financial-accounts/{_id}/reconciliations/{reconciliationID}/clear", reconciliation.ClearTransactions, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPost, "/v1/financial-accounts/{_id}/reconciliations/{reconciliationID}/finish", reconciliation.FinishReconciliation, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+
+	// NetWorth Routes
+	app.Handle(http.MethodGet, "/v1/net-worth", netWorth.List)
+	app.Handle(http.MethodPost, "/v1/net-worth/snapshots", netWorth.Snapshot, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
 
 	// Note Routes
 	app.Handle(http.MethodGet, "/v1/notes", note.ListNotes)
@@ -133,21 +229,65 @@ func API(shutdown chan os.Signal, logger *log.Logger, db *mongo.Database, authen
 	app.Handle(http.MethodPut, "/v1/notes/{_id}", note.UpdateOneNote, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
 	app.Handle(http.MethodDelete, "/v1/notes/{_id}", note.DeleteNote, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
 
+	// Participant Routes
+	app.Handle(http.MethodGet, "/v1/participants", participant.ListParticipants)
+	app.Handle(http.MethodPost, "/v1/participants", participant.CreateParticipant, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodGet, "/v1/participants/settle-up", participant.SettleUp)
+	app.Handle(http.MethodGet, "/v1/participants/{_id}", participant.RetrieveParticipant)
+	app.Handle(http.MethodPut, "/v1/participants/{_id}", participant.UpdateOneParticipant, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodDelete, "/v1/participants/{_id}", participant.DeleteParticipant, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+
+	// RecurringTransaction Routes
+	app.Handle(http.MethodGet, "/v1/recurring-transactions", recurringTransaction.ListRecurringTransactions)
+	app.Handle(http.MethodPost, "/v1/recurring-transactions", recurringTransaction.CreateRecurringTransaction, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodGet, "/v1/recurring-transactions/{_id}", recurringTransaction.RetrieveRecurringTransaction)
+	app.Handle(http.MethodGet, "/v1/recurring-transactions/{_id}/preview", recurringTransaction.PreviewRecurringTransaction)
+	app.Handle(http.MethodPut, "/v1/recurring-transactions/{_id}", recurringTransaction.UpdateOneRecurringTransaction, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodDelete, "/v1/recurring-transactions/{_id}", recurringTransaction.DeleteRecurringTransaction, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+
+	// Report Routes
+	app.Handle(http.MethodGet, "/v1/reports/cash-flow", report.CashFlow)
+	app.Handle(http.MethodGet, "/v1/reports/forecast", report.Forecast)
+
 	// Transaction Routes
 	app.Handle(http.MethodGet, "/v1/transactions", transaction.ListTransactions)
-	// app.Handle(http.MethodPost, "/v1/transactions/filter", transaction.FilterTransactions)
+	app.Handle(http.MethodPost, "/v1/transactions/filter", transaction.FilterTransactions)
+	app.Handle(http.MethodGet, "/v1/transactions/export", transaction.ExportTransactions)
+	app.Handle(http.MethodGet, "/v1/transactions/tags", transaction.ListTags)
+	app.Handle(http.MethodGet, "/v1/transactions/duplicates", transaction.ListDuplicates)
 	app.Handle(http.MethodPost, "/v1/transactions", transaction.CreateTransaction, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPost, "/v1/transactions/import", transaction.ImportTransactions, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPost, "/v1/transactions/merge", transaction.MergeTransactions, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
 	app.Handle(http.MethodGet, "/v1/transactions/{_id}", transaction.RetrieveTransaction)
 	app.Handle(http.MethodPut, "/v1/transactions/{_id}", transaction.UpdateOneTransaction, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
 	app.Handle(http.MethodDelete, "/v1/transactions/{_id}", transaction.DeleteTransaction, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPost, "/v1/transactions/{_id}/unlock", transaction.UnlockTransaction, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodGet, "/v1/transactions/{_id}/attachments", attachment.ListAttachments, mid.Authenticate(authenticator))
+	app.Handle(http.MethodPost, "/v1/transactions/{_id}/attachments", attachment.AddAttachment, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodGet, "/v1/transactions/{_id}/attachments/{attachmentID}", attachment.DownloadAttachment, mid.Authenticate(authenticator))
+	app.Handle(http.MethodDelete, "/v1/transactions/{_id}/attachments/{attachmentID}", attachment.DeleteAttachment, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+
+	// Transfer Routes
+	app.Handle(http.MethodPost, "/v1/transfers", transfer.CreateTransfer, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodGet, "/v1/transfers/{_id}", transfer.RetrieveTransfer)
+	app.Handle(http.MethodPut, "/v1/transfers/{_id}", transfer.UpdateOneTransfer, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodDelete, "/v1/transfers/{_id}", transfer.DeleteTransfer, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
 
 	// Vendor Routes
 	app.Handle(http.MethodGet, "/v1/vendors", vendor.ListVendors)
 	app.Handle(http.MethodPost, "/v1/vendors", vendor.CreateVendor, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
 	app.Handle(http.MethodGet, "/v1/vendors/{_id}", vendor.RetrieveVendor)
+	app.Handle(http.MethodGet, "/v1/vendors/{_id}/transactions", vendor.VendorTransactions)
 	app.Handle(http.MethodPut, "/v1/vendors/{_id}", vendor.UpdateOneVendor, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
 	app.Handle(http.MethodDelete, "/v1/vendors/{_id}", vendor.DeleteVendor, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
 
+	// Webhook Routes
+	app.Handle(http.MethodGet, "/v1/webhooks", webhook.ListWebhooks, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodPost, "/v1/webhooks", webhook.CreateWebhook, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodGet, "/v1/webhooks/{_id}", webhook.RetrieveWebhook, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodDelete, "/v1/webhooks/{_id}", webhook.DeleteWebhook, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle(http.MethodGet, "/v1/webhooks/{_id}/deliveries", webhook.ListDeliveries, mid.Authenticate(authenticator), mid.HasRole(auth.RoleAdmin))
+
 	// Episode Routes
 	app.Handle(http.MethodGet, "/v1/episodes", episode.EpisodeList)
 	app.Handle(http.MethodGet, "/v1/podcasts/{_id}/episodes", episode.PodcastEpisodeList)
@@ -2,13 +2,17 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
 	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/blob"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/web"
 	"github.com/go-chi/chi"
 	"github.com/pkg/errors"
@@ -19,47 +23,233 @@ import (
 // Transaction defines all of the handlers related to transaction.
 // It holds the application state needed by the handler methods.
 type Transaction struct {
-	DB  *mongo.Collection
-	Log *log.Logger
+	DB    *mongo.Collection
+	Files blob.Store
+	Log   *log.Logger
 }
 
 // ListTransactions gets all transactions from the service layer.
+// Criteria in the query string, e.g. ?budget_id=...&tranx_debit_min=10, narrow the list.
 func (t Transaction) ListTransactions(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 
 	ctx, span := trace.StartSpan(ctx, "handlers.Transaction.ListTransactions")
 	defer span.End()
 
-	list, err := budget.ListTransactions(ctx, t.DB)
+	filterTranx, err := decodeTransactionFilter(r.URL.Query())
 	if err != nil {
 		return err
 	}
 
+	page, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+
+	list, info, err := budget.FilterTransactions(ctx, t.DB, filterTranx, page)
+	if err != nil {
+		return pageError(err)
+	}
+
+	setPageHeaders(w, r, info)
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// ListTags counts the transactions per tag, most used first.
+// The query string takes the same criteria as ListTransactions, e.g. ?occurrence_from=2020-01-01.
+func (t Transaction) ListTags(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Transaction.ListTags")
+	defer span.End()
+
+	filterTranx, err := decodeTransactionFilter(r.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	list, err := budget.ListTags(ctx, t.DB, filterTranx)
+	if err != nil {
+		return errors.Wrap(err, "listing tags")
+	}
+
 	return web.Respond(ctx, w, list, http.StatusOK)
 }
 
 // FilterTransactions gets all filtered transactions from the service layer.
-// func (t Transaction) FilterTransactions(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+// The filter criteria are sent as a JSON document in the body of the request.
+func (t Transaction) FilterTransactions(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Transaction.FilterTransactions")
+	defer span.End()
+
+	var filterTranx budget.FilterTransaction
+
+	if err := web.Decode(r, &filterTranx); err != nil {
+		return err
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+
+	list, info, err := budget.FilterTransactions(ctx, t.DB, filterTranx, page)
+	if err != nil {
+		return errors.Wrapf(pageError(err), "filtering transactions %+v", filterTranx)
+	}
+
+	setPageHeaders(w, r, info)
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// ExportTransactions streams the transactions that fit the criteria of the query string into a file.
+// The format query parameter is csv (the default), jsonl or excel. Budgets, vendors, currencies and accounts are written by name.
+// An error met after the file has started is logged, as the response status can no longer change.
+func (t Transaction) ExportTransactions(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Transaction.ExportTransactions")
+	defer span.End()
+
+	filterTranx, err := decodeTransactionFilter(r.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = budget.FormatCSV
+	}
+
+	export, err := budget.OpenTransactionExport(ctx, t.DB.Database(), filterTranx, format)
+	if err != nil {
+		if err == budget.ErrInvalidExport {
+			return web.NewRequestError(err, http.StatusBadRequest)
+		}
+		return errors.Wrap(err, "exporting transactions")
+	}
+	defer export.Close(ctx)
+
+	w.Header().Set("Content-Disposition", `attachment; filename="transactions.`+export.Extension()+`"`)
+	if err := web.RespondStream(ctx, w, export.ContentType(), http.StatusOK); err != nil {
+		return err
+	}
+
+	if n, err := export.Write(ctx, w); err != nil {
+		t.Log.Printf("ERROR : exporting transactions, stopped after %d : %+v", n, err)
+	}
+
+	return nil
+}
+
+// decodeTransactionFilter reads the transaction filter criteria from a query string.
+// Amounts are decimal numbers. Dates are RFC3339 timestamps or YYYY-MM-DD dates.
+func decodeTransactionFilter(q url.Values) (budget.FilterTransaction, error) {
+
+	var fields []web.FieldError
+
+	filterTranx := budget.FilterTransaction{
+		BudgetID:           q.Get("budget_id"),
+		CurrencyID:         q.Get("currency_id"),
+		FinancialAccountID: q.Get("fin_acc_id"),
+		OccurrenceString:   q.Get("occurrence_string"),
+		OccurrenceFrom:     queryDate(q, "occurrence_from", &fields),
+		TransactionEvent:   q.Get("tranx_event"),
+		CreditMin:          queryMoney(q, "tranx_credit_min", &fields),
+		CreditMax:          queryMoney(q, "tranx_credit_max", &fields),
+		DebitMin:           queryMoney(q, "tranx_debit_min", &fields),
+		DebitMax:           queryMoney(q, "tranx_debit_max", &fields),
+		VendorID:           q.Get("vendor_id"),
+		ParticipantID:      q.Get("participant_id"),
+		Tags:               q["tag"],
+		AnyTags:            q["any_tag"],
+		CreatedFrom:        queryDate(q, "created_from", &fields),
+		UpdatedFrom:        queryDate(q, "updated_from", &fields),
+	}
+
+	filterTranx.OccurrenceTo, filterTranx.OccurrenceBefore = queryDateTo(q, "occurrence_to", &fields)
+	filterTranx.CreatedTo, filterTranx.CreatedBefore = queryDateTo(q, "created_to", &fields)
+	filterTranx.UpdatedTo, filterTranx.UpdatedBefore = queryDateTo(q, "updated_to", &fields)
+
+	if v := q.Get("locked"); v != "" {
+		locked, err := strconv.ParseBool(v)
+		if err != nil {
+			fields = append(fields, web.FieldError{Field: "locked", Error: "locked must be true or false"})
+		}
+		filterTranx.Locked = &locked
+	}
+
+	if len(fields) > 0 {
+		return filterTranx, queryError(fields)
+	}
+
+	return filterTranx, nil
+}
+
+// queryMoney reads an optional decimal amount from a query string.
+// A malformed amount is recorded in fields and nil is returned.
+func queryMoney(q url.Values, key string, fields *[]web.FieldError) *budget.Money {
+
+	v := q.Get(key)
+	if v == "" {
+		return nil
+	}
+
+	m, err := budget.ParseMoney(v, q.Get("currency_id"))
+	if err != nil {
+		*fields = append(*fields, web.FieldError{Field: key, Error: key + " must be a decimal number"})
+		return nil
+	}
 
-// 	ctx, span := trace.StartSpan(ctx, "handlers.Transaction.FilterTransactions")
-// 	defer span.End()
+	return &m
+}
+
+// queryDate reads an optional date from a query string.
+// A malformed date is recorded in fields and nil is returned.
+func queryDate(q url.Values, key string, fields *[]web.FieldError) *time.Time {
 
-// 	var filterTranx budget.FilterTransaction
-// 	fmt.Println("println")
-// 	fmt.Print("print")
+	v := q.Get(key)
+	if v == "" {
+		return nil
+	}
 
-// 	if err := web.Decode(r, &filterTranx); err != nil {
-// 		return err
-// 	}
-// 	fmt.Println("println", filterTranx)
-// 	fmt.Print("print", filterTranx)
+	t, err := parseDate(v)
+	if err != nil {
+		*fields = append(*fields, web.FieldError{Field: key, Error: key + " must be an RFC3339 timestamp or a YYYY-MM-DD date"})
+		return nil
+	}
+
+	return &t
+}
 
-// 	list, err := budget.FilterTransactions(ctx, t.DB, filterTranx)
-// 	if err != nil {
-// 		return errors.Wrapf(err, "filtering transaction %q", filterTranx)
-// 	}
+// queryDateTo reads an optional upper date bound from a query string.
+// A timestamp is returned as the inclusive bound, and a YYYY-MM-DD date as the start of the next day,
+// an exclusive bound, so the whole day is included.
+func queryDateTo(q url.Values, key string, fields *[]web.FieldError) (to, before *time.Time) {
 
-// 	return web.Respond(ctx, w, list, http.StatusOK)
-// }
+	t := queryDate(q, key, fields)
+	if t == nil {
+		return nil, nil
+	}
+
+	if _, err := time.Parse(time.RFC3339, q.Get(key)); err == nil {
+		return t, nil
+	}
+
+	next := t.AddDate(0, 0, 1)
+
+	return nil, &next
+}
+
+// parseDate accepts either a full RFC3339 timestamp or a YYYY-MM-DD date in UTC.
+func parseDate(v string) (time.Time, error) {
+
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+
+	return time.Parse("2006-01-02", v)
+}
 
 // CreateTransaction decodes the body of a request to create a new transaction.
 // The full transaction with generated fields is sent back in the response.
@@ -76,19 +266,156 @@ func (t Transaction) CreateTransaction(ctx context.Context, w http.ResponseWrite
 		return err
 	}
 
-	tranxCreated, err := budget.CreateTransaction(ctx, t.DB, claims, newTransaction, time.Now())
+	tranxCreated, err := budget.CreateTransaction(ctx, t.DB.Database(), claims, newTransaction, time.Now())
 	if err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
 		switch err {
 		case apierror.ErrForbidden:
 			return web.NewRequestError(err, http.StatusForbidden)
 		default:
-			return errors.Wrapf(err, "creating transaction %q", newTransaction)
+			return errors.Wrapf(err, "creating transaction %+v", newTransaction)
 		}
 	}
 
 	return web.Respond(ctx, w, tranxCreated, http.StatusCreated)
 }
 
+// ImportTransactions creates transactions from a bank statement in CSV or OFX format.
+// The statement is either the body of the request or the "file" part of a multipart form.
+// The format and a JSON import profile are read from the "format" and "profile" values of the form or query string.
+// A report of the rows created, skipped as duplicates and failed is sent back in the response.
+func (t Transaction) ImportTransactions(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Transaction.ImportTransactions")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claims missing from context")
+	}
+
+	file, err := uploadedFile(w, r)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	format := r.FormValue("format")
+	if format == "" {
+		format = budget.FormatCSV
+	}
+
+	var profile budget.ImportProfile
+	if v := r.FormValue("profile"); v != "" {
+		if err := json.Unmarshal([]byte(v), &profile); err != nil {
+			return web.NewRequestError(errors.Wrap(err, "decoding import profile"), http.StatusBadRequest)
+		}
+	}
+
+	report, err := budget.ImportTransactions(ctx, t.DB.Database(), claims, format, file, profile, time.Now())
+	if err != nil {
+		if errors.Cause(err) == budget.ErrInvalidImport {
+			return web.NewRequestError(err, http.StatusBadRequest)
+		}
+		switch err {
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrap(err, "importing transactions")
+		}
+	}
+
+	return web.Respond(ctx, w, report, http.StatusOK)
+}
+
+// ListDuplicates gets the pairs of transactions that look like the same one stored twice, most likely first.
+// The query string takes the from and to dates, the last 90 days without from, days (how far apart duplicates may be, 3 by default)
+// and min_score (the lowest confidence reported, 0.5 by default). Pairs are returned one page at a time.
+func (t Transaction) ListDuplicates(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Transaction.ListDuplicates")
+	defer span.End()
+
+	q := r.URL.Query()
+
+	window, err := decodeSummaryWindow(q)
+	if err != nil {
+		return err
+	}
+
+	query := budget.DuplicateQuery{Window: window}
+
+	var fields []web.FieldError
+
+	if v := q.Get("days"); v != "" {
+		if query.Days, err = strconv.Atoi(v); err != nil || query.Days < 0 {
+			fields = append(fields, web.FieldError{Field: "days", Error: "must be a positive number"})
+		}
+	}
+
+	if v := q.Get("min_score"); v != "" {
+		if query.MinScore, err = strconv.ParseFloat(v, 64); err != nil || query.MinScore < 0 || query.MinScore > 1 {
+			fields = append(fields, web.FieldError{Field: "min_score", Error: "must be a number from 0 to 1"})
+		}
+	}
+
+	if len(fields) > 0 {
+		return queryError(fields)
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+
+	list, info, err := budget.FindDuplicates(ctx, t.DB.Database(), query, page, time.Now())
+	if err != nil {
+		return errors.Wrapf(pageError(err), "finding duplicate transactions %+v", query)
+	}
+
+	setPageHeaders(w, r, info)
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// MergeTransactions decodes the body of a request naming a transaction to keep and its duplicate.
+// The duplicate is merged into the kept transaction, which is sent back in the response.
+func (t Transaction) MergeTransactions(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claims missing from context")
+	}
+
+	var merge budget.TransactionMerge
+	if err := web.Decode(r, &merge); err != nil {
+		return err
+	}
+
+	tranx, err := budget.MergeTransactions(ctx, t.DB.Database(), claims, merge, time.Now())
+	if err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		case apierror.ErrLocked:
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrapf(err, "merging transaction %q into %q", merge.DuplicateID, merge.KeepID)
+		}
+	}
+
+	return web.Respond(ctx, w, tranx, http.StatusOK)
+}
+
 // RetrieveTransaction will get the tranx from the db identified by an _id in the request URL, then encodes it in a response client.
 func (t Transaction) RetrieveTransaction(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 
@@ -125,7 +452,10 @@ func (t *Transaction) UpdateOneTransaction(ctx context.Context, w http.ResponseW
 		return errors.Wrap(err, "decoding transaction update")
 	}
 
-	if err := budget.UpdateOneTransaction(ctx, t.DB, claims, tranxID, transactionUpdate, time.Now()); err != nil {
+	if err := budget.UpdateOneTransaction(ctx, t.DB.Database(), claims, tranxID, transactionUpdate, time.Now()); err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
 		switch err {
 		case apierror.ErrNotFound:
 			return web.NewRequestError(err, http.StatusNotFound)
@@ -133,6 +463,8 @@ func (t *Transaction) UpdateOneTransaction(ctx context.Context, w http.ResponseW
 			return web.NewRequestError(err, http.StatusBadRequest)
 		case apierror.ErrForbidden:
 			return web.NewRequestError(err, http.StatusForbidden)
+		case apierror.ErrLocked:
+			return web.NewRequestError(err, http.StatusConflict)
 		default:
 			return errors.Wrapf(err, "updating transaction %q", tranxID)
 		}
@@ -151,7 +483,7 @@ func (t *Transaction) DeleteTransaction(ctx context.Context, w http.ResponseWrit
 
 	tranxID := chi.URLParam(r, "_id")
 
-	if err := budget.DeleteTransaction(ctx, t.DB, claims, tranxID); err != nil {
+	if err := budget.DeleteTransaction(ctx, t.DB.Database(), t.Files, claims, tranxID, time.Now()); err != nil {
 		switch err {
 		case apierror.ErrNotFound:
 			return web.NewRequestError(err, http.StatusNotFound)
@@ -159,6 +491,8 @@ func (t *Transaction) DeleteTransaction(ctx context.Context, w http.ResponseWrit
 			return web.NewRequestError(err, http.StatusBadRequest)
 		case apierror.ErrForbidden:
 			return web.NewRequestError(err, http.StatusForbidden)
+		case apierror.ErrLocked:
+			return web.NewRequestError(err, http.StatusConflict)
 		default:
 			return errors.Wrapf(err, "deleting transaction %q", tranxID)
 		}
@@ -166,3 +500,30 @@ func (t *Transaction) DeleteTransaction(ctx context.Context, w http.ResponseWrit
 
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
 }
+
+// UnlockTransaction lets the reconciled transaction identified by an _id in the request URL be changed or deleted again.
+func (t Transaction) UnlockTransaction(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	tranxID := chi.URLParam(r, "_id")
+
+	tranx, err := budget.UnlockTransaction(ctx, t.DB.Database(), claims, tranxID, time.Now())
+	if err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "unlocking transaction %q", tranxID)
+		}
+	}
+
+	return web.Respond(ctx, w, tranx, http.StatusOK)
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/blob"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/web"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Transfer defines all of the handlers related to transfers between financial accounts.
+// A transfer is identified by the _id of either of its transactions.
+type Transfer struct {
+	DB    *mongo.Collection
+	Files blob.Store
+	Log   *log.Logger
+}
+
+// CreateTransfer decodes the body of a request to move money from one financial account to another.
+// Both transactions of the transfer are sent back in the response.
+func (x Transfer) CreateTransfer(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claims missing from context")
+	}
+
+	var newTransfer budget.NewTransfer
+	if err := web.Decode(r, &newTransfer); err != nil {
+		return err
+	}
+
+	transfer, err := budget.CreateTransfer(ctx, x.DB.Database(), claims, newTransfer, time.Now())
+	if err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		switch err {
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "creating transfer %+v", newTransfer)
+		}
+	}
+
+	return web.Respond(ctx, w, transfer, http.StatusCreated)
+}
+
+// RetrieveTransfer gets the transfer identified by an _id in the request URL.
+func (x Transfer) RetrieveTransfer(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	_id := chi.URLParam(r, "_id")
+
+	transfer, err := budget.RetrieveTransfer(ctx, x.DB, _id)
+	if err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "looking for transfer %q", _id)
+		}
+	}
+
+	return web.Respond(ctx, w, transfer, http.StatusOK)
+}
+
+// UpdateOneTransfer decodes the body of a request to change both transactions of a transfer.
+// The _id of the transfer is part of the request URL.
+func (x Transfer) UpdateOneTransfer(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	tranxID := chi.URLParam(r, "_id")
+
+	var transferUpdate budget.UpdateTransfer
+	if err := web.Decode(r, &transferUpdate); err != nil {
+		return errors.Wrap(err, "decoding transfer update")
+	}
+
+	if err := budget.UpdateOneTransfer(ctx, x.DB.Database(), claims, tranxID, transferUpdate, time.Now()); err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		case apierror.ErrLocked:
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrapf(err, "updating transfer %q", tranxID)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusOK)
+}
+
+// DeleteTransfer removes both transactions of the transfer identified by an _id in the request URL.
+func (x Transfer) DeleteTransfer(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	tranxID := chi.URLParam(r, "_id")
+
+	if err := budget.DeleteTransfer(ctx, x.DB.Database(), x.Files, claims, tranxID, time.Now()); err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		case apierror.ErrLocked:
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrapf(err, "deleting transfer %q", tranxID)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
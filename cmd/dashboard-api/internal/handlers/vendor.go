@@ -29,11 +29,18 @@ func (v Vendor) ListVendors(ctx context.Context, w http.ResponseWriter, r *http.
 	ctx, span := trace.StartSpan(ctx, "handlers.Vendor.ListVendors")
 	defer span.End()
 
-	list, err := budget.ListVendors(ctx, v.DB)
+	page, err := parsePage(r)
 	if err != nil {
 		return err
 	}
 
+	list, info, err := budget.ListVendors(ctx, v.DB, page)
+	if err != nil {
+		return pageError(err)
+	}
+
+	setPageHeaders(w, r, info)
+
 	return web.Respond(ctx, w, list, http.StatusOK)
 }
 
@@ -54,11 +61,16 @@ func (v Vendor) CreateVendor(ctx context.Context, w http.ResponseWriter, r *http
 
 	vendorCreated, err := budget.CreateVendor(ctx, v.DB, claims, newVendor, time.Now())
 	if err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
 		switch err {
 		case apierror.ErrForbidden:
 			return web.NewRequestError(err, http.StatusForbidden)
+		case apierror.ErrLocked:
+			return web.NewRequestError(err, http.StatusConflict)
 		default:
-			return errors.Wrapf(err, "creating vendor %q", newVendor)
+			return errors.Wrapf(err, "creating vendor %+v", newVendor)
 		}
 	}
 	return web.Respond(ctx, w, vendorCreated, http.StatusCreated)
@@ -83,6 +95,43 @@ func (v Vendor) RetrieveVendor(ctx context.Context, w http.ResponseWriter, r *ht
 	return web.Respond(ctx, w, vFound, http.StatusOK)
 }
 
+// VendorTransactions gets a page of the transactions of the vendor identified by an _id in the request URL,
+// with the totals of all of them. The optional from and to dates limit the transactions and
+// currency_id converts the totals into a single currency.
+func (v Vendor) VendorTransactions(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Vendor.VendorTransactions")
+	defer span.End()
+
+	vendorID := chi.URLParam(r, "_id")
+
+	window, err := decodeSummaryWindow(r.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+
+	ledger, info, err := budget.VendorTransactions(ctx, v.DB.Database(), vendorID, window, r.URL.Query().Get("currency_id"), page)
+	if err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(conversionError(pageError(err)), "listing transactions of vendor %q", vendorID)
+		}
+	}
+
+	setPageHeaders(w, r, info)
+
+	return web.Respond(ctx, w, ledger, http.StatusOK)
+}
+
 // UpdateOneVendor decodes the body of a request to update an existing vendor.
 // The _id of the vendor is part of the request URL.
 func (v *Vendor) UpdateOneVendor(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
@@ -100,6 +149,9 @@ func (v *Vendor) UpdateOneVendor(ctx context.Context, w http.ResponseWriter, r *
 	}
 
 	if err := budget.UpdateOneVendor(ctx, v.DB, claims, vendorID, vendorUpdate, time.Now()); err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
 		switch err {
 		case apierror.ErrNotFound:
 			return web.NewRequestError(err, http.StatusNotFound)
@@ -107,6 +159,8 @@ func (v *Vendor) UpdateOneVendor(ctx context.Context, w http.ResponseWriter, r *
 			return web.NewRequestError(err, http.StatusBadRequest)
 		case apierror.ErrForbidden:
 			return web.NewRequestError(err, http.StatusForbidden)
+		case apierror.ErrLocked:
+			return web.NewRequestError(err, http.StatusConflict)
 		default:
 			return errors.Wrapf(err, "updating vendor %q", vendorID)
 		}
@@ -125,7 +179,18 @@ func (v *Vendor) DeleteVendor(ctx context.Context, w http.ResponseWriter, r *htt
 
 	vendorID := chi.URLParam(r, "_id")
 
-	if err := budget.DeleteVendor(ctx, v.DB, claims, vendorID); err != nil {
+	rule, err := parseDeleteRule(r)
+	if err != nil {
+		return err
+	}
+
+	if err := budget.DeleteVendor(ctx, v.DB.Database(), claims, vendorID, rule, time.Now()); err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		if cerr, ok := err.(*apierror.ConflictError); ok {
+			return conflictError(cerr)
+		}
 		switch err {
 		case apierror.ErrNotFound:
 			return web.NewRequestError(err, http.StatusNotFound)
@@ -133,6 +198,8 @@ func (v *Vendor) DeleteVendor(ctx context.Context, w http.ResponseWriter, r *htt
 			return web.NewRequestError(err, http.StatusBadRequest)
 		case apierror.ErrForbidden:
 			return web.NewRequestError(err, http.StatusForbidden)
+		case apierror.ErrLocked:
+			return web.NewRequestError(err, http.StatusConflict)
 		default:
 			return errors.Wrapf(err, "deleting vendor %q", vendorID)
 		}
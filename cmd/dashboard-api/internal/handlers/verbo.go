@@ -29,11 +29,18 @@ func (v Verbo) VerboList(ctx context.Context, w http.ResponseWriter, r *http.Req
 	ctx, span := trace.StartSpan(ctx, "handlers.Verbo.VerboList")
 	defer span.End()
 
-	verboList, err := word.VerboList(ctx, v.DB)
+	page, err := parsePage(r)
 	if err != nil {
 		return err
 	}
 
+	verboList, info, err := word.VerboList(ctx, v.DB, page)
+	if err != nil {
+		return pageError(err)
+	}
+
+	setPageHeaders(w, r, info)
+
 	return web.Respond(ctx, w, verboList, http.StatusOK)
 }
 
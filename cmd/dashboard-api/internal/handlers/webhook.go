@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/web"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opencensus.io/trace"
+)
+
+// Webhook defines all of the handlers related to the webhooks that are sent budget alerts.
+// It holds the application state needed by the handler methods.
+type Webhook struct {
+	DB  *mongo.Collection
+	Log *log.Logger
+}
+
+// ListWebhooks gets a page of webhooks from the service layer.
+func (x Webhook) ListWebhooks(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Webhook.ListWebhooks")
+	defer span.End()
+
+	page, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+
+	list, info, err := budget.ListWebhooks(ctx, x.DB, page)
+	if err != nil {
+		return pageError(err)
+	}
+
+	setPageHeaders(w, r, info)
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// RetrieveWebhook gets the webhook identified by an _id in the request URL.
+func (x Webhook) RetrieveWebhook(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	_id := chi.URLParam(r, "_id")
+
+	hook, err := budget.RetrieveWebhook(ctx, x.DB, _id)
+	if err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "looking for webhook %q", _id)
+		}
+	}
+
+	return web.Respond(ctx, w, hook, http.StatusOK)
+}
+
+// CreateWebhook decodes the body of a request to register a webhook.
+// The response holds the secret that signs the deliveries, which is NOT shown again.
+func (x Webhook) CreateWebhook(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return web.NewShutdownError("auth claims missing from context")
+	}
+
+	var newHook budget.NewWebhook
+	if err := web.Decode(r, &newHook); err != nil {
+		return err
+	}
+
+	hook, err := budget.CreateWebhook(ctx, x.DB, claims, newHook, time.Now())
+	if err != nil {
+		if verr, ok := err.(*apierror.ValidationError); ok {
+			return validationError(verr)
+		}
+		switch err {
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "creating webhook %q", newHook.URL)
+		}
+	}
+
+	return web.Respond(ctx, w, hook, http.StatusCreated)
+}
+
+// DeleteWebhook removes the webhook identified by an _id in the request URL.
+func (x Webhook) DeleteWebhook(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	hookID := chi.URLParam(r, "_id")
+
+	if err := budget.DeleteWebhook(ctx, x.DB, claims, hookID); err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case apierror.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "deleting webhook %q", hookID)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// ListDeliveries gets a page of the delivery log of the webhook identified by an _id in the request URL.
+func (x Webhook) ListDeliveries(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	ctx, span := trace.StartSpan(ctx, "handlers.Webhook.ListDeliveries")
+	defer span.End()
+
+	hookID := chi.URLParam(r, "_id")
+
+	page, err := parsePage(r)
+	if err != nil {
+		return err
+	}
+
+	list, info, err := budget.ListWebhookDeliveries(ctx, x.DB.Database(), hookID, page)
+	if err != nil {
+		switch err {
+		case apierror.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case apierror.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(pageError(err), "listing deliveries of webhook %q", hookID)
+		}
+	}
+
+	setPageHeaders(w, r, info)
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
@@ -29,11 +29,18 @@ func (wd Word) WordList(ctx context.Context, w http.ResponseWriter, r *http.Requ
 	ctx, span := trace.StartSpan(ctx, "handlers.Word.WordList")
 	defer span.End()
 
-	wordList, err := word.WordList(ctx, wd.DB)
+	page, err := parsePage(r)
 	if err != nil {
 		return err
 	}
 
+	wordList, info, err := word.WordList(ctx, wd.DB, page)
+	if err != nil {
+		return pageError(err)
+	}
+
+	setPageHeaders(w, r, info)
+
 	return web.Respond(ctx, w, wordList, http.StatusOK)
 }
 
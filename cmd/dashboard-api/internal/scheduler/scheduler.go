@@ -0,0 +1,91 @@
+// Package scheduler runs background jobs of the API on a fixed interval.
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Job is work done on every tick of a Scheduler.
+// Jobs must be safe to run again, and from more than one instance of the API at once.
+type Job struct {
+	Name string
+	Run  func(ctx context.Context, now time.Time) error
+}
+
+// Scheduler runs its jobs one after another, once when it is started and then once every interval.
+// A job that fails is logged and tried again on the next tick.
+type Scheduler struct {
+	log      *log.Logger
+	interval time.Duration
+	jobs     []Job
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New constructs a Scheduler for the jobs. It does nothing until it is started.
+func New(log *log.Logger, interval time.Duration, jobs ...Job) *Scheduler {
+	return &Scheduler{
+		log:      log,
+		interval: interval,
+		jobs:     jobs,
+	}
+}
+
+// Start runs the jobs in the background until Stop is called.
+func (s *Scheduler) Start() {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			s.runJobs(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop cancels the jobs that are running and waits for them to return.
+func (s *Scheduler) Stop() {
+
+	if s.cancel == nil {
+		return
+	}
+
+	s.cancel()
+	s.wg.Wait()
+}
+
+// runJobs runs every job once, unless the scheduler is stopped first.
+func (s *Scheduler) runJobs(ctx context.Context) {
+
+	for _, job := range s.jobs {
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		start := time.Now()
+		if err := job.Run(ctx, start); err != nil {
+			s.log.Printf("scheduler : %s : ERROR : %v", job.Name, err)
+			continue
+		}
+
+		s.log.Printf("scheduler : %s : completed in %v", job.Name, time.Since(start))
+	}
+}
@@ -0,0 +1,57 @@
+package scheduler_test
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/cmd/dashboard-api/internal/scheduler"
+	"github.com/pkg/errors"
+)
+
+func TestScheduler(t *testing.T) {
+
+	var runs, failures int32
+
+	count := scheduler.Job{
+		Name: "count",
+		Run: func(ctx context.Context, now time.Time) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		},
+	}
+
+	fail := scheduler.Job{
+		Name: "fail",
+		Run: func(ctx context.Context, now time.Time) error {
+			atomic.AddInt32(&failures, 1)
+			return errors.New("failed")
+		},
+	}
+
+	s := scheduler.New(log.New(ioutil.Discard, "", 0), 10*time.Millisecond, fail, count)
+	s.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&runs) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the job to run 3 times, it ran %d times", atomic.LoadInt32(&runs))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	s.Stop()
+
+	stopped := atomic.LoadInt32(&runs)
+	if atomic.LoadInt32(&failures) < stopped {
+		t.Fatalf("expected a failing job to keep running, it ran %d times", atomic.LoadInt32(&failures))
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&runs); got != stopped {
+		t.Fatalf("expected no runs after Stop, got %d more", got-stopped)
+	}
+}
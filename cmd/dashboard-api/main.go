@@ -16,14 +16,19 @@ import (
 
 	"contrib.go.opencensus.io/exporter/zipkin"
 	"github.com/dapperAuteur/dashboard-go-api/cmd/dashboard-api/internal/handlers"
+	"github.com/dapperAuteur/dashboard-go-api/cmd/dashboard-api/internal/scheduler"
 	"github.com/dapperAuteur/dashboard-go-api/environment"
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/blob"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/conf"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/webhook"
 	jwt "github.com/dgrijalva/jwt-go"
 	openzipkin "github.com/openzipkin/zipkin-go"
 	zipkinHTTP "github.com/openzipkin/zipkin-go/reporter/http"
 	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.opencensus.io/trace"
 )
 
@@ -55,6 +60,24 @@ func run() error {
 			PrivateKeyFile string `conf:"default:private.pem"`
 			Algorithm      string `conf:"default:RS256"`
 		}
+		Schedule struct {
+			Interval time.Duration `conf:"default:1h"`
+		}
+		Webhook struct {
+			Interval time.Duration `conf:"default:1m"`
+			Attempts int           `conf:"default:5"`
+			Backoff  time.Duration `conf:"default:2s"`
+			Timeout  time.Duration `conf:"default:10s"`
+		}
+		NetWorth struct {
+			Interval   time.Duration `conf:"default:24h"`
+			CurrencyID string        `conf:"default:"` // snapshots are only taken on a schedule when it is set
+		}
+		Attachments struct {
+			Store   string `conf:"default:gridfs"`      // gridfs or disk
+			Dir     string `conf:"default:attachments"` // only used by the disk store
+			MaxSize int64  `conf:"default:10485760"`    // in bytes
+		}
 		Trace struct {
 			URL         string  `conf:"default:http://localhost:9411/api/v2/spans"`
 			Service     string  `conf:"default:dashboard-api"`
@@ -92,7 +115,8 @@ func run() error {
 	log.Printf("main : Config :\n%v\n", out)
 
 	// is it ok to do this twice, I think ctx is needed here to close the context later
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
 	// ==
 	// Initialize authentication support
@@ -151,13 +175,84 @@ func run() error {
 	// myDatabase := client.Database(("quickstart")) // development database
 	myDatabase := client.Database(("palabras-express-api")) // production database
 
+	if err := budget.EnsureIndexes(ctx, myDatabase); err != nil {
+		return errors.Wrap(err, "ensuring database indexes")
+	}
+
+	files, err := createFileStore(myDatabase, cfg.Attachments.Store, cfg.Attachments.Dir)
+	if err != nil {
+		return errors.Wrap(err, "constructing attachment store")
+	}
+
 	api := http.Server{
 		Addr:         cfg.Web.Address,
-		Handler:      handlers.API(shutdown, log, myDatabase, authenticator),
+		Handler:      handlers.API(shutdown, log, myDatabase, authenticator, files, cfg.Attachments.MaxSize),
 		ReadTimeout:  cfg.Web.ReadTimeout,
 		WriteTimeout: cfg.Web.WriteTimeout,
 	}
 
+	// =========================================================================
+	// Start Scheduler
+
+	// Post the recurring transactions that are due.
+	sched := scheduler.New(log, cfg.Schedule.Interval, scheduler.Job{
+		Name: "post recurring transactions",
+		Run: func(ctx context.Context, now time.Time) error {
+			n, err := budget.PostDueTransactions(ctx, myDatabase, now)
+			log.Printf("main : Posted %d recurring transactions", n)
+			return err
+		},
+	}, scheduler.Job{
+		Name: "remove orphaned attachments",
+		Run: func(ctx context.Context, now time.Time) error {
+			n, err := budget.RemoveOrphanedAttachments(ctx, myDatabase, files)
+			log.Printf("main : Removed %d orphaned attachments", n)
+			return err
+		},
+	})
+	sched.Start()
+
+	// Stopped before the database is disconnected, so a running job can finish.
+	defer sched.Stop()
+
+	// Check the alerts of budgets whose check failed, and send the webhook deliveries of budget alerts,
+	// more often than the other jobs.
+	sender := webhook.Sender{
+		Client:   &http.Client{Timeout: cfg.Webhook.Timeout},
+		Attempts: cfg.Webhook.Attempts,
+		Backoff:  cfg.Webhook.Backoff,
+	}
+	deliveries := scheduler.New(log, cfg.Webhook.Interval, scheduler.Job{
+		Name: "check failed budget alerts",
+		Run: func(ctx context.Context, now time.Time) error {
+			n, err := budget.CheckFailedAlerts(ctx, myDatabase, now)
+			log.Printf("main : Checked alerts of %d budgets again", n)
+			return err
+		},
+	}, scheduler.Job{
+		Name: "deliver webhooks",
+		Run: func(ctx context.Context, now time.Time) error {
+			n, err := budget.DeliverWebhooks(ctx, myDatabase, sender, now)
+			log.Printf("main : Delivered %d webhooks", n)
+			return err
+		},
+	})
+	deliveries.Start()
+	defer deliveries.Stop()
+
+	// Snapshot the net worth once a day, in the base currency.
+	if cfg.NetWorth.CurrencyID != "" {
+		snapshots := scheduler.New(log, cfg.NetWorth.Interval, scheduler.Job{
+			Name: "snapshot net worth",
+			Run: func(ctx context.Context, now time.Time) error {
+				_, err := budget.TakeNetWorthSnapshot(ctx, myDatabase, cfg.NetWorth.CurrencyID, now)
+				return err
+			},
+		})
+		snapshots.Start()
+		defer snapshots.Stop()
+	}
+
 	// Make a channel to listen for errors coming from the listener. Use a
 	// buffered channel so the goroutine can exit if we don't collect this error.
 	serverErrors := make(chan error, 1)
@@ -218,6 +313,19 @@ func createAuth(privateKeyFile, keyID, algorithm string) (*auth.Authenticator, e
 	return auth.NewAuthenticator(key, keyID, algorithm, public)
 }
 
+// createFileStore returns the blob store the attachments of transactions are kept in.
+func createFileStore(db *mongo.Database, store, dir string) (blob.Store, error) {
+
+	switch store {
+	case "gridfs":
+		return blob.NewGridFS(db, "attachments")
+	case "disk":
+		return blob.NewDisk(dir)
+	default:
+		return nil, errors.Errorf("unknown attachment store %q, use gridfs or disk", store)
+	}
+}
+
 func registerTracer(service, httpAddr, traceURL string, probabilty float64) (func() error, error) {
 	localEndpoint, err := openzipkin.NewEndpoint(service, httpAddr)
 	if err != nil {
@@ -1,6 +1,9 @@
 package apierror
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // Predefined Errors indentify expected failure conditions.
 var (
@@ -12,4 +15,49 @@ var (
 
 	// ErrForbidden occurs when a user tries to do something that is forbidden to them according to our access control policies.
 	ErrForbidden = errors.New("Attempted action is NOT allowed")
+
+	// ErrLocked is used when a document that was reconciled is changed without being unlocked first.
+	ErrLocked = errors.New("document is locked by a reconciliation")
 )
+
+// FieldError is used to indicate an error with a specific field of a document.
+type FieldError struct {
+	Field string
+	Error string
+}
+
+// ValidationError occurs when the fields of a document break one or more business rules.
+// Unlike struct tag validation it can only be checked by the service layer.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// Error implements the error interface.
+func (ve *ValidationError) Error() string {
+	return "field validation error"
+}
+
+// Add records a problem with a field.
+func (ve *ValidationError) Add(field, message string) {
+	ve.Fields = append(ve.Fields, FieldError{Field: field, Error: message})
+}
+
+// Err returns the ValidationError if any problems were recorded and nil otherwise.
+func (ve *ValidationError) Err() error {
+	if len(ve.Fields) == 0 {
+		return nil
+	}
+	return ve
+}
+
+// ConflictError occurs when a document can NOT be removed because other documents still refer to it.
+type ConflictError struct {
+	Collection string   `json:"collection"` // collection of the documents that refer to it
+	Count      int64    `json:"count"`
+	IDs        []string `json:"_ids"` // _id of the first of them
+}
+
+// Error implements the error interface.
+func (ce *ConflictError) Error() string {
+	return fmt.Sprintf("document is still referenced by %d %s", ce.Count, ce.Collection)
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
 	"github.com/dapperAuteur/dashboard-go-api/internal/utility"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,19 +16,17 @@ import (
 )
 
 // ListNotes gets all the Notes from the db then encodes them in a response client
-func ListNotes(ctx context.Context, db *mongo.Collection) ([]Note, error) {
+// Results are returned one page at a time.
+func ListNotes(ctx context.Context, db *mongo.Collection, page database.Page) ([]Note, *database.PageInfo, error) {
 
 	list := []Note{}
 
-	cursor, err := db.Find(ctx, bson.M{})
+	info, err := database.FindPage(ctx, db, bson.M{}, page, &list)
 	if err != nil {
-		return nil, errors.Wrapf(err, "getting cursor from note collection.")
+		return nil, nil, errors.Wrapf(err, "retrieving note list")
 	}
 
-	if err = cursor.All(ctx, &list); err != nil {
-		return nil, errors.Wrapf(err, "retrieving note list")
-	}
-	return list, nil
+	return list, info, nil
 }
 
 // CreateNote takes data from the client to create a note in the db
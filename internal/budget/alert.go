@@ -0,0 +1,624 @@
+package budget
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/webhook"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EventBudgetThreshold is the event of the deliveries sent when a budget crosses one of its alert thresholds.
+const EventBudgetThreshold = "budget.threshold_crossed"
+
+// Statuses of a WebhookDelivery.
+const (
+	DeliveryPending   = "pending"
+	DeliverySending   = "sending" // claimed by a run of DeliverWebhooks
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// MaxDeliveries is the most deliveries one run of DeliverWebhooks sends.
+const MaxDeliveries = 100
+
+// MaxDeliveryAge is how long a delivery that keeps failing in a way that may pass is tried again before it is marked failed.
+const MaxDeliveryAge = 24 * time.Hour
+
+// deliveryLease is how long a run of DeliverWebhooks holds a delivery it claimed. It covers every attempt of the sender;
+// a delivery still sending after it, e.g. because the instance stopped, is claimed again by a later run.
+const deliveryLease = 10 * time.Minute
+
+// A delivery that failed waits minRetryDelay before it is sent again, doubled after each failed run up to maxRetryDelay.
+const (
+	minRetryDelay = time.Minute
+	maxRetryDelay = time.Hour
+)
+
+// alertEvent is the payload of an EventBudgetThreshold delivery.
+type alertEvent struct {
+	Event string      `json:"event"`
+	Alert BudgetAlert `json:"alert"`
+}
+
+// ListWebhooks gets the webhooks from the db, without their secrets.
+// Results are returned one page at a time.
+func ListWebhooks(ctx context.Context, db *mongo.Collection, page database.Page) ([]Webhook, *database.PageInfo, error) {
+
+	list := []Webhook{}
+
+	info, err := database.FindPage(ctx, db, bson.M{}, page, &list)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "retrieving webhook list")
+	}
+
+	for i := range list {
+		list[i].Secret = ""
+	}
+
+	return list, info, nil
+}
+
+// RetrieveWebhook finds the webhook identified by a given _id, without its secret.
+func RetrieveWebhook(ctx context.Context, db *mongo.Collection, _id string) (*Webhook, error) {
+
+	hook, err := findWebhook(ctx, db, _id)
+	if err != nil {
+		return nil, err
+	}
+
+	hook.Secret = ""
+
+	return hook, nil
+}
+
+// findWebhook finds the webhook identified by a given _id, with its secret.
+func findWebhook(ctx context.Context, db *mongo.Collection, _id string) (*Webhook, error) {
+
+	var hook Webhook
+
+	id, err := primitive.ObjectIDFromHex(_id)
+	if err != nil {
+		return nil, apierror.ErrInvalidID
+	}
+
+	if err := db.FindOne(ctx, bson.M{"_id": id}).Decode(&hook); err != nil {
+		return nil, apierror.ErrNotFound
+	}
+
+	return &hook, nil
+}
+
+// CreateWebhook registers a URL to be sent every budget alert.
+// The secret that signs the deliveries is sent back this once.
+func CreateWebhook(ctx context.Context, db *mongo.Collection, user auth.Claims, newHook NewWebhook, now time.Time) (*Webhook, error) {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return nil, apierror.ErrForbidden
+	}
+
+	verr := apierror.ValidationError{}
+
+	if u, err := url.Parse(newHook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		verr.Add("url", "url must be an absolute http or https URL")
+	}
+
+	if err := verr.Err(); err != nil {
+		return nil, err
+	}
+
+	secret := newHook.Secret
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, errors.Wrap(err, "making webhook secret")
+		}
+		secret = hex.EncodeToString(b)
+	}
+
+	hook := Webhook{
+		ID:        primitive.NewObjectID(),
+		URL:       newHook.URL,
+		Secret:    secret,
+		ManagerID: user.Subject,
+		CreatedAt: now.UTC(),
+		UpdatedAt: now.UTC(),
+	}
+
+	hookResult, err := db.InsertOne(ctx, hook)
+	if err != nil {
+		return nil, errors.Wrapf(err, "inserting webhook %s", hook.URL)
+	}
+	fmt.Println("hookResult : ", hookResult)
+
+	return &hook, nil
+}
+
+// DeleteWebhook removes the webhook identified by a given _id.
+// Its delivery log is kept.
+func DeleteWebhook(ctx context.Context, db *mongo.Collection, user auth.Claims, hookID string) error {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return apierror.ErrForbidden
+	}
+
+	id, err := primitive.ObjectIDFromHex(hookID)
+	if err != nil {
+		return apierror.ErrInvalidID
+	}
+
+	result, err := db.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return errors.Wrapf(err, "deleting webhook %s", hookID)
+	}
+
+	if result.DeletedCount == 0 {
+		return apierror.ErrNotFound
+	}
+
+	return nil
+}
+
+// ListWebhookDeliveries gets the delivery log of the webhook identified by hookID.
+// Results are returned one page at a time.
+func ListWebhookDeliveries(ctx context.Context, db *mongo.Database, hookID string, page database.Page) ([]WebhookDelivery, *database.PageInfo, error) {
+
+	if _, err := findWebhook(ctx, db.Collection(WebhookCollection), hookID); err != nil {
+		return nil, nil, err
+	}
+
+	list := []WebhookDelivery{}
+
+	info, err := database.FindPage(ctx, db.Collection(WebhookDeliveryCollection), bson.M{"webhook_id": hookID}, page, &list)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "retrieving deliveries of webhook %s", hookID)
+	}
+
+	return list, info, nil
+}
+
+// ListBudgetAlerts gets the alerts raised for the budget identified by budgetID.
+// Results are returned one page at a time.
+func ListBudgetAlerts(ctx context.Context, db *mongo.Database, budgetID string, page database.Page) ([]BudgetAlert, *database.PageInfo, error) {
+
+	if _, err := Retrieve(ctx, db.Collection(BudgetCollection), budgetID); err != nil {
+		return nil, nil, err
+	}
+
+	list := []BudgetAlert{}
+
+	info, err := database.FindPage(ctx, db.Collection(BudgetAlertCollection), bson.M{"budget_id": budgetID}, page, &list)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "retrieving alerts of budget %s", budgetID)
+	}
+
+	return list, info, nil
+}
+
+// normalizeThresholds sorts alert thresholds and drops repeated ones.
+func normalizeThresholds(thresholds []float64) []float64 {
+
+	if len(thresholds) == 0 {
+		return nil
+	}
+
+	sorted := append([]float64(nil), thresholds...)
+	sort.Float64s(sorted)
+
+	out := sorted[:1]
+	for _, t := range sorted[1:] {
+		if t != out[len(out)-1] {
+			out = append(out, t)
+		}
+	}
+
+	return out
+}
+
+// checkThresholds records a validation problem for every alert threshold that is NOT a positive percentage.
+func checkThresholds(thresholds []float64, verr *apierror.ValidationError) {
+
+	for i, t := range thresholds {
+		if t <= 0 {
+			verr.Add(fmt.Sprintf("alert_thresholds[%d]", i), "threshold must be a percentage more than 0")
+		}
+	}
+}
+
+// alertBudgets checks the alert thresholds of the budgets of the transactions after they were written.
+// The write already happened, so a failed check does NOT fail it. The budget is marked instead
+// and checked again by CheckFailedAlerts.
+func alertBudgets(ctx context.Context, db *mongo.Database, now time.Time, tranxs ...Transaction) {
+
+	seen := map[string]bool{}
+
+	for _, tranx := range tranxs {
+		for _, id := range append([]string{tranx.BudgetID}, splitBudgetIDs(tranx)...) {
+			if id == "" || seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			err := checkAlerts(ctx, db, id, now)
+			switch {
+			case err == nil:
+			case permanentAlertError(err):
+				fmt.Printf("checking alerts of budget %s, it is NOT checked again : %v\n", id, err)
+			default:
+				fmt.Printf("checking alerts of budget %s, it is checked again later : %v\n", id, err)
+				markFailedAlerts(ctx, db, id, now)
+			}
+		}
+	}
+}
+
+// markFailedAlerts records on the budget identified by budgetID that checking its alerts at now failed.
+// The earliest failed check is kept.
+func markFailedAlerts(ctx context.Context, db *mongo.Database, budgetID string, now time.Time) {
+
+	id, err := primitive.ObjectIDFromHex(budgetID)
+	if err != nil {
+		return
+	}
+
+	update := bson.M{"$min": bson.M{"alert_check_at": now.UTC()}}
+	if _, err := db.Collection(BudgetCollection).UpdateOne(ctx, bson.M{"_id": id}, update); err != nil {
+		fmt.Printf("marking alerts of budget %s to be checked again : %v\n", budgetID, err)
+	}
+}
+
+// CheckFailedAlerts checks the alerts of the budgets whose check failed after a transaction was written, see alertBudgets.
+// Each one is checked as of the failed check, so a threshold crossed in a period that has ended since is still raised,
+// and as of now. The mark is removed once both checks pass, or when one fails in a way that will NOT pass by trying again,
+// see permanentAlertError. It returns the number of budgets checked successfully.
+func CheckFailedAlerts(ctx context.Context, db *mongo.Database, now time.Time) (int, error) {
+
+	budgets := db.Collection(BudgetCollection)
+
+	cursor, err := budgets.Find(ctx, bson.M{"alert_check_at": bson.M{"$exists": true}})
+	if err != nil {
+		return 0, errors.Wrap(err, "finding budgets with failed alert checks")
+	}
+
+	var failed []Budget
+	if err := cursor.All(ctx, &failed); err != nil {
+		return 0, errors.Wrap(err, "decoding budgets with failed alert checks")
+	}
+
+	var checked int
+	var firstErr error
+
+	for _, b := range failed {
+
+		// One budget that still fails does NOT hold up the others.
+		var err error
+		for _, at := range []time.Time{*b.AlertCheckAt, now} {
+			if err = checkAlerts(ctx, db, b.ID.Hex(), at); err != nil {
+				break
+			}
+		}
+		if err != nil && !permanentAlertError(err) {
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "checking alerts of budget %s again", b.ID.Hex())
+			}
+			continue
+		}
+
+		// A check that failed again since keeps the mark.
+		filter := bson.M{"_id": b.ID, "alert_check_at": b.AlertCheckAt}
+		if _, err := budgets.UpdateOne(ctx, filter, bson.M{"$unset": bson.M{"alert_check_at": ""}}); err != nil {
+			return checked, errors.Wrapf(err, "unmarking alerts of budget %s", b.ID.Hex())
+		}
+
+		if err != nil {
+			fmt.Printf("checking alerts of budget %s, it is NOT checked again : %v\n", b.ID.Hex(), err)
+			continue
+		}
+
+		checked++
+	}
+
+	return checked, firstErr
+}
+
+// permanentAlertError reports whether checking the alerts of a budget failed in a way that trying again will NOT fix:
+// its amounts are in more than one currency and the budget has no currency_id, or no rate converts them.
+func permanentAlertError(err error) bool {
+	switch errors.Cause(err) {
+	case ErrMixedCurrencies, ErrNoExchangeRate:
+		return true
+	}
+	return false
+}
+
+// checkAlerts raises an alert for every threshold the budget identified by budgetID has crossed in the period of now,
+// unless it was raised before. Each new alert is queued for delivery to every webhook.
+func checkAlerts(ctx context.Context, db *mongo.Database, budgetID string, now time.Time) error {
+
+	b, err := Retrieve(ctx, db.Collection(BudgetCollection), budgetID)
+	if err != nil {
+		return err
+	}
+
+	if len(b.AlertThresholds) == 0 {
+		return nil
+	}
+
+	usage, err := budgetUsage(ctx, db, *b, now)
+	if err != nil || usage == nil {
+		return err
+	}
+
+	for _, threshold := range b.AlertThresholds {
+
+		if usage.PercentUsed < threshold {
+			break
+		}
+
+		alert := BudgetAlert{
+			BudgetID:    budgetID,
+			BudgetName:  b.BudgetName,
+			Period:      usage.Name,
+			Threshold:   threshold,
+			PercentUsed: usage.PercentUsed,
+			Available:   usage.Available,
+			Spent:       usage.Spent,
+			Received:    usage.Received,
+			CreatedAt:   now.UTC(),
+		}
+
+		raised, err := raiseAlert(ctx, db, &alert)
+		if err != nil {
+			return err
+		}
+
+		if !raised {
+			continue
+		}
+
+		if err := queueDeliveries(ctx, db, alertEvent{Event: EventBudgetThreshold, Alert: alert}, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// budgetUsage works out how much of the budget is used in the period of now.
+// It is nil when now is outside the periods of the budget. A budget without a period is used over all time.
+func budgetUsage(ctx context.Context, db *mongo.Database, b Budget, now time.Time) (*PeriodSummary, error) {
+
+	conv := NewConverter(db, b.CurrencyID)
+
+	if b.Period == "" {
+		totals, err := budgetTotals(ctx, db, []string{b.ID.Hex()}, SummaryWindow{}, conv)
+		if err != nil {
+			return nil, err
+		}
+
+		summary, err := newBudgetSummary(ctx, b, totals[b.ID.Hex()], SummaryWindow{}, conv)
+		if err != nil {
+			return nil, err
+		}
+
+		return &PeriodSummary{
+			Allocated:   summary.BudgetValue,
+			Available:   summary.BudgetValue,
+			Spent:       summary.Spent,
+			Received:    summary.Received,
+			Remaining:   summary.Remaining,
+			PercentUsed: summary.PercentUsed,
+		}, nil
+	}
+
+	from := day(now)
+	summary, err := newPeriodSummary(ctx, db, b, SummaryWindow{From: &from, To: &now}, conv, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(summary.Periods) == 0 {
+		return nil, nil
+	}
+
+	current := summary.Periods[len(summary.Periods)-1]
+
+	return &current, nil
+}
+
+// raiseAlert stores the alert unless one was raised before for the same budget, period and threshold.
+// It reports whether the alert is new.
+func raiseAlert(ctx context.Context, db *mongo.Database, alert *BudgetAlert) (bool, error) {
+
+	filter := bson.M{"budget_id": alert.BudgetID, "period": alert.Period, "threshold": alert.Threshold}
+
+	result, err := db.Collection(BudgetAlertCollection).UpdateOne(ctx, filter, bson.M{"$setOnInsert": alert}, options.Update().SetUpsert(true))
+	if err != nil {
+		return false, errors.Wrapf(err, "raising alert of budget %s", alert.BudgetID)
+	}
+
+	if result.UpsertedID == nil {
+		return false, nil
+	}
+
+	alert.ID, _ = result.UpsertedID.(primitive.ObjectID)
+
+	return true, nil
+}
+
+// queueDeliveries stores a pending delivery of the event for every webhook.
+func queueDeliveries(ctx context.Context, db *mongo.Database, event alertEvent, now time.Time) error {
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "encoding alert event")
+	}
+
+	var hooks []Webhook
+
+	cursor, err := db.Collection(WebhookCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return errors.Wrap(err, "finding webhooks")
+	}
+
+	if err := cursor.All(ctx, &hooks); err != nil {
+		return errors.Wrap(err, "decoding webhooks")
+	}
+
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, len(hooks))
+	for i, hook := range hooks {
+		docs[i] = WebhookDelivery{
+			WebhookID: hook.ID.Hex(),
+			Event:     event.Event,
+			Payload:   payload,
+			Status:    DeliveryPending,
+			CreatedAt: now.UTC(),
+		}
+	}
+
+	if _, err := db.Collection(WebhookDeliveryCollection).InsertMany(ctx, docs); err != nil {
+		return errors.Wrap(err, "queueing webhook deliveries")
+	}
+
+	return nil
+}
+
+// DeliverWebhooks sends up to MaxDeliveries pending deliveries that are due, oldest first, and logs how each one went.
+// Each delivery is claimed before it is sent, so runs on more than one instance, or runs that overlap, send it once.
+// The sender tries each delivery again with backoff. One that still fails stays pending for a later run, see Record.
+// It returns the number of deliveries the receivers accepted.
+func DeliverWebhooks(ctx context.Context, db *mongo.Database, sender webhook.Sender, now time.Time) (int, error) {
+
+	deliveries := db.Collection(WebhookDeliveryCollection)
+
+	var delivered int
+
+	for i := 0; i < MaxDeliveries; i++ {
+
+		claimed, err := claimDelivery(ctx, deliveries, now)
+		if err != nil {
+			return delivered, err
+		}
+		if claimed == nil {
+			break
+		}
+		d, lease := *claimed, *claimed.LeaseUntil
+
+		hook, err := findWebhook(ctx, db.Collection(WebhookCollection), d.WebhookID)
+		switch {
+		case err == apierror.ErrNotFound:
+			d = d.Record(webhook.Result{Err: errors.New("the webhook was deleted")}, now)
+		case err != nil:
+			return delivered, err
+		default:
+			d = d.Record(sender.Send(ctx, hook.URL, hook.Secret, webhook.Message{ID: d.ID.Hex(), Event: d.Event, Body: d.Payload}), now)
+		}
+
+		if d.Status == DeliveryDelivered {
+			delivered++
+		}
+
+		set := bson.M{"status": d.Status, "attempts": d.Attempts, "retries": d.Retries, "response": d.Response, "error": d.Error}
+		unset := bson.M{"lease_until": ""}
+
+		if d.NextAttemptAt != nil {
+			set["next_attempt_at"] = d.NextAttemptAt
+		} else {
+			unset["next_attempt_at"] = ""
+		}
+
+		if d.DeliveredAt != nil {
+			set["delivered_at"] = d.DeliveredAt
+		}
+
+		// Only the run holding the lease logs the result; a run whose lease ran out leaves it to the one that took over.
+		stillClaimed := bson.M{"_id": d.ID, "status": DeliverySending, "lease_until": lease}
+		if _, err := deliveries.UpdateOne(ctx, stillClaimed, bson.M{"$set": set, "$unset": unset}); err != nil {
+			return delivered, errors.Wrapf(err, "logging delivery %s", d.ID.Hex())
+		}
+	}
+
+	return delivered, nil
+}
+
+// claimDelivery marks the oldest delivery that is due as sending until a lease runs out, and returns it, or nil when none is due.
+// Pending deliveries are due once their next attempt is, and sending ones once their lease ran out.
+func claimDelivery(ctx context.Context, deliveries *mongo.Collection, now time.Time) (*WebhookDelivery, error) {
+
+	due := bson.M{"$or": []bson.M{
+		{"status": DeliveryPending, "next_attempt_at": bson.M{"$exists": false}},
+		{"status": DeliveryPending, "next_attempt_at": bson.M{"$lte": now.UTC()}},
+		{"status": DeliverySending, "lease_until": bson.M{"$lte": now.UTC()}},
+	}}
+
+	lease := now.UTC().Add(deliveryLease)
+	update := bson.M{"$set": bson.M{"status": DeliverySending, "lease_until": lease}}
+
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"_id": 1}).SetReturnDocument(options.After)
+
+	var d WebhookDelivery
+	if err := deliveries.FindOneAndUpdate(ctx, due, update, opts).Decode(&d); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "claiming a pending delivery")
+	}
+
+	return &d, nil
+}
+
+// Record returns the delivery with the result of sending it once more logged.
+// A failure that may pass keeps it pending until a later run, after a delay that doubles with each failed run,
+// unless the delivery is older than MaxDeliveryAge. Any other failure marks it failed.
+func (d WebhookDelivery) Record(result webhook.Result, now time.Time) WebhookDelivery {
+
+	d.Attempts += result.Attempts
+	d.Response = result.Status
+	d.NextAttemptAt = nil
+
+	if result.Err == nil {
+		delivered := now.UTC()
+		d.Status = DeliveryDelivered
+		d.DeliveredAt = &delivered
+		d.Error = ""
+		return d
+	}
+
+	d.Error = result.Err.Error()
+	d.Status = DeliveryFailed
+
+	if result.Retry && now.Sub(d.CreatedAt) < MaxDeliveryAge {
+		delay := minRetryDelay << uint(d.Retries)
+		if delay > maxRetryDelay || delay <= 0 {
+			delay = maxRetryDelay
+		}
+		next := now.UTC().Add(delay)
+
+		d.Status = DeliveryPending
+		d.NextAttemptAt = &next
+		d.Retries++
+	}
+
+	return d
+}
@@ -0,0 +1,76 @@
+package budget_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/webhook"
+	pkgerrors "github.com/pkg/errors"
+)
+
+func TestWebhookDeliveryRecord(t *testing.T) {
+	now := time.Date(2020, time.September, 14, 12, 0, 0, 0, time.UTC)
+	failure := errors.New("receiver answered 503")
+
+	tests := []struct {
+		name    string
+		age     time.Duration
+		retries int
+		result  webhook.Result
+		status  string
+		next    time.Duration // wait before the next attempt, 0 for none
+	}{
+		{"accepted", 0, 0, webhook.Result{Attempts: 1, Status: 200}, budget.DeliveryDelivered, 0},
+		{"accepted on a later run", time.Hour, 3, webhook.Result{Attempts: 2, Status: 200}, budget.DeliveryDelivered, 0},
+		{"rejected", 0, 0, webhook.Result{Attempts: 1, Status: 400, Err: failure}, budget.DeliveryFailed, 0},
+		{"receiver down", 0, 0, webhook.Result{Attempts: 5, Status: 503, Err: failure, Retry: true}, budget.DeliveryPending, time.Minute},
+		{"receiver still down", time.Hour, 3, webhook.Result{Attempts: 5, Status: 503, Err: failure, Retry: true}, budget.DeliveryPending, 8 * time.Minute},
+		{"longest wait", 2 * time.Hour, 10, webhook.Result{Attempts: 5, Err: failure, Retry: true}, budget.DeliveryPending, time.Hour},
+		{"too old", budget.MaxDeliveryAge, 20, webhook.Result{Attempts: 5, Status: 503, Err: failure, Retry: true}, budget.DeliveryFailed, 0},
+	}
+
+	for _, tt := range tests {
+		d := budget.WebhookDelivery{Status: budget.DeliveryPending, Attempts: 5 * tt.retries, Retries: tt.retries, CreatedAt: now.Add(-tt.age)}
+
+		got := d.Record(tt.result, now)
+
+		if got.Status != tt.status {
+			t.Fatalf("%s: expected status %q, got %q", tt.name, tt.status, got.Status)
+		}
+		if got.Attempts != d.Attempts+tt.result.Attempts {
+			t.Fatalf("%s: expected %d attempts, got %d", tt.name, d.Attempts+tt.result.Attempts, got.Attempts)
+		}
+		if (got.DeliveredAt != nil) != (tt.status == budget.DeliveryDelivered) {
+			t.Fatalf("%s: expected delivered_at only once delivered, got %v", tt.name, got.DeliveredAt)
+		}
+
+		switch {
+		case tt.next == 0 && got.NextAttemptAt != nil:
+			t.Fatalf("%s: expected no next attempt, got %s", tt.name, got.NextAttemptAt)
+		case tt.next != 0 && (got.NextAttemptAt == nil || !got.NextAttemptAt.Equal(now.Add(tt.next))):
+			t.Fatalf("%s: expected the next attempt after %s, got %v", tt.name, tt.next, got.NextAttemptAt)
+		case tt.next != 0 && got.Retries != tt.retries+1:
+			t.Fatalf("%s: expected %d retries, got %d", tt.name, tt.retries+1, got.Retries)
+		}
+	}
+}
+
+func TestPermanentAlertError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"mixed currencies", budget.ErrMixedCurrencies, true},
+		{"no exchange rate", pkgerrors.Wrap(budget.ErrNoExchangeRate, "EUR/USD on 2020-09-14"), true},
+		{"database down", errors.New("server selection timeout"), false},
+	}
+
+	for _, tt := range tests {
+		if got := budget.PermanentAlertError(tt.err); got != tt.want {
+			t.Fatalf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package budget
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/blob"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrAttachmentTooLarge is used when an uploaded file is larger than allowed.
+var ErrAttachmentTooLarge = errors.New("attachment is larger than allowed")
+
+// NewAttachment is a file uploaded to be kept with a transaction.
+type NewAttachment struct {
+	FileName    string
+	ContentType string // detected from the content when missing or application/octet-stream
+	Content     io.Reader
+}
+
+// AddAttachment stores the uploaded file with the transaction identified by tranxID.
+// The file is read once, and its size and checksum are worked out as it is stored.
+// Files larger than maxSize bytes are NOT kept.
+func AddAttachment(ctx context.Context, db *mongo.Database, store blob.Store, user auth.Claims, tranxID string, upload NewAttachment, maxSize int64, now time.Time) (*Attachment, error) {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return nil, apierror.ErrForbidden
+	}
+
+	if _, err := RetrieveTransaction(ctx, db.Collection(TransactionCollection), tranxID); err != nil {
+		return nil, err
+	}
+
+	fileName := filepath.Base(strings.ReplaceAll(upload.FileName, `\`, "/"))
+	if fileName == "." || fileName == "/" {
+		fileName = "attachment"
+	}
+
+	content := bufio.NewReaderSize(upload.Content, 512)
+
+	contentType := upload.ContentType
+	if contentType == "" || contentType == "application/octet-stream" {
+		head, _ := content.Peek(512)
+		contentType = http.DetectContentType(head)
+	}
+
+	measured := measuredReader{r: content, h: sha256.New(), max: maxSize}
+
+	blobID, err := store.Put(ctx, fileName, &measured)
+	if err != nil {
+		if errors.Cause(err) == ErrAttachmentTooLarge {
+			return nil, ErrAttachmentTooLarge
+		}
+		return nil, errors.Wrapf(err, "storing attachment %q", fileName)
+	}
+
+	attachment := Attachment{
+		ID:            primitive.NewObjectID(),
+		TransactionID: tranxID,
+		FileName:      fileName,
+		ContentType:   contentType,
+		Size:          measured.n,
+		Checksum:      hex.EncodeToString(measured.h.Sum(nil)),
+		BlobID:        blobID,
+		CreatedAt:     now.UTC(),
+	}
+
+	if attachment.Size == 0 {
+		_ = store.Delete(ctx, blobID)
+		return nil, &apierror.ValidationError{Fields: []apierror.FieldError{{Field: "file", Error: "file is empty"}}}
+	}
+
+	if _, err := db.Collection(AttachmentCollection).InsertOne(ctx, attachment); err != nil {
+		_ = store.Delete(ctx, blobID)
+		return nil, errors.Wrapf(err, "inserting attachment : %v", attachment)
+	}
+
+	fmt.Printf("attachment %s of transaction %s stored : %d bytes\n", attachment.ID.Hex(), tranxID, attachment.Size)
+
+	return &attachment, nil
+}
+
+// measuredReader counts and hashes what is read through it, and fails once more than max bytes were read.
+type measuredReader struct {
+	r   io.Reader
+	h   hash.Hash
+	n   int64
+	max int64
+}
+
+func (m *measuredReader) Read(p []byte) (int, error) {
+
+	n, err := m.r.Read(p)
+	m.n += int64(n)
+	m.h.Write(p[:n])
+
+	if m.n > m.max {
+		return n, ErrAttachmentTooLarge
+	}
+
+	return n, err
+}
+
+// ListAttachments gets the attachments of the transaction identified by tranxID, oldest first unless another order is asked for.
+// Results are returned one page at a time.
+func ListAttachments(ctx context.Context, db *mongo.Database, tranxID string, page database.Page) ([]Attachment, *database.PageInfo, error) {
+
+	if _, err := RetrieveTransaction(ctx, db.Collection(TransactionCollection), tranxID); err != nil {
+		return nil, nil, err
+	}
+
+	if page.Sort == "" {
+		page.Sort = "created_at"
+	}
+
+	list := []Attachment{}
+
+	info, err := database.FindPage(ctx, db.Collection(AttachmentCollection), bson.M{"tranx_id": tranxID}, page, &list)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "retrieving attachments of transaction %s", tranxID)
+	}
+
+	return list, info, nil
+}
+
+// RetrieveAttachment finds an attachment of the transaction identified by tranxID.
+// The attachments of a transaction that was deleted are NOT found, even before they are removed.
+func RetrieveAttachment(ctx context.Context, db *mongo.Database, tranxID, attachmentID string) (*Attachment, error) {
+
+	id, err := primitive.ObjectIDFromHex(attachmentID)
+	if err != nil {
+		return nil, apierror.ErrInvalidID
+	}
+
+	if _, err := RetrieveTransaction(ctx, db.Collection(TransactionCollection), tranxID); err != nil {
+		return nil, err
+	}
+
+	var attachment Attachment
+	if err := db.Collection(AttachmentCollection).FindOne(ctx, bson.M{"_id": id, "tranx_id": tranxID}).Decode(&attachment); err != nil {
+		return nil, apierror.ErrNotFound
+	}
+
+	return &attachment, nil
+}
+
+// OpenAttachment finds an attachment of the transaction identified by tranxID and opens its content, which must be closed.
+func OpenAttachment(ctx context.Context, db *mongo.Database, store blob.Store, tranxID, attachmentID string) (*Attachment, io.ReadCloser, error) {
+
+	attachment, err := RetrieveAttachment(ctx, db, tranxID, attachmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content, err := store.Open(ctx, attachment.BlobID)
+	if err == blob.ErrNotFound {
+		return nil, nil, apierror.ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "opening attachment %s", attachmentID)
+	}
+
+	return attachment, content, nil
+}
+
+// DeleteAttachment removes an attachment of the transaction identified by tranxID and its content.
+// The content goes first, so a failure leaves an attachment that can be deleted again rather than a blob nobody refers to.
+func DeleteAttachment(ctx context.Context, db *mongo.Database, store blob.Store, user auth.Claims, tranxID, attachmentID string) error {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return apierror.ErrForbidden
+	}
+
+	attachment, err := RetrieveAttachment(ctx, db, tranxID, attachmentID)
+	if err != nil {
+		return err
+	}
+
+	return removeAttachment(ctx, db, store, *attachment)
+}
+
+// removeAttachment deletes the content of the attachment and then the attachment itself.
+// Content that is already gone is NOT an error.
+func removeAttachment(ctx context.Context, db *mongo.Database, store blob.Store, attachment Attachment) error {
+
+	if err := store.Delete(ctx, attachment.BlobID); err != nil && err != blob.ErrNotFound {
+		return errors.Wrapf(err, "deleting content of attachment %s", attachment.ID.Hex())
+	}
+
+	if _, err := db.Collection(AttachmentCollection).DeleteOne(ctx, bson.M{"_id": attachment.ID}); err != nil {
+		return errors.Wrapf(err, "deleting attachment %s", attachment.ID.Hex())
+	}
+
+	return nil
+}
+
+// removeTransactionAttachments deletes the attachments, and their content, of the transactions identified by tranxIDs.
+// It is called once the transactions are deleted. Failures are only printed; RemoveOrphanedAttachments picks those attachments up later.
+func removeTransactionAttachments(ctx context.Context, db *mongo.Database, store blob.Store, tranxIDs ...string) {
+
+	cursor, err := db.Collection(AttachmentCollection).Find(ctx, bson.M{"tranx_id": bson.M{"$in": tranxIDs}})
+	if err != nil {
+		fmt.Printf("finding attachments of deleted transactions %v : %v\n", tranxIDs, err)
+		return
+	}
+
+	var attachments []Attachment
+	if err := cursor.All(ctx, &attachments); err != nil {
+		fmt.Printf("retrieving attachments of deleted transactions %v : %v\n", tranxIDs, err)
+		return
+	}
+
+	for _, attachment := range attachments {
+		if err := removeAttachment(ctx, db, store, attachment); err != nil {
+			fmt.Printf("removing attachment of deleted transaction %s : %v\n", attachment.TransactionID, err)
+		}
+	}
+}
+
+// RemoveOrphanedAttachments deletes the attachments, and their content, of transactions that no longer exist.
+// Deleting a transaction removes its attachments, but transactions deleted along with e.g. their vendor, or whose
+// attachments could NOT be removed at the time, are cleaned up here.
+// It returns the number of attachments removed.
+func RemoveOrphanedAttachments(ctx context.Context, db *mongo.Database, store blob.Store) (int, error) {
+
+	values, err := db.Collection(AttachmentCollection).Distinct(ctx, "tranx_id", bson.M{})
+	if err != nil {
+		return 0, errors.Wrap(err, "finding transactions of attachments")
+	}
+
+	var tranxIDs []string
+	for _, v := range values {
+		if id, ok := v.(string); ok {
+			tranxIDs = append(tranxIDs, id)
+		}
+	}
+
+	orphans, err := missingIDs(ctx, db, TransactionCollection, tranxIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(orphans) == 0 {
+		return 0, nil
+	}
+
+	cursor, err := db.Collection(AttachmentCollection).Find(ctx, bson.M{"tranx_id": bson.M{"$in": orphans}})
+	if err != nil {
+		return 0, errors.Wrap(err, "getting cursor from attachment collection")
+	}
+
+	var attachments []Attachment
+	if err := cursor.All(ctx, &attachments); err != nil {
+		return 0, errors.Wrap(err, "retrieving orphaned attachments")
+	}
+
+	removed := 0
+	for _, attachment := range attachments {
+		if err := removeAttachment(ctx, db, store, attachment); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,173 @@
+package budget
+
+import (
+	"context"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// withTransaction runs fn in a multi-document transaction so that either every write it makes is kept or none are.
+// The writes inside fn must use the session context it is given.
+// Multi-document transactions need a replica set, e.g. MongoDB Atlas.
+func withTransaction(ctx context.Context, db *mongo.Database, fn func(sc mongo.SessionContext) error) error {
+
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return errors.Wrap(err, "starting session")
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+
+	return err
+}
+
+// balanceDelta is how much a transaction moves the balance of each of its financial accounts.
+func balanceDelta(tranx Transaction) Money {
+	return tranx.TransactionCredit.Sub(tranx.TransactionDebit)
+}
+
+// applyBalance adds delta to the current value of each financial account identified in accountIDs.
+// IDs that are NOT ObjectIDs can NOT reference an account and are skipped.
+func applyBalance(ctx context.Context, db *mongo.Database, accountIDs []string, delta Money, now time.Time) error {
+
+	if delta.IsZero() || len(accountIDs) == 0 {
+		return nil
+	}
+
+	var ids []primitive.ObjectID
+	for _, accountID := range accountIDs {
+		if id, err := primitive.ObjectIDFromHex(accountID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	update := bson.M{
+		"$inc": bson.M{"current_value.amount": delta.Amount},
+		"$set": bson.M{"updated_at": now.UTC()},
+	}
+
+	if _, err := db.Collection(FinancialAccountCollection).UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, update); err != nil {
+		return errors.Wrapf(err, "applying %v to financial accounts %v", delta, accountIDs)
+	}
+
+	return nil
+}
+
+// ledgerNet sums the credits less the debits of every transaction recorded against a financial account.
+func ledgerNet(ctx context.Context, db *mongo.Database, fa FinancialAccount) (Money, error) {
+	return netOf(ctx, db, fa, bson.M{"fin_acc_id": fa.ID.Hex()})
+}
+
+// netOf sums the credits less the debits of the transactions of a financial account that fit the filter.
+// Transactions in another currency than the account, recorded before currencies were checked, are converted
+// at the rate of their day.
+func netOf(ctx context.Context, db *mongo.Database, fa FinancialAccount, filter bson.M) (Money, error) {
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		ledgerGroup(fa.ID.Hex(), "$tranx_credit.amount", "$tranx_debit.amount"),
+	}
+
+	lines, err := ledgerLines(ctx, db, pipeline, NewConverter(db, fa.CurrencyID))
+	if err != nil {
+		return Money{}, errors.Wrapf(err, "summing ledger of financial account %s", fa.ID.Hex())
+	}
+
+	return linesNet(lines, fa.CurrencyID), nil
+}
+
+// linesNet sums the credits less the debits of ledger lines already converted into the currency identified by currencyID.
+func linesNet(lines []ledgerLine, currencyID string) Money {
+
+	net := Money{CurrencyID: currencyID}
+	for _, line := range lines {
+		net = net.Add(line.Credit).Sub(line.Debit)
+	}
+	net.CurrencyID = currencyID
+
+	return net
+}
+
+// accountValue is the value of a financial account whose transactions add up to net: its opening value plus net.
+func accountValue(fa FinancialAccount, net Money) Money {
+
+	value := fa.OpeningValue.Add(net)
+	value.CurrencyID = fa.CurrencyID
+
+	return value
+}
+
+// RecomputeBalance repairs the current value of a financial account from its ledger.
+// The current value is set to the opening value plus the credits less the debits of its transactions.
+func RecomputeBalance(ctx context.Context, db *mongo.Database, user auth.Claims, faID string, now time.Time) (*FinancialAccount, error) {
+
+	faObjectID, err := primitive.ObjectIDFromHex(faID)
+	if err != nil {
+		return nil, apierror.ErrInvalidID
+	}
+
+	foundFA, err := RetrieveFinancialAccount(ctx, db.Collection(FinancialAccountCollection), faID)
+	if err != nil {
+		return nil, apierror.ErrNotFound
+	}
+
+	var (
+		isAdmin = user.HasRole(auth.RoleAdmin)
+		isOwner = foundFA.MangerID == user.Subject
+		canView = isAdmin || isOwner
+	)
+
+	if !canView {
+		return nil, apierror.ErrForbidden
+	}
+
+	err = withTransaction(ctx, db, func(sc mongo.SessionContext) error {
+		return recomputeBalance(sc, db, faObjectID, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return RetrieveFinancialAccount(ctx, db.Collection(FinancialAccountCollection), faID)
+}
+
+// recomputeBalance sets the current value of a financial account to its opening value plus its ledger.
+// Run it inside withTransaction so a transaction written at the same time can NOT be lost.
+func recomputeBalance(ctx context.Context, db *mongo.Database, faObjectID primitive.ObjectID, now time.Time) error {
+
+	fa, err := RetrieveFinancialAccount(ctx, db.Collection(FinancialAccountCollection), faObjectID.Hex())
+	if err != nil {
+		return err
+	}
+
+	net, err := ledgerNet(ctx, db, *fa)
+	if err != nil {
+		return err
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"current_value": accountValue(*fa, net),
+			"updated_at":    now.UTC(),
+		},
+	}
+
+	if _, err := db.Collection(FinancialAccountCollection).UpdateOne(ctx, bson.M{"_id": faObjectID}, update); err != nil {
+		return errors.Wrapf(err, "recomputing balance of financial account %s", faObjectID.Hex())
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package budget_test
+
+import (
+	"testing"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBalanceDelta(t *testing.T) {
+	tests := []struct {
+		name          string
+		credit, debit string
+		want          string
+	}{
+		{"debit", "0", "12.50", "-12.50"},
+		{"credit", "100", "0", "100"},
+		{"both", "30", "12.25", "17.75"},
+		{"neither", "0", "0", "0"},
+	}
+
+	for _, tt := range tests {
+		tranx := budget.Transaction{TransactionCredit: money(t, tt.credit), TransactionDebit: money(t, tt.debit)}
+
+		if got := budget.BalanceDelta(tranx); got.Cmp(money(t, tt.want)) != 0 {
+			t.Fatalf("%s: expected a delta of %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestRecomputedValue(t *testing.T) {
+	pair := func(credit, debit string) [2]budget.Money { return [2]budget.Money{money(t, credit), money(t, debit)} }
+
+	tests := []struct {
+		name    string
+		opening string
+		lines   [][2]budget.Money
+		want    string
+	}{
+		{"no transactions", "250", nil, "250"},
+		{"no opening value", "0", [][2]budget.Money{pair("100", "0"), pair("0", "40.10")}, "59.90"},
+		{"several days", "1000", [][2]budget.Money{pair("0", "900"), pair("1200", "310"), pair("0.01", "0")}, "990.01"},
+		{"overdrawn", "20", [][2]budget.Money{pair("0", "45")}, "-25"},
+	}
+
+	for _, tt := range tests {
+		fa := budget.FinancialAccount{OpeningValue: money(t, tt.opening), CurrencyID: "usd"}
+
+		net := budget.LinesNet(fa.CurrencyID, tt.lines...)
+		got := budget.AccountValue(fa, net)
+
+		if got.Cmp(money(t, tt.want)) != 0 {
+			t.Fatalf("%s: expected a value of %s, got %s", tt.name, tt.want, got)
+		}
+		if got.CurrencyID != "usd" {
+			t.Fatalf("%s: expected the value in the currency of the account, got %q", tt.name, got.CurrencyID)
+		}
+	}
+}
+
+func TestCurrencyMismatches(t *testing.T) {
+	usd := budget.FinancialAccount{ID: primitive.NewObjectID(), CurrencyID: "usd"}
+	eur := budget.FinancialAccount{ID: primitive.NewObjectID(), CurrencyID: "eur"}
+	none := budget.FinancialAccount{ID: primitive.NewObjectID()}
+
+	tests := []struct {
+		name       string
+		currencyID string
+		accounts   []budget.FinancialAccount
+		want       int
+	}{
+		{"same currency", "usd", []budget.FinancialAccount{usd}, 0},
+		{"other currency", "usd", []budget.FinancialAccount{eur}, 1},
+		{"one of two", "eur", []budget.FinancialAccount{usd, eur}, 1},
+		{"account without a currency", "usd", []budget.FinancialAccount{none}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := budget.CurrencyMismatches(tt.currencyID, tt.accounts); len(got) != tt.want {
+			t.Fatalf("%s: expected %d mismatched accounts, got %d", tt.name, tt.want, len(got))
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson" // for BSON ObjectID
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -14,20 +15,17 @@ import (
 )
 
 // List gets all the Budgets from the db then encodes them in a response client
-func List(ctx context.Context, db *mongo.Collection) ([]Budget, error) {
+// Results are returned one page at a time.
+func List(ctx context.Context, db *mongo.Collection, page database.Page) ([]Budget, *database.PageInfo, error) {
+
 	list := []Budget{}
 
-	cursor, err := db.Find(ctx, bson.M{})
+	info, err := database.FindPage(ctx, db, bson.M{}, page, &list)
 	if err != nil {
-		return nil, errors.Wrapf(err, "getting cursor from budget db. retrieving budget list")
-	}
-
-	if err = cursor.All(ctx, &list); err != nil {
-		return nil, errors.Wrapf(err, "retrieving budget list")
+		return nil, nil, errors.Wrapf(err, "retrieving budget list")
 	}
 
-	return list, nil
-
+	return list, info, nil
 }
 
 // Retrieve finds the budget identified by a given _id.
@@ -74,11 +72,44 @@ func Create(ctx context.Context, db *mongo.Collection, user auth.Claims, newBudg
 	}
 
 	budget := Budget{
-		ManagerID:   user.Subject,
-		BudgetName:  newBudget.BudgetName,
-		BudgetValue: newBudget.BudgetValue,
-		CreatedAt:   now.UTC(),
-		UpdatedAt:   now.UTC(),
+		ManagerID:       user.Subject,
+		BudgetName:      newBudget.BudgetName,
+		BudgetValue:     Money{Amount: newBudget.BudgetValue.Amount, CurrencyID: newBudget.CurrencyID},
+		CurrencyID:      newBudget.CurrencyID,
+		Period:          newBudget.Period,
+		Allocations:     withAllocationCurrency(newBudget.Allocations, newBudget.CurrencyID),
+		Rollover:        newBudget.Rollover,
+		AlertThresholds: normalizeThresholds(newBudget.AlertThresholds),
+		CreatedAt:       now.UTC(),
+		UpdatedAt:       now.UTC(),
+	}
+
+	verr := apierror.ValidationError{}
+
+	if newBudget.Start != "" {
+		start, err := parseRecurringDate(newBudget.Start)
+		if err != nil {
+			verr.Add("start", err.Error())
+		}
+		budget.Start = &start
+	} else if budget.Period != "" {
+		start := currentPeriodStart(budget.Period, now)
+		budget.Start = &start
+	}
+
+	if newBudget.End != "" {
+		end, err := parseRecurringDate(newBudget.End)
+		if err != nil {
+			verr.Add("end", err.Error())
+		}
+		budget.End = &end
+	}
+
+	checkBudgetPeriod(budget, &verr)
+	checkThresholds(budget.AlertThresholds, &verr)
+
+	if err := verr.Err(); err != nil {
+		return nil, err
 	}
 
 	budgetResult, err := db.InsertOne(ctx, budget)
@@ -116,22 +147,109 @@ func UpdateOne(ctx context.Context, db *mongo.Collection, user auth.Claims, budg
 		return apierror.ErrForbidden
 	}
 
-	budget := Budget{}
+	// merged is the budget as it will be after the update, to check the period settings together.
+	merged := *foundBudget
+	set := bson.M{}
+	unset := bson.M{}
 
 	if updateBudget.BudgetName != nil {
-		budget.BudgetName = *updateBudget.BudgetName
+		set["budget_name"] = *updateBudget.BudgetName
+	}
+
+	currencyID := foundBudget.CurrencyID
+	if updateBudget.CurrencyID != nil {
+		currencyID = *updateBudget.CurrencyID
+		set["currency_id"] = currencyID
+		set["budget_value"] = Money{Amount: foundBudget.BudgetValue.Amount, CurrencyID: currencyID}
+		merged.Allocations = withAllocationCurrency(foundBudget.Allocations, currencyID)
+		if len(merged.Allocations) > 0 {
+			set["allocations"] = merged.Allocations
+		}
 	}
 
 	if updateBudget.BudgetValue != nil {
-		budget.BudgetValue = *updateBudget.BudgetValue
+		set["budget_value"] = Money{Amount: updateBudget.BudgetValue.Amount, CurrencyID: currencyID}
+	}
+
+	verr := apierror.ValidationError{}
+
+	if updateBudget.Period != nil {
+		merged.Period = *updateBudget.Period
+		if merged.Period == "" {
+			unset["period"] = ""
+		} else {
+			set["period"] = merged.Period
+		}
+	}
+
+	if updateBudget.Start != nil {
+		start, err := parseRecurringDate(*updateBudget.Start)
+		if err != nil {
+			verr.Add("start", err.Error())
+		}
+		merged.Start = &start
+		set["start"] = start
+	}
+
+	if updateBudget.End != nil {
+		merged.End = nil
+		if *updateBudget.End == "" {
+			unset["end"] = ""
+		} else {
+			end, err := parseRecurringDate(*updateBudget.End)
+			if err != nil {
+				verr.Add("end", err.Error())
+			}
+			merged.End = &end
+			set["end"] = end
+		}
+	}
+
+	if updateBudget.Allocations != nil {
+		merged.Allocations = withAllocationCurrency(*updateBudget.Allocations, currencyID)
+		if len(merged.Allocations) == 0 {
+			delete(set, "allocations")
+			unset["allocations"] = ""
+		} else {
+			set["allocations"] = merged.Allocations
+		}
+	}
+
+	if updateBudget.Rollover != nil {
+		merged.Rollover = *updateBudget.Rollover
+		set["rollover"] = merged.Rollover
 	}
 
-	budget.ID = budgetObjectID
+	if updateBudget.AlertThresholds != nil {
+		thresholds := normalizeThresholds(*updateBudget.AlertThresholds)
+		checkThresholds(thresholds, &verr)
+		if len(thresholds) == 0 {
+			unset["alert_thresholds"] = ""
+		} else {
+			set["alert_thresholds"] = thresholds
+		}
+	}
+
+	if merged.Period != "" && merged.Start == nil {
+		start := currentPeriodStart(merged.Period, now)
+		merged.Start = &start
+		set["start"] = start
+	}
 
-	budget.UpdatedAt = now
+	checkBudgetPeriod(merged, &verr)
+
+	if err := verr.Err(); err != nil {
+		return err
+	}
+
+	set["updated_at"] = now
 
 	updateB := bson.M{
-		"$set": budget,
+		"$set": set,
+	}
+
+	if len(unset) > 0 {
+		updateB["$unset"] = unset
 	}
 
 	budgetResult, err := db.UpdateOne(ctx, bson.M{"_id": budgetObjectID}, updateB)
@@ -145,14 +263,15 @@ func UpdateOne(ctx context.Context, db *mongo.Collection, user auth.Claims, budg
 }
 
 // Delete removes the budget identified by a given _id
-func Delete(ctx context.Context, db *mongo.Collection, user auth.Claims, budgetID string) error {
+// Transactions of the budget block the delete unless the rule cascades or reassigns them.
+func Delete(ctx context.Context, db *mongo.Database, user auth.Claims, budgetID string, rule DeleteRule, now time.Time) error {
 
 	budgetObjectID, err := primitive.ObjectIDFromHex(budgetID)
 	if err != nil {
 		return apierror.ErrInvalidID
 	}
 
-	foundBudget, err := Retrieve(ctx, db, budgetID)
+	foundBudget, err := Retrieve(ctx, db.Collection(BudgetCollection), budgetID)
 	if err != nil {
 		return apierror.ErrNotFound
 	}
@@ -167,12 +286,19 @@ func Delete(ctx context.Context, db *mongo.Collection, user auth.Claims, budgetI
 		return apierror.ErrForbidden
 	}
 
-	result, err := db.DeleteOne(ctx, bson.M{"_id": budgetObjectID})
-	if err != nil {
-		return errors.Wrapf(err, "deleting budget %s", budgetID)
-	}
+	return withTransaction(ctx, db, func(sc mongo.SessionContext) error {
 
-	fmt.Print("result of deleting : ", result)
+		if err := resolveDependents(sc, db, budgetRef, budgetID, rule, now); err != nil {
+			return err
+		}
 
-	return nil
+		result, err := db.Collection(BudgetCollection).DeleteOne(sc, bson.M{"_id": budgetObjectID})
+		if err != nil {
+			return errors.Wrapf(err, "deleting budget %s", budgetID)
+		}
+
+		fmt.Print("result of deleting : ", result)
+
+		return nil
+	})
 }
@@ -0,0 +1,249 @@
+package budget
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Intervals a cash flow report can be broken into.
+const (
+	IntervalMonth = "month"
+	IntervalYear  = "year"
+)
+
+// Groupings of a cash flow report. Without one there is a single series for all transactions.
+const (
+	GroupByBudget      = "budget"
+	GroupByVendor      = "vendor"
+	GroupByAccount     = "account"
+	GroupByParticipant = "participant"
+	GroupByTag         = "tag"
+)
+
+// CashFlowQuery describes a cash flow report.
+type CashFlowQuery struct {
+	Interval   string        // IntervalMonth or IntervalYear, month when empty
+	GroupBy    string        // one of the GroupBy values, or empty
+	CurrencyID string        // amounts are converted into this currency unless it is empty
+	Window     SummaryWindow // limits the transactions by occurrence
+	Tags       []string      // limits the transactions to those with every one of these tags
+}
+
+// CashFlowReport is the income, expenses and net of transactions per period.
+// There is one series for each group, and one for each currency of a group when no currency was requested.
+// Every series has the same periods, without gaps, so they chart side by side.
+type CashFlowReport struct {
+	Interval   string           `json:"interval"`
+	GroupBy    string           `json:"group_by,omitempty"`
+	CurrencyID string           `json:"currency_id,omitempty"`
+	Periods    []string         `json:"periods"`
+	Series     []CashFlowSeries `json:"series"`
+	Window     SummaryWindow    `json:"window"`
+	Tags       []string         `json:"tags,omitempty"`
+}
+
+// CashFlowSeries is the cash flow of one group in one currency.
+type CashFlowSeries struct {
+	Key        string           `json:"key,omitempty"` // _id of the budget, vendor, account or participant, or the tag; empty for transactions without one
+	CurrencyID string           `json:"currency_id,omitempty"`
+	Income     Money            `json:"income"`   // sum of credits over all periods
+	Expenses   Money            `json:"expenses"` // sum of debits over all periods
+	Net        Money            `json:"net"`      // income less expenses
+	Periods    []CashFlowPeriod `json:"periods"`
+}
+
+// CashFlowPeriod is the cash flow of one group within a month or year.
+type CashFlowPeriod struct {
+	Period   string `json:"period"`   // YYYY-MM by month, YYYY by year
+	Income   Money  `json:"income"`   // sum of credits
+	Expenses Money  `json:"expenses"` // sum of debits
+	Net      Money  `json:"net"`      // income less expenses
+}
+
+// CashFlow reports the income, expenses and net of transactions per month or year, grouped as the query asks.
+// Transfers are left out as they only move money between accounts, as are transactions without an occurrence date.
+// A split transaction counts toward the budget of each split, and a transaction with several accounts,
+// participants or tags counts toward each of them.
+func CashFlow(ctx context.Context, db *mongo.Database, q CashFlowQuery) (*CashFlowReport, error) {
+
+	verr := apierror.ValidationError{}
+
+	if q.Interval == "" {
+		q.Interval = IntervalMonth
+	}
+
+	if q.Interval != IntervalMonth && q.Interval != IntervalYear {
+		verr.Add("interval", "interval must be month or year")
+	}
+
+	pipeline, err := cashFlowPipeline(q)
+	if err != nil {
+		verr.Add("group_by", err.Error())
+	}
+
+	if err := verr.Err(); err != nil {
+		return nil, err
+	}
+
+	lines, err := ledgerLines(ctx, db, pipeline, NewConverter(db, q.CurrencyID))
+	if err != nil {
+		return nil, err
+	}
+
+	type seriesKey struct{ key, currencyID string }
+	sums := map[seriesKey]map[string]CashFlowPeriod{}
+	var first, last time.Time
+
+	for _, line := range lines {
+
+		start := periodStart(line.at, q.Interval)
+		if first.IsZero() || start.Before(first) {
+			first = start
+		}
+		if start.After(last) {
+			last = start
+		}
+
+		sk := seriesKey{key: line.ID.Key, currencyID: line.Credit.CurrencyID}
+		if sums[sk] == nil {
+			sums[sk] = map[string]CashFlowPeriod{}
+		}
+
+		name := periodName(start, q.Interval)
+		p := sums[sk][name]
+		p.Income = p.Income.Add(line.Credit)
+		p.Expenses = p.Expenses.Add(line.Debit)
+		sums[sk][name] = p
+	}
+
+	if q.Window.From != nil {
+		first = periodStart(*q.Window.From, q.Interval)
+	}
+	if q.Window.To != nil {
+		last = periodStart(*q.Window.To, q.Interval)
+	}
+
+	report := CashFlowReport{
+		Interval:   q.Interval,
+		GroupBy:    q.GroupBy,
+		CurrencyID: q.CurrencyID,
+		Periods:    []string{},
+		Series:     []CashFlowSeries{},
+		Window:     q.Window,
+		Tags:       normalizeTags(q.Tags),
+	}
+
+	if len(sums) > 0 {
+		for t := first; !t.After(last); t = nextPeriod(t, q.Interval) {
+			report.Periods = append(report.Periods, periodName(t, q.Interval))
+		}
+	}
+
+	for sk, periods := range sums {
+
+		series := CashFlowSeries{Key: sk.key, CurrencyID: sk.currencyID, Periods: make([]CashFlowPeriod, len(report.Periods))}
+
+		for i, name := range report.Periods {
+			p := periods[name]
+			p.Period = name
+			p.Net = p.Income.Sub(p.Expenses)
+			series.Periods[i] = p
+
+			series.Income = series.Income.Add(p.Income)
+			series.Expenses = series.Expenses.Add(p.Expenses)
+		}
+
+		series.Net = series.Income.Sub(series.Expenses)
+		report.Series = append(report.Series, series)
+	}
+
+	sort.Slice(report.Series, func(i, j int) bool {
+		if report.Series[i].Key != report.Series[j].Key {
+			return report.Series[i].Key < report.Series[j].Key
+		}
+		return report.Series[i].CurrencyID < report.Series[j].CurrencyID
+	})
+
+	return &report, nil
+}
+
+// cashFlowPipeline builds the pipeline that sums the transactions of a cash flow report per group, currency and day.
+func cashFlowPipeline(q CashFlowQuery) (mongo.Pipeline, error) {
+
+	match := bson.M{
+		"transfer_id": bson.M{"$exists": false},
+		"occurrence":  bson.M{"$type": "date"},
+	}
+	if r := dateRangeQuery(q.Window.From, q.Window.To); r != nil {
+		match["$and"] = []bson.M{{"occurrence": r}}
+	}
+	if r := tagsQuery(q.Tags, nil); r != nil {
+		match["tags"] = r
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: match}}}
+
+	// unwind keeps transactions whose array is empty or missing, so they are reported without a key.
+	unwind := func(path string) bson.D {
+		return bson.D{{Key: "$unwind", Value: bson.M{"path": path, "preserveNullAndEmptyArrays": true}}}
+	}
+
+	switch q.GroupBy {
+	case GroupByBudget:
+		pipeline = append(pipeline, budgetLines()...)
+		return append(pipeline, ledgerGroup("$lines.budget_id", "$lines.credit", "$lines.debit")), nil
+	case GroupByVendor:
+		return append(pipeline, ledgerGroup("$vendor_id", "$tranx_credit.amount", "$tranx_debit.amount")), nil
+	case GroupByAccount:
+		pipeline = append(pipeline, unwind("$fin_acc_id"))
+		return append(pipeline, ledgerGroup("$fin_acc_id", "$tranx_credit.amount", "$tranx_debit.amount")), nil
+	case GroupByParticipant:
+		pipeline = append(pipeline, unwind("$participant_id"))
+		return append(pipeline, ledgerGroup("$participant_id", "$tranx_credit.amount", "$tranx_debit.amount")), nil
+	case GroupByTag:
+		pipeline = append(pipeline, unwind("$tags"))
+		return append(pipeline, ledgerGroup("$tags", "$tranx_credit.amount", "$tranx_debit.amount")), nil
+	case "":
+		return append(pipeline, ledgerGroup(nil, "$tranx_credit.amount", "$tranx_debit.amount")), nil
+	default:
+		return nil, errors.New("group_by must be budget, vendor, account, participant or tag")
+	}
+}
+
+// periodStart returns the first day of the month or year of t.
+func periodStart(t time.Time, interval string) time.Time {
+
+	t = t.UTC()
+
+	if interval == IntervalYear {
+		return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// nextPeriod returns the first day of the period after the one starting at start.
+func nextPeriod(start time.Time, interval string) time.Time {
+
+	if interval == IntervalYear {
+		return start.AddDate(1, 0, 0)
+	}
+
+	return start.AddDate(0, 1, 0)
+}
+
+// periodName names the period starting at start as YYYY-MM, or YYYY by year.
+func periodName(start time.Time, interval string) string {
+
+	if interval == IntervalYear {
+		return start.Format("2006")
+	}
+
+	return start.Format("2006-01")
+}
@@ -0,0 +1,99 @@
+package budget_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/google/go-cmp/cmp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// stageOps lists the operator of each stage of a pipeline.
+func stageOps(pipeline mongo.Pipeline) []string {
+	ops := make([]string, len(pipeline))
+	for i, stage := range pipeline {
+		ops[i] = stage[0].Key
+	}
+	return ops
+}
+
+func TestCashFlowPipelineStages(t *testing.T) {
+	tests := []struct {
+		groupBy string
+		ops     []string
+		unwind  interface{} // path of the $unwind stage, nil without one
+		key     interface{} // what the $group stage groups by
+	}{
+		{"", []string{"$match", "$group"}, nil, nil},
+		{budget.GroupByBudget, []string{"$match", "$project", "$unwind", "$group"}, "$lines", "$lines.budget_id"},
+		{budget.GroupByVendor, []string{"$match", "$group"}, nil, "$vendor_id"},
+		{budget.GroupByAccount, []string{"$match", "$unwind", "$group"}, "$fin_acc_id", "$fin_acc_id"},
+		{budget.GroupByParticipant, []string{"$match", "$unwind", "$group"}, "$participant_id", "$participant_id"},
+		{budget.GroupByTag, []string{"$match", "$unwind", "$group"}, "$tags", "$tags"},
+	}
+
+	for _, tt := range tests {
+		pipeline, err := budget.CashFlowPipeline(budget.CashFlowQuery{GroupBy: tt.groupBy})
+		if err != nil {
+			t.Fatalf("group by %q: %v", tt.groupBy, err)
+		}
+
+		if diff := cmp.Diff(tt.ops, stageOps(pipeline)); diff != "" {
+			t.Fatalf("group by %q: stages did not match expected. Diff:\n%s", tt.groupBy, diff)
+		}
+
+		var unwind interface{}
+		for _, stage := range pipeline {
+			if stage[0].Key != "$unwind" {
+				continue
+			}
+			switch v := stage[0].Value.(type) {
+			case bson.M:
+				unwind = v["path"]
+				if v["preserveNullAndEmptyArrays"] != true {
+					t.Fatalf("group by %q: transactions without a %v are dropped", tt.groupBy, v["path"])
+				}
+			default:
+				unwind = v
+			}
+		}
+		if unwind != tt.unwind {
+			t.Fatalf("group by %q: expected to unwind %v, got %v", tt.groupBy, tt.unwind, unwind)
+		}
+
+		group := pipeline[len(pipeline)-1][0].Value.(bson.M)
+		id := group["_id"].(bson.M)
+		if id["key"] != tt.key || id["currency_id"] != "$currency_id" {
+			t.Fatalf("group by %q: unexpected group _id %v", tt.groupBy, id)
+		}
+	}
+}
+
+func TestCashFlowPipelineMatch(t *testing.T) {
+	from := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2020, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+	q := budget.CashFlowQuery{Window: budget.SummaryWindow{From: &from, To: &to}, Tags: []string{"Food"}}
+
+	pipeline, err := budget.CashFlowPipeline(q)
+	if err != nil {
+		t.Fatalf("building pipeline: %v", err)
+	}
+
+	want := bson.M{
+		"transfer_id": bson.M{"$exists": false},
+		"occurrence":  bson.M{"$type": "date"},
+		"$and":        []bson.M{{"occurrence": bson.M{"$gte": from, "$lte": to}}},
+		"tags":        "food",
+	}
+
+	if diff := cmp.Diff(want, pipeline[0][0].Value); diff != "" {
+		t.Fatalf("$match stage did not match expected. Diff:\n%s", diff)
+	}
+
+	if _, err := budget.CashFlowPipeline(budget.CashFlowQuery{GroupBy: "currency"}); err == nil {
+		t.Fatalf("expected grouping by currency to be rejected")
+	}
+}
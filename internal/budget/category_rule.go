@@ -0,0 +1,485 @@
+package budget
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
+	"github.com/dapperAuteur/dashboard-go-api/internal/utility"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ReapplyOptions says how ReapplyCategoryRules treats the transactions it finds.
+type ReapplyOptions struct {
+	DryRun    bool // report the changes without saving them
+	Overwrite bool // replace a budget_id that is already set
+}
+
+// RuleChange is what applying the category rules changes about one transaction.
+type RuleChange struct {
+	TransactionID     string   `json:"tranx_id"`
+	RuleIDs           []string `json:"rule_ids"` // rules that matched, in the order they were applied
+	PreviousBudgetID  string   `json:"previous_budget_id,omitempty"`
+	BudgetID          string   `json:"budget_id,omitempty"` // set when the budget changes
+	AddedParticipants []string `json:"added_participant_id,omitempty"`
+	AddedTags         []string `json:"added_tags,omitempty"`
+}
+
+// RuleReport lists the changes made, or that would be made by a dry run, when category rules are applied again.
+type RuleReport struct {
+	DryRun  bool         `json:"dry_run"`
+	Checked int          `json:"checked"` // transactions that fit the filter
+	Changed int          `json:"changed"`
+	Changes []RuleChange `json:"changes"`
+}
+
+// ListCategoryRules gets the category rules from the db.
+// Results are returned one page at a time.
+func ListCategoryRules(ctx context.Context, db *mongo.Collection, page database.Page) ([]CategoryRule, *database.PageInfo, error) {
+
+	list := []CategoryRule{}
+
+	info, err := database.FindPage(ctx, db, bson.M{}, page, &list)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "retrieving category rule list")
+	}
+
+	return list, info, nil
+}
+
+// RetrieveCategoryRule finds the category rule identified by a given _id.
+func RetrieveCategoryRule(ctx context.Context, db *mongo.Collection, _id string) (*CategoryRule, error) {
+
+	var rule CategoryRule
+
+	id, err := primitive.ObjectIDFromHex(_id)
+	if err != nil {
+		return nil, apierror.ErrInvalidID
+	}
+
+	if err := db.FindOne(ctx, bson.M{"_id": id}).Decode(&rule); err != nil {
+		return nil, apierror.ErrNotFound
+	}
+
+	return &rule, nil
+}
+
+// CreateCategoryRule stores a rule that is applied to every transaction created or imported from now on.
+func CreateCategoryRule(ctx context.Context, db *mongo.Database, user auth.Claims, newRule NewCategoryRule, now time.Time) (*CategoryRule, error) {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return nil, apierror.ErrForbidden
+	}
+
+	rule := CategoryRule{
+		ID:            primitive.NewObjectID(),
+		RuleName:      newRule.RuleName,
+		Priority:      newRule.Priority,
+		VendorID:      newRule.VendorID,
+		EventPattern:  newRule.EventPattern,
+		AmountMin:     newRule.AmountMin,
+		AmountMax:     newRule.AmountMax,
+		BudgetID:      newRule.BudgetID,
+		ParticipantID: utility.RemoveDuplicateStringValues(newRule.ParticipantID),
+		Tags:          normalizeTags(newRule.Tags),
+		CreatedAt:     now.UTC(),
+		UpdatedAt:     now.UTC(),
+	}
+
+	if err := checkCategoryRule(ctx, db, rule); err != nil {
+		return nil, err
+	}
+
+	ruleResult, err := db.Collection(CategoryRuleCollection).InsertOne(ctx, rule)
+	if err != nil {
+		return nil, errors.Wrapf(err, "inserting category rule : %v", rule)
+	}
+
+	fmt.Println("ruleResult : ", ruleResult)
+
+	return &rule, nil
+}
+
+// UpdateOneCategoryRule modifies a category rule.
+// It will error if the specified _id is invalid or does NOT reference an existing category rule.
+// Transactions that already exist are NOT changed, see ReapplyCategoryRules.
+func UpdateOneCategoryRule(ctx context.Context, db *mongo.Database, user auth.Claims, ruleID string, updateRule UpdateCategoryRule, now time.Time) error {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return apierror.ErrForbidden
+	}
+
+	ruleCollection := db.Collection(CategoryRuleCollection)
+
+	rule, err := RetrieveCategoryRule(ctx, ruleCollection, ruleID)
+	if err != nil {
+		return err
+	}
+
+	if updateRule.RuleName != nil {
+		rule.RuleName = *updateRule.RuleName
+	}
+
+	if updateRule.Priority != nil {
+		rule.Priority = *updateRule.Priority
+	}
+
+	if updateRule.VendorID != nil {
+		rule.VendorID = *updateRule.VendorID
+	}
+
+	if updateRule.EventPattern != nil {
+		rule.EventPattern = *updateRule.EventPattern
+	}
+
+	// A zero bound can NOT narrow the amounts of transactions, so it removes the bound.
+	if updateRule.AmountMin != nil {
+		rule.AmountMin = updateRule.AmountMin
+		if rule.AmountMin.IsZero() {
+			rule.AmountMin = nil
+		}
+	}
+
+	if updateRule.AmountMax != nil {
+		rule.AmountMax = updateRule.AmountMax
+		if rule.AmountMax.IsZero() {
+			rule.AmountMax = nil
+		}
+	}
+
+	if updateRule.BudgetID != nil {
+		rule.BudgetID = *updateRule.BudgetID
+	}
+
+	if updateRule.ParticipantID != nil {
+		rule.ParticipantID = utility.RemoveDuplicateStringValues(*updateRule.ParticipantID)
+	}
+
+	if updateRule.Tags != nil {
+		rule.Tags = normalizeTags(*updateRule.Tags)
+	}
+
+	if err := checkCategoryRule(ctx, db, *rule); err != nil {
+		return err
+	}
+
+	rule.UpdatedAt = now.UTC()
+
+	// The whole rule is replaced so removed conditions and assignments are removed from the document too.
+	ruleResult, err := ruleCollection.ReplaceOne(ctx, bson.M{"_id": rule.ID}, rule)
+	if err != nil {
+		return errors.Wrap(err, "updating category rule")
+	}
+
+	fmt.Printf("ruleResult updated %v : \n", ruleResult)
+
+	return nil
+}
+
+// DeleteCategoryRule removes the category rule identified by a given _id.
+// Transactions it already categorized keep their budget, participants and tags.
+func DeleteCategoryRule(ctx context.Context, db *mongo.Collection, user auth.Claims, ruleID string) error {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return apierror.ErrForbidden
+	}
+
+	ruleObjectID, err := primitive.ObjectIDFromHex(ruleID)
+	if err != nil {
+		return apierror.ErrInvalidID
+	}
+
+	result, err := db.DeleteOne(ctx, bson.M{"_id": ruleObjectID})
+	if err != nil {
+		return errors.Wrapf(err, "deleting category rule %s", ruleID)
+	}
+
+	if result.DeletedCount == 0 {
+		return apierror.ErrNotFound
+	}
+
+	return nil
+}
+
+// ReapplyCategoryRules applies the category rules to the existing transactions that fit the filter.
+// A dry run reports what would change without saving anything. Reconciled transactions are left as they are.
+func ReapplyCategoryRules(ctx context.Context, db *mongo.Database, user auth.Claims, filterTranx FilterTransaction, opts ReapplyOptions, now time.Time) (*RuleReport, error) {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return nil, apierror.ErrForbidden
+	}
+
+	rules, err := loadCategoryRules(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	tranxCollection := db.Collection(TransactionCollection)
+
+	unlocked := false
+	filterTranx.Locked = &unlocked
+
+	cursor, err := tranxCollection.Find(ctx, filterTranx.Query())
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cursor from transaction collection")
+	}
+	defer cursor.Close(ctx)
+
+	report := RuleReport{DryRun: opts.DryRun, Changes: []RuleChange{}}
+
+	// Transactions moved to another budget, whose alerts are checked at the end.
+	var moved []Transaction
+
+	for cursor.Next(ctx) {
+
+		var tranx Transaction
+		if err := cursor.Decode(&tranx); err != nil {
+			return nil, errors.Wrap(err, "decoding transaction")
+		}
+		report.Checked++
+
+		before := tranx
+		if opts.Overwrite && len(tranx.Splits) == 0 {
+			tranx.BudgetID = ""
+		}
+
+		ruleIDs := ApplyCategoryRules(rules, &tranx)
+		if tranx.BudgetID == "" {
+			tranx.BudgetID = before.BudgetID
+		}
+
+		change := RuleChange{
+			TransactionID:     tranx.ID.Hex(),
+			RuleIDs:           ruleIDs,
+			AddedParticipants: added(before.ParticipantID, tranx.ParticipantID),
+			AddedTags:         added(before.Tags, tranx.Tags),
+		}
+
+		if tranx.BudgetID != before.BudgetID {
+			change.PreviousBudgetID = before.BudgetID
+			change.BudgetID = tranx.BudgetID
+		}
+
+		if change.BudgetID == "" && len(change.AddedParticipants) == 0 && len(change.AddedTags) == 0 {
+			continue
+		}
+
+		report.Changed++
+		report.Changes = append(report.Changes, change)
+
+		if opts.DryRun {
+			continue
+		}
+
+		set := bson.M{"updated_at": now.UTC()}
+		if change.BudgetID != "" {
+			set["budget_id"] = change.BudgetID
+		}
+		if len(change.AddedParticipants) > 0 {
+			set["participant_id"] = tranx.ParticipantID
+		}
+		if len(change.AddedTags) > 0 {
+			set["tags"] = tranx.Tags
+		}
+
+		// The transaction may have been reconciled since it was read.
+		unlockedTranx := bson.M{"_id": tranx.ID, "reconciled_in": bson.M{"$exists": false}}
+		if _, err := tranxCollection.UpdateOne(ctx, unlockedTranx, bson.M{"$set": set}); err != nil {
+			return nil, errors.Wrapf(err, "categorizing transaction %s", tranx.ID.Hex())
+		}
+
+		if change.BudgetID != "" {
+			moved = append(moved, tranx)
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading transactions")
+	}
+
+	alertBudgets(ctx, db, now, moved...)
+
+	return &report, nil
+}
+
+// Matches reports whether the transaction meets every condition of the rule.
+// A rule without conditions matches nothing.
+func (r CategoryRule) Matches(tranx Transaction) bool {
+
+	if r.VendorID == "" && r.EventPattern == "" && r.AmountMin == nil && r.AmountMax == nil {
+		return false
+	}
+
+	if r.VendorID != "" && r.VendorID != tranx.VendorID {
+		return false
+	}
+
+	if r.EventPattern != "" {
+		re := r.eventRegexp
+		if re == nil {
+			var err error
+			if re, err = compileEventPattern(r.EventPattern); err != nil {
+				return false
+			}
+		}
+		if !re.MatchString(tranx.TransactionEvent) {
+			return false
+		}
+	}
+
+	amount := tranxAmount(tranx)
+
+	if r.AmountMin != nil && amount.Cmp(*r.AmountMin) < 0 {
+		return false
+	}
+
+	if r.AmountMax != nil && amount.Cmp(*r.AmountMax) > 0 {
+		return false
+	}
+
+	return true
+}
+
+// ApplyCategoryRules assigns what the matching rules say to the transaction. Rules are applied in the order given.
+// The first matching rule with a budget sets the budget, unless the transaction already has one or is split.
+// Participants and tags of every matching rule are added. It returns the _ids of the rules that matched.
+// A reconciled transaction is locked, so no rule is applied to it.
+func ApplyCategoryRules(rules []CategoryRule, tranx *Transaction) []string {
+
+	var ids []string
+
+	if checkUnlocked(*tranx) != nil {
+		return ids
+	}
+
+	for _, r := range rules {
+
+		if !r.Matches(*tranx) {
+			continue
+		}
+
+		ids = append(ids, r.ID.Hex())
+
+		if r.BudgetID != "" && tranx.BudgetID == "" && len(tranx.Splits) == 0 {
+			tranx.BudgetID = r.BudgetID
+		}
+
+		if len(r.ParticipantID) > 0 {
+			tranx.ParticipantID = utility.RemoveDuplicateStringValues(append(tranx.ParticipantID, r.ParticipantID...))
+		}
+
+		if len(r.Tags) > 0 {
+			tranx.Tags = normalizeTags(append(tranx.Tags, r.Tags...))
+		}
+	}
+
+	return ids
+}
+
+// loadCategoryRules gets every category rule in the order they are applied: by priority, then oldest first.
+// Event patterns are compiled once here. A rule whose pattern does NOT compile matches nothing, so it is left out.
+func loadCategoryRules(ctx context.Context, db *mongo.Database) ([]CategoryRule, error) {
+
+	opts := options.Find().SetSort(bson.D{{Key: "priority", Value: 1}, {Key: "_id", Value: 1}})
+
+	cursor, err := db.Collection(CategoryRuleCollection).Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cursor from category rule collection")
+	}
+
+	var rules []CategoryRule
+	if err := cursor.All(ctx, &rules); err != nil {
+		return nil, errors.Wrap(err, "retrieving category rules")
+	}
+
+	compiled := rules[:0]
+	for _, r := range rules {
+		if r.EventPattern != "" {
+			re, err := compileEventPattern(r.EventPattern)
+			if err != nil {
+				fmt.Printf("category rule %s is NOT applied, its event_pattern does NOT compile : %v\n", r.ID.Hex(), err)
+				continue
+			}
+			r.eventRegexp = re
+		}
+		compiled = append(compiled, r)
+	}
+
+	return compiled, nil
+}
+
+// compileEventPattern compiles the event pattern of a rule, which ignores case.
+func compileEventPattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?i)" + pattern)
+}
+
+// checkCategoryRule returns a ValidationError when the rule can NOT be applied:
+// it needs a condition, something to assign, a pattern that compiles and references that exist.
+func checkCategoryRule(ctx context.Context, db *mongo.Database, rule CategoryRule) error {
+
+	verr := apierror.ValidationError{}
+
+	if rule.VendorID == "" && rule.EventPattern == "" && rule.AmountMin == nil && rule.AmountMax == nil {
+		verr.Add("vendor_id", "a rule needs a vendor_id, event_pattern, amount_min or amount_max to match")
+	}
+
+	if rule.BudgetID == "" && len(rule.ParticipantID) == 0 && len(rule.Tags) == 0 {
+		verr.Add("budget_id", "a rule needs a budget_id, participant_id or tags to assign")
+	}
+
+	if rule.EventPattern != "" {
+		if _, err := compileEventPattern(rule.EventPattern); err != nil {
+			verr.Add("event_pattern", err.Error())
+		}
+	}
+
+	if rule.AmountMin != nil && rule.AmountMax != nil && rule.AmountMin.Cmp(*rule.AmountMax) > 0 {
+		verr.Add("amount_max", "amount_max must NOT be less than amount_min")
+	}
+
+	refs := Transaction{
+		BudgetID:      rule.BudgetID,
+		VendorID:      rule.VendorID,
+		ParticipantID: rule.ParticipantID,
+	}
+
+	if err := checkReferences(ctx, db, refs, &verr); err != nil {
+		return err
+	}
+
+	return verr.Err()
+}
+
+// added returns the values of after that are NOT in before.
+func added(before, after []string) []string {
+
+	had := map[string]bool{}
+	for _, v := range before {
+		had[v] = true
+	}
+
+	var out []string
+	for _, v := range after {
+		if !had[v] {
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,113 @@
+package budget_test
+
+import (
+	"testing"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/google/go-cmp/cmp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// bound returns a pointer to an amount, as rules hold their amount ranges.
+func bound(t *testing.T, amount string) *budget.Money {
+	t.Helper()
+
+	m := money(t, amount)
+	return &m
+}
+
+func TestCategoryRuleMatches(t *testing.T) {
+	groceries := budget.Transaction{
+		VendorID:         "5f3e18f8d95d06627dc8e990",
+		TransactionEvent: "Groceries: alkalizer & detoxifier supplement",
+		TransactionDebit: money(t, "29.28"),
+	}
+
+	tests := []struct {
+		name string
+		rule budget.CategoryRule
+		want bool
+	}{
+		{"vendor", budget.CategoryRule{VendorID: "5f3e18f8d95d06627dc8e990"}, true},
+		{"other vendor", budget.CategoryRule{VendorID: "5f3e18f8d95d06627dc8e94e"}, false},
+		{"pattern ignores case", budget.CategoryRule{EventPattern: "^groceries"}, true},
+		{"pattern does not match", budget.CategoryRule{EventPattern: "movies"}, false},
+		{"amount in range", budget.CategoryRule{AmountMin: bound(t, "10"), AmountMax: bound(t, "29.28")}, true},
+		{"amount above range", budget.CategoryRule{AmountMax: bound(t, "20")}, false},
+		{"every condition must hold", budget.CategoryRule{VendorID: "5f3e18f8d95d06627dc8e990", AmountMin: bound(t, "50")}, false},
+		{"no conditions", budget.CategoryRule{BudgetID: "5f3e189bd95d06627dc8e932"}, false},
+		{"invalid pattern", budget.CategoryRule{EventPattern: "("}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.rule.Matches(groceries); got != tt.want {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestApplyCategoryRules(t *testing.T) {
+	first := primitive.NewObjectID()
+	second := primitive.NewObjectID()
+	unmatched := primitive.NewObjectID()
+
+	rules := []budget.CategoryRule{
+		{ID: first, EventPattern: "movies", BudgetID: "5f3e189bd95d06627dc8e931", Tags: []string{"Fun"}},
+		{ID: unmatched, EventPattern: "groceries", BudgetID: "5f3e189bd95d06627dc8e932"},
+		{ID: second, AmountMin: bound(t, "10"), BudgetID: "5f3e189bd95d06627dc8e937", ParticipantID: []string{"5ab055eae67be20014ca5284"}, Tags: []string{"fun", "big"}},
+	}
+
+	tranx := budget.Transaction{
+		TransactionEvent: "movies-Bad Boy For Life",
+		TransactionDebit: money(t, "13.25"),
+		Tags:             []string{"cinema"},
+	}
+
+	ids := budget.ApplyCategoryRules(rules, &tranx)
+
+	if diff := cmp.Diff([]string{first.Hex(), second.Hex()}, ids); diff != "" {
+		t.Fatalf("matched rules did not match expected. Diff:\n%s", diff)
+	}
+
+	if tranx.BudgetID != "5f3e189bd95d06627dc8e931" {
+		t.Fatalf("expected the first matching rule to set the budget, got %q", tranx.BudgetID)
+	}
+
+	if diff := cmp.Diff([]string{"cinema", "fun", "big"}, tranx.Tags); diff != "" {
+		t.Fatalf("tags did not match expected. Diff:\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]string{"5ab055eae67be20014ca5284"}, tranx.ParticipantID); diff != "" {
+		t.Fatalf("participants did not match expected. Diff:\n%s", diff)
+	}
+
+	kept := budget.Transaction{BudgetID: "5f3e189bd95d06627dc8e939", TransactionEvent: "movies"}
+	budget.ApplyCategoryRules(rules, &kept)
+
+	if kept.BudgetID != "5f3e189bd95d06627dc8e939" {
+		t.Fatalf("expected the budget sent by the client to be kept, got %q", kept.BudgetID)
+	}
+}
+
+func TestApplyCategoryRulesLocked(t *testing.T) {
+	rules := []budget.CategoryRule{
+		{ID: primitive.NewObjectID(), EventPattern: "rent", BudgetID: "5f3e189bd95d06627dc8e931", ParticipantID: []string{"5ab055eae67be20014ca5284"}, Tags: []string{"home"}},
+	}
+
+	tranx := budget.Transaction{
+		TransactionEvent: "rent",
+		TransactionDebit: money(t, "900"),
+		ReconciledIn:     []string{"5f3e16a8d95d06627dc8e928"},
+	}
+	want := tranx
+
+	if ids := budget.ApplyCategoryRules(rules, &tranx); len(ids) != 0 {
+		t.Fatalf("expected no rule to apply to a reconciled transaction, got %v", ids)
+	}
+
+	decimals := cmp.Comparer(func(a, b primitive.Decimal128) bool { return a.String() == b.String() })
+
+	if diff := cmp.Diff(want, tranx, decimals); diff != "" {
+		t.Fatalf("reconciled transaction was changed. Diff:\n%s", diff)
+	}
+}
@@ -0,0 +1,22 @@
+package budget
+
+// Names of the collections holding budget documents.
+// Functions that work across collections take a *mongo.Database and use these names.
+const (
+	AttachmentCollection       = "attachments"
+	BudgetAlertCollection      = "budgetalerts"
+	BudgetCollection           = "budgets"
+	CategoryRuleCollection     = "categoryrules"
+	CurrencyCollection         = "allowedCurrency"
+	ExchangeRateCollection     = "exchangerates"
+	FinancialAccountCollection = "financialaccounts"
+	NetWorthCollection         = "networthsnapshots"
+	ParticipantCollection      = "participants"
+	ReconciliationCollection   = "reconciliations"
+	RecurringCollection        = "recurringtransactions"
+	TransactionCollection      = "transactions"
+	UserCollection             = "users"
+	VendorCollection           = "vendors"
+	WebhookCollection          = "webhooks"
+	WebhookDeliveryCollection  = "webhookdeliveries"
+)
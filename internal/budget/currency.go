@@ -7,6 +7,7 @@ import (
 
 	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -14,20 +15,17 @@ import (
 )
 
 // CurrencyList gets all the currencies from the database then encodes them in a response client.
-func CurrencyList(ctx context.Context, db *mongo.Collection) ([]Currency, error) {
+// Results are returned one page at a time.
+func CurrencyList(ctx context.Context, db *mongo.Collection, page database.Page) ([]Currency, *database.PageInfo, error) {
 
 	currencyList := []Currency{}
 
-	currencyCursor, err := db.Find(ctx, bson.M{})
+	info, err := database.FindPage(ctx, db, bson.M{}, page, &currencyList)
 	if err != nil {
-		return nil, errors.Wrapf(err, "getting currencyCursor retrieving currency list")
+		return nil, nil, errors.Wrapf(err, "retrieving currency list")
 	}
 
-	if err = currencyCursor.All(ctx, &currencyList); err != nil {
-		return nil, errors.Wrapf(err, "retrieving currency list")
-	}
-
-	return currencyList, nil
+	return currencyList, info, nil
 }
 
 // RetrieveCurrencyByID gets the first Currency in the db with the provided ID.
@@ -131,14 +129,15 @@ func UpdateOneCurrency(ctx context.Context, db *mongo.Collection, user auth.Clai
 }
 
 // DeleteCurrencyByID removes the Currency identified by a given ID.
-func DeleteCurrencyByID(ctx context.Context, db *mongo.Collection, user auth.Claims, currencyID string) error {
+// Transactions in the currency block the delete unless the rule cascades or reassigns them.
+func DeleteCurrencyByID(ctx context.Context, db *mongo.Database, user auth.Claims, currencyID string, rule DeleteRule, now time.Time) error {
 
 	currencyObjectID, err := primitive.ObjectIDFromHex(currencyID)
 	if err != nil {
 		return apierror.ErrInvalidID
 	}
 
-	_, err = RetrieveCurrencyByID(ctx, db, currencyID)
+	_, err = RetrieveCurrencyByID(ctx, db.Collection(CurrencyCollection), currencyID)
 	if err != nil {
 		return apierror.ErrNotFound
 	}
@@ -149,12 +148,19 @@ func DeleteCurrencyByID(ctx context.Context, db *mongo.Collection, user auth.Cla
 		return apierror.ErrForbidden
 	}
 
-	result, err := db.DeleteOne(ctx, bson.M{"_id": currencyObjectID})
-	if err != nil {
-		return errors.Wrapf(err, "deleting currency %s", currencyID)
-	}
+	return withTransaction(ctx, db, func(sc mongo.SessionContext) error {
 
-	fmt.Print("result of deleting : ", result)
+		if err := resolveDependents(sc, db, currencyRef, currencyID, rule, now); err != nil {
+			return err
+		}
 
-	return nil
+		result, err := db.Collection(CurrencyCollection).DeleteOne(sc, bson.M{"_id": currencyObjectID})
+		if err != nil {
+			return errors.Wrapf(err, "deleting currency %s", currencyID)
+		}
+
+		fmt.Print("result of deleting : ", result)
+
+		return nil
+	})
 }
@@ -0,0 +1,486 @@
+package budget
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
+	"github.com/dapperAuteur/dashboard-go-api/internal/utility"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DuplicateDays is how many days back FindDuplicates looks when its window has no start.
+const DuplicateDays = 90
+
+// MaxDuplicateGroups is the most groups of transactions with the same currency and amounts FindDuplicates looks into.
+const MaxDuplicateGroups = 1000
+
+// DuplicateQuery describes the search for duplicate transactions.
+type DuplicateQuery struct {
+	Window   SummaryWindow // limits the transactions by occurrence, the last DuplicateDays without a start
+	Days     int           // how many days apart duplicates may be, 3 when zero
+	MinScore float64       // pairs scoring less are left out, 0.5 when zero
+}
+
+// DuplicateCandidate is a pair of transactions that look like the same one stored twice.
+// The original was created first.
+type DuplicateCandidate struct {
+	Original  Transaction `json:"original"`
+	Duplicate Transaction `json:"duplicate"`
+	Score     float64     `json:"score"` // confidence from 0 to 1
+	Reasons   []string    `json:"reasons"`
+}
+
+// TransactionMerge names the transaction to keep and the duplicate merged into it.
+type TransactionMerge struct {
+	KeepID      string `json:"keep_id" validate:"required"`
+	DuplicateID string `json:"duplicate_id" validate:"required"`
+}
+
+// FindDuplicates looks for pairs of transactions occurring within the window that are likely duplicates, most likely first.
+// Transfers are left out, as both of their transactions have the same amount by design.
+// Only transactions sharing their currency and amounts with another can be duplicates, so only the first MaxDuplicateGroups
+// such groups are loaded, in the order of their currency and amounts.
+// The pairs are worked out on every request, so they are returned one page at a time in their own order, see database.SlicePage.
+func FindDuplicates(ctx context.Context, db *mongo.Database, q DuplicateQuery, page database.Page, now time.Time) ([]DuplicateCandidate, *database.PageInfo, error) {
+
+	window := duplicateWindow(q.Window, now)
+
+	filter := bson.M{
+		"transfer_id": bson.M{"$exists": false},
+		"occurrence":  dateRangeQuery(window.From, window.To),
+	}
+
+	// a zero amount is left out of the document, or was stored as 0 before the move to Money
+	amount := func(field string) bson.M { return bson.M{"$ifNull": bson.A{"$" + field + ".amount", 0}} }
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.D{{Key: "currency_id", Value: "$currency_id"}, {Key: "credit", Value: amount("tranx_credit")}, {Key: "debit", Value: amount("tranx_debit")}},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$limit", Value: MaxDuplicateGroups}},
+	}
+
+	groupCursor, err := db.Collection(TransactionCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "grouping transactions by amount")
+	}
+
+	var groups []struct {
+		IDs []primitive.ObjectID `bson:"ids"`
+	}
+	if err := groupCursor.All(ctx, &groups); err != nil {
+		return nil, nil, errors.Wrap(err, "decoding transaction groups")
+	}
+
+	var ids []primitive.ObjectID
+	for _, g := range groups {
+		ids = append(ids, g.IDs...)
+	}
+
+	var tranxs []Transaction
+
+	if len(ids) > 0 {
+		cursor, err := db.Collection(TransactionCollection).Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "getting cursor from transaction collection")
+		}
+
+		if err := cursor.All(ctx, &tranxs); err != nil {
+			return nil, nil, errors.Wrap(err, "retrieving transactions")
+		}
+	}
+
+	candidates := DetectDuplicates(tranxs, q.Days, q.MinScore)
+
+	start, end, info, err := database.SlicePage(len(candidates), page)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return candidates[start:end], info, nil
+}
+
+// duplicateWindow is the window FindDuplicates looks in: the one asked for, starting DuplicateDays before its end,
+// or before now, when it has no start.
+func duplicateWindow(window SummaryWindow, now time.Time) SummaryWindow {
+
+	if window.From != nil {
+		return window
+	}
+
+	end := now
+	if window.To != nil {
+		end = *window.To
+	}
+
+	from := day(end).AddDate(0, 0, -DuplicateDays)
+	window.From = &from
+
+	return window
+}
+
+// DetectDuplicates pairs up transactions with the same amount, currency, vendor and account that occur
+// within days of each other, and scores each pair on how close their dates and event texts are.
+// Transactions whose vendors, accounts or bank ids differ are never paired.
+// Pairs scoring less than minScore are left out; the rest are sorted by score, highest first.
+func DetectDuplicates(tranxs []Transaction, days int, minScore float64) []DuplicateCandidate {
+
+	if days <= 0 {
+		days = 3
+	}
+	if minScore <= 0 {
+		minScore = 0.5
+	}
+
+	// Only transactions with the same currency and amounts can be duplicates.
+	// Amounts are bucketed as floats so "10" and "10.00" meet; duplicateScore compares them exactly.
+	buckets := map[string][]Transaction{}
+	for _, tranx := range tranxs {
+		key := fmt.Sprintf("%s|%v|%v", tranx.CurrencyID, tranx.TransactionCredit.Float64(), tranx.TransactionDebit.Float64())
+		buckets[key] = append(buckets[key], tranx)
+	}
+
+	candidates := []DuplicateCandidate{}
+
+	for _, bucket := range buckets {
+
+		sort.Slice(bucket, func(i, j int) bool { return bucket[i].Occurrence.Before(bucket[j].Occurrence) })
+
+		for i, a := range bucket {
+			for _, b := range bucket[i+1:] {
+
+				apart := int(math.Round(b.Occurrence.Sub(a.Occurrence).Hours() / 24))
+				if apart > days {
+					break
+				}
+
+				score, reasons, ok := duplicateScore(a, b, apart, days)
+				if !ok || score < minScore {
+					continue
+				}
+
+				original, duplicate := a, b
+				if duplicate.CreatedAt.Before(original.CreatedAt) {
+					original, duplicate = duplicate, original
+				}
+
+				candidates = append(candidates, DuplicateCandidate{Original: original, Duplicate: duplicate, Score: score, Reasons: reasons})
+			}
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].Original.Occurrence.Before(candidates[j].Original.Occurrence)
+	})
+
+	return candidates
+}
+
+// duplicateScore scores two transactions with the same amount that occur apart days from each other.
+// The amount counts for 0.4, the dates for up to 0.25, the event texts for up to 0.25, and the same vendor
+// and account for 0.05 each. ok is false when the transactions can NOT be the same one.
+func duplicateScore(a, b Transaction, apart, days int) (score float64, reasons []string, ok bool) {
+
+	if a.TransactionCredit.Cmp(b.TransactionCredit) != 0 || a.TransactionDebit.Cmp(b.TransactionDebit) != 0 {
+		return 0, nil, false
+	}
+
+	if a.ExternalID != "" && b.ExternalID != "" {
+		if a.ExternalID != b.ExternalID {
+			return 0, nil, false
+		}
+		return 1, []string{"same bank id"}, true
+	}
+
+	if a.VendorID != "" && b.VendorID != "" && a.VendorID != b.VendorID {
+		return 0, nil, false
+	}
+
+	sharedAccount := len(a.FinancialAccountID) == 0 || len(b.FinancialAccountID) == 0
+	for _, id := range a.FinancialAccountID {
+		if contains(b.FinancialAccountID, id) {
+			sharedAccount = true
+		}
+	}
+	if !sharedAccount {
+		return 0, nil, false
+	}
+
+	score = 0.4
+	reasons = append(reasons, "same amount")
+
+	score += 0.25 * (1 - float64(apart)/float64(days+1))
+	if apart == 0 {
+		reasons = append(reasons, "same day")
+	} else {
+		reasons = append(reasons, fmt.Sprintf("%d days apart", apart))
+	}
+
+	alike := textSimilarity(a.TransactionEvent, b.TransactionEvent)
+	score += 0.25 * alike
+	if alike > 0 {
+		reasons = append(reasons, fmt.Sprintf("event %.0f%% alike", alike*100))
+	}
+
+	if a.VendorID != "" && a.VendorID == b.VendorID {
+		score += 0.05
+		reasons = append(reasons, "same vendor")
+	}
+
+	if len(a.FinancialAccountID) > 0 && len(b.FinancialAccountID) > 0 {
+		score += 0.05
+		reasons = append(reasons, "same account")
+	}
+
+	return math.Round(score*100) / 100, reasons, true
+}
+
+// textSimilarity returns how alike two texts are, from 0 to 1, ignoring case and spacing.
+// It is one less the edit distance between them relative to the longer one.
+func textSimilarity(a, b string) float64 {
+
+	ra := []rune(strings.Join(strings.Fields(strings.ToLower(a)), " "))
+	rb := []rune(strings.Join(strings.Fields(strings.ToLower(b)), " "))
+
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 0
+	}
+
+	// Levenshtein distance, keeping a single row of the matrix.
+	row := make([]int, len(rb)+1)
+	for j := range row {
+		row[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		diagonal := row[0]
+		row[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			next := diagonal + cost
+			if row[j]+1 < next {
+				next = row[j] + 1
+			}
+			if row[j-1]+1 < next {
+				next = row[j-1] + 1
+			}
+			diagonal, row[j] = row[j], next
+		}
+	}
+
+	return 1 - float64(row[len(rb)])/float64(longest)
+}
+
+// MergeTransactions keeps one transaction of a duplicate pair and deletes the other.
+// The kept transaction gains the participants, accounts, tags and cleared accounts of the duplicate, and the fields it is missing,
+// e.g. a budget or vendor, are taken from the duplicate. Attachments of the duplicate move to the kept transaction.
+// Both must have the same currency and amounts, and neither may be locked by a reconciliation.
+// Account balances are left as if the duplicate was never stored.
+func MergeTransactions(ctx context.Context, db *mongo.Database, user auth.Claims, merge TransactionMerge, now time.Time) (*Transaction, error) {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return nil, apierror.ErrForbidden
+	}
+
+	if merge.KeepID == merge.DuplicateID {
+		return nil, &apierror.ValidationError{Fields: []apierror.FieldError{{Field: "duplicate_id", Error: "a transaction can NOT be merged into itself"}}}
+	}
+
+	tranxCollection := db.Collection(TransactionCollection)
+
+	var merged Transaction
+	var duplicate *Transaction
+
+	err := withTransaction(ctx, db, func(sc mongo.SessionContext) error {
+
+		keep, err := RetrieveTransaction(sc, tranxCollection, merge.KeepID)
+		if err != nil {
+			return err
+		}
+
+		duplicate, err = RetrieveTransaction(sc, tranxCollection, merge.DuplicateID)
+		if err != nil {
+			return err
+		}
+
+		if err := checkUnlocked(*keep, *duplicate); err != nil {
+			return err
+		}
+
+		if err := checkMerge(*keep, *duplicate); err != nil {
+			return err
+		}
+
+		merged = Merge(*keep, *duplicate)
+		merged.UpdatedAt = now.UTC()
+
+		// The duplicate is removed along with its effect on its accounts,
+		// so the accounts the kept transaction gains have to be moved by it instead.
+		var gained []string
+		for _, id := range merged.FinancialAccountID {
+			if !contains(keep.FinancialAccountID, id) {
+				gained = append(gained, id)
+			}
+		}
+
+		if err := removeTransaction(sc, db, *duplicate, now); err != nil {
+			return err
+		}
+
+		if err := applyBalance(sc, db, gained, balanceDelta(merged), now); err != nil {
+			return err
+		}
+
+		if _, err := tranxCollection.ReplaceOne(sc, bson.M{"_id": merged.ID}, merged); err != nil {
+			return errors.Wrapf(err, "updating transaction %s", merge.KeepID)
+		}
+
+		update := bson.M{"$set": bson.M{"tranx_id": merge.KeepID}}
+		if _, err := db.Collection(AttachmentCollection).UpdateMany(sc, bson.M{"tranx_id": merge.DuplicateID}, update); err != nil {
+			return errors.Wrap(err, "moving attachments of duplicate transaction")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("transaction %s merged into %s\n", merge.DuplicateID, merge.KeepID)
+
+	alertBudgets(ctx, db, now, merged, *duplicate)
+
+	return &merged, nil
+}
+
+// checkMerge returns a ValidationError unless duplicate can be merged into keep.
+func checkMerge(keep, duplicate Transaction) error {
+
+	verr := apierror.ValidationError{}
+
+	if keep.TransferID != "" || duplicate.TransferID != "" {
+		verr.Add("duplicate_id", "transactions of a transfer can NOT be merged")
+	}
+
+	if keep.CurrencyID != duplicate.CurrencyID ||
+		keep.TransactionCredit.Cmp(duplicate.TransactionCredit) != 0 ||
+		keep.TransactionDebit.Cmp(duplicate.TransactionDebit) != 0 {
+		verr.Add("duplicate_id", "only transactions with the same currency and amounts can be merged")
+	}
+
+	return verr.Err()
+}
+
+// Merge returns keep with the participants, accounts, tags, cleared accounts and event text of duplicate added,
+// and its missing fields filled in from duplicate.
+// A budget or splits, and a payer with shares, are only taken together.
+func Merge(keep, duplicate Transaction) Transaction {
+
+	merged := keep
+
+	merged.FinancialAccountID = utility.RemoveDuplicateStringValues(append(append([]string{}, keep.FinancialAccountID...), duplicate.FinancialAccountID...))
+	merged.ParticipantID = utility.RemoveDuplicateStringValues(append(append([]string{}, keep.ParticipantID...), duplicate.ParticipantID...))
+	merged.Tags = normalizeTags(append(append([]string{}, keep.Tags...), duplicate.Tags...))
+	merged.ClearedIn = utility.RemoveDuplicateStringValues(append(append([]string{}, keep.ClearedIn...), duplicate.ClearedIn...))
+
+	if merged.BudgetID == "" && len(merged.Splits) == 0 {
+		merged.BudgetID = duplicate.BudgetID
+		merged.Splits = duplicate.Splits
+	}
+
+	if merged.PaidBy == "" {
+		merged.PaidBy = duplicate.PaidBy
+		merged.Shares = duplicate.Shares
+	}
+
+	if merged.VendorID == "" {
+		merged.VendorID = duplicate.VendorID
+	}
+
+	merged.TransactionEvent = mergeEvents(keep.TransactionEvent, duplicate.TransactionEvent)
+
+	if merged.Occurrence.IsZero() {
+		merged.Occurrence = duplicate.Occurrence
+		merged.OccurrenceString = duplicate.OccurrenceString
+	}
+
+	if merged.ExternalID == "" {
+		merged.ExternalID = duplicate.ExternalID
+	}
+
+	if merged.RecurringID == "" {
+		merged.RecurringID = duplicate.RecurringID
+	}
+
+	withShareParticipants(&merged)
+
+	return merged
+}
+
+// eventSeparator joins the event texts of merged transactions.
+const eventSeparator = "; "
+
+// mergeEvents adds the event text of duplicate to keep, leaving out the parts keep already has, whatever their case.
+func mergeEvents(keep, duplicate string) string {
+
+	seen := map[string]bool{}
+	for _, part := range strings.Split(keep, eventSeparator) {
+		seen[strings.ToLower(strings.TrimSpace(part))] = true
+	}
+
+	events := keep
+	for _, part := range strings.Split(duplicate, eventSeparator) {
+		part = strings.TrimSpace(part)
+		if part == "" || seen[strings.ToLower(part)] {
+			continue
+		}
+		seen[strings.ToLower(part)] = true
+
+		if strings.TrimSpace(events) == "" {
+			events = part
+		} else {
+			events += eventSeparator + part
+		}
+	}
+
+	return events
+}
+
+// contains reports whether s is one of list.
+func contains(list []string, s string) bool {
+
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
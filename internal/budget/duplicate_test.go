@@ -0,0 +1,178 @@
+package budget_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/google/go-cmp/cmp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDetectDuplicates(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2020, time.September, d, 0, 0, 0, 0, time.UTC) }
+
+	tranx := func(event, debit string, d int, edit func(*budget.Transaction)) budget.Transaction {
+		tr := budget.Transaction{
+			ID:               primitive.NewObjectID(),
+			CurrencyID:       "usd",
+			TransactionEvent: event,
+			TransactionDebit: money(t, debit),
+			Occurrence:       day(d),
+			CreatedAt:        day(d),
+		}
+		if edit != nil {
+			edit(&tr)
+		}
+		return tr
+	}
+
+	withVendor := func(id string) func(*budget.Transaction) {
+		return func(tr *budget.Transaction) { tr.VendorID = id }
+	}
+
+	tests := []struct {
+		name   string
+		tranxs []budget.Transaction
+		want   string
+	}{
+		{"identical", []budget.Transaction{
+			tranx("Coffee", "4.50", 1, func(tr *budget.Transaction) { tr.VendorID, tr.FinancialAccountID = "v", []string{"a"} }),
+			tranx("COFFEE ", "4.5", 1, func(tr *budget.Transaction) { tr.VendorID, tr.FinancialAccountID = "v", []string{"b", "a"} }),
+		}, "1"},
+		{"without vendor or account", []budget.Transaction{tranx("rent", "900", 1, nil), tranx("rent", "900.00", 1, nil)}, "0.9"},
+		{"different amounts", []budget.Transaction{tranx("rent", "900", 1, nil), tranx("rent", "900.01", 1, nil)}, ""},
+		{"different currencies", []budget.Transaction{
+			tranx("rent", "900", 1, nil),
+			tranx("rent", "900", 1, func(tr *budget.Transaction) { tr.CurrencyID = "eur" }),
+		}, ""},
+		{"different vendors", []budget.Transaction{tranx("rent", "900", 1, withVendor("v")), tranx("rent", "900", 1, withVendor("w"))}, ""},
+		{"different accounts", []budget.Transaction{
+			tranx("rent", "900", 1, func(tr *budget.Transaction) { tr.FinancialAccountID = []string{"a"} }),
+			tranx("rent", "900", 1, func(tr *budget.Transaction) { tr.FinancialAccountID = []string{"b"} }),
+		}, ""},
+		{"different bank ids", []budget.Transaction{
+			tranx("rent", "900", 1, func(tr *budget.Transaction) { tr.ExternalID = "1" }),
+			tranx("rent", "900", 1, func(tr *budget.Transaction) { tr.ExternalID = "2" }),
+		}, ""},
+		{"too far apart", []budget.Transaction{tranx("rent", "900", 1, nil), tranx("rent", "900", 5, nil)}, ""},
+		{"unlike events days apart", []budget.Transaction{tranx("aaaa", "900", 1, nil), tranx("bbbb", "900", 4, nil)}, ""},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, c := range budget.DetectDuplicates(tt.tranxs, 3, 0.5) {
+			got = append(got, fmt.Sprint(c.Score))
+		}
+
+		if g := strings.Join(got, " "); g != tt.want {
+			t.Fatalf("%s: expected scores %q, got %q", tt.name, tt.want, g)
+		}
+	}
+}
+
+func TestDetectDuplicatesOrder(t *testing.T) {
+	first := budget.Transaction{ID: primitive.NewObjectID(), TransactionEvent: "gym", TransactionDebit: money(t, "30"), CreatedAt: time.Date(2020, time.September, 2, 0, 0, 0, 0, time.UTC)}
+	second := budget.Transaction{ID: primitive.NewObjectID(), TransactionEvent: "gym", TransactionDebit: money(t, "30"), CreatedAt: time.Date(2020, time.September, 9, 0, 0, 0, 0, time.UTC)}
+
+	got := budget.DetectDuplicates([]budget.Transaction{second, first}, 0, 0)
+	if len(got) != 1 {
+		t.Fatalf("expected 1 candidate, got %d", len(got))
+	}
+
+	if got[0].Original.ID != first.ID || got[0].Duplicate.ID != second.ID {
+		t.Fatalf("expected the transaction created first to be the original, got %+v", got[0])
+	}
+}
+
+func TestMerge(t *testing.T) {
+	keep := budget.Transaction{
+		ID:                 primitive.NewObjectID(),
+		TransactionEvent:   "groceries",
+		TransactionDebit:   money(t, "52.10"),
+		FinancialAccountID: []string{"a"},
+		ParticipantID:      []string{"p"},
+		Tags:               []string{"food"},
+	}
+	duplicate := budget.Transaction{
+		ID:                 primitive.NewObjectID(),
+		BudgetID:           "b",
+		VendorID:           "v",
+		TransactionEvent:   "GROCERIES #12",
+		TransactionDebit:   money(t, "52.10"),
+		FinancialAccountID: []string{"c", "a"},
+		ParticipantID:      []string{"q", "p"},
+		ExternalID:         "fitid",
+		Tags:               []string{"Food", "weekly"},
+	}
+
+	want := keep
+	want.BudgetID = "b"
+	want.VendorID = "v"
+	want.ExternalID = "fitid"
+	want.FinancialAccountID = []string{"a", "c"}
+	want.ParticipantID = []string{"p", "q"}
+	want.Tags = []string{"food", "weekly"}
+	want.TransactionEvent = "groceries; GROCERIES #12"
+
+	decimals := cmp.Comparer(func(a, b primitive.Decimal128) bool { return a.String() == b.String() })
+
+	if diff := cmp.Diff(want, budget.Merge(keep, duplicate), decimals); diff != "" {
+		t.Fatalf("merged transaction did not match expected. Diff:\n%s", diff)
+	}
+}
+
+func TestMergeEvents(t *testing.T) {
+	tests := []struct {
+		name            string
+		keep, duplicate string
+		want            string
+	}{
+		{"different", "Coffee", "STARBUCKS #123", "Coffee; STARBUCKS #123"},
+		{"same but case", "Coffee", " COFFEE ", "Coffee"},
+		{"kept has none", "", "Coffee", "Coffee"},
+		{"duplicate has none", "Coffee", "", "Coffee"},
+		{"merged before", "Coffee; STARBUCKS #123", "starbucks #123; Latte", "Coffee; STARBUCKS #123; Latte"},
+	}
+
+	for _, tt := range tests {
+		keep := budget.Transaction{TransactionEvent: tt.keep}
+		duplicate := budget.Transaction{TransactionEvent: tt.duplicate}
+
+		if got := budget.Merge(keep, duplicate).TransactionEvent; got != tt.want {
+			t.Fatalf("%s: expected event %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestDuplicateWindow(t *testing.T) {
+	now := time.Date(2020, time.September, 14, 15, 30, 0, 0, time.UTC)
+	from := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2020, time.March, 31, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		window budget.SummaryWindow
+		from   time.Time
+		to     *time.Time
+	}{
+		{"open window", budget.SummaryWindow{}, time.Date(2020, time.June, 16, 0, 0, 0, 0, time.UTC), nil},
+		{"only to", budget.SummaryWindow{To: &to}, time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC), &to},
+		{"from given", budget.SummaryWindow{From: &from}, from, nil},
+		{"both given", budget.SummaryWindow{From: &from, To: &to}, from, &to},
+	}
+
+	for _, tt := range tests {
+		got := budget.DuplicateWindow(tt.window, now)
+
+		if got.From == nil || !got.From.Equal(tt.from) {
+			t.Fatalf("%s: expected the window to start on %v, got %v", tt.name, tt.from, got.From)
+		}
+
+		if diff := cmp.Diff(tt.to, got.To); diff != "" {
+			t.Fatalf("%s: end of the window did not match expected. Diff:\n%s", tt.name, diff)
+		}
+	}
+}
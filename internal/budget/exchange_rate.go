@@ -0,0 +1,492 @@
+package budget
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNoExchangeRate is used when an amount can NOT be converted because no rate is stored for its currency pair.
+var ErrNoExchangeRate = errors.New("no exchange rate for the currency pair on or before the date")
+
+// ErrInvalidImport is used when an imported file can NOT be read at all, e.g. it is missing a column.
+var ErrInvalidImport = errors.New("import file is NOT in its proper form")
+
+// inversePlaces is the number of decimal places kept when a rate is inverted.
+const inversePlaces = 18
+
+// ListExchangeRates gets the exchange rates from the db, newest first unless the page asks for another order.
+// The rates can be limited to a base and/or quote currency.
+// Results are returned one page at a time.
+func ListExchangeRates(ctx context.Context, db *mongo.Collection, baseCurrencyID, quoteCurrencyID string, page database.Page) ([]ExchangeRate, *database.PageInfo, error) {
+
+	filter := bson.M{}
+
+	if baseCurrencyID != "" {
+		filter["base_currency_id"] = baseCurrencyID
+	}
+
+	if quoteCurrencyID != "" {
+		filter["quote_currency_id"] = quoteCurrencyID
+	}
+
+	if page.Sort == "" {
+		page.Sort = "date"
+		page.Desc = true
+	}
+
+	list := []ExchangeRate{}
+
+	info, err := database.FindPage(ctx, db, filter, page, &list)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "retrieving exchange rates list")
+	}
+
+	return list, info, nil
+}
+
+// RetrieveExchangeRate finds the exchange rate identified by a given _id.
+func RetrieveExchangeRate(ctx context.Context, db *mongo.Collection, _id string) (*ExchangeRate, error) {
+
+	var rate ExchangeRate
+
+	id, err := primitive.ObjectIDFromHex(_id)
+	if err != nil {
+		return nil, apierror.ErrInvalidID
+	}
+
+	if err := db.FindOne(ctx, bson.M{"_id": id}).Decode(&rate); err != nil {
+		return nil, apierror.ErrNotFound
+	}
+
+	return &rate, nil
+}
+
+// CreateExchangeRate stores the rate of a currency pair on a date.
+// A rate already stored for the pair on that date is replaced, so there is only ever one.
+func CreateExchangeRate(ctx context.Context, db *mongo.Collection, user auth.Claims, newRate NewExchangeRate, now time.Time) (*ExchangeRate, error) {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return nil, apierror.ErrForbidden
+	}
+
+	verr := apierror.ValidationError{}
+
+	if newRate.BaseCurrencyID == "" {
+		verr.Add("base_currency_id", "base_currency_id is required")
+	}
+
+	if newRate.QuoteCurrencyID == "" {
+		verr.Add("quote_currency_id", "quote_currency_id is required")
+	}
+
+	if newRate.BaseCurrencyID != "" && newRate.BaseCurrencyID == newRate.QuoteCurrencyID {
+		verr.Add("quote_currency_id", "quote_currency_id must differ from base_currency_id")
+	}
+
+	if newRate.Rate.Sign() <= 0 {
+		verr.Add("rate", "rate must be greater than 0")
+	}
+
+	date, err := parseRateDate(newRate.Date)
+	if err != nil {
+		verr.Add("date", err.Error())
+	}
+
+	if err := verr.Err(); err != nil {
+		return nil, err
+	}
+
+	rate := ExchangeRate{
+		BaseCurrencyID:  newRate.BaseCurrencyID,
+		QuoteCurrencyID: newRate.QuoteCurrencyID,
+		Rate:            Money{Amount: newRate.Rate.Amount, CurrencyID: newRate.QuoteCurrencyID},
+		Date:            date,
+	}
+
+	id, err := upsertRate(ctx, db, rate, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return RetrieveExchangeRate(ctx, db, id.Hex())
+}
+
+// upsertRate replaces the rate stored for the pair and date of rate, inserting it when there is none.
+// It returns the _id of the stored rate.
+func upsertRate(ctx context.Context, db *mongo.Collection, rate ExchangeRate, now time.Time) (primitive.ObjectID, error) {
+
+	filter := bson.M{
+		"base_currency_id":  rate.BaseCurrencyID,
+		"quote_currency_id": rate.QuoteCurrencyID,
+		"date":              rate.Date,
+	}
+
+	update := bson.M{
+		"$set":         bson.M{"rate": rate.Rate, "updated_at": now.UTC()},
+		"$setOnInsert": bson.M{"created_at": now.UTC()},
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var stored ExchangeRate
+	if err := db.FindOneAndUpdate(ctx, filter, update, opts).Decode(&stored); err != nil {
+		return primitive.NilObjectID, errors.Wrapf(err, "storing exchange rate %s/%s on %s", rate.BaseCurrencyID, rate.QuoteCurrencyID, rate.Date.Format("2006-01-02"))
+	}
+
+	return stored.ID, nil
+}
+
+// UpdateOneExchangeRate modifies the rate or date of an exchange rate.
+// It will error if the specified _id is invalid or does NOT reference an existing exchange rate.
+func UpdateOneExchangeRate(ctx context.Context, db *mongo.Collection, user auth.Claims, rateID string, updateRate UpdateExchangeRate, now time.Time) error {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return apierror.ErrForbidden
+	}
+
+	rateObjectID, err := primitive.ObjectIDFromHex(rateID)
+	if err != nil {
+		return apierror.ErrInvalidID
+	}
+
+	foundRate, err := RetrieveExchangeRate(ctx, db, rateID)
+	if err != nil {
+		return apierror.ErrNotFound
+	}
+
+	rate := ExchangeRate{}
+
+	verr := apierror.ValidationError{}
+
+	if updateRate.Rate != nil {
+		if updateRate.Rate.Sign() <= 0 {
+			verr.Add("rate", "rate must be greater than 0")
+		}
+		rate.Rate = Money{Amount: updateRate.Rate.Amount, CurrencyID: foundRate.QuoteCurrencyID}
+	}
+
+	if updateRate.Date != nil {
+		date, err := parseRateDate(*updateRate.Date)
+		if err != nil {
+			verr.Add("date", err.Error())
+		}
+		rate.Date = date
+	}
+
+	if err := verr.Err(); err != nil {
+		return err
+	}
+
+	rate.UpdatedAt = now.UTC()
+
+	if _, err := db.UpdateOne(ctx, bson.M{"_id": rateObjectID}, bson.M{"$set": rate}); err != nil {
+		return errors.Wrap(err, "updating exchange rate")
+	}
+
+	return nil
+}
+
+// DeleteExchangeRate removes the exchange rate identified by a given _id.
+func DeleteExchangeRate(ctx context.Context, db *mongo.Collection, user auth.Claims, rateID string) error {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return apierror.ErrForbidden
+	}
+
+	rateObjectID, err := primitive.ObjectIDFromHex(rateID)
+	if err != nil {
+		return apierror.ErrInvalidID
+	}
+
+	result, err := db.DeleteOne(ctx, bson.M{"_id": rateObjectID})
+	if err != nil {
+		return errors.Wrapf(err, "deleting exchange rate %s", rateID)
+	}
+
+	if result.DeletedCount == 0 {
+		return apierror.ErrNotFound
+	}
+
+	return nil
+}
+
+// parseRateDate reads the day a rate applies from. Any time of day is dropped.
+func parseRateDate(value string) (time.Time, error) {
+
+	t, err := ParseOccurrence(value, 0)
+	if err != nil {
+		return time.Time{}, errors.New("date must be YYYY-MM-DD")
+	}
+
+	return t.Truncate(24 * time.Hour), nil
+}
+
+// RowError reports why one line of an imported file was NOT imported.
+type RowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// RateImport reports the outcome of ImportExchangeRates.
+type RateImport struct {
+	Imported int        `json:"imported"`
+	Failed   []RowError `json:"failed,omitempty"`
+}
+
+// ImportExchangeRates stores the exchange rates read from a CSV file.
+// The file must start with the header "base,quote,date,rate". Currencies are given by _id or by name, e.g. "USD".
+// Rates already stored for a pair on a date are replaced, so a file can be imported more than once.
+// Lines that can NOT be read are reported and skipped.
+func ImportExchangeRates(ctx context.Context, db *mongo.Database, r io.Reader, now time.Time) (*RateImport, error) {
+
+	currencies, err := currencyIDsByName(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidImport, "reading exchange rate header: %v", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range []string{"base", "quote", "date", "rate"} {
+		if _, ok := columns[name]; !ok {
+			return nil, errors.Wrapf(ErrInvalidImport, "exchange rate file is missing the %q column", name)
+		}
+	}
+
+	ratesCollection := db.Collection(ExchangeRateCollection)
+
+	var result RateImport
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			result.Failed = append(result.Failed, RowError{Line: line, Error: err.Error()})
+			continue
+		}
+
+		rate, err := rateFromRecord(record, columns, currencies)
+		if err != nil {
+			result.Failed = append(result.Failed, RowError{Line: line, Error: err.Error()})
+			continue
+		}
+
+		if _, err := upsertRate(ctx, ratesCollection, rate, now); err != nil {
+			return nil, err
+		}
+		result.Imported++
+	}
+
+	return &result, nil
+}
+
+// rateFromRecord reads an exchange rate from one line of an imported file.
+func rateFromRecord(record []string, columns map[string]int, currencies map[string]string) (ExchangeRate, error) {
+
+	field := func(name string) string {
+		return strings.TrimSpace(record[columns[name]])
+	}
+
+	base, err := resolveCurrency(field("base"), currencies)
+	if err != nil {
+		return ExchangeRate{}, err
+	}
+
+	quote, err := resolveCurrency(field("quote"), currencies)
+	if err != nil {
+		return ExchangeRate{}, err
+	}
+
+	if base == quote {
+		return ExchangeRate{}, errors.New("quote must differ from base")
+	}
+
+	date, err := parseRateDate(field("date"))
+	if err != nil {
+		return ExchangeRate{}, err
+	}
+
+	rate, err := ParseMoney(field("rate"), quote)
+	if err != nil {
+		return ExchangeRate{}, err
+	}
+
+	if rate.Sign() <= 0 {
+		return ExchangeRate{}, errors.New("rate must be greater than 0")
+	}
+
+	return ExchangeRate{BaseCurrencyID: base, QuoteCurrencyID: quote, Rate: rate, Date: date}, nil
+}
+
+// currencyIDsByName maps the upper case name of every currency to its _id.
+func currencyIDsByName(ctx context.Context, db *mongo.Database) (map[string]string, error) {
+
+	cursor, err := db.Collection(CurrencyCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cursor from currency collection")
+	}
+
+	var currencies []Currency
+	if err := cursor.All(ctx, &currencies); err != nil {
+		return nil, errors.Wrap(err, "retrieving currencies")
+	}
+
+	ids := make(map[string]string, len(currencies))
+	for _, c := range currencies {
+		ids[strings.ToUpper(c.CurrencyName)] = c.ID.Hex()
+	}
+
+	return ids, nil
+}
+
+// resolveCurrency returns the _id of a currency given by _id or by name.
+func resolveCurrency(value string, currencies map[string]string) (string, error) {
+
+	if _, err := primitive.ObjectIDFromHex(value); err == nil {
+		return value, nil
+	}
+
+	if id, ok := currencies[strings.ToUpper(value)]; ok {
+		return id, nil
+	}
+
+	return "", errors.Errorf("unknown currency %q", value)
+}
+
+// FindRate returns how much of the currency identified by to one unit of the currency identified by from is worth at a date.
+// The latest rate on or before the date is used. A zero date uses the latest rate stored.
+// When only the inverse pair is stored its rate is inverted.
+func FindRate(ctx context.Context, db *mongo.Database, from, to string, at time.Time) (Money, error) {
+
+	if from == to {
+		one, _ := ParseMoney("1", to)
+		return one, nil
+	}
+
+	rate, err := latestRate(ctx, db, from, to, at)
+	if err == nil {
+		return rate.Rate, nil
+	}
+	if errors.Cause(err) != ErrNoExchangeRate {
+		return Money{}, err
+	}
+
+	inverse, err := latestRate(ctx, db, to, from, at)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return invertRate(inverse.Rate, to), nil
+}
+
+// latestRate finds the latest rate of a currency pair on or before a date.
+func latestRate(ctx context.Context, db *mongo.Database, base, quote string, at time.Time) (*ExchangeRate, error) {
+
+	filter := bson.M{"base_currency_id": base, "quote_currency_id": quote}
+	if !at.IsZero() {
+		filter["date"] = bson.M{"$lte": at}
+	}
+
+	opts := options.FindOne().SetSort(bson.D{{Key: "date", Value: -1}})
+
+	var rate ExchangeRate
+	if err := db.Collection(ExchangeRateCollection).FindOne(ctx, filter, opts).Decode(&rate); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.Wrapf(ErrNoExchangeRate, "%s/%s on %s", base, quote, at.Format("2006-01-02"))
+		}
+		return nil, errors.Wrapf(err, "finding exchange rate %s/%s", base, quote)
+	}
+
+	return &rate, nil
+}
+
+// invertRate returns 1/rate in the currency identified by currencyID, rounded to inversePlaces decimal places.
+func invertRate(rate Money, currencyID string) Money {
+
+	r, ok := new(big.Rat).SetString(rate.String())
+	if !ok || r.Sign() == 0 {
+		return Money{}
+	}
+
+	s := strings.TrimRight(new(big.Rat).Inv(r).FloatString(inversePlaces), "0")
+	inverse, _ := ParseMoney(strings.TrimSuffix(s, "."), currencyID)
+
+	return inverse
+}
+
+// Converter converts amounts into one currency at the rates stored for each day.
+// Rates are looked up once per currency and day, so a Converter should only live as long as a request.
+type Converter struct {
+	db    *mongo.Database
+	to    string
+	rates map[string]Money
+}
+
+// NewConverter returns a Converter into the currency identified by currencyID.
+// When currencyID is empty amounts are NOT converted.
+func NewConverter(db *mongo.Database, currencyID string) *Converter {
+	return &Converter{db: db, to: currencyID, rates: map[string]Money{}}
+}
+
+// Convert returns m in the currency of the Converter at the rate of the day at.
+// A zero at uses the latest rate. Amounts without a currency are taken to be in the currency of the Converter already.
+func (c *Converter) Convert(ctx context.Context, m Money, at time.Time) (Money, error) {
+
+	if c.to == "" {
+		return m, nil
+	}
+
+	if m.CurrencyID == "" || m.CurrencyID == c.to || m.IsZero() {
+		m.CurrencyID = c.to
+		return m, nil
+	}
+
+	key := fmt.Sprintf("%s@%s", m.CurrencyID, at.UTC().Format("2006-01-02"))
+	if at.IsZero() {
+		key = m.CurrencyID
+	}
+
+	rate, ok := c.rates[key]
+	if !ok {
+		var err error
+		if rate, err = FindRate(ctx, c.db, m.CurrencyID, c.to, at); err != nil {
+			return Money{}, err
+		}
+		c.rates[key] = rate
+	}
+
+	return m.Convert(rate), nil
+}
@@ -0,0 +1,346 @@
+package budget
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Formats of the files written by a TransactionExport, besides FormatCSV.
+const (
+	FormatJSONL = "jsonl"
+	FormatExcel = "excel" // CSV with a byte order mark and CRLF line endings, as Excel expects
+)
+
+// ErrInvalidExport is used when transactions are exported in a format that is NOT supported.
+var ErrInvalidExport = errors.New("export format must be csv, jsonl or excel")
+
+// exportColumns is the header of a CSV export, in the order of the ExportRow fields.
+var exportColumns = []string{
+	"_id",
+	"occurrence",
+	"tranx_event",
+	"tranx_credit",
+	"tranx_debit",
+	"currency",
+	"budget",
+	"vendor",
+	"financial_accounts",
+	"external_id",
+	"created_at",
+	"updated_at",
+}
+
+// ExportRow is a transaction as it is written by an export.
+// References to other documents are replaced by their names.
+type ExportRow struct {
+	ID                string   `json:"_id"`
+	Occurrence        string   `json:"occurrence"` // YYYY-MM-DD
+	TransactionEvent  string   `json:"tranx_event"`
+	TransactionCredit Money    `json:"tranx_credit"`
+	TransactionDebit  Money    `json:"tranx_debit"`
+	Currency          string   `json:"currency"`
+	Budget            string   `json:"budget"`
+	Vendor            string   `json:"vendor"`
+	FinancialAccounts []string `json:"financial_accounts"`
+	ExternalID        string   `json:"external_id,omitempty"`
+	CreatedAt         string   `json:"created_at"`
+	UpdatedAt         string   `json:"updated_at"`
+}
+
+// TransactionExport streams the transactions that fit a filter from a database cursor into a file.
+// It is opened with OpenTransactionExport and must be closed when done.
+type TransactionExport struct {
+	format     string
+	cursor     *mongo.Cursor
+	budgets    map[string]string
+	currencies map[string]string
+	vendors    map[string]string
+	accounts   map[string]string
+}
+
+// OpenTransactionExport checks the format, loads the names of the documents transactions refer to,
+// and opens a cursor over the transactions that fit the filter, oldest first.
+// Nothing is read from the cursor until Write is called, so errors found here can still be reported to a client.
+func OpenTransactionExport(ctx context.Context, db *mongo.Database, filterTranx FilterTransaction, format string) (*TransactionExport, error) {
+
+	format = strings.ToLower(format)
+
+	switch format {
+	case FormatCSV, FormatJSONL, FormatExcel:
+	default:
+		return nil, ErrInvalidExport
+	}
+
+	e := TransactionExport{format: format}
+
+	var err error
+
+	if e.budgets, err = namesByID(ctx, db, BudgetCollection, "budget_name"); err != nil {
+		return nil, err
+	}
+
+	if e.currencies, err = namesByID(ctx, db, CurrencyCollection, "currency"); err != nil {
+		return nil, err
+	}
+
+	if e.vendors, err = namesByID(ctx, db, VendorCollection, "vendor_name"); err != nil {
+		return nil, err
+	}
+
+	if e.accounts, err = namesByID(ctx, db, FinancialAccountCollection, "account_name"); err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "occurrence", Value: 1}, {Key: "_id", Value: 1}})
+
+	e.cursor, err = db.Collection(TransactionCollection).Find(ctx, filterTranx.Query(), opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cursor from transaction collection")
+	}
+
+	return &e, nil
+}
+
+// ContentType is the media type of the file written by the export.
+func (e *TransactionExport) ContentType() string {
+
+	if e.format == FormatJSONL {
+		return "application/x-ndjson; charset=utf-8"
+	}
+
+	return "text/csv; charset=utf-8"
+}
+
+// Extension is the file extension of the file written by the export, without a dot.
+func (e *TransactionExport) Extension() string {
+
+	if e.format == FormatJSONL {
+		return FormatJSONL
+	}
+
+	return FormatCSV
+}
+
+// Write reads the transactions from the cursor and writes them to w one at a time,
+// so the whole export is never held in memory. It returns the number of transactions written.
+func (e *TransactionExport) Write(ctx context.Context, w io.Writer) (int, error) {
+
+	if e.format == FormatJSONL {
+		return e.writeJSONL(ctx, w)
+	}
+
+	return e.writeCSV(ctx, w)
+}
+
+// Close closes the cursor of the export.
+func (e *TransactionExport) Close(ctx context.Context) error {
+	return e.cursor.Close(ctx)
+}
+
+// writeJSONL writes one JSON document per line.
+func (e *TransactionExport) writeJSONL(ctx context.Context, w io.Writer) (int, error) {
+
+	enc := json.NewEncoder(w)
+
+	n := 0
+	for e.cursor.Next(ctx) {
+
+		var tranx Transaction
+		if err := e.cursor.Decode(&tranx); err != nil {
+			return n, errors.Wrap(err, "decoding transaction")
+		}
+
+		if err := enc.Encode(e.row(tranx)); err != nil {
+			return n, errors.Wrap(err, "writing transaction")
+		}
+		n++
+	}
+
+	if err := e.cursor.Err(); err != nil {
+		return n, errors.Wrap(err, "reading transactions")
+	}
+
+	return n, nil
+}
+
+// writeCSV writes a header followed by one record per transaction.
+// Accounts are joined with "; " so each transaction stays on one line.
+func (e *TransactionExport) writeCSV(ctx context.Context, w io.Writer) (int, error) {
+
+	excel := e.format == FormatExcel
+
+	if excel {
+		if _, err := io.WriteString(w, "\ufeff"); err != nil {
+			return 0, errors.Wrap(err, "writing byte order mark")
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = excel
+
+	if err := cw.Write(exportColumns); err != nil {
+		return 0, errors.Wrap(err, "writing header")
+	}
+
+	text := func(s string) string {
+		if excel {
+			return spreadsheetText(s)
+		}
+		return s
+	}
+
+	n := 0
+	for e.cursor.Next(ctx) {
+
+		var tranx Transaction
+		if err := e.cursor.Decode(&tranx); err != nil {
+			return n, errors.Wrap(err, "decoding transaction")
+		}
+
+		row := e.row(tranx)
+
+		record := []string{
+			row.ID,
+			row.Occurrence,
+			text(row.TransactionEvent),
+			row.TransactionCredit.String(),
+			row.TransactionDebit.String(),
+			text(row.Currency),
+			text(row.Budget),
+			text(row.Vendor),
+			text(strings.Join(row.FinancialAccounts, "; ")),
+			text(row.ExternalID),
+			row.CreatedAt,
+			row.UpdatedAt,
+		}
+
+		if err := cw.Write(record); err != nil {
+			return n, errors.Wrap(err, "writing transaction")
+		}
+		n++
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return n, errors.Wrap(err, "writing transactions")
+	}
+
+	if err := e.cursor.Err(); err != nil {
+		return n, errors.Wrap(err, "reading transactions")
+	}
+
+	return n, nil
+}
+
+// row replaces the references of a transaction by names.
+// A reference to a document that no longer exists is written as it is stored.
+// The budget of a split transaction lists the budget of each split.
+func (e *TransactionExport) row(tranx Transaction) ExportRow {
+
+	name := func(names map[string]string, id string) string {
+		if n, ok := names[id]; ok {
+			return n
+		}
+		return id
+	}
+
+	accounts := make([]string, len(tranx.FinancialAccountID))
+	for i, id := range tranx.FinancialAccountID {
+		accounts[i] = name(e.accounts, id)
+	}
+
+	budgetName := name(e.budgets, tranx.BudgetID)
+	if len(tranx.Splits) > 0 {
+		budgets := make([]string, len(tranx.Splits))
+		for i, s := range tranx.Splits {
+			budgets[i] = name(e.budgets, s.BudgetID)
+		}
+		budgetName = strings.Join(budgets, "; ")
+	}
+
+	row := ExportRow{
+		ID:                tranx.ID.Hex(),
+		TransactionEvent:  tranx.TransactionEvent,
+		TransactionCredit: tranx.TransactionCredit,
+		TransactionDebit:  tranx.TransactionDebit,
+		Currency:          name(e.currencies, tranx.CurrencyID),
+		Budget:            budgetName,
+		Vendor:            name(e.vendors, tranx.VendorID),
+		FinancialAccounts: accounts,
+		ExternalID:        tranx.ExternalID,
+		CreatedAt:         exportTime(tranx.CreatedAt),
+		UpdatedAt:         exportTime(tranx.UpdatedAt),
+	}
+
+	if !tranx.Occurrence.IsZero() {
+		row.Occurrence = tranx.Occurrence.UTC().Format("2006-01-02")
+	}
+
+	return row
+}
+
+// exportTime formats a timestamp as RFC3339, or as an empty string when it is NOT set.
+func exportTime(t time.Time) string {
+
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// spreadsheetText keeps a spreadsheet from reading text as a formula by starting it with a quote.
+func spreadsheetText(s string) string {
+
+	if s != "" && strings.ContainsAny(s[:1], "=+-@\t\r") {
+		return "'" + s
+	}
+
+	return s
+}
+
+// namesByID maps the _id of every document of a collection to the value of one of its string fields.
+func namesByID(ctx context.Context, db *mongo.Database, collection, field string) (map[string]string, error) {
+
+	opts := options.Find().SetProjection(bson.M{field: 1})
+
+	cursor, err := db.Collection(collection).Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting cursor from %s collection", collection)
+	}
+
+	var docs []bson.M
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, errors.Wrapf(err, "retrieving %s", collection)
+	}
+
+	names := make(map[string]string, len(docs))
+	for _, doc := range docs {
+
+		var id string
+		switch v := doc["_id"].(type) {
+		case primitive.ObjectID:
+			id = v.Hex()
+		case string:
+			id = v
+		default:
+			continue
+		}
+
+		if name, ok := doc[field].(string); ok {
+			names[id] = name
+		}
+	}
+
+	return names, nil
+}
@@ -0,0 +1,47 @@
+package budget
+
+import "go.mongodb.org/mongo-driver/bson"
+
+// Unexported parts of the package used by the tests of package budget_test.
+var (
+	BalanceDelta          = balanceDelta
+	AccountValue          = accountValue
+	CurrencyMismatches    = currencyMismatches
+	PermanentAlertError   = permanentAlertError
+	IsDuplicateKey        = isDuplicateKey
+	DuplicateFilter       = duplicateFilter
+	CheckTransferAccounts = checkTransferAccounts
+	DuplicateWindow       = duplicateWindow
+	CashFlowPipeline      = cashFlowPipeline
+	VendorKey             = vendorKey
+)
+
+// LinesNet sums one ledger line for each credit and debit pair, see linesNet.
+func LinesNet(currencyID string, pairs ...[2]Money) Money {
+
+	lines := make([]ledgerLine, len(pairs))
+	for i, p := range pairs {
+		lines[i] = ledgerLine{Credit: p[0], Debit: p[1]}
+	}
+
+	return linesNet(lines, currencyID)
+}
+
+// references are the fields of a transaction a deleted document may be referred to by.
+var references = map[string]reference{
+	budgetRef.field:      budgetRef,
+	currencyRef.field:    currencyRef,
+	vendorRef.field:      vendorRef,
+	accountRef.field:     accountRef,
+	participantRef.field: participantRef,
+}
+
+// ReferenceFilter matches the transactions that refer through field to the document identified by id, see reference.filter.
+func ReferenceFilter(field, id string) bson.M {
+	return references[field].filter(id)
+}
+
+// CheckDeleteRule checks the rule for deleting the document identified by id that is referred to through field, see DeleteRule.check.
+func CheckDeleteRule(field, id string, rule DeleteRule) error {
+	return rule.check(references[field], id)
+}
@@ -7,29 +7,41 @@ import (
 
 	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// Types of financial accounts. Net worth snapshots are grouped by them.
+// Accounts that are owed, like credit cards and loans, carry a negative value when money is owed on them.
+const (
+	AccountChecking   = "checking"
+	AccountSavings    = "savings"
+	AccountCredit     = "credit"
+	AccountLoan       = "loan"
+	AccountInvestment = "investment"
+	AccountCash       = "cash"
+	AccountOther      = "other"
+)
+
 // ListFinancialAccounts gets all the FinancialAccounts from the db then encodes them in a response client
-func ListFinancialAccounts(ctx context.Context, db *mongo.Collection) ([]FinancialAccount, error) {
+// Results are returned one page at a time.
+func ListFinancialAccounts(ctx context.Context, db *mongo.Collection, page database.Page) ([]FinancialAccount, *database.PageInfo, error) {
+
 	list := []FinancialAccount{}
 
-	cursor, err := db.Find(ctx, bson.M{})
+	info, err := database.FindPage(ctx, db, bson.M{}, page, &list)
 	if err != nil {
-		return nil, errors.Wrapf(err, "getting cursor from financial accounts collection.")
-	}
-
-	if err = cursor.All(ctx, &list); err != nil {
-		return nil, errors.Wrapf(err, "retrieving financial accounts list")
+		return nil, nil, errors.Wrapf(err, "retrieving financial accounts list")
 	}
 
-	return list, nil
+	return list, info, nil
 }
 
 // CreateFinancialAccount takes data from the client to create a financial account in the db
+// The current value provided is kept as the opening value of the account.
 func CreateFinancialAccount(ctx context.Context, db *mongo.Collection, user auth.Claims, newFA NewFinancialAccount, now time.Time) (*FinancialAccount, error) {
 
 	var isAdmin = user.HasRole(auth.RoleAdmin)
@@ -38,10 +50,18 @@ func CreateFinancialAccount(ctx context.Context, db *mongo.Collection, user auth
 		return nil, apierror.ErrForbidden
 	}
 
+	if err := checkAccountType(newFA.AccountType); err != nil {
+		return nil, err
+	}
+
 	financialAccount := FinancialAccount{
+		ID:                   primitive.NewObjectID(),
 		AccountName:          newFA.AccountName,
-		CurrentValue:         newFA.CurrentValue,
+		CurrentValue:         Money{Amount: newFA.CurrentValue.Amount, CurrencyID: newFA.CurrencyID},
+		OpeningValue:         Money{Amount: newFA.CurrentValue.Amount, CurrencyID: newFA.CurrencyID},
+		CurrencyID:           newFA.CurrencyID,
 		FinancialInstitution: newFA.FinancialInstitution,
+		AccountType:          newFA.AccountType,
 		MangerID:             user.Subject,
 		CreatedAt:            now.UTC(),
 		UpdatedAt:            now.UTC(),
@@ -76,14 +96,17 @@ func RetrieveFinancialAccount(ctx context.Context, db *mongo.Collection, _id str
 
 // UpdateOneFinancialAccount modifies data about a Financial Account.
 // It will error if the specified _id is invalid or does NOT reference an existing Financial Account.
-func UpdateOneFinancialAccount(ctx context.Context, db *mongo.Collection, user auth.Claims, faID string, updateFA UpdateFinancialAccount, now time.Time) error {
+// A new current value corrects the balance: the opening value is adjusted so the account keeps its ledger.
+func UpdateOneFinancialAccount(ctx context.Context, db *mongo.Database, user auth.Claims, faID string, updateFA UpdateFinancialAccount, now time.Time) error {
 
 	faObjectID, err := primitive.ObjectIDFromHex(faID)
 	if err != nil {
 		return apierror.ErrInvalidID
 	}
 
-	foundFA, err := RetrieveFinancialAccount(ctx, db, faID)
+	faCollection := db.Collection(FinancialAccountCollection)
+
+	foundFA, err := RetrieveFinancialAccount(ctx, faCollection, faID)
 	if err != nil {
 		return apierror.ErrNotFound
 	}
@@ -106,14 +129,23 @@ func UpdateOneFinancialAccount(ctx context.Context, db *mongo.Collection, user a
 		financialAccount.AccountName = *updateFA.AccountName
 	}
 
-	if updateFA.CurrentValue != nil {
-		financialAccount.CurrentValue = *updateFA.CurrentValue
-	}
-
 	if updateFA.FinancialInstitution != nil {
 		financialAccount.FinancialInstitution = *updateFA.FinancialInstitution
 	}
 
+	if updateFA.AccountType != nil {
+		if err := checkAccountType(*updateFA.AccountType); err != nil {
+			return err
+		}
+		financialAccount.AccountType = *updateFA.AccountType
+	}
+
+	currencyID := foundFA.CurrencyID
+	if updateFA.CurrencyID != nil {
+		currencyID = *updateFA.CurrencyID
+		financialAccount.CurrencyID = currencyID
+	}
+
 	financialAccount.ID = faObjectID
 
 	financialAccount.UpdatedAt = now
@@ -122,25 +154,55 @@ func UpdateOneFinancialAccount(ctx context.Context, db *mongo.Collection, user a
 		"$set": financialAccount,
 	}
 
-	faResult, err := db.UpdateOne(ctx, bson.M{"_id": faObjectID}, updateFinAcc)
-	if err != nil {
-		return errors.Wrap(err, "updating financial account")
-	}
+	return withTransaction(ctx, db, func(sc mongo.SessionContext) error {
 
-	fmt.Printf("faResult updated %v : \n", faResult)
+		faResult, err := faCollection.UpdateOne(sc, bson.M{"_id": faObjectID}, updateFinAcc)
+		if err != nil {
+			return errors.Wrap(err, "updating financial account")
+		}
 
-	return nil
+		fmt.Printf("faResult updated %v : \n", faResult)
+
+		if updateFA.CurrentValue == nil {
+			return nil
+		}
+
+		net, err := ledgerNet(sc, db, FinancialAccount{ID: faObjectID, CurrencyID: currencyID})
+		if err != nil {
+			return err
+		}
+
+		currentValue := *updateFA.CurrentValue
+		currentValue.CurrencyID = currencyID
+
+		openingValue := currentValue.Sub(net)
+		openingValue.CurrencyID = currencyID
+
+		updateValue := bson.M{
+			"$set": bson.M{
+				"current_value": currentValue,
+				"opening_value": openingValue,
+			},
+		}
+
+		if _, err := faCollection.UpdateOne(sc, bson.M{"_id": faObjectID}, updateValue); err != nil {
+			return errors.Wrap(err, "updating financial account value")
+		}
+
+		return nil
+	})
 }
 
 // DeleteFinancialAccount removes the financial account identified by a given _id
-func DeleteFinancialAccount(ctx context.Context, db *mongo.Collection, user auth.Claims, faID string) error {
+// Transactions of the account block the delete unless the rule cascades or reassigns them.
+func DeleteFinancialAccount(ctx context.Context, db *mongo.Database, user auth.Claims, faID string, rule DeleteRule, now time.Time) error {
 
 	faObjectID, err := primitive.ObjectIDFromHex(faID)
 	if err != nil {
 		return apierror.ErrInvalidID
 	}
 
-	foundFA, err := RetrieveFinancialAccount(ctx, db, faID)
+	foundFA, err := RetrieveFinancialAccount(ctx, db.Collection(FinancialAccountCollection), faID)
 	if err != nil {
 		return apierror.ErrNotFound
 	}
@@ -157,12 +219,34 @@ func DeleteFinancialAccount(ctx context.Context, db *mongo.Collection, user auth
 		return apierror.ErrForbidden
 	}
 
-	result, err := db.DeleteOne(ctx, bson.M{"_id": faObjectID})
-	if err != nil {
-		return errors.Wrapf(err, "deleting financial account %s", faID)
-	}
+	return withTransaction(ctx, db, func(sc mongo.SessionContext) error {
+
+		if err := resolveDependents(sc, db, accountRef, faID, rule, now); err != nil {
+			return err
+		}
+
+		result, err := db.Collection(FinancialAccountCollection).DeleteOne(sc, bson.M{"_id": faObjectID})
+		if err != nil {
+			return errors.Wrapf(err, "deleting financial account %s", faID)
+		}
+
+		fmt.Print("result of deleting : ", result)
 
-	fmt.Print("result of deleting : ", result)
+		return nil
+	})
+}
+
+// checkAccountType returns a ValidationError when the type is NOT one of the account types.
+// An account without a type is allowed.
+func checkAccountType(accountType string) error {
+
+	switch accountType {
+	case "", AccountChecking, AccountSavings, AccountCredit, AccountLoan, AccountInvestment, AccountCash, AccountOther:
+		return nil
+	}
 
-	return nil
+	return &apierror.ValidationError{Fields: []apierror.FieldError{{
+		Field: "account_type",
+		Error: "account_type must be checking, savings, credit, loan, investment, cash or other",
+	}}}
 }
@@ -0,0 +1,732 @@
+package budget
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/utility"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Limits and defaults of a ForecastQuery.
+const (
+	DefaultForecastMonths  = 3
+	DefaultForecastHistory = 6
+	MaxForecastMonths      = 24
+)
+
+// Sources of a ForecastRecurrence.
+const (
+	RecurrenceTemplate = "template" // a RecurringTransaction
+	RecurrenceDetected = "detected" // a monthly pattern found in past transactions
+)
+
+// A monthly pattern needs minRecurrences occurrences, each minRecurrenceGap to maxRecurrenceGap days after the one before,
+// with amounts within maxRecurrenceDrift of their median. The latest must be at most maxRecurrenceGap days old.
+const (
+	minRecurrences     = 3
+	minRecurrenceGap   = 25
+	maxRecurrenceGap   = 35
+	maxRecurrenceDrift = 0.2
+)
+
+// ForecastQuery describes a cash flow forecast.
+type ForecastQuery struct {
+	Months     int   // months projected after the current one, DefaultForecastMonths when zero
+	History    int   // full months before the current one the averages are learned from, DefaultForecastHistory when zero
+	MinBalance Money // a projected balance below it is a low-balance warning
+}
+
+// Forecast projects the balances of the financial accounts and the spending of the budgets, month by month,
+// from the rest of the current month through the months asked for.
+type Forecast struct {
+	From        time.Time            `json:"from"` // first projected day, tomorrow
+	To          time.Time            `json:"to"`   // last projected day
+	History     SummaryWindow        `json:"history"`
+	MinBalance  Money                `json:"min_balance"`
+	Periods     []string             `json:"periods"` // YYYY-MM
+	Accounts    []AccountForecast    `json:"accounts"`
+	Budgets     []BudgetForecast     `json:"budgets"`
+	Recurring   []ForecastRecurrence `json:"recurring"`
+	Warnings    []LowBalanceWarning  `json:"warnings"`
+	Unconverted []UnconvertedAmount  `json:"unconverted"` // left out of the account balances
+}
+
+// ForecastRates are the latest exchange rates of the currency pairs a forecast needs, keyed by the from and to currency IDs.
+type ForecastRates map[[2]string]Money
+
+// UnconvertedAmount is a transaction or recurring transaction left out of the projected balance of a financial account
+// because there is no exchange rate from its currency into the currency of the account.
+type UnconvertedAmount struct {
+	Source             string `json:"source"`        // "transaction", RecurrenceTemplate or RecurrenceDetected
+	ID                 string `json:"_id,omitempty"` // of the transaction or the template
+	VendorID           string `json:"vendor_id,omitempty"`
+	FinancialAccountID string `json:"fin_acc_id"`
+	CurrencyID         string `json:"currency_id"`
+}
+
+// AccountForecast is the projected balance of one financial account, in the currency of the account.
+type AccountForecast struct {
+	FinancialAccountID string                  `json:"fin_acc_id"`
+	AccountName        string                  `json:"account_name,omitempty"`
+	CurrencyID         string                  `json:"currency_id,omitempty"`
+	Balance            Money                   `json:"balance"` // current value
+	Periods            []AccountForecastPeriod `json:"periods"`
+}
+
+// AccountForecastPeriod is the projected balance of a financial account within a month.
+type AccountForecastPeriod struct {
+	Period    string `json:"period"`
+	Recurring Money  `json:"recurring"` // net of the recurring transactions due
+	Average   Money  `json:"average"`   // net of every other transaction, from the monthly average of the history
+	Low       Money  `json:"low"`       // lowest balance at the end of a day
+	Balance   Money  `json:"balance"`   // balance at the end of the month
+}
+
+// BudgetForecast is the projected spending of one budget in one currency.
+type BudgetForecast struct {
+	BudgetID   string                 `json:"budget_id"`
+	CurrencyID string                 `json:"currency_id,omitempty"`
+	Periods    []BudgetForecastPeriod `json:"periods"`
+}
+
+// BudgetForecastPeriod is the projected spending of a budget within a month. Spending is debits less credits.
+type BudgetForecastPeriod struct {
+	Period    string `json:"period"`
+	Recurring Money  `json:"recurring"` // spending of the recurring transactions due
+	Average   Money  `json:"average"`   // spending of every other transaction, from the monthly average of the history
+	Spending  Money  `json:"spending"`  // recurring plus average
+}
+
+// ForecastRecurrence is a transaction expected to repeat, with the dates it is projected on.
+type ForecastRecurrence struct {
+	Source             string      `json:"source"`                 // RecurrenceTemplate or RecurrenceDetected
+	RecurringID        string      `json:"recurring_id,omitempty"` // _id of the RecurringTransaction of a template
+	BudgetID           string      `json:"budget_id,omitempty"`
+	VendorID           string      `json:"vendor_id,omitempty"`
+	FinancialAccountID []string    `json:"fin_acc_id,omitempty"`
+	CurrencyID         string      `json:"currency_id,omitempty"`
+	TransactionEvent   string      `json:"tranx_event,omitempty"`
+	TransactionCredit  Money       `json:"tranx_credit"`
+	TransactionDebit   Money       `json:"tranx_debit"`
+	Dates              []time.Time `json:"dates"` // overdue occurrences of a template are projected on the first day
+
+	rule  RecurrenceRule
+	start time.Time // first occurrence of the rule
+	after time.Time // occurrences on or before it are already recorded
+}
+
+// LowBalanceWarning is a month in which the projected balance of a financial account falls below the minimum.
+type LowBalanceWarning struct {
+	FinancialAccountID string    `json:"fin_acc_id"`
+	AccountName        string    `json:"account_name,omitempty"`
+	Period             string    `json:"period"`
+	Date               time.Time `json:"date"`    // first day the balance is below the minimum
+	Balance            Money     `json:"balance"` // lowest balance of the month
+}
+
+// ForecastCashFlow loads the financial accounts, the recurring transaction templates and the transactions of the history
+// and projects them with NewForecast.
+func ForecastCashFlow(ctx context.Context, db *mongo.Database, q ForecastQuery, now time.Time) (*Forecast, error) {
+
+	verr := apierror.ValidationError{}
+	if q.Months < 0 || q.Months > MaxForecastMonths {
+		verr.Add("months", "must be between 1 and 24")
+	}
+	if q.History < 0 || q.History > MaxForecastMonths {
+		verr.Add("history", "must be between 1 and 24")
+	}
+	if err := verr.Err(); err != nil {
+		return nil, err
+	}
+
+	q = q.withDefaults()
+
+	cursor, err := db.Collection(FinancialAccountCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cursor from financial account collection")
+	}
+
+	var accounts []FinancialAccount
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, errors.Wrap(err, "retrieving financial accounts")
+	}
+
+	cursor, err = db.Collection(RecurringCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cursor from recurring transaction collection")
+	}
+
+	var templates []RecurringTransaction
+	if err := cursor.All(ctx, &templates); err != nil {
+		return nil, errors.Wrap(err, "retrieving recurring transactions")
+	}
+
+	// The current month is needed to tell whether a pattern is still going on.
+	filter := bson.M{"occurrence": bson.M{"$gte": historyStart(now, q.History), "$lt": day(now.UTC()).AddDate(0, 0, 1)}}
+
+	cursor, err = db.Collection(TransactionCollection).Find(ctx, filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cursor from transaction collection")
+	}
+
+	var history []Transaction
+	if err := cursor.All(ctx, &history); err != nil {
+		return nil, errors.Wrap(err, "retrieving transactions")
+	}
+
+	rates, err := forecastRates(ctx, db, accounts, templates, history)
+	if err != nil {
+		return nil, err
+	}
+
+	forecast := NewForecast(accounts, templates, history, rates, q, now)
+
+	return &forecast, nil
+}
+
+// forecastRates finds the latest rate of each pair of a transaction or template currency and the currency of one of its accounts.
+// Pairs without a rate are left out.
+func forecastRates(ctx context.Context, db *mongo.Database, accounts []FinancialAccount, templates []RecurringTransaction, history []Transaction) (ForecastRates, error) {
+
+	currencies := accountCurrencies(accounts)
+	rates := ForecastRates{}
+
+	need := func(currencyID string, faIDs []string) error {
+		for _, faID := range faIDs {
+			pair := [2]string{currencyID, currencies[faID]}
+			if _, ok := rates[pair]; ok || pair[0] == "" || pair[1] == "" || pair[0] == pair[1] {
+				continue
+			}
+			rate, err := FindRate(ctx, db, pair[0], pair[1], time.Time{})
+			if errors.Cause(err) == ErrNoExchangeRate {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			rates[pair] = rate
+		}
+		return nil
+	}
+
+	for _, rt := range templates {
+		if err := need(rt.CurrencyID, rt.FinancialAccountID); err != nil {
+			return nil, err
+		}
+	}
+	for _, tranx := range history {
+		if err := need(tranx.CurrencyID, tranx.FinancialAccountID); err != nil {
+			return nil, err
+		}
+	}
+
+	return rates, nil
+}
+
+// convert returns m, in the currency identified by from, in the currency identified by to.
+// It reports false when the currencies differ and there is no rate for them.
+func (r ForecastRates) convert(m Money, from, to string) (Money, bool) {
+
+	if from == "" || to == "" || from == to {
+		return m, true
+	}
+
+	rate, ok := r[[2]string{from, to}]
+	if !ok {
+		return Money{}, false
+	}
+
+	converted := m.Convert(rate)
+	converted.CurrencyID = to
+
+	return converted, true
+}
+
+// accountCurrencies maps the _id of each financial account to its currency.
+func accountCurrencies(accounts []FinancialAccount) map[string]string {
+
+	currencies := map[string]string{}
+	for _, fa := range accounts {
+		currencies[fa.ID.Hex()] = fa.CurrencyID
+	}
+
+	return currencies
+}
+
+// NewForecast projects the accounts from tomorrow through the end of the last month of the query.
+//
+// Recurring transactions are the occurrences of the templates not posted yet and the monthly patterns detected in history
+// that no template covers. A pattern is at least three transactions with the same vendor, currency, accounts and direction,
+// roughly a month apart and of similar amounts; it is projected on the day of the month of its latest occurrence.
+// Every other transaction of the full months of history is averaged per month and spread evenly over the days of each
+// projected month. Account averages include transfers, budget averages do not.
+//
+// Amounts in another currency than that of an account are converted at rates. Those without a rate are left out of the
+// account and listed in Unconverted. Budgets are projected in the currencies of their transactions.
+//
+// A warning is raised for each month an account ends a day below q.MinBalance.
+func NewForecast(accounts []FinancialAccount, templates []RecurringTransaction, history []Transaction, rates ForecastRates, q ForecastQuery, now time.Time) Forecast {
+
+	q = q.withDefaults()
+
+	today := day(now.UTC())
+	first := periodStart(today, IntervalMonth)
+	last := first.AddDate(0, q.Months, 0)
+	historyFrom, historyTo := historyStart(now, q.History), first.AddDate(0, 0, -1)
+
+	forecast := Forecast{
+		From:        today.AddDate(0, 0, 1),
+		To:          nextPeriod(last, IntervalMonth).AddDate(0, 0, -1),
+		History:     SummaryWindow{From: &historyFrom, To: &historyTo},
+		MinBalance:  q.MinBalance,
+		Periods:     []string{},
+		Accounts:    []AccountForecast{},
+		Budgets:     []BudgetForecast{},
+		Recurring:   []ForecastRecurrence{},
+		Warnings:    []LowBalanceWarning{},
+		Unconverted: []UnconvertedAmount{},
+	}
+
+	currencies := accountCurrencies(accounts)
+
+	unconverted := func(source, id, vendorID, faID, currencyID string) {
+		forecast.Unconverted = append(forecast.Unconverted, UnconvertedAmount{source, id, vendorID, faID, currencyID})
+	}
+
+	var starts []time.Time
+	for start := first; !start.After(last); start = nextPeriod(start, IntervalMonth) {
+		starts = append(starts, start)
+		forecast.Periods = append(forecast.Periods, periodName(start, IntervalMonth))
+	}
+
+	// Recurring transactions, with the history transactions they account for.
+	recurring := map[string]bool{}
+
+	for _, rt := range templates {
+		rule, err := rt.rule()
+		if err != nil {
+			continue
+		}
+		forecast.Recurring = append(forecast.Recurring, ForecastRecurrence{
+			Source:             RecurrenceTemplate,
+			RecurringID:        rt.ID.Hex(),
+			BudgetID:           rt.BudgetID,
+			VendorID:           rt.VendorID,
+			FinancialAccountID: rt.FinancialAccountID,
+			CurrencyID:         rt.CurrencyID,
+			TransactionEvent:   rt.TransactionEvent,
+			TransactionCredit:  rt.TransactionCredit,
+			TransactionDebit:   rt.TransactionDebit,
+			rule:               rule,
+			start:              rt.Start,
+			after:              rt.postedThrough(),
+		})
+	}
+
+	for _, tranx := range history {
+		if tranx.RecurringID != "" {
+			recurring[tranx.ID.Hex()] = true
+		}
+	}
+
+	for _, pattern := range detectRecurring(history, templates, now) {
+		for _, tranx := range pattern.transactions {
+			recurring[tranx.ID.Hex()] = true
+		}
+		forecast.Recurring = append(forecast.Recurring, pattern.ForecastRecurrence)
+	}
+
+	type event struct {
+		accounts []string
+		budgetID string
+		currency string
+		net      Money // credit less debit
+	}
+	events := map[time.Time][]event{}
+
+	projected := forecast.Recurring[:0]
+	for _, r := range forecast.Recurring {
+		after := r.after
+		if r.Source == RecurrenceDetected && after.Before(today) {
+			after = today
+		}
+
+		r.Dates = []time.Time{}
+		for _, d := range r.rule.Between(r.start, after.AddDate(0, 0, 1), forecast.To) {
+			r.Dates = append(r.Dates, d)
+			if d.Before(forecast.From) {
+				d = forecast.From
+			}
+			events[d] = append(events[d], event{r.FinancialAccountID, r.BudgetID, r.CurrencyID, r.TransactionCredit.Sub(r.TransactionDebit)})
+		}
+
+		if len(r.Dates) == 0 {
+			continue
+		}
+		projected = append(projected, r)
+
+		for _, faID := range utility.RemoveDuplicateStringValues(r.FinancialAccountID) {
+			if _, ok := rates.convert(r.TransactionCredit, r.CurrencyID, currencies[faID]); !ok {
+				unconverted(r.Source, r.RecurringID, r.VendorID, faID, r.CurrencyID)
+			}
+		}
+	}
+	forecast.Recurring = projected
+
+	sort.SliceStable(forecast.Recurring, func(i, j int) bool {
+		return forecast.Recurring[i].Dates[0].Before(forecast.Recurring[j].Dates[0])
+	})
+
+	// Monthly averages of everything else.
+	accountTotals := map[string]Money{}
+	budgetTotals := map[[2]string]Money{}
+
+	for _, tranx := range history {
+		if recurring[tranx.ID.Hex()] || tranx.Occurrence.Before(historyFrom) || !tranx.Occurrence.Before(first) {
+			continue
+		}
+
+		net := tranx.TransactionCredit.Sub(tranx.TransactionDebit)
+		for _, faID := range utility.RemoveDuplicateStringValues(tranx.FinancialAccountID) {
+			converted, ok := rates.convert(net, tranx.CurrencyID, currencies[faID])
+			if !ok {
+				unconverted("transaction", tranx.ID.Hex(), tranx.VendorID, faID, tranx.CurrencyID)
+				continue
+			}
+			accountTotals[faID] = accountTotals[faID].Add(converted)
+		}
+
+		if tranx.TransferID != "" {
+			continue
+		}
+		for _, line := range spendingLines(tranx) {
+			key := [2]string{line.BudgetID, tranx.CurrencyID}
+			budgetTotals[key] = budgetTotals[key].Add(line.Amount)
+		}
+	}
+
+	// Accounts, day by day.
+	for _, fa := range accounts {
+
+		faID := fa.ID.Hex()
+		zero := Money{CurrencyID: fa.CurrencyID}
+
+		balance := fa.CurrentValue
+		if balance.CurrencyID == "" {
+			balance.CurrencyID = fa.CurrencyID
+		}
+
+		af := AccountForecast{
+			FinancialAccountID: faID,
+			AccountName:        fa.AccountName,
+			CurrencyID:         fa.CurrencyID,
+			Balance:            balance,
+			Periods:            []AccountForecastPeriod{},
+		}
+
+		average := zero
+		if total, ok := accountTotals[faID]; ok {
+			average = total.Split(q.History)[0]
+			average.CurrencyID = fa.CurrencyID
+		}
+
+		for i, start := range starts {
+
+			p := AccountForecastPeriod{Period: forecast.Periods[i], Recurring: zero, Average: zero, Low: balance}
+			daily := average.Split(daysIn(start))
+
+			var below time.Time
+			for d := start; d.Before(nextPeriod(start, IntervalMonth)); d = d.AddDate(0, 0, 1) {
+				if d.Before(forecast.From) {
+					continue
+				}
+
+				p.Average = p.Average.Add(daily[d.Day()-1])
+				balance = balance.Add(daily[d.Day()-1])
+
+				for _, e := range events[d] {
+					if !contains(e.accounts, faID) {
+						continue
+					}
+					if net, ok := rates.convert(e.net, e.currency, fa.CurrencyID); ok {
+						p.Recurring = p.Recurring.Add(net)
+						balance = balance.Add(net)
+					}
+				}
+
+				if balance.Cmp(p.Low) < 0 {
+					p.Low = balance
+				}
+				if below.IsZero() && balance.Cmp(q.MinBalance) < 0 {
+					below = d
+				}
+			}
+
+			p.Balance = balance
+			af.Periods = append(af.Periods, p)
+
+			if !below.IsZero() {
+				forecast.Warnings = append(forecast.Warnings, LowBalanceWarning{
+					FinancialAccountID: faID,
+					AccountName:        fa.AccountName,
+					Period:             p.Period,
+					Date:               below,
+					Balance:            p.Low,
+				})
+			}
+		}
+
+		forecast.Accounts = append(forecast.Accounts, af)
+	}
+
+	// Budgets, month by month.
+	budgets := map[[2]string]*BudgetForecast{}
+
+	budgetFor := func(key [2]string) *BudgetForecast {
+		if budgets[key] == nil {
+			zero := Money{CurrencyID: key[1]}
+			bf := &BudgetForecast{BudgetID: key[0], CurrencyID: key[1], Periods: make([]BudgetForecastPeriod, len(starts))}
+			for i := range bf.Periods {
+				bf.Periods[i] = BudgetForecastPeriod{Period: forecast.Periods[i], Recurring: zero, Average: zero, Spending: zero}
+			}
+			budgets[key] = bf
+		}
+		return budgets[key]
+	}
+
+	for key, total := range budgetTotals {
+		if key[0] == "" {
+			continue
+		}
+		bf := budgetFor(key)
+		average := total.Split(q.History)[0]
+		average.CurrencyID = key[1]
+		for i, start := range starts {
+			for _, part := range average.Split(daysIn(start))[pastDays(start, forecast.From):] {
+				bf.Periods[i].Average = bf.Periods[i].Average.Add(part)
+			}
+		}
+	}
+
+	for d, list := range events {
+		i := monthsBetween(first, d)
+		for _, e := range list {
+			if e.budgetID == "" {
+				continue
+			}
+			bf := budgetFor([2]string{e.budgetID, e.currency})
+			bf.Periods[i].Recurring = bf.Periods[i].Recurring.Sub(e.net)
+		}
+	}
+
+	for _, bf := range budgets {
+		for i, p := range bf.Periods {
+			bf.Periods[i].Spending = p.Recurring.Add(p.Average)
+		}
+		forecast.Budgets = append(forecast.Budgets, *bf)
+	}
+
+	sort.Slice(forecast.Accounts, func(i, j int) bool {
+		a, b := forecast.Accounts[i], forecast.Accounts[j]
+		if a.AccountName != b.AccountName {
+			return a.AccountName < b.AccountName
+		}
+		return a.FinancialAccountID < b.FinancialAccountID
+	})
+	sort.Slice(forecast.Budgets, func(i, j int) bool {
+		a, b := forecast.Budgets[i], forecast.Budgets[j]
+		if a.BudgetID != b.BudgetID {
+			return a.BudgetID < b.BudgetID
+		}
+		return a.CurrencyID < b.CurrencyID
+	})
+	sort.SliceStable(forecast.Warnings, func(i, j int) bool { return forecast.Warnings[i].Date.Before(forecast.Warnings[j].Date) })
+
+	return forecast
+}
+
+// withDefaults fills in the months and history left at zero.
+func (q ForecastQuery) withDefaults() ForecastQuery {
+
+	if q.Months == 0 {
+		q.Months = DefaultForecastMonths
+	}
+	if q.History == 0 {
+		q.History = DefaultForecastHistory
+	}
+
+	return q
+}
+
+// detectedRecurrence is a monthly pattern with the history transactions it was detected from.
+type detectedRecurrence struct {
+	ForecastRecurrence
+	transactions []Transaction
+}
+
+// detectRecurring finds the monthly patterns in history that are still going on as of now. Transfers, transactions posted
+// by a template and transactions without a vendor are left out, as are patterns of a vendor and currency a template already has.
+func detectRecurring(history []Transaction, templates []RecurringTransaction, now time.Time) []detectedRecurrence {
+
+	covered := map[string]bool{}
+	for _, rt := range templates {
+		covered[rt.VendorID+"|"+rt.CurrencyID] = true
+	}
+
+	groups := map[string][]Transaction{}
+	var keys []string
+
+	for _, tranx := range history {
+		if tranx.VendorID == "" || tranx.RecurringID != "" || tranx.TransferID != "" || tranx.Occurrence.IsZero() {
+			continue
+		}
+		if covered[tranx.VendorID+"|"+tranx.CurrencyID] {
+			continue
+		}
+
+		direction := "debit"
+		if tranx.TransactionDebit.IsZero() {
+			direction = "credit"
+		}
+
+		accounts := append([]string{}, tranx.FinancialAccountID...)
+		sort.Strings(accounts)
+
+		key := strings.Join([]string{tranx.VendorID, tranx.CurrencyID, direction, strings.Join(accounts, ",")}, "|")
+		if groups[key] == nil {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], tranx)
+	}
+
+	sort.Strings(keys)
+
+	var patterns []detectedRecurrence
+	for _, key := range keys {
+
+		group := groups[key]
+		if len(group) < minRecurrences {
+			continue
+		}
+
+		sort.SliceStable(group, func(i, j int) bool { return group[i].Occurrence.Before(group[j].Occurrence) })
+
+		monthly := daysBetween(group[len(group)-1].Occurrence, now) <= maxRecurrenceGap
+		for i := 1; i < len(group) && monthly; i++ {
+			gap := daysBetween(group[i-1].Occurrence, group[i].Occurrence)
+			monthly = gap >= minRecurrenceGap && gap <= maxRecurrenceGap
+		}
+		if !monthly {
+			continue
+		}
+
+		amounts := make([]Money, len(group))
+		for i, tranx := range group {
+			amounts[i] = tranx.TransactionDebit
+			if amounts[i].IsZero() {
+				amounts[i] = tranx.TransactionCredit
+			}
+		}
+		sorted := append([]Money{}, amounts...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Cmp(sorted[j]) < 0 })
+		median := sorted[len(sorted)/2]
+
+		similar := median.Sign() != 0
+		for _, amount := range amounts {
+			if similar && math.Abs(amount.Sub(median).Float64()/median.Float64()) > maxRecurrenceDrift {
+				similar = false
+			}
+		}
+		if !similar {
+			continue
+		}
+
+		latest := group[len(group)-1]
+		median.CurrencyID = latest.CurrencyID
+
+		r := ForecastRecurrence{
+			Source:             RecurrenceDetected,
+			BudgetID:           latest.BudgetID,
+			VendorID:           latest.VendorID,
+			FinancialAccountID: latest.FinancialAccountID,
+			CurrencyID:         latest.CurrencyID,
+			TransactionEvent:   latest.TransactionEvent,
+			TransactionCredit:  Money{CurrencyID: latest.CurrencyID},
+			TransactionDebit:   Money{CurrencyID: latest.CurrencyID},
+			rule:               RecurrenceRule{Freq: FreqMonthly, Interval: 1, ByMonthDay: []int{latest.Occurrence.Day()}},
+			start:              day(latest.Occurrence),
+			after:              day(latest.Occurrence),
+		}
+		if latest.TransactionDebit.IsZero() {
+			r.TransactionCredit = median
+		} else {
+			r.TransactionDebit = median
+		}
+
+		patterns = append(patterns, detectedRecurrence{r, group})
+	}
+
+	return patterns
+}
+
+// spendingLines is the spending, debit less credit, of a transaction per budget.
+// A split is a debit when the transaction has a debit, otherwise it is a credit.
+func spendingLines(tranx Transaction) []Split {
+
+	if len(tranx.Splits) == 0 {
+		if tranx.BudgetID == "" {
+			return nil
+		}
+		return []Split{{BudgetID: tranx.BudgetID, Amount: tranx.TransactionDebit.Sub(tranx.TransactionCredit)}}
+	}
+
+	lines := make([]Split, len(tranx.Splits))
+	for i, s := range tranx.Splits {
+		lines[i] = Split{BudgetID: s.BudgetID, Amount: s.Amount}
+		if tranx.TransactionDebit.IsZero() {
+			lines[i].Amount = s.Amount.Neg()
+		}
+	}
+
+	return lines
+}
+
+// historyStart is the first day of the months of history before the month of now.
+func historyStart(now time.Time, months int) time.Time {
+	return periodStart(now, IntervalMonth).AddDate(0, -months, 0)
+}
+
+// daysIn is the number of days of the month starting at start.
+func daysIn(start time.Time) int {
+	return nextPeriod(start, IntervalMonth).AddDate(0, 0, -1).Day()
+}
+
+// pastDays is the number of days of the month starting at start that fall before from, which are NOT projected.
+func pastDays(start, from time.Time) int {
+
+	if !from.After(start) {
+		return 0
+	}
+	if n := daysBetween(start, from); n < daysIn(start) {
+		return n
+	}
+
+	return daysIn(start)
+}
+
+// daysBetween is the number of days from the date of a to the date of b.
+func daysBetween(a, b time.Time) int {
+	return int(day(b).Sub(day(a)).Hours() / 24)
+}
+
+// monthsBetween is the number of months from the month of a to the month of b.
+func monthsBetween(a, b time.Time) int {
+	return (b.Year()-a.Year())*12 + int(b.Month()) - int(a.Month())
+}
@@ -0,0 +1,230 @@
+package budget_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/google/go-cmp/cmp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNewForecast(t *testing.T) {
+	date := func(m time.Month, d int) time.Time { return time.Date(2020, m, d, 0, 0, 0, 0, time.UTC) }
+
+	checking := budget.FinancialAccount{ID: primitive.NewObjectID(), AccountName: "checking", CurrentValue: money(t, "200"), CurrencyID: "5f381f30f815d062fb9da8f1"}
+	faID := checking.ID.Hex()
+
+	tranx := func(vendorID, budgetID, debit string, occurrence time.Time) budget.Transaction {
+		return budget.Transaction{
+			ID:                 primitive.NewObjectID(),
+			BudgetID:           budgetID,
+			VendorID:           vendorID,
+			CurrencyID:         "5f381f30f815d062fb9da8f1",
+			FinancialAccountID: []string{faID},
+			TransactionDebit:   money(t, debit),
+			Occurrence:         occurrence,
+		}
+	}
+
+	transfer := tranx("", "", "90", date(time.July, 10))
+	transfer.TransferID = primitive.NewObjectID().Hex()
+
+	history := []budget.Transaction{
+		tranx("landlord", "home", "900", date(time.June, 1)),
+		tranx("landlord", "home", "900", date(time.July, 1)),
+		tranx("landlord", "home", "900", date(time.August, 1)),
+		tranx("landlord", "home", "900", date(time.September, 1)),
+		tranx("market", "food", "310", date(time.June, 3)),
+		tranx("market", "food", "300", date(time.July, 20)),
+		tranx("market", "food", "320", date(time.August, 2)),
+		transfer,
+	}
+
+	lastPosted := date(time.August, 15)
+	salary := budget.RecurringTransaction{
+		ID:                 primitive.NewObjectID(),
+		Rule:               "FREQ=MONTHLY;BYMONTHDAY=15",
+		Start:              date(time.January, 15),
+		LastPosted:         &lastPosted,
+		CurrencyID:         "5f381f30f815d062fb9da8f1",
+		FinancialAccountID: []string{faID},
+		TransactionCredit:  money(t, "1000"),
+		VendorID:           "employer",
+	}
+
+	q := budget.ForecastQuery{Months: 3, History: 3, MinBalance: money(t, "100")}
+	now := time.Date(2020, time.September, 14, 18, 30, 0, 0, time.UTC)
+
+	forecast := budget.NewForecast([]budget.FinancialAccount{checking}, []budget.RecurringTransaction{salary}, history, nil, q, now)
+
+	if got := strings.Join(forecast.Periods, " "); got != "2020-09 2020-10 2020-11 2020-12" {
+		t.Fatalf("expected the current month and 3 more, got %q", got)
+	}
+
+	if got := forecast.History.From.Format("2006-01-02") + " " + forecast.History.To.Format("2006-01-02"); got != "2020-06-01 2020-08-31" {
+		t.Fatalf("expected June through August as history, got %s", got)
+	}
+
+	var recurring []string
+	for _, r := range forecast.Recurring {
+		var dates []string
+		for _, d := range r.Dates {
+			dates = append(dates, d.Format("01-02"))
+		}
+		recurring = append(recurring, r.Source+" "+r.VendorID+" "+strings.Join(dates, ","))
+	}
+	if got, want := strings.Join(recurring, "; "), "template employer 09-15,10-15,11-15,12-15; detected landlord 10-01,11-01,12-01"; got != want {
+		t.Fatalf("expected recurring %q, got %q", want, got)
+	}
+
+	if len(forecast.Accounts) != 1 {
+		t.Fatalf("expected 1 account, got %d", len(forecast.Accounts))
+	}
+
+	amounts := []struct {
+		name      string
+		got, want budget.Money
+	}{
+		{"september average", forecast.Accounts[0].Periods[0].Average, money(t, "-181.28")},
+		{"september recurring", forecast.Accounts[0].Periods[0].Recurring, money(t, "1000")},
+		{"september balance", forecast.Accounts[0].Periods[0].Balance, money(t, "1018.72")},
+		{"october recurring", forecast.Accounts[0].Periods[1].Recurring, money(t, "100")},
+		{"october low", forecast.Accounts[0].Periods[1].Low, money(t, "-34.86")},
+		{"october balance", forecast.Accounts[0].Periods[1].Balance, money(t, "778.72")},
+		{"december balance", forecast.Accounts[0].Periods[3].Balance, money(t, "298.72")},
+	}
+	for _, tt := range amounts {
+		if tt.got.Cmp(tt.want) != 0 {
+			t.Fatalf("expected %s of %s, got %s", tt.name, tt.want, tt.got)
+		}
+	}
+
+	var budgets []string
+	for _, b := range forecast.Budgets {
+		var spending []string
+		for _, p := range b.Periods {
+			spending = append(spending, p.Spending.String())
+		}
+		budgets = append(budgets, b.BudgetID+" "+strings.Join(spending, ","))
+	}
+	if got, want := strings.Join(budgets, "; "), "food 165.28,310.00,310.00,310.00; home 0,900,900,900"; got != want {
+		t.Fatalf("expected budget spending %q, got %q", want, got)
+	}
+
+	var warnings []string
+	for _, w := range forecast.Warnings {
+		warnings = append(warnings, w.Period+" "+w.Date.Format("01-02")+" "+w.Balance.String())
+	}
+	if got, want := strings.Join(warnings, "; "), "2020-10 10-02 -34.86; 2020-11 11-01 -280.00; 2020-12 12-01 -514.86"; got != want {
+		t.Fatalf("expected warnings %q, got %q", want, got)
+	}
+}
+
+func TestNewForecastDetection(t *testing.T) {
+	now := time.Date(2020, time.September, 14, 0, 0, 0, 0, time.UTC)
+
+	pattern := func(vendorID string, amounts []string, days ...int) []budget.Transaction {
+		var tranxs []budget.Transaction
+		for i, d := range days {
+			tranxs = append(tranxs, budget.Transaction{
+				ID:                 primitive.NewObjectID(),
+				VendorID:           vendorID,
+				FinancialAccountID: []string{"a"},
+				TransactionDebit:   money(t, amounts[i%len(amounts)]),
+				Occurrence:         time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, d),
+			})
+		}
+		return tranxs
+	}
+
+	tests := []struct {
+		name      string
+		history   []budget.Transaction
+		templates []budget.RecurringTransaction
+		want      int
+	}{
+		{"monthly", pattern("gym", []string{"30"}, 0, 30, 61, 92), nil, 1},
+		{"similar amounts", pattern("power", []string{"80", "95", "72"}, 0, 30, 61, 92), nil, 1},
+		{"too few", pattern("gym", []string{"30"}, 61, 92), nil, 0},
+		{"irregular", pattern("gym", []string{"30"}, 0, 12, 61, 92), nil, 0},
+		{"different amounts", pattern("power", []string{"80", "160"}, 0, 30, 61, 92), nil, 0},
+		{"stopped", pattern("gym", []string{"30"}, 0, 30, 61), nil, 0},
+		{"without vendor", pattern("", []string{"30"}, 0, 30, 61, 92), nil, 0},
+		{"covered by a template", pattern("gym", []string{"30"}, 0, 30, 61, 92), []budget.RecurringTransaction{{Rule: "FREQ=YEARLY", Start: now.AddDate(1, 0, 0), VendorID: "gym"}}, 0},
+	}
+
+	for _, tt := range tests {
+		forecast := budget.NewForecast(nil, tt.templates, tt.history, nil, budget.ForecastQuery{}, now)
+
+		var got int
+		for _, r := range forecast.Recurring {
+			if r.Source == budget.RecurrenceDetected {
+				got++
+			}
+		}
+
+		if got != tt.want {
+			t.Fatalf("%s: expected %d detected patterns, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestNewForecastCurrencies(t *testing.T) {
+	date := func(m time.Month, d int) time.Time { return time.Date(2020, m, d, 0, 0, 0, 0, time.UTC) }
+
+	checking := budget.FinancialAccount{ID: primitive.NewObjectID(), AccountName: "checking", CurrentValue: money(t, "0"), CurrencyID: "usd"}
+	faID := checking.ID.Hex()
+
+	tranx := func(currencyID, debit string) budget.Transaction {
+		return budget.Transaction{
+			ID:                 primitive.NewObjectID(),
+			CurrencyID:         currencyID,
+			FinancialAccountID: []string{faID},
+			TransactionDebit:   money(t, debit),
+			Occurrence:         date(time.August, 10),
+		}
+	}
+
+	unknown := tranx("gbp", "10")
+	history := []budget.Transaction{tranx("usd", "10"), tranx("eur", "10"), unknown}
+
+	templates := []budget.RecurringTransaction{
+		{ID: primitive.NewObjectID(), Rule: "FREQ=MONTHLY;BYMONTHDAY=15", Start: date(time.October, 15), CurrencyID: "eur", FinancialAccountID: []string{faID}, TransactionCredit: money(t, "100")},
+		{ID: primitive.NewObjectID(), Rule: "FREQ=MONTHLY;BYMONTHDAY=20", Start: date(time.October, 20), CurrencyID: "gbp", FinancialAccountID: []string{faID}, TransactionCredit: money(t, "500"), VendorID: "employer"},
+	}
+
+	rates := budget.ForecastRates{{"eur", "usd"}: money(t, "1.5")}
+	q := budget.ForecastQuery{Months: 3, History: 1}
+	now := time.Date(2020, time.September, 30, 12, 0, 0, 0, time.UTC)
+
+	forecast := budget.NewForecast([]budget.FinancialAccount{checking}, templates, history, rates, q, now)
+
+	periods := forecast.Accounts[0].Periods
+	amounts := []struct {
+		name      string
+		got, want budget.Money
+	}{
+		{"october average", periods[1].Average, money(t, "-25")},
+		{"october recurring", periods[1].Recurring, money(t, "150")},
+		{"december balance", periods[3].Balance, money(t, "375")},
+	}
+	for _, tt := range amounts {
+		if tt.got.Cmp(tt.want) != 0 {
+			t.Fatalf("expected %s of %s, got %s", tt.name, tt.want, tt.got)
+		}
+	}
+
+	var got []string
+	for _, u := range forecast.Unconverted {
+		got = append(got, strings.Join([]string{u.Source, u.ID, u.VendorID, u.FinancialAccountID, u.CurrencyID}, " "))
+	}
+	want := []string{
+		strings.Join([]string{budget.RecurrenceTemplate, templates[1].ID.Hex(), "employer", faID, "gbp"}, " "),
+		strings.Join([]string{"transaction", unknown.ID.Hex(), "", faID, "gbp"}, " "),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("unconverted amounts did not match expected. Diff:\n%s", diff)
+	}
+}
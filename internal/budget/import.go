@@ -0,0 +1,555 @@
+package budget
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Formats of the bank statements accepted by ImportTransactions.
+const (
+	FormatCSV = "csv"
+	FormatOFX = "ofx" // QFX files are OFX files too
+)
+
+// Outcomes of one row of an imported statement.
+const (
+	ImportCreated = "created"
+	ImportSkipped = "skipped"
+	ImportFailed  = "failed"
+)
+
+// ImportProfile says which columns of a CSV statement hold each part of a transaction, and
+// which budget, currency and financial account the imported transactions belong to.
+// Columns are matched by header name, ignoring case.
+// A statement either has one signed amount column, where a negative amount is a debit, or separate credit and debit columns.
+type ImportProfile struct {
+	Date        string `json:"date,omitempty"`        // default "date"
+	DateFormat  string `json:"date_format,omitempty"` // Go layout, e.g. "01/02/2006"; by default the occurrence formats are tried
+	Description string `json:"description,omitempty"` // default "description"
+	Amount      string `json:"amount,omitempty"`      // default "amount" unless credit or debit is given
+	Credit      string `json:"credit,omitempty"`
+	Debit       string `json:"debit,omitempty"`
+	Vendor      string `json:"vendor,omitempty"`      // by default the description names the vendor
+	ExternalID  string `json:"external_id,omitempty"` // column holding an id given by the bank
+	Delimiter   string `json:"delimiter,omitempty"`   // default ","
+
+	BudgetID           string `json:"budget_id,omitempty"`
+	CurrencyID         string `json:"currency_id,omitempty"` // by default the CURDEF of an OFX statement
+	FinancialAccountID string `json:"fin_acc_id,omitempty"`
+}
+
+// StatementRow is one transaction read from a bank statement.
+type StatementRow struct {
+	Line        int // line of a CSV file, position of a transaction in an OFX file
+	ExternalID  string
+	Occurrence  time.Time
+	Description string
+	Vendor      string
+	Amount      Money // negative for a debit
+	Err         error // why the row could NOT be read
+}
+
+// ImportRow reports what happened to one row of an imported statement.
+type ImportRow struct {
+	Line          int    `json:"line"`
+	Status        string `json:"status"`
+	TransactionID string `json:"tranx_id,omitempty"` // the created transaction, or the existing one a duplicate matched
+	Reason        string `json:"reason,omitempty"`
+}
+
+// ImportReport reports the outcome of ImportTransactions.
+type ImportReport struct {
+	Created int         `json:"created"`
+	Skipped int         `json:"skipped"`
+	Failed  int         `json:"failed"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+// add records the outcome of one row.
+func (r *ImportReport) add(row ImportRow) {
+
+	switch row.Status {
+	case ImportCreated:
+		r.Created++
+	case ImportSkipped:
+		r.Skipped++
+	case ImportFailed:
+		r.Failed++
+	}
+
+	r.Rows = append(r.Rows, row)
+}
+
+// ImportTransactions creates a transaction for every row of a bank statement.
+// Vendors are found by name, ignoring case, and created when they do NOT exist.
+// Rows matching an existing transaction are skipped as duplicates: by external id when the bank gives one,
+// otherwise by occurrence date, description and amount. Rows without an external id may rightly repeat, e.g. two equal
+// purchases on one day, so the nth such row is only skipped when n transactions match it.
+// Importing the same statement twice creates nothing the second time.
+func ImportTransactions(ctx context.Context, db *mongo.Database, user auth.Claims, format string, r io.Reader, profile ImportProfile, now time.Time) (*ImportReport, error) {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return nil, apierror.ErrForbidden
+	}
+
+	rows, currencyName, err := ParseStatement(format, r, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	currencyID := profile.CurrencyID
+	if currencyID == "" && currencyName != "" {
+		currencies, err := currencyIDsByName(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		currencyID = currencies[strings.ToUpper(currencyName)]
+	}
+
+	vendors, err := vendorIDsByName(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	report := ImportReport{Rows: []ImportRow{}}
+	seen := map[string]int{}  // line a key was first found on
+	count := map[string]int{} // rows found with a key so far
+
+	for _, row := range rows {
+
+		if row.Err != nil {
+			report.add(ImportRow{Line: row.Line, Status: ImportFailed, Reason: row.Err.Error()})
+			continue
+		}
+
+		row.Amount.CurrencyID = currencyID
+
+		key := row.key()
+		count[key]++
+		if count[key] == 1 {
+			seen[key] = row.Line
+		} else if row.ExternalID != "" {
+			report.add(ImportRow{Line: row.Line, Status: ImportSkipped, Reason: fmt.Sprintf("duplicate of line %d", seen[key])})
+			continue
+		}
+
+		duplicate, err := findDuplicate(ctx, db, row, profile.FinancialAccountID, count[key])
+		if err != nil {
+			return nil, err
+		}
+		if duplicate != nil {
+			report.add(ImportRow{Line: row.Line, Status: ImportSkipped, TransactionID: duplicate.ID.Hex(), Reason: "duplicate of an existing transaction"})
+			continue
+		}
+
+		vendorID, err := resolveVendor(ctx, db, user, vendors, row.Vendor, now)
+		if err != nil {
+			return nil, err
+		}
+
+		newTranx := NewTransaction{
+			BudgetID:         profile.BudgetID,
+			CurrencyID:       currencyID,
+			Occurrence:       row.Occurrence.Format("2006-01-02"),
+			TransactionEvent: row.Description,
+			VendorID:         vendorID,
+			ExternalID:       row.ExternalID,
+		}
+
+		if profile.FinancialAccountID != "" {
+			newTranx.FinancialAccountID = &[]string{profile.FinancialAccountID}
+		}
+
+		if row.Amount.Sign() < 0 {
+			newTranx.TransactionDebit = row.Amount.Neg()
+		} else {
+			newTranx.TransactionCredit = row.Amount
+		}
+
+		tranx, err := CreateTransaction(ctx, db, user, newTranx, now)
+		if err != nil {
+			if verr, ok := err.(*apierror.ValidationError); ok {
+				report.add(ImportRow{Line: row.Line, Status: ImportFailed, Reason: verr.Error()})
+				continue
+			}
+			return nil, err
+		}
+
+		report.add(ImportRow{Line: row.Line, Status: ImportCreated, TransactionID: tranx.ID.Hex()})
+	}
+
+	return &report, nil
+}
+
+// key identifies a row. A bank id listed twice in one statement is only imported once;
+// rows without one are counted so each is matched against a different existing transaction.
+func (row StatementRow) key() string {
+
+	if row.ExternalID != "" {
+		return "id:" + row.ExternalID
+	}
+
+	return fmt.Sprintf("%s|%s|%s", row.Occurrence.Format("2006-01-02"), row.Description, row.Amount)
+}
+
+// findDuplicate returns the nth existing transaction, oldest first, that row was already imported as,
+// or nil when fewer than n match.
+func findDuplicate(ctx context.Context, db *mongo.Database, row StatementRow, faID string, n int) (*Transaction, error) {
+
+	opts := options.FindOne().SetSort(bson.M{"_id": 1}).SetSkip(int64(n - 1))
+
+	var tranx Transaction
+	if err := db.Collection(TransactionCollection).FindOne(ctx, duplicateFilter(row, faID), opts).Decode(&tranx); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "looking for duplicate transaction")
+	}
+
+	return &tranx, nil
+}
+
+// duplicateFilter matches the transactions row may have been imported as into the financial account identified by faID:
+// the one with its bank id, or else those on its day with its description and amount.
+func duplicateFilter(row StatementRow, faID string) bson.M {
+
+	filter := bson.M{}
+
+	if faID != "" {
+		filter["fin_acc_id"] = faID
+	}
+
+	if row.ExternalID != "" {
+		filter["external_id"] = row.ExternalID
+	} else {
+		// a zero amount is left out of the document, or was stored as 0 before the move to Money
+		zero := bson.M{"$in": bson.A{nil, 0}}
+		credit, debit := zero, zero
+		if row.Amount.Sign() < 0 {
+			debit = bson.M{"$eq": row.Amount.Neg().Amount}
+		} else if row.Amount.Sign() > 0 {
+			credit = bson.M{"$eq": row.Amount.Amount}
+		}
+
+		// transactions are stored on the day of the row, without the time of day a statement may give
+		filter["occurrence"] = day(row.Occurrence)
+		filter["tranx_event"] = row.Description
+		filter["tranx_credit.amount"] = credit
+		filter["tranx_debit.amount"] = debit
+	}
+
+	return filter
+}
+
+// vendorIDsByName maps the name of every vendor to its _id, keyed by vendorKey.
+func vendorIDsByName(ctx context.Context, db *mongo.Database) (map[string]string, error) {
+
+	cursor, err := db.Collection(VendorCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cursor from vendor collection")
+	}
+
+	var vendors []Vendor
+	if err := cursor.All(ctx, &vendors); err != nil {
+		return nil, errors.Wrap(err, "retrieving vendors")
+	}
+
+	ids := make(map[string]string, len(vendors))
+	for _, v := range vendors {
+		ids[vendorKey(v.VendorName)] = v.ID.Hex()
+	}
+
+	return ids, nil
+}
+
+// resolveVendor returns the _id of the vendor with the given name, creating the vendor when there is none.
+// Created vendors are added to vendors so they are only created once.
+func resolveVendor(ctx context.Context, db *mongo.Database, user auth.Claims, vendors map[string]string, name string, now time.Time) (string, error) {
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil
+	}
+
+	if id, ok := vendors[vendorKey(name)]; ok {
+		return id, nil
+	}
+
+	vendor, err := CreateVendor(ctx, db.Collection(VendorCollection), user, NewVendor{VendorName: name}, now)
+	if err != nil {
+		return "", err
+	}
+
+	vendors[vendorKey(name)] = vendor.ID.Hex()
+
+	return vendor.ID.Hex(), nil
+}
+
+// ParseStatement reads the transactions of a bank statement in the given format.
+// For an OFX statement the currency named by its CURDEF is returned too.
+// Rows that can NOT be read are returned with Err set; an error is only returned when the file can NOT be read at all.
+func ParseStatement(format string, r io.Reader, profile ImportProfile) ([]StatementRow, string, error) {
+
+	switch strings.ToLower(format) {
+	case FormatCSV:
+		rows, err := parseCSVStatement(r, profile)
+		return rows, "", err
+	case FormatOFX, "qfx":
+		return parseOFXStatement(r)
+	default:
+		return nil, "", errors.Wrapf(ErrInvalidImport, "format must be %s or %s, got %q", FormatCSV, FormatOFX, format)
+	}
+}
+
+// parseCSVStatement reads the rows of a CSV statement using the columns named by profile.
+func parseCSVStatement(r io.Reader, profile ImportProfile) ([]StatementRow, error) {
+
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	if profile.Delimiter != "" {
+		d, _ := utf8.DecodeRuneInString(profile.Delimiter)
+		reader.Comma = d
+	}
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidImport, "reading statement header: %v", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+
+	if profile.Date == "" {
+		profile.Date = "date"
+	}
+	if profile.Description == "" {
+		profile.Description = "description"
+	}
+	if profile.Amount == "" && profile.Credit == "" && profile.Debit == "" {
+		profile.Amount = "amount"
+	}
+
+	required := []string{profile.Date, profile.Description, profile.Amount, profile.Credit, profile.Debit, profile.Vendor, profile.ExternalID}
+	for _, name := range required {
+		if _, ok := columns[strings.ToLower(name)]; name != "" && !ok {
+			return nil, errors.Wrapf(ErrInvalidImport, "statement is missing the %q column", name)
+		}
+	}
+
+	var rows []StatementRow
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			rows = append(rows, StatementRow{Line: line, Err: err})
+			continue
+		}
+
+		rows = append(rows, csvRow(line, record, columns, profile))
+	}
+
+	return rows, nil
+}
+
+// csvRow reads one line of a CSV statement.
+func csvRow(line int, record []string, columns map[string]int, profile ImportProfile) StatementRow {
+
+	field := func(name string) string {
+		i, ok := columns[strings.ToLower(name)]
+		if name == "" || !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	row := StatementRow{
+		Line:        line,
+		ExternalID:  field(profile.ExternalID),
+		Description: field(profile.Description),
+		Vendor:      field(profile.Vendor),
+	}
+
+	if row.Vendor == "" {
+		row.Vendor = row.Description
+	}
+
+	var err error
+
+	if row.Occurrence, err = parseStatementDate(field(profile.Date), profile.DateFormat); err != nil {
+		row.Err = err
+		return row
+	}
+
+	if profile.Amount != "" {
+		if row.Amount, err = parseStatementAmount(field(profile.Amount)); err != nil {
+			row.Err = err
+		}
+		return row
+	}
+
+	credit, err := parseStatementAmount(field(profile.Credit))
+	if err != nil {
+		row.Err = err
+		return row
+	}
+
+	debit, err := parseStatementAmount(field(profile.Debit))
+	if err != nil {
+		row.Err = err
+		return row
+	}
+
+	// Some banks list debits as negative numbers in the debit column.
+	if debit.Sign() < 0 {
+		debit = debit.Neg()
+	}
+
+	row.Amount = credit.Sub(debit)
+
+	return row
+}
+
+// parseStatementDate reads a date with the layout of the profile, or any of the occurrence formats when it has none.
+func parseStatementDate(value, layout string) (time.Time, error) {
+
+	if layout == "" {
+		return ParseOccurrence(value, 0)
+	}
+
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return time.Time{}, errors.Errorf("date %q does NOT match %q", value, layout)
+	}
+
+	return t.UTC(), nil
+}
+
+// parseStatementAmount reads an amount as banks print it, e.g. "$1,234.56" or "(12.00)" for a negative amount.
+// An empty value is zero.
+func parseStatementAmount(value string) (Money, error) {
+
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return Money{}, nil
+	}
+
+	negative := strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")")
+	value = strings.Trim(value, "()")
+	value = strings.NewReplacer(",", "", "$", "", " ", "").Replace(value)
+
+	amount, err := ParseMoney(value, "")
+	if err != nil {
+		return Money{}, errors.Errorf("amount %q is NOT a number", value)
+	}
+
+	if negative {
+		amount = amount.Neg()
+	}
+
+	return amount, nil
+}
+
+// parseOFXStatement reads the STMTTRN records of an OFX or QFX statement.
+// Both the SGML form of OFX 1.x, where elements are NOT closed, and the XML form of OFX 2.x are read.
+func parseOFXStatement(r io.Reader) ([]StatementRow, string, error) {
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "reading statement")
+	}
+
+	text := string(data)
+
+	if !strings.Contains(strings.ToUpper(text), "<OFX>") {
+		return nil, "", errors.Wrap(ErrInvalidImport, "statement is NOT an OFX file")
+	}
+
+	currency := ofxValue(text, "CURDEF")
+
+	var rows []StatementRow
+
+	blocks := strings.Split(text, "<STMTTRN>")
+	for i, block := range blocks[1:] {
+		if end := strings.Index(block, "</STMTTRN>"); end >= 0 {
+			block = block[:end]
+		}
+
+		row := StatementRow{
+			Line:        i + 1,
+			ExternalID:  ofxValue(block, "FITID"),
+			Description: ofxValue(block, "NAME"),
+			Vendor:      ofxValue(block, "NAME"),
+		}
+
+		if row.Description == "" {
+			row.Description = ofxValue(block, "MEMO")
+		}
+		if row.Vendor == "" {
+			row.Vendor = ofxValue(block, "PAYEE")
+		}
+
+		if row.Occurrence, err = parseOFXDate(ofxValue(block, "DTPOSTED")); err != nil {
+			row.Err = err
+		} else if row.Amount, err = parseStatementAmount(ofxValue(block, "TRNAMT")); err != nil {
+			row.Err = err
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, currency, nil
+}
+
+// ofxValue returns the value of the first element named tag, whether or NOT it is closed.
+func ofxValue(text, tag string) string {
+
+	start := strings.Index(text, "<"+tag+">")
+	if start < 0 {
+		return ""
+	}
+
+	value := text[start+len(tag)+2:]
+	if end := strings.Index(value, "<"); end >= 0 {
+		value = value[:end]
+	}
+
+	return strings.TrimSpace(value)
+}
+
+// parseOFXDate reads the day of an OFX datetime, e.g. "20200301120000.000[-5:EST]".
+func parseOFXDate(value string) (time.Time, error) {
+
+	if len(value) < 8 {
+		return time.Time{}, errors.Errorf("date %q is NOT an OFX date", value)
+	}
+
+	t, err := time.Parse("20060102", value[:8])
+	if err != nil {
+		return time.Time{}, errors.Errorf("date %q is NOT an OFX date", value)
+	}
+
+	return t, nil
+}
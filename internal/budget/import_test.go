@@ -0,0 +1,170 @@
+package budget_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/pkg/errors"
+)
+
+func TestParseCSVStatement(t *testing.T) {
+
+	statement := `Posted,Details,Payee,Withdrawal,Deposit,Reference
+03/01/2020,Movie tickets,AMC,"$1,024.50",,A1
+03/02/2020,Paycheck,ACME,,2000,A2
+03/03/2020,Late fee,AMC,(12.00),,A3
+3rd of March,Coffee,Cafe,4.50,,A4
+`
+
+	profile := budget.ImportProfile{
+		Date:        "posted",
+		DateFormat:  "01/02/2006",
+		Description: "details",
+		Vendor:      "payee",
+		Debit:       "withdrawal",
+		Credit:      "deposit",
+		ExternalID:  "reference",
+	}
+
+	rows, _, err := budget.ParseStatement(budget.FormatCSV, strings.NewReader(statement), profile)
+	if err != nil {
+		t.Fatalf("parsing statement: %v", err)
+	}
+
+	if len(rows) != 4 {
+		t.Fatalf("expected 4 rows, got %d", len(rows))
+	}
+
+	want := []struct {
+		line   int
+		amount string
+		vendor string
+	}{
+		{2, "-1024.50", "AMC"},
+		{3, "2000", "ACME"},
+		{4, "-12.00", "AMC"},
+	}
+
+	for i, w := range want {
+		row := rows[i]
+		if row.Err != nil {
+			t.Fatalf("line %d: unexpected error %v", w.line, row.Err)
+		}
+		if row.Line != w.line || row.Amount.String() != w.amount || row.Vendor != w.vendor {
+			t.Fatalf("line %d: expected amount %s from %s, got line %d amount %s from %s", w.line, w.amount, w.vendor, row.Line, row.Amount, row.Vendor)
+		}
+	}
+
+	if !rows[0].Occurrence.Equal(time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC)) || rows[0].ExternalID != "A1" {
+		t.Fatalf("expected the first row on 2020-03-01 with id A1, got %v and %q", rows[0].Occurrence, rows[0].ExternalID)
+	}
+
+	if rows[3].Err == nil {
+		t.Fatal("expected the row with a malformed date to fail")
+	}
+
+	_, _, err = budget.ParseStatement(budget.FormatCSV, strings.NewReader("date,amount\n"), budget.ImportProfile{})
+	if errors.Cause(err) != budget.ErrInvalidImport {
+		t.Fatalf("expected a statement without a description column to be invalid, got %v", err)
+	}
+}
+
+func TestParseOFXStatement(t *testing.T) {
+
+	statement := `OFXHEADER:100
+DATA:OFXSGML
+
+<OFX>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<CURDEF>USD
+<BANKTRANLIST>
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20200301120000.000[-5:EST]
+<TRNAMT>-42.10
+<FITID>2020030101
+<NAME>Whole Foods
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20200302
+<TRNAMT>1500.00
+<FITID>2020030201
+<MEMO>Payroll
+</STMTTRN>
+</BANKTRANLIST>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`
+
+	rows, currency, err := budget.ParseStatement("qfx", strings.NewReader(statement), budget.ImportProfile{})
+	if err != nil {
+		t.Fatalf("parsing statement: %v", err)
+	}
+
+	if currency != "USD" {
+		t.Fatalf("expected currency USD, got %q", currency)
+	}
+
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+
+	first := rows[0]
+	if first.Err != nil || first.ExternalID != "2020030101" || first.Description != "Whole Foods" || first.Amount.String() != "-42.10" {
+		t.Fatalf("unexpected first row %+v", first)
+	}
+
+	if !first.Occurrence.Equal(time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected the first row on 2020-03-01, got %v", first.Occurrence)
+	}
+
+	if rows[1].Description != "Payroll" || rows[1].Amount.String() != "1500.00" {
+		t.Fatalf("unexpected second row %+v", rows[1])
+	}
+}
+
+func TestDuplicateFilterOfTimestampedStatement(t *testing.T) {
+
+	statement := `Date,Description,Amount
+2020-03-01 09:15:00,Coffee,-4.50
+2020-03-01 17:40:00,Coffee,-4.50
+2020-03-02 23:59:59,Paycheck,2000
+`
+
+	profile := budget.ImportProfile{Date: "date", DateFormat: "2006-01-02 15:04:05", Description: "description", Amount: "amount"}
+
+	// The first import stores each row on its day, as ImportTransactions does.
+	first, _, err := budget.ParseStatement(budget.FormatCSV, strings.NewReader(statement), profile)
+	if err != nil {
+		t.Fatalf("parsing statement: %v", err)
+	}
+
+	stored := make([]time.Time, len(first))
+	for i, row := range first {
+		if stored[i], err = budget.ParseOccurrence(row.Occurrence.Format("2006-01-02"), 0); err != nil {
+			t.Fatalf("storing row %d: %v", row.Line, err)
+		}
+	}
+
+	// Importing the statement again looks for them on the same day, whatever the time of day.
+	second, _, err := budget.ParseStatement(budget.FormatCSV, strings.NewReader(statement), profile)
+	if err != nil {
+		t.Fatalf("parsing statement again: %v", err)
+	}
+
+	for i, row := range second {
+		filter := budget.DuplicateFilter(row, "5f5e5b9c8d1e2a3b4c5d6e7f")
+
+		occurrence, ok := filter["occurrence"].(time.Time)
+		if !ok || !occurrence.Equal(stored[i]) {
+			t.Fatalf("row %d: expected a duplicate on %v, filter has %v", row.Line, stored[i], filter["occurrence"])
+		}
+
+		if filter["fin_acc_id"] != "5f5e5b9c8d1e2a3b4c5d6e7f" || filter["tranx_event"] != row.Description {
+			t.Fatalf("row %d: unexpected filter %v", row.Line, filter)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package budget
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// indexes are the indexes of each collection the queries of the package rely on.
+var indexes = map[string][]mongo.IndexModel{
+	TransactionCollection: {
+		{Keys: bson.D{{Key: "tags", Value: 1}}, Options: options.Index().SetName("tags")},
+	},
+	ReconciliationCollection: {
+		// A financial account has at most one open reconciliation, even when two are started at once.
+		{
+			Keys: bson.D{{Key: "fin_acc_id", Value: 1}},
+			Options: options.Index().SetName("open_fin_acc_id").SetUnique(true).
+				SetPartialFilterExpression(bson.M{"status": ReconciliationOpen}),
+		},
+	},
+}
+
+// duplicateKeyCode is the code of the error a write gets when it would break a unique index.
+const duplicateKeyCode = 11000
+
+// isDuplicateKey reports whether a write failed because it would break a unique index.
+func isDuplicateKey(err error) bool {
+
+	if we, ok := errors.Cause(err).(mongo.WriteException); ok {
+		for _, e := range we.WriteErrors {
+			if e.Code == duplicateKeyCode {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// EnsureIndexes creates the indexes the package relies on. Indexes that already exist are left as they are,
+// so it is safe to call every time the service starts.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+
+	for collection, models := range indexes {
+		if _, err := db.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			return errors.Wrapf(err, "creating indexes of %s", collection)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,31 @@
+package budget_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	pkgerrors "github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestIsDuplicateKey(t *testing.T) {
+	duplicate := mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000, Message: "E11000 duplicate key error"}}}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"duplicate key", duplicate, true},
+		{"wrapped duplicate key", pkgerrors.Wrap(duplicate, "inserting reconciliation"), true},
+		{"other write error", mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 121, Message: "document failed validation"}}}, false},
+		{"not a write error", errors.New("server selection timeout"), false},
+	}
+
+	for _, tt := range tests {
+		if got := budget.IsDuplicateKey(tt.err); got != tt.want {
+			t.Fatalf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
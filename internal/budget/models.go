@@ -1,6 +1,8 @@
 package budget
 
 import (
+	"encoding/json"
+	"regexp"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -8,19 +10,40 @@ import (
 
 // Budget type is a group of related financial transactions
 type Budget struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty" validate:"required"`
-	ManagerID   string             `bson:"manager_id,omitempty" json:"manager_id,omitempty"`
-	BudgetName  string             `bson:"budget_name,omitempty" json:"budget_name,omitempty" validate:"required"`
-	BudgetValue float64            `bson:"budget_value,omitempty" json:"budget_value,omitempty" default:"0"` // default doesn't give desired result
-	CreatedAt   time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty" validate:"datetime"`
-	UpdatedAt   time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty" validate:"datetime"`
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty" validate:"required"`
+	ManagerID       string             `bson:"manager_id,omitempty" json:"manager_id,omitempty"`
+	BudgetName      string             `bson:"budget_name,omitempty" json:"budget_name,omitempty" validate:"required"`
+	BudgetValue     Money              `bson:"budget_value,omitempty" json:"budget_value,omitempty"`
+	CurrencyID      string             `bson:"currency_id,omitempty" json:"currency_id,omitempty"`
+	Period          string             `bson:"period,omitempty" json:"period,omitempty"`                     // monthly, quarterly, yearly or custom; empty for a single value for all time
+	Start           *time.Time         `bson:"start,omitempty" json:"start,omitempty"`                       // first day of the first period
+	End             *time.Time         `bson:"end,omitempty" json:"end,omitempty"`                           // last day of the last period, open when missing
+	Allocations     []Allocation       `bson:"allocations,omitempty" json:"allocations,omitempty"`           // values of single periods that replace budget_value
+	Rollover        bool               `bson:"rollover,omitempty" json:"rollover,omitempty"`                 // what is left of a period is added to the next one
+	AlertThresholds []float64          `bson:"alert_thresholds,omitempty" json:"alert_thresholds,omitempty"` // percentages of the period amount that notify the webhooks when crossed
+	AlertCheckAt    *time.Time         `bson:"alert_check_at,omitempty" json:"alert_check_at,omitempty"`     // time of the earliest check of the alerts that failed, see CheckFailedAlerts
+	CreatedAt       time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty" validate:"datetime"`
+	UpdatedAt       time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty" validate:"datetime"`
+}
+
+// Allocation is the value of a Budget for one of its periods.
+type Allocation struct {
+	Period string `bson:"period" json:"period"` // YYYY-MM, YYYY-Qn or YYYY, by the period of the budget
+	Amount Money  `bson:"amount" json:"amount"`
 }
 
 // NewBudget type is what's required from the client to create a new Budget
 type NewBudget struct {
-	ManagerID   string  `bson:"manager_id,omitempty" json:"manager_id,omitempty"`
-	BudgetName  string  `bson:"budget_name,omitempty" json:"budget_name,omitempty" validate:"required"`
-	BudgetValue float64 `bson:"budget_value,omitempty" json:"budget_value,omitempty" default:"0"` // default doesn't give desired result
+	ManagerID       string       `bson:"manager_id,omitempty" json:"manager_id,omitempty"`
+	BudgetName      string       `bson:"budget_name,omitempty" json:"budget_name,omitempty" validate:"required"`
+	BudgetValue     Money        `bson:"budget_value,omitempty" json:"budget_value,omitempty"`
+	CurrencyID      string       `bson:"currency_id,omitempty" json:"currency_id,omitempty"`
+	Period          string       `json:"period,omitempty"`
+	Start           string       `json:"start,omitempty"` // YYYY-MM-DD, the start of the current period when missing
+	End             string       `json:"end,omitempty"`   // YYYY-MM-DD
+	Allocations     []Allocation `json:"allocations,omitempty"`
+	Rollover        bool         `json:"rollover,omitempty"`
+	AlertThresholds []float64    `json:"alert_thresholds,omitempty"`
 }
 
 // UpdateBudget defines what information may be provided to modify an existing Budget.
@@ -29,18 +52,28 @@ type NewBudget struct {
 // It uses pointer fields so we can differentiate between a field that was not provided and a field that was provided as explicitly blank.
 // Normally we do not want to use pointers to basic types but we make exceptions around marshalling/unmarshalling.
 type UpdateBudget struct {
-	ID          *primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
-	ManagerID   *string             `bson:"manager_id,omitempty" json:"manager_id,omitempty"`
-	BudgetName  *string             `bson:"budget_name,omitempty" json:"budget_name,omitempty"`
-	BudgetValue *float64            `bson:"budget_value,omitempty" json:"budget_value,omitempty" default:"0"` // default doesn't give desired result
+	ID              *primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	ManagerID       *string             `bson:"manager_id,omitempty" json:"manager_id,omitempty"`
+	BudgetName      *string             `bson:"budget_name,omitempty" json:"budget_name,omitempty"`
+	BudgetValue     *Money              `bson:"budget_value,omitempty" json:"budget_value,omitempty"`
+	CurrencyID      *string             `bson:"currency_id,omitempty" json:"currency_id,omitempty"`
+	Period          *string             `json:"period,omitempty"`      // empty to remove the period
+	Start           *string             `json:"start,omitempty"`       // YYYY-MM-DD
+	End             *string             `json:"end,omitempty"`         // YYYY-MM-DD, empty to remove the end
+	Allocations     *[]Allocation       `json:"allocations,omitempty"` // replaces every allocation, empty to remove them
+	Rollover        *bool               `json:"rollover,omitempty"`
+	AlertThresholds *[]float64          `json:"alert_thresholds,omitempty"` // empty to remove the alerts
 }
 
 // FinancialAccount type is used to track balance record transactions
 type FinancialAccount struct {
 	ID                   primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
 	AccountName          string             `bson:"account_name,omitempty" json:"account_name,omitempty" validate:"required"`
-	CurrentValue         float64            `bson:"current_value,omitempty" json:"current_value,omitempty" validate:"required"`
+	CurrentValue         Money              `bson:"current_value,omitempty" json:"current_value,omitempty" validate:"required"` // kept in sync with the transactions of the account
+	OpeningValue         Money              `bson:"opening_value,omitempty" json:"opening_value,omitempty"`                     // value before any recorded transaction
+	CurrencyID           string             `bson:"currency_id,omitempty" json:"currency_id,omitempty"`
 	FinancialInstitution string             `bson:"financial_institution,omitempty" json:"financial_institution,omitempty" validate:"required"`
+	AccountType          string             `bson:"account_type,omitempty" json:"account_type,omitempty"` // checking, savings, credit, loan, investment, cash or other
 	MangerID             string             `bson:"manger_id,omitempty" json:"manger_id,omitempty" validate:"required"`
 	CreatedAt            time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty" validate:"datetime"`
 	UpdatedAt            time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty" validate:"datetime"`
@@ -48,10 +81,12 @@ type FinancialAccount struct {
 
 // NewFinancialAccount type is used to track balance record transactions
 type NewFinancialAccount struct {
-	AccountName          string  `bson:"account_name,omitempty" json:"account_name,omitempty" validate:"required"`
-	CurrentValue         float64 `bson:"current_value,omitempty" json:"current_value,omitempty" validate:"required"`
-	FinancialInstitution string  `bson:"financial_institution,omitempty" json:"financial_institution,omitempty" validate:"required"`
-	MangerID             string  `bson:"manger_id,omitempty" json:"manger_id,omitempty"`
+	AccountName          string `bson:"account_name,omitempty" json:"account_name,omitempty" validate:"required"`
+	CurrentValue         Money  `bson:"current_value,omitempty" json:"current_value,omitempty" validate:"required"`
+	CurrencyID           string `bson:"currency_id,omitempty" json:"currency_id,omitempty"`
+	FinancialInstitution string `bson:"financial_institution,omitempty" json:"financial_institution,omitempty" validate:"required"`
+	AccountType          string `bson:"account_type,omitempty" json:"account_type,omitempty"`
+	MangerID             string `bson:"manger_id,omitempty" json:"manger_id,omitempty"`
 }
 
 // UpdateFinancialAccount defines what information may be provided to modify an existing Financial Account.
@@ -62,20 +97,24 @@ type UpdateFinancialAccount struct {
 	ID                   *primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
 	ManagerID            *string             `bson:"manager_id,omitempty" json:"manager_id,omitempty"`
 	AccountName          *string             `bson:"account_name,omitempty" json:"account_name,omitempty"`
-	CurrentValue         *float64            `bson:"current_value,omitempty" json:"current_value,omitempty"`
+	CurrentValue         *Money              `bson:"current_value,omitempty" json:"current_value,omitempty"`
+	CurrencyID           *string             `bson:"currency_id,omitempty" json:"currency_id,omitempty"`
 	FinancialInstitution *string             `bson:"financial_institution,omitempty" json:"financial_institution,omitempty"`
+	AccountType          *string             `bson:"account_type,omitempty" json:"account_type,omitempty"`
 }
 
 // Vendor type is a group of vendors that process transactions
+// TransactionIDs are NOT stored, they are read from the vendor_id of the transactions whenever a vendor is retrieved.
 type Vendor struct {
 	ID             primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty" validate:"required"`
-	TransactionIDs []string           `bson:"tranx_id,omitempty" json:"tranx_id,omitempty"`
+	TransactionIDs []string           `bson:"-" json:"tranx_id,omitempty"`
 	VendorName     string             `bson:"vendor_name,omitempty" json:"vendor_name,omitempty"`
 	CreatedAt      time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty" validate:"datetime"`
 	UpdatedAt      time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty" validate:"datetime"`
 }
 
 // NewVendor type is what's required from the client to create a new vendor
+// The transactions in TransactionIDs are assigned to the new vendor.
 type NewVendor struct {
 	TransactionIDs *[]string `bson:"tranx_id,omitempty" json:"tranx_id,omitempty"`
 	VendorName     string    `bson:"vendor_name,omitempty" json:"vendor_name,omitempty"`
@@ -86,6 +125,7 @@ type NewVendor struct {
 // changed.
 // It uses pointer fields so we can differentiate between a field that was not provided and a field that was provided as explicitly blank.
 // Normally we do not want to use pointers to basic types but we make exceptions around marshalling/unmarshalling.
+// The transactions in TransactionIDs are assigned to the vendor; transactions already assigned are kept.
 type UpdateVendor struct {
 	TransactionIDs *[]string `bson:"tranx_id,omitempty" json:"tranx_id,omitempty"`
 	VendorName     *string   `bson:"vendor_name,omitempty" json:"vendor_name,omitempty"`
@@ -98,15 +138,24 @@ type Transaction struct {
 	BudgetID           string             `bson:"budget_id,omitempty" json:"budget_id,omitempty"`
 	CurrencyID         string             `bson:"currency_id,omitempty" json:"currency_id,omitempty"`
 	FinancialAccountID []string           `bson:"fin_acc_id,omitempty" json:"fin_acc_id,omitempty"`
-	// Occurrence         time.Time            `bson:"occurrence,omitempty" json:"occurrence,omitempty" validate:"datetime"`
-	OccurrenceString  string    `bson:"occurrence_string,omitempty" json:"occurrence_string,omitempty"`
-	TransactionEvent  string    `bson:"tranx_event,omitempty" json:"tranx_event,omitempty"`
-	TransactionCredit float64   `bson:"tranx_credit,omitempty" json:"tranx_credit,omitempty"`
-	TransactionDebit  float64   `bson:"tranx_debit,omitempty" json:"tranx_debit,omitempty"`
-	VendorID          string    `bson:"vendor_id,omitempty" json:"vendor_id,omitempty"`
-	ParticipantID     []string  `bson:"participant_id,omitempty" json:"participant_id,omitempty"`
-	CreatedAt         time.Time `bson:"created_at,omitempty" json:"created_at,omitempty" validate:"datetime"`
-	UpdatedAt         time.Time `bson:"updated_at,omitempty" json:"updated_at,omitempty" validate:"datetime"`
+	Occurrence         time.Time          `bson:"occurrence,omitempty" json:"occurrence,omitempty"`
+	OccurrenceString   string             `bson:"occurrence_string,omitempty" json:"occurrence_string,omitempty"` // legacy "M/D" value kept for reference
+	TransactionEvent   string             `bson:"tranx_event,omitempty" json:"tranx_event,omitempty"`
+	TransactionCredit  Money              `bson:"tranx_credit,omitempty" json:"tranx_credit,omitempty"`
+	TransactionDebit   Money              `bson:"tranx_debit,omitempty" json:"tranx_debit,omitempty"`
+	VendorID           string             `bson:"vendor_id,omitempty" json:"vendor_id,omitempty"`
+	ParticipantID      []string           `bson:"participant_id,omitempty" json:"participant_id,omitempty"`
+	ExternalID         string             `bson:"external_id,omitempty" json:"external_id,omitempty"`   // id given by the bank, e.g. an OFX FITID
+	RecurringID        string             `bson:"recurring_id,omitempty" json:"recurring_id,omitempty"` // _id of the RecurringTransaction that posted it
+	TransferID         string             `bson:"transfer_id,omitempty" json:"transfer_id,omitempty"`   // _id of the other transaction of a Transfer
+	Splits             []Split            `bson:"splits,omitempty" json:"splits,omitempty"`             // used instead of BudgetID when the transaction covers several budgets
+	PaidBy             string             `bson:"paid_by,omitempty" json:"paid_by,omitempty"`           // _id of the participant who paid for a shared expense
+	Shares             []Share            `bson:"shares,omitempty" json:"shares,omitempty"`             // what each participant owes of a shared expense, equal shares when missing
+	Tags               []string           `bson:"tags,omitempty" json:"tags,omitempty"`
+	ClearedIn          []string           `bson:"cleared_in,omitempty" json:"cleared_in,omitempty"`       // _ids of the financial accounts whose statements show it
+	ReconciledIn       []string           `bson:"reconciled_in,omitempty" json:"reconciled_in,omitempty"` // _ids of the financial accounts it was reconciled in, it is locked until unlocked
+	CreatedAt          time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty" validate:"datetime"`
+	UpdatedAt          time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty" validate:"datetime"`
 }
 
 // NewTransaction type is what's required from the client to create a new transaction.
@@ -114,13 +163,18 @@ type NewTransaction struct {
 	BudgetID           string    `bson:"budget_id,omitempty" json:"budget_id,omitempty"`
 	CurrencyID         string    `bson:"currency_id,omitempty" json:"currency_id,omitempty"`
 	FinancialAccountID *[]string `bson:"fin_acc_id,omitempty" json:"fin_acc_id,omitempty"`
-	// Occurrence         *time.Time            `bson:"occurrence,omitempty" json:"occurrence,omitempty" validate:"datetime"`
-	OccurrenceString  string    `bson:"occurrence_string,omitempty" json:"occurrence_string,omitempty"`
-	TransactionEvent  string    `bson:"tranx_event,omitempty" json:"tranx_event,omitempty"`
-	TransactionCredit float64   `bson:"tranx_credit,omitempty" json:"tranx_credit,omitempty"`
-	TransactionDebit  float64   `bson:"tranx_debit,omitempty" json:"tranx_debit,omitempty"`
-	VendorID          string    `bson:"vendor_id,omitempty" json:"vendor_id,omitempty"`
-	ParticipantID     *[]string `bson:"participant_id,omitempty" json:"participant_id,omitempty"`
+	Occurrence         string    `bson:"occurrence,omitempty" json:"occurrence,omitempty"` // RFC3339, YYYY-MM-DD or M/D/YYYY
+	OccurrenceString   string    `bson:"occurrence_string,omitempty" json:"occurrence_string,omitempty"`
+	TransactionEvent   string    `bson:"tranx_event,omitempty" json:"tranx_event,omitempty"`
+	TransactionCredit  Money     `bson:"tranx_credit,omitempty" json:"tranx_credit,omitempty"`
+	TransactionDebit   Money     `bson:"tranx_debit,omitempty" json:"tranx_debit,omitempty"`
+	VendorID           string    `bson:"vendor_id,omitempty" json:"vendor_id,omitempty"`
+	ParticipantID      *[]string `bson:"participant_id,omitempty" json:"participant_id,omitempty"`
+	ExternalID         string    `bson:"external_id,omitempty" json:"external_id,omitempty"`
+	Splits             []Split   `bson:"splits,omitempty" json:"splits,omitempty"`
+	PaidBy             string    `bson:"paid_by,omitempty" json:"paid_by,omitempty"`
+	Shares             []Share   `bson:"shares,omitempty" json:"shares,omitempty"`
+	Tags               []string  `bson:"tags,omitempty" json:"tags,omitempty"`
 }
 
 // UpdateTransaction defines what information may be provided to modify an existing Transaction.
@@ -132,29 +186,116 @@ type UpdateTransaction struct {
 	BudgetID           *string   `bson:"budget_id,omitempty" json:"budget_id,omitempty"`
 	CurrencyID         *string   `bson:"currency_id,omitempty" json:"currency_id,omitempty"`
 	FinancialAccountID *[]string `bson:"fin_acc_id,omitempty" json:"fin_acc_id,omitempty"`
-	// Occurrence         *time.Time            `bson:"occurrence,omitempty" json:"occurrence,omitempty" validate:"datetime"`
-	OccurrenceString  *string   `bson:"occurrence_string,omitempty" json:"occurrence_string,omitempty"`
-	TransactionEvent  *string   `bson:"tranx_event,omitempty" json:"tranx_event,omitempty"`
-	TransactionCredit *float64  `bson:"tranx_credit,omitempty" json:"tranx_credit,omitempty"`
-	TransactionDebit  *float64  `bson:"tranx_debit,omitempty" json:"tranx_debit,omitempty"`
-	VendorID          *string   `bson:"vendor_id,omitempty" json:"vendor_id,omitempty"`
-	ParticipantID     *[]string `bson:"participant_id,omitempty" json:"participant_id,omitempty"`
+	Occurrence         *string   `bson:"occurrence,omitempty" json:"occurrence,omitempty"` // RFC3339, YYYY-MM-DD or M/D/YYYY
+	OccurrenceString   *string   `bson:"occurrence_string,omitempty" json:"occurrence_string,omitempty"`
+	TransactionEvent   *string   `bson:"tranx_event,omitempty" json:"tranx_event,omitempty"`
+	TransactionCredit  *Money    `bson:"tranx_credit,omitempty" json:"tranx_credit,omitempty"`
+	TransactionDebit   *Money    `bson:"tranx_debit,omitempty" json:"tranx_debit,omitempty"`
+	VendorID           *string   `bson:"vendor_id,omitempty" json:"vendor_id,omitempty"`
+	ParticipantID      *[]string `bson:"participant_id,omitempty" json:"participant_id,omitempty"`
+	Splits             *[]Split  `bson:"splits,omitempty" json:"splits,omitempty"`   // an empty list removes the splits
+	PaidBy             *string   `bson:"paid_by,omitempty" json:"paid_by,omitempty"` // empty when the expense is NOT shared
+	Shares             *[]Share  `bson:"shares,omitempty" json:"shares,omitempty"`   // an empty list shares the expense equally
+	Tags               *[]string `bson:"tags,omitempty" json:"tags,omitempty"`       // replaces the tags, an empty list removes them
+	AddTags            []string  `bson:"-" json:"add_tags,omitempty"`                // added to the tags, after any replacement
+	RemoveTags         []string  `bson:"-" json:"remove_tags,omitempty"`             // removed from the tags, after any addition
+}
+
+// Split is the part of a Transaction that belongs to one budget.
+// The amounts of the splits of a transaction add up to its debit, or to its credit when it has no debit.
+type Split struct {
+	BudgetID string `bson:"budget_id" json:"budget_id"`
+	Amount   Money  `bson:"amount" json:"amount"`
+	Memo     string `bson:"memo,omitempty" json:"memo,omitempty"`
+}
+
+// Share is what one participant owes of a shared Transaction.
+// The shares of a transaction add up to its debit, or to its credit when it has no debit.
+type Share struct {
+	ParticipantID string `bson:"participant_id" json:"participant_id"`
+	Amount        Money  `bson:"amount" json:"amount"`
+}
+
+// Participant is a person who takes part in transactions, e.g. a member of a household sharing expenses.
+// A participant may be linked to the User they sign in as.
+type Participant struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Name      string             `bson:"name,omitempty" json:"name,omitempty"`
+	Email     string             `bson:"email,omitempty" json:"email,omitempty"`
+	UserID    string             `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	CreatedAt time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty"`
+	UpdatedAt time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// NewParticipant is what's required from the client to create a new Participant.
+type NewParticipant struct {
+	Name   string `json:"name" validate:"required"`
+	Email  string `json:"email,omitempty"`
+	UserID string `json:"user_id,omitempty"`
+}
+
+// UpdateParticipant defines what information may be provided to modify an existing Participant.
+// All fields are optional so clients can send just the fields they want changed.
+type UpdateParticipant struct {
+	Name   *string `json:"name,omitempty"`
+	Email  *string `json:"email,omitempty"`
+	UserID *string `json:"user_id,omitempty"` // empty to unlink the user
+}
+
+// Transfer moves money from one FinancialAccount to another.
+// It is stored as a pair of transactions that refer to each other by transfer_id: a debit of the account the money leaves
+// and a credit of the account it enters. Both are created, changed and deleted together.
+type Transfer struct {
+	From Transaction `json:"from"`
+	To   Transaction `json:"to"`
+}
+
+// NewTransfer is what's required from the client to create a new Transfer.
+type NewTransfer struct {
+	FromAccountID    string `json:"from_fin_acc_id" validate:"required"`
+	ToAccountID      string `json:"to_fin_acc_id" validate:"required"`
+	CurrencyID       string `json:"currency_id,omitempty"`
+	Amount           Money  `json:"amount"`
+	Occurrence       string `json:"occurrence,omitempty"` // RFC3339, YYYY-MM-DD or M/D/YYYY
+	TransactionEvent string `json:"tranx_event,omitempty"`
+}
+
+// UpdateTransfer defines what information may be provided to modify an existing Transfer.
+// All fields are optional so clients can send just the fields they want changed. Changes apply to both transactions.
+type UpdateTransfer struct {
+	Amount           *Money  `json:"amount,omitempty"`
+	Occurrence       *string `json:"occurrence,omitempty"` // RFC3339, YYYY-MM-DD or M/D/YYYY
+	TransactionEvent *string `json:"tranx_event,omitempty"`
 }
 
 // FilterTransaction type is used to retrieve a filtered list of transactions.
+// Every field is optional so clients can send just the criteria they want applied.
+// Ranges are inclusive and either end of a range may be left open.
+// The exclusive bounds are only set from a query string, they are NOT part of a filter body.
 type FilterTransaction struct {
-	BudgetID           string `bson:"budget_id,omitempty" json:"budget_id,omitempty"`
-	CurrencyID         string `bson:"currency_id,omitempty" json:"currency_id,omitempty"`
-	FinancialAccountID string `bson:"fin_acc_id,omitempty" json:"fin_acc_id,omitempty"`
-	// Occurrence         time.Time            `bson:"occurrence,omitempty" json:"occurrence,omitempty" validate:"datetime"`
-	OccurrenceString  string    `bson:"occurrence_string,omitempty" json:"occurrence_string,omitempty"`
-	TransactionEvent  string    `bson:"tranx_event,omitempty" json:"tranx_event,omitempty"`
-	TransactionCredit string    `bson:"tranx_credit,omitempty" json:"tranx_credit,omitempty"`
-	TransactionDebit  string    `bson:"tranx_debit,omitempty" json:"tranx_debit,omitempty"`
-	VendorID          string    `bson:"vendor_id,omitempty" json:"vendor_id,omitempty"`
-	ParticipantID     string    `bson:"participant_id,omitempty" json:"participant_id,omitempty"`
-	CreatedAt         time.Time `bson:"created_at,omitempty" json:"created_at,omitempty" validate:"datetime"`
-	UpdatedAt         time.Time `bson:"updated_at,omitempty" json:"updated_at,omitempty" validate:"datetime"`
+	BudgetID           string     `json:"budget_id,omitempty"`
+	CurrencyID         string     `json:"currency_id,omitempty"`
+	FinancialAccountID string     `json:"fin_acc_id,omitempty"`
+	OccurrenceString   string     `json:"occurrence_string,omitempty"`
+	OccurrenceFrom     *time.Time `json:"occurrence_from,omitempty"`
+	OccurrenceTo       *time.Time `json:"occurrence_to,omitempty"`
+	OccurrenceBefore   *time.Time `json:"-"`                     // exclusive, the day after a date-only occurrence_to query
+	TransactionEvent   string     `json:"tranx_event,omitempty"` // case-insensitive substring
+	CreditMin          *Money     `json:"tranx_credit_min,omitempty"`
+	CreditMax          *Money     `json:"tranx_credit_max,omitempty"`
+	DebitMin           *Money     `json:"tranx_debit_min,omitempty"`
+	DebitMax           *Money     `json:"tranx_debit_max,omitempty"`
+	VendorID           string     `json:"vendor_id,omitempty"`
+	ParticipantID      string     `json:"participant_id,omitempty"`
+	Tags               []string   `json:"tags,omitempty"`     // every one of them
+	AnyTags            []string   `json:"any_tags,omitempty"` // at least one of them
+	CreatedFrom        *time.Time `json:"created_from,omitempty"`
+	CreatedTo          *time.Time `json:"created_to,omitempty"`
+	CreatedBefore      *time.Time `json:"-"`
+	UpdatedFrom        *time.Time `json:"updated_from,omitempty"`
+	UpdatedTo          *time.Time `json:"updated_to,omitempty"`
+	UpdatedBefore      *time.Time `json:"-"`
+	Locked             *bool      `json:"locked,omitempty"` // true for reconciled transactions only, false for the others
 }
 
 // Currency type is a group of currencies
@@ -184,3 +325,243 @@ type UpdateCurrency struct {
 	CurrencyType *string            `bson:"curr_type,omitempty" json:"curr_type,omitempty"`
 	Symbol       *string            `bson:"symbol,omitempty" json:"symbol,omitempty"`
 }
+
+// ExchangeRate type is the value of one currency in another on a date.
+// One unit of the base currency is worth Rate of the quote currency.
+type ExchangeRate struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	BaseCurrencyID  string             `bson:"base_currency_id,omitempty" json:"base_currency_id,omitempty" validate:"required"`
+	QuoteCurrencyID string             `bson:"quote_currency_id,omitempty" json:"quote_currency_id,omitempty" validate:"required"`
+	Rate            Money              `bson:"rate,omitempty" json:"rate,omitempty" validate:"required"` // in the quote currency
+	Date            time.Time          `bson:"date,omitempty" json:"date,omitempty"`                     // midnight UTC of the day the rate applies from
+	CreatedAt       time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty" validate:"datetime"`
+	UpdatedAt       time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty" validate:"datetime"`
+}
+
+// NewExchangeRate type is what's required from the client to create a new ExchangeRate
+type NewExchangeRate struct {
+	BaseCurrencyID  string `json:"base_currency_id,omitempty" validate:"required"`
+	QuoteCurrencyID string `json:"quote_currency_id,omitempty" validate:"required"`
+	Rate            Money  `json:"rate,omitempty" validate:"required"`
+	Date            string `json:"date,omitempty" validate:"required"` // YYYY-MM-DD
+}
+
+// UpdateExchangeRate defines what information may be provided to modify an existing ExchangeRate.
+// All fields are optional so clients can send just the fields they want changed.
+// It uses pointer fields so we can differentiate between a field that was not provided and a field that was provided as explicitly blank.
+type UpdateExchangeRate struct {
+	Rate *Money  `json:"rate,omitempty"`
+	Date *string `json:"date,omitempty"` // YYYY-MM-DD
+}
+
+// RecurringTransaction is a template for a transaction that repeats, e.g. rent, a subscription or a paycheck.
+// A transaction is posted for every occurrence of the Rule from Start through End once it is due.
+type RecurringTransaction struct {
+	ID                 primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Rule               string             `bson:"rule,omitempty" json:"rule,omitempty"` // RRULE, e.g. "FREQ=MONTHLY;BYMONTHDAY=1"
+	Start              time.Time          `bson:"start,omitempty" json:"start,omitempty"`
+	End                *time.Time         `bson:"end,omitempty" json:"end,omitempty"`
+	LastPosted         *time.Time         `bson:"last_posted,omitempty" json:"last_posted,omitempty"` // occurrence of the latest transaction posted
+	BudgetID           string             `bson:"budget_id,omitempty" json:"budget_id,omitempty"`
+	CurrencyID         string             `bson:"currency_id,omitempty" json:"currency_id,omitempty"`
+	FinancialAccountID []string           `bson:"fin_acc_id,omitempty" json:"fin_acc_id,omitempty"`
+	TransactionEvent   string             `bson:"tranx_event,omitempty" json:"tranx_event,omitempty"`
+	TransactionCredit  Money              `bson:"tranx_credit,omitempty" json:"tranx_credit,omitempty"`
+	TransactionDebit   Money              `bson:"tranx_debit,omitempty" json:"tranx_debit,omitempty"`
+	VendorID           string             `bson:"vendor_id,omitempty" json:"vendor_id,omitempty"`
+	CreatedAt          time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty" validate:"datetime"`
+	UpdatedAt          time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty" validate:"datetime"`
+}
+
+// NewRecurringTransaction type is what's required from the client to create a new RecurringTransaction
+type NewRecurringTransaction struct {
+	Rule               string    `json:"rule,omitempty" validate:"required"`
+	Start              string    `json:"start,omitempty" validate:"required"` // YYYY-MM-DD
+	End                *string   `json:"end,omitempty"`                       // YYYY-MM-DD
+	BudgetID           string    `json:"budget_id,omitempty"`
+	CurrencyID         string    `json:"currency_id,omitempty"`
+	FinancialAccountID *[]string `json:"fin_acc_id,omitempty"`
+	TransactionEvent   string    `json:"tranx_event,omitempty"`
+	TransactionCredit  Money     `json:"tranx_credit,omitempty"`
+	TransactionDebit   Money     `json:"tranx_debit,omitempty"`
+	VendorID           string    `json:"vendor_id,omitempty"`
+}
+
+// UpdateRecurringTransaction defines what information may be provided to modify an existing RecurringTransaction.
+// All fields are optional so clients can send just the fields they want changed.
+// It uses pointer fields so we can differentiate between a field that was not provided and a field that was provided as explicitly blank.
+// Changes apply to occurrences that are NOT posted yet.
+type UpdateRecurringTransaction struct {
+	Rule               *string   `json:"rule,omitempty"`
+	End                *string   `json:"end,omitempty"` // YYYY-MM-DD, empty to repeat without end
+	BudgetID           *string   `json:"budget_id,omitempty"`
+	CurrencyID         *string   `json:"currency_id,omitempty"`
+	FinancialAccountID *[]string `json:"fin_acc_id,omitempty"`
+	TransactionEvent   *string   `json:"tranx_event,omitempty"`
+	TransactionCredit  *Money    `json:"tranx_credit,omitempty"`
+	TransactionDebit   *Money    `json:"tranx_debit,omitempty"`
+	VendorID           *string   `json:"vendor_id,omitempty"`
+}
+
+// CategoryRule assigns a budget, participants and tags to the transactions it matches.
+// A transaction matches when it meets every condition the rule has: its vendor, a pattern in its tranx_event and a range of its amount.
+// The amount of a transaction is its debit, or its credit when it has no debit.
+type CategoryRule struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty" validate:"required"`
+	RuleName      string             `bson:"rule_name,omitempty" json:"rule_name,omitempty"`
+	Priority      int                `bson:"priority" json:"priority"` // rules with a lower priority are applied first
+	VendorID      string             `bson:"vendor_id,omitempty" json:"vendor_id,omitempty"`
+	EventPattern  string             `bson:"event_pattern,omitempty" json:"event_pattern,omitempty"` // regular expression matched against tranx_event, ignoring case
+	AmountMin     *Money             `bson:"amount_min,omitempty" json:"amount_min,omitempty"`
+	AmountMax     *Money             `bson:"amount_max,omitempty" json:"amount_max,omitempty"`
+	BudgetID      string             `bson:"budget_id,omitempty" json:"budget_id,omitempty"`
+	ParticipantID []string           `bson:"participant_id,omitempty" json:"participant_id,omitempty"`
+	Tags          []string           `bson:"tags,omitempty" json:"tags,omitempty"`
+	CreatedAt     time.Time          `bson:"created_at,omitempty" json:"created_at,omitempty" validate:"datetime"`
+	UpdatedAt     time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty" validate:"datetime"`
+
+	eventRegexp *regexp.Regexp // EventPattern compiled once by loadCategoryRules
+}
+
+// NewCategoryRule type is what's required from the client to create a new CategoryRule.
+// At least one condition and one assignment are required.
+type NewCategoryRule struct {
+	RuleName      string   `json:"rule_name,omitempty"`
+	Priority      int      `json:"priority,omitempty"`
+	VendorID      string   `json:"vendor_id,omitempty"`
+	EventPattern  string   `json:"event_pattern,omitempty"`
+	AmountMin     *Money   `json:"amount_min,omitempty"`
+	AmountMax     *Money   `json:"amount_max,omitempty"`
+	BudgetID      string   `json:"budget_id,omitempty"`
+	ParticipantID []string `json:"participant_id,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+}
+
+// UpdateCategoryRule defines what information may be provided to modify an existing CategoryRule.
+// All fields are optional so clients can send just the fields they want changed.
+// It uses pointer fields so we can differentiate between a field that was not provided and a field that was provided as explicitly blank.
+// A blank value removes a condition or an assignment.
+type UpdateCategoryRule struct {
+	RuleName      *string   `json:"rule_name,omitempty"`
+	Priority      *int      `json:"priority,omitempty"`
+	VendorID      *string   `json:"vendor_id,omitempty"`
+	EventPattern  *string   `json:"event_pattern,omitempty"`
+	AmountMin     *Money    `json:"amount_min,omitempty"`
+	AmountMax     *Money    `json:"amount_max,omitempty"`
+	BudgetID      *string   `json:"budget_id,omitempty"`
+	ParticipantID *[]string `json:"participant_id,omitempty"`
+	Tags          *[]string `json:"tags,omitempty"`
+}
+
+// BudgetAlert records that a Budget crossed one of its alert thresholds within a period.
+// A threshold alerts once per period.
+type BudgetAlert struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	BudgetID    string             `bson:"budget_id" json:"budget_id"`
+	BudgetName  string             `bson:"budget_name,omitempty" json:"budget_name,omitempty"`
+	Period      string             `bson:"period,omitempty" json:"period,omitempty"` // empty for a budget without a period
+	Threshold   float64            `bson:"threshold" json:"threshold"`
+	PercentUsed float64            `bson:"percent_used" json:"percent_used"`
+	Available   Money              `bson:"available" json:"available"`
+	Spent       Money              `bson:"spent" json:"spent"`
+	Received    Money              `bson:"received" json:"received"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Webhook is a URL that is sent every alert.
+// Deliveries are signed with the Secret, which is only shown when the webhook is created.
+type Webhook struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	URL       string             `bson:"url" json:"url"`
+	Secret    string             `bson:"secret" json:"secret,omitempty"`
+	ManagerID string             `bson:"manager_id,omitempty" json:"manager_id,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// NewWebhook type is what's required from the client to register a Webhook.
+// A secret is made up when none is given.
+type NewWebhook struct {
+	URL    string `json:"url" validate:"required"`
+	Secret string `json:"secret,omitempty"`
+}
+
+// WebhookDelivery is one event sent, or to be sent, to a Webhook.
+type WebhookDelivery struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	WebhookID     string             `bson:"webhook_id" json:"webhook_id"`
+	Event         string             `bson:"event" json:"event"`
+	Payload       json.RawMessage    `bson:"payload" json:"payload"`
+	Status        string             `bson:"status" json:"status"` // pending, sending, delivered or failed
+	Attempts      int                `bson:"attempts" json:"attempts"`
+	Retries       int                `bson:"retries,omitempty" json:"retries,omitempty"`                 // runs of DeliverWebhooks that failed to send it
+	NextAttemptAt *time.Time         `bson:"next_attempt_at,omitempty" json:"next_attempt_at,omitempty"` // a pending delivery is NOT sent before it
+	LeaseUntil    *time.Time         `bson:"lease_until,omitempty" json:"lease_until,omitempty"`         // a sending delivery is claimed by one run until it
+	Response      int                `bson:"response,omitempty" json:"response,omitempty"`               // HTTP status of the last response
+	Error         string             `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	DeliveredAt   *time.Time         `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+}
+
+// NetWorthSnapshot is the value of every FinancialAccount on a day, in one currency.
+// There is one snapshot per currency and day; taking another one on the same day replaces it.
+type NetWorthSnapshot struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Day           time.Time          `bson:"day" json:"day"`
+	CurrencyID    string             `bson:"currency_id" json:"currency_id"`
+	NetWorth      Money              `bson:"net_worth" json:"net_worth"`     // assets less liabilities
+	Assets        Money              `bson:"assets" json:"assets"`           // sum of the accounts with a positive value
+	Liabilities   Money              `bson:"liabilities" json:"liabilities"` // what is owed on the accounts with a negative value
+	Accounts      int                `bson:"accounts" json:"accounts"`
+	ByInstitution []NetWorthGroup    `bson:"by_institution" json:"by_institution"`
+	ByType        []NetWorthGroup    `bson:"by_type" json:"by_type"`
+	TakenAt       time.Time          `bson:"taken_at" json:"taken_at"`
+}
+
+// NetWorthGroup is the value of the accounts of one financial institution or account type.
+type NetWorthGroup struct {
+	Key      string `bson:"key" json:"key"` // empty for accounts without an institution or type
+	Value    Money  `bson:"value" json:"value"`
+	Accounts int    `bson:"accounts" json:"accounts"`
+}
+
+// Attachment is a file, like a receipt, kept with a Transaction.
+// The content is held by a blob store; the attachment describes it.
+type Attachment struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	TransactionID string             `bson:"tranx_id" json:"tranx_id"`
+	FileName      string             `bson:"file_name" json:"file_name"`
+	ContentType   string             `bson:"content_type" json:"content_type"`
+	Size          int64              `bson:"size" json:"size"`         // in bytes
+	Checksum      string             `bson:"checksum" json:"checksum"` // hex SHA-256 of the content
+	BlobID        string             `bson:"blob_id" json:"-"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Reconciliation matches the transactions of a FinancialAccount against a bank statement.
+// Transactions are marked cleared as they are found on the statement; finishing the reconciliation locks them.
+type Reconciliation struct {
+	ID                 primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	FinancialAccountID string             `bson:"fin_acc_id" json:"fin_acc_id"`
+	StatementDate      time.Time          `bson:"statement_date" json:"statement_date"`
+	StatementBalance   Money              `bson:"statement_balance" json:"statement_balance"`   // ending balance of the statement
+	ClearedBalance     Money              `bson:"cleared_balance" json:"cleared_balance"`       // opening value plus the cleared transactions
+	Difference         Money              `bson:"difference" json:"difference"`                 // statement balance less cleared balance
+	Status             string             `bson:"status" json:"status"`                         // open or finished
+	TransactionIDs     []string           `bson:"tranx_id,omitempty" json:"tranx_id,omitempty"` // transactions locked when it was finished
+	CreatedAt          time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt          time.Time          `bson:"updated_at" json:"updated_at"`
+	FinishedAt         *time.Time         `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+}
+
+// NewReconciliation is what's required from the client to start reconciling a financial account.
+type NewReconciliation struct {
+	StatementDate    string `json:"statement_date" validate:"required"` // RFC3339, YYYY-MM-DD or M/D/YYYY
+	StatementBalance Money  `json:"statement_balance"`
+}
+
+// ClearTransactions marks transactions of a financial account as found, or NOT found, on its statement.
+type ClearTransactions struct {
+	TransactionIDs []string `json:"tranx_id" validate:"required"`
+	Cleared        *bool    `json:"cleared,omitempty"` // true when missing
+}
@@ -0,0 +1,359 @@
+package budget
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrInvalidAmount is used when an amount of money is NOT a decimal number.
+var ErrInvalidAmount = errors.New("amount must be a decimal number, e.g. \"12.34\"")
+
+// Money is an exact amount of a currency.
+// The amount is stored as a BSON Decimal128 so sums do NOT drift the way float64 sums do.
+// Decimal128 is used rather than integer minor units because some currencies, e.g. ETHEREUM, have 18 decimal places.
+//
+// In JSON Money is a string decimal, e.g. "12.34". A JSON number is also accepted when decoding.
+// The currency is taken from the currency_id of the document holding the amount.
+type Money struct {
+	Amount     primitive.Decimal128 `bson:"amount"`
+	CurrencyID string               `bson:"currency_id,omitempty"`
+}
+
+// ParseMoney reads a decimal amount, e.g. "-12.34", of the currency identified by currencyID.
+func ParseMoney(amount string, currencyID string) (Money, error) {
+
+	d, err := primitive.ParseDecimal128(strings.TrimSpace(amount))
+	if err != nil || d.IsNaN() || d.IsInf() != 0 {
+		return Money{}, ErrInvalidAmount
+	}
+
+	return Money{Amount: d, CurrencyID: currencyID}, nil
+}
+
+// coefficient returns the amount as coefficient × 10^exp.
+// Zero is always returned with an exponent of 0.
+func (m Money) coefficient() (*big.Int, int) {
+
+	bi, exp, err := m.Amount.BigInt()
+	if err != nil || bi.Sign() == 0 {
+		return new(big.Int), 0
+	}
+
+	return bi, exp
+}
+
+// maxDigits is the number of significant digits a Decimal128 holds.
+const maxDigits = 34
+
+// newMoney builds Money from coefficient × 10^exp.
+// A coefficient with more than maxDigits digits is rounded half away from zero to fit.
+func newMoney(bi *big.Int, exp int, currencyID string) Money {
+
+	for digits := len(new(big.Int).Abs(bi).String()); digits > maxDigits; digits = len(new(big.Int).Abs(bi).String()) {
+		// Round once over all the dropped digits so the result is NOT rounded twice.
+		div := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits-maxDigits)), nil)
+		q, r := new(big.Int).QuoRem(bi, div, new(big.Int))
+		if new(big.Int).Mul(r, big.NewInt(2)).CmpAbs(div) >= 0 {
+			q.Add(q, big.NewInt(int64(bi.Sign())))
+		}
+		bi = q
+		exp += digits - maxDigits
+	}
+
+	// Only an exponent out of the range of Decimal128 is left to fail.
+	d, ok := primitive.ParseDecimal128FromBigInt(bi, exp)
+	if !ok {
+		d, _ = primitive.ParseDecimal128("NaN")
+	}
+
+	return Money{Amount: d, CurrencyID: currencyID}
+}
+
+// align returns the coefficients of m and n scaled to a common exponent.
+func align(m, n Money) (*big.Int, *big.Int, int) {
+
+	a, ea := m.coefficient()
+	b, eb := n.coefficient()
+
+	exp := ea
+	if eb < exp {
+		exp = eb
+	}
+
+	a = new(big.Int).Mul(a, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(ea-exp)), nil))
+	b = new(big.Int).Mul(b, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(eb-exp)), nil))
+
+	return a, b, exp
+}
+
+// currency returns the currency of m, or of n when m does NOT have one.
+func currency(m, n Money) string {
+
+	if m.CurrencyID != "" {
+		return m.CurrencyID
+	}
+
+	return n.CurrencyID
+}
+
+// Add returns m + n.
+func (m Money) Add(n Money) Money {
+
+	a, b, exp := align(m, n)
+
+	return newMoney(a.Add(a, b), exp, currency(m, n))
+}
+
+// Sub returns m - n.
+func (m Money) Sub(n Money) Money {
+
+	a, b, exp := align(m, n)
+
+	return newMoney(a.Sub(a, b), exp, currency(m, n))
+}
+
+// Neg returns -m.
+func (m Money) Neg() Money {
+
+	bi, exp := m.coefficient()
+
+	return newMoney(bi.Neg(bi), exp, m.CurrencyID)
+}
+
+// Convert returns m in another currency.
+// The rate is the amount of the other currency worth one unit of the currency of m.
+func (m Money) Convert(rate Money) Money {
+
+	a, ea := m.coefficient()
+	b, eb := rate.coefficient()
+
+	return newMoney(a.Mul(a, b), ea+eb, rate.CurrencyID)
+}
+
+// Split divides m into n parts that add up to m and differ by at most one cent, larger parts first.
+// Amounts with more decimal places than cents are divided at their own precision.
+func (m Money) Split(n int) []Money {
+
+	if n < 1 {
+		return nil
+	}
+
+	bi, exp := m.coefficient()
+	if exp > -2 {
+		bi.Mul(bi, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp+2)), nil))
+		exp = -2
+	}
+
+	q, r := new(big.Int).QuoRem(bi, big.NewInt(int64(n)), new(big.Int))
+	extra := int(new(big.Int).Abs(r).Int64())
+	unit := big.NewInt(int64(bi.Sign()))
+
+	parts := make([]Money, n)
+	for i := range parts {
+		part := new(big.Int).Set(q)
+		if i < extra {
+			part.Add(part, unit)
+		}
+		parts[i] = newMoney(part, exp, m.CurrencyID)
+	}
+
+	return parts
+}
+
+// Cmp compares the amounts of m and n and returns -1, 0 or +1.
+func (m Money) Cmp(n Money) int {
+
+	a, b, _ := align(m, n)
+
+	return a.Cmp(b)
+}
+
+// Sign returns -1, 0 or +1 depending on the sign of the amount.
+func (m Money) Sign() int {
+
+	bi, _ := m.coefficient()
+
+	return bi.Sign()
+}
+
+// IsZero reports whether the amount is zero.
+// It lets `omitempty` leave zero amounts out of BSON documents, as it did for float64 amounts.
+func (m Money) IsZero() bool {
+	return m.Sign() == 0
+}
+
+// Float64 returns the nearest float64 to the amount.
+// Use it for ratios, e.g. percentages, never for sums.
+func (m Money) Float64() float64 {
+
+	f, _ := strconv.ParseFloat(m.String(), 64)
+
+	return f
+}
+
+// String formats the amount as a plain decimal without an exponent, e.g. "-12.34".
+func (m Money) String() string {
+
+	bi, exp := m.coefficient()
+
+	if exp >= 0 {
+		return bi.String() + strings.Repeat("0", exp)
+	}
+
+	sign := ""
+	if bi.Sign() < 0 {
+		sign = "-"
+		bi.Neg(bi)
+	}
+
+	digits := bi.String()
+	places := -exp
+
+	if len(digits) <= places {
+		digits = strings.Repeat("0", places-len(digits)+1) + digits
+	}
+
+	point := len(digits) - places
+
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+// MarshalJSON encodes the amount as a string decimal.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON decodes an amount sent as a string decimal or a JSON number.
+// The currency is left for the caller to fill in.
+func (m *Money) UnmarshalJSON(data []byte) error {
+
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return ErrInvalidAmount
+		}
+	}
+
+	money, err := ParseMoney(s, m.CurrencyID)
+	if err != nil {
+		return err
+	}
+
+	*m = money
+
+	return nil
+}
+
+// UnmarshalBSONValue decodes Money from its embedded document.
+// Amounts stored as plain numbers before the move to Money are decoded too, without a currency.
+func (m *Money) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+
+	raw := bson.RawValue{Type: t, Value: data}
+
+	switch t {
+	case bsontype.EmbeddedDocument:
+		type money Money
+		var doc money
+		if err := raw.Unmarshal(&doc); err != nil {
+			return errors.Wrap(err, "decoding money")
+		}
+		*m = Money(doc)
+	case bsontype.Decimal128:
+		*m = Money{Amount: raw.Decimal128()}
+	case bsontype.Double:
+		*m = newMoneyFromFloat(raw.Double())
+	case bsontype.Int32:
+		*m = newMoney(big.NewInt(int64(raw.Int32())), 0, "")
+	case bsontype.Int64:
+		*m = newMoney(big.NewInt(raw.Int64()), 0, "")
+	case bsontype.Null, bsontype.Undefined:
+		*m = Money{}
+	default:
+		return errors.Errorf("can NOT decode BSON %v as money", t)
+	}
+
+	return nil
+}
+
+// newMoneyFromFloat converts a float64 amount using the shortest decimal that reads back as the same float64.
+func newMoneyFromFloat(f float64) Money {
+
+	money, err := ParseMoney(strconv.FormatFloat(f, 'f', -1, 64), "")
+	if err != nil {
+		return Money{}
+	}
+
+	return money
+}
+
+// moneyFields lists the amounts of each collection that were stored as plain numbers before the move to Money.
+var moneyFields = []struct {
+	collection string
+	field      string
+}{
+	{BudgetCollection, "budget_value"},
+	{FinancialAccountCollection, "current_value"},
+	{FinancialAccountCollection, "opening_value"},
+	{TransactionCollection, "tranx_credit"},
+	{TransactionCollection, "tranx_debit"},
+}
+
+// MoneyMigration reports the outcome of MigrateMoney.
+// Migrated is keyed by "collection.field".
+type MoneyMigration struct {
+	Migrated map[string]int64 `json:"migrated"`
+}
+
+// MigrateMoney converts amounts stored as plain numbers into Money documents.
+// Each amount is divided by 10^scale, so a scale of 2 reads 10184 as 101.84. Use a scale of 0 to keep amounts as they are.
+// The currency is copied from the currency_id of the document when it has one.
+// The conversion is done by the database, which must be MongoDB 4.2 or later.
+// Amounts that are already Money are left alone so the migration can be run more than once.
+func MigrateMoney(ctx context.Context, db *mongo.Database, scale int) (*MoneyMigration, error) {
+
+	if scale < 0 {
+		return nil, errors.New("scale can NOT be negative")
+	}
+
+	divisor, err := primitive.ParseDecimal128("1" + strings.Repeat("0", scale))
+	if err != nil {
+		return nil, errors.Wrapf(err, "building divisor for scale %d", scale)
+	}
+
+	result := MoneyMigration{Migrated: map[string]int64{}}
+
+	for _, mf := range moneyFields {
+
+		amount := bson.M{"$divide": bson.A{bson.M{"$toDecimal": "$" + mf.field}, divisor}}
+
+		update := mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				mf.field: bson.M{"amount": amount, "currency_id": "$currency_id"},
+			}}},
+		}
+
+		filter := bson.M{mf.field: bson.M{"$type": "number"}}
+
+		updated, err := db.Collection(mf.collection).UpdateMany(ctx, filter, update)
+		if err != nil {
+			return nil, errors.Wrapf(err, "migrating %s.%s", mf.collection, mf.field)
+		}
+
+		result.Migrated[mf.collection+"."+mf.field] = updated.ModifiedCount
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,202 @@
+package budget_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func money(t *testing.T, amount string) budget.Money {
+	t.Helper()
+
+	m, err := budget.ParseMoney(amount, "5f381f30f815d062fb9da8f1")
+	if err != nil {
+		t.Fatalf("parsing %q: %v", amount, err)
+	}
+
+	return m
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		amount string
+		want   string
+		ok     bool
+	}{
+		{"12.34", "12.34", true},
+		{" -0.05 ", "-0.05", true},
+		{"100", "100", true},
+		{"1E+3", "1000", true},
+		{"0.000000000000000001", "0.000000000000000001", true},
+		{"12,34", "", false},
+		{"NaN", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		got, err := budget.ParseMoney(tt.amount, "")
+		if tt.ok != (err == nil) {
+			t.Fatalf("%q: expected ok %v, got error %v", tt.amount, tt.ok, err)
+		}
+		if tt.ok && got.String() != tt.want {
+			t.Fatalf("%q: expected %s, got %s", tt.amount, tt.want, got)
+		}
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+
+	// Summing ten cents ten times drifts with float64.
+	var sum budget.Money
+	for i := 0; i < 10; i++ {
+		sum = sum.Add(money(t, "0.10"))
+	}
+
+	if got := sum.String(); got != "1.00" {
+		t.Fatalf("expected 1.00, got %s", got)
+	}
+
+	if sum.CurrencyID != "5f381f30f815d062fb9da8f1" {
+		t.Fatalf("expected the sum to keep its currency, got %q", sum.CurrencyID)
+	}
+
+	if got := money(t, "12.3").Sub(money(t, "20.05")).String(); got != "-7.75" {
+		t.Fatalf("expected -7.75, got %s", got)
+	}
+
+	if got := money(t, "7.75").Neg().String(); got != "-7.75" {
+		t.Fatalf("expected -7.75, got %s", got)
+	}
+
+	if money(t, "1.50").Cmp(money(t, "1.5")) != 0 {
+		t.Fatal("expected 1.50 to equal 1.5")
+	}
+
+	if !(budget.Money{}).IsZero() || money(t, "0.01").IsZero() {
+		t.Fatal("IsZero does not match the amount")
+	}
+}
+
+func TestMoneyConvert(t *testing.T) {
+
+	rate, err := budget.ParseMoney("0.8532", "5f381f30f815d062fb9da8f2")
+	if err != nil {
+		t.Fatalf("parsing rate: %v", err)
+	}
+
+	got := money(t, "19.99").Convert(rate)
+
+	if got.String() != "17.055468" {
+		t.Fatalf("expected 17.055468, got %s", got)
+	}
+
+	if got.CurrencyID != rate.CurrencyID {
+		t.Fatalf("expected the converted amount in the currency of the rate, got %q", got.CurrencyID)
+	}
+
+	// Products too long for a Decimal128 are rounded to fit.
+	long := money(t, "1.234567890123456789012345678901234")
+	if got := long.Convert(long).String(); got != "1.524157875323883675049535156256667" {
+		t.Fatalf("expected the product rounded to 34 digits, got %s", got)
+	}
+}
+
+func TestMoneySplit(t *testing.T) {
+	tests := []struct {
+		amount string
+		n      int
+		want   string
+	}{
+		{"10", 3, "3.34 3.33 3.33"},
+		{"100.00", 4, "25.00 25.00 25.00 25.00"},
+		{"0.05", 2, "0.03 0.02"},
+		{"-10", 3, "-3.34 -3.33 -3.33"},
+		{"1.001", 2, "0.501 0.500"},
+	}
+
+	for _, tt := range tests {
+		parts := money(t, tt.amount).Split(tt.n)
+
+		got := make([]string, len(parts))
+		var sum budget.Money
+		for i, p := range parts {
+			got[i] = p.String()
+			sum = sum.Add(p)
+		}
+
+		if strings.Join(got, " ") != tt.want {
+			t.Fatalf("%s / %d: expected %s, got %s", tt.amount, tt.n, tt.want, strings.Join(got, " "))
+		}
+		if sum.Cmp(money(t, tt.amount)) != 0 {
+			t.Fatalf("%s / %d: parts add up to %s", tt.amount, tt.n, sum)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+
+	var tranx budget.NewTransaction
+	body := `{"tranx_credit": "19.99", "tranx_debit": 5.25}`
+	if err := json.Unmarshal([]byte(body), &tranx); err != nil {
+		t.Fatalf("decoding transaction: %v", err)
+	}
+
+	if got := tranx.TransactionCredit.String(); got != "19.99" {
+		t.Fatalf("expected credit 19.99, got %s", got)
+	}
+
+	if got := tranx.TransactionDebit.String(); got != "5.25" {
+		t.Fatalf("expected debit 5.25, got %s", got)
+	}
+
+	data, err := json.Marshal(money(t, "19.99"))
+	if err != nil {
+		t.Fatalf("encoding money: %v", err)
+	}
+
+	if string(data) != `"19.99"` {
+		t.Fatalf("expected a string decimal, got %s", data)
+	}
+
+	if err := json.Unmarshal([]byte(`{"tranx_credit": "lots"}`), &tranx); err == nil {
+		t.Fatal("expected an error decoding a malformed amount")
+	}
+}
+
+func TestMoneyBSON(t *testing.T) {
+
+	data, err := bson.Marshal(budget.Transaction{TransactionCredit: money(t, "19.99")})
+	if err != nil {
+		t.Fatalf("encoding transaction: %v", err)
+	}
+
+	var tranx budget.Transaction
+	if err := bson.Unmarshal(data, &tranx); err != nil {
+		t.Fatalf("decoding transaction: %v", err)
+	}
+
+	if tranx.TransactionCredit.String() != "19.99" || tranx.TransactionCredit.CurrencyID != "5f381f30f815d062fb9da8f1" {
+		t.Fatalf("expected credit to survive a round trip, got %+v", tranx.TransactionCredit)
+	}
+
+	if _, ok := bson.Raw(data).Lookup("tranx_debit").DocumentOK(); ok {
+		t.Fatal("expected a zero debit to be left out")
+	}
+
+	// Documents written before the move to Money hold plain numbers.
+	legacy, err := bson.Marshal(bson.M{"tranx_credit": 0.1, "tranx_debit": int32(25)})
+	if err != nil {
+		t.Fatalf("encoding legacy transaction: %v", err)
+	}
+
+	if err := bson.Unmarshal(legacy, &tranx); err != nil {
+		t.Fatalf("decoding legacy transaction: %v", err)
+	}
+
+	if tranx.TransactionCredit.String() != "0.1" || tranx.TransactionDebit.String() != "25" {
+		t.Fatalf("expected legacy amounts 0.1 and 25, got %s and %s", tranx.TransactionCredit, tranx.TransactionDebit)
+	}
+}
@@ -0,0 +1,169 @@
+package budget
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NetWorthHistory is the net worth snapshots of a currency over time, oldest first.
+type NetWorthHistory struct {
+	CurrencyID string             `json:"currency_id"`
+	Window     SummaryWindow      `json:"window"`
+	Snapshots  []NetWorthSnapshot `json:"snapshots"`
+}
+
+// TakeNetWorthSnapshot stores the value of every financial account as of now, converted into the currency identified by currencyID
+// at the rates of the day. The snapshot of the day is replaced if one was already taken, so it can be taken as often as needed.
+func TakeNetWorthSnapshot(ctx context.Context, db *mongo.Database, currencyID string, now time.Time) (*NetWorthSnapshot, error) {
+
+	if err := checkNetWorthCurrency(ctx, db, currencyID); err != nil {
+		return nil, err
+	}
+
+	cursor, err := db.Collection(FinancialAccountCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cursor from financial account collection")
+	}
+
+	var accounts []FinancialAccount
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, errors.Wrap(err, "retrieving financial accounts")
+	}
+
+	conv := NewConverter(db, currencyID)
+
+	for i, fa := range accounts {
+		value := fa.CurrentValue
+		if value.CurrencyID == "" {
+			value.CurrencyID = fa.CurrencyID
+		}
+		if accounts[i].CurrentValue, err = conv.Convert(ctx, value, now); err != nil {
+			return nil, errors.Wrapf(err, "converting value of financial account %s", fa.ID.Hex())
+		}
+	}
+
+	snapshot := NewNetWorthSnapshot(accounts, currencyID, now)
+
+	filter := bson.M{"currency_id": snapshot.CurrencyID, "day": snapshot.Day}
+	opts := options.FindOneAndReplace().SetUpsert(true).SetReturnDocument(options.After)
+
+	var stored NetWorthSnapshot
+	if err := db.Collection(NetWorthCollection).FindOneAndReplace(ctx, filter, snapshot, opts).Decode(&stored); err != nil {
+		return nil, errors.Wrapf(err, "storing net worth snapshot of %s", snapshot.Day.Format("2006-01-02"))
+	}
+
+	fmt.Printf("net worth snapshot %s : %s\n", stored.Day.Format("2006-01-02"), stored.NetWorth)
+
+	return &stored, nil
+}
+
+// NewNetWorthSnapshot sums the current values of the accounts, which must already be in the currency identified by currencyID,
+// into the snapshot of the day of now. Groups are sorted by key.
+func NewNetWorthSnapshot(accounts []FinancialAccount, currencyID string, now time.Time) NetWorthSnapshot {
+
+	zero := Money{CurrencyID: currencyID}
+
+	snapshot := NetWorthSnapshot{
+		Day:           day(now.UTC()),
+		CurrencyID:    currencyID,
+		NetWorth:      zero,
+		Assets:        zero,
+		Liabilities:   zero,
+		Accounts:      len(accounts),
+		ByInstitution: []NetWorthGroup{},
+		ByType:        []NetWorthGroup{},
+		TakenAt:       now.UTC(),
+	}
+
+	byInstitution := map[string]*NetWorthGroup{}
+	byType := map[string]*NetWorthGroup{}
+
+	add := func(groups map[string]*NetWorthGroup, key string, value Money) {
+		if groups[key] == nil {
+			groups[key] = &NetWorthGroup{Key: key, Value: zero}
+		}
+		groups[key].Value = groups[key].Value.Add(value)
+		groups[key].Accounts++
+	}
+
+	for _, fa := range accounts {
+
+		value := fa.CurrentValue
+		value.CurrencyID = currencyID
+
+		if value.Sign() < 0 {
+			snapshot.Liabilities = snapshot.Liabilities.Sub(value)
+		} else {
+			snapshot.Assets = snapshot.Assets.Add(value)
+		}
+
+		add(byInstitution, fa.FinancialInstitution, value)
+		add(byType, fa.AccountType, value)
+	}
+
+	snapshot.NetWorth = snapshot.Assets.Sub(snapshot.Liabilities)
+
+	for _, g := range byInstitution {
+		snapshot.ByInstitution = append(snapshot.ByInstitution, *g)
+	}
+	for _, g := range byType {
+		snapshot.ByType = append(snapshot.ByType, *g)
+	}
+
+	sort.Slice(snapshot.ByInstitution, func(i, j int) bool { return snapshot.ByInstitution[i].Key < snapshot.ByInstitution[j].Key })
+	sort.Slice(snapshot.ByType, func(i, j int) bool { return snapshot.ByType[i].Key < snapshot.ByType[j].Key })
+
+	return snapshot
+}
+
+// ListNetWorth gets the net worth snapshots in the currency identified by currencyID taken within the window, oldest first.
+func ListNetWorth(ctx context.Context, db *mongo.Database, currencyID string, window SummaryWindow) (*NetWorthHistory, error) {
+
+	if currencyID == "" {
+		return nil, &apierror.ValidationError{Fields: []apierror.FieldError{{Field: "currency_id", Error: "currency_id is a required field"}}}
+	}
+
+	filter := bson.M{"currency_id": currencyID}
+	if r := dateRangeQuery(window.From, window.To); r != nil {
+		filter["day"] = r
+	}
+
+	cursor, err := db.Collection(NetWorthCollection).Find(ctx, filter, options.Find().SetSort(bson.M{"day": 1}))
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cursor from net worth collection")
+	}
+
+	history := NetWorthHistory{CurrencyID: currencyID, Window: window, Snapshots: []NetWorthSnapshot{}}
+	if err := cursor.All(ctx, &history.Snapshots); err != nil {
+		return nil, errors.Wrap(err, "retrieving net worth snapshots")
+	}
+
+	return &history, nil
+}
+
+// checkNetWorthCurrency returns a ValidationError unless currencyID identifies an existing currency.
+func checkNetWorthCurrency(ctx context.Context, db *mongo.Database, currencyID string) error {
+
+	if currencyID == "" {
+		return &apierror.ValidationError{Fields: []apierror.FieldError{{Field: "currency_id", Error: "currency_id is a required field"}}}
+	}
+
+	missing, err := missingIDs(ctx, db, CurrencyCollection, []string{currencyID})
+	if err != nil {
+		return err
+	}
+
+	if len(missing) > 0 {
+		return &apierror.ValidationError{Fields: []apierror.FieldError{{Field: "currency_id", Error: fmt.Sprintf("%q does NOT reference an existing currency", currencyID)}}}
+	}
+
+	return nil
+}
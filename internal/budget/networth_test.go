@@ -0,0 +1,62 @@
+package budget_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+)
+
+func TestNewNetWorthSnapshot(t *testing.T) {
+	account := func(institution, accountType, value string) budget.FinancialAccount {
+		return budget.FinancialAccount{FinancialInstitution: institution, AccountType: accountType, CurrentValue: money(t, value)}
+	}
+
+	accounts := []budget.FinancialAccount{
+		account("bank", budget.AccountChecking, "1200.50"),
+		account("bank", budget.AccountCredit, "-300.25"),
+		account("broker", budget.AccountInvestment, "5000"),
+		account("", "", "20"),
+	}
+
+	now := time.Date(2020, time.September, 14, 18, 30, 0, 0, time.UTC)
+	snapshot := budget.NewNetWorthSnapshot(accounts, "5f381f30f815d062fb9da8f1", now)
+
+	if got := snapshot.Day.Format(time.RFC3339); got != "2020-09-14T00:00:00Z" {
+		t.Fatalf("expected the snapshot of the day, got %s", got)
+	}
+
+	totals := []struct {
+		name      string
+		got, want budget.Money
+	}{
+		{"net worth", snapshot.NetWorth, money(t, "5920.25")},
+		{"assets", snapshot.Assets, money(t, "6220.50")},
+		{"liabilities", snapshot.Liabilities, money(t, "300.25")},
+	}
+	for _, tt := range totals {
+		if tt.got.Cmp(tt.want) != 0 {
+			t.Fatalf("expected %s of %s, got %s", tt.name, tt.want, tt.got)
+		}
+	}
+
+	groups := []struct {
+		name   string
+		groups []budget.NetWorthGroup
+		keys   []string
+		values []string
+	}{
+		{"institution", snapshot.ByInstitution, []string{"", "bank", "broker"}, []string{"20", "900.25", "5000"}},
+		{"type", snapshot.ByType, []string{"", budget.AccountChecking, budget.AccountCredit, budget.AccountInvestment}, []string{"20", "1200.50", "-300.25", "5000"}},
+	}
+	for _, tt := range groups {
+		if len(tt.groups) != len(tt.keys) {
+			t.Fatalf("by %s: expected %d groups, got %+v", tt.name, len(tt.keys), tt.groups)
+		}
+		for i, g := range tt.groups {
+			if g.Key != tt.keys[i] || g.Value.Cmp(money(t, tt.values[i])) != 0 {
+				t.Fatalf("by %s: expected %q worth %s, got %q worth %s", tt.name, tt.keys[i], tt.values[i], g.Key, g.Value)
+			}
+		}
+	}
+}
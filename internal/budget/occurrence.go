@@ -0,0 +1,99 @@
+package budget
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrInvalidOccurrence is used when an occurrence date is NOT in one of the accepted formats.
+var ErrInvalidOccurrence = errors.New("occurrence must be an RFC3339 timestamp, YYYY-MM-DD or M/D/YYYY")
+
+// occurrenceLayouts are the date formats accepted for a transaction occurrence.
+var occurrenceLayouts = []string{
+	time.RFC3339,
+	"2006-01-02",
+	"1/2/2006",
+	"1/2/06",
+}
+
+// ParseOccurrence converts a date sent by a client or stored in occurrence_string into a time.
+// Dates without a time are midnight UTC.
+// When defaultYear is NOT zero a month and day without a year, e.g. "3/1", is read as that day of defaultYear.
+func ParseOccurrence(value string, defaultYear int) (time.Time, error) {
+
+	value = strings.TrimSpace(value)
+
+	for _, layout := range occurrenceLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+
+	if defaultYear != 0 {
+		if t, err := time.Parse("1/2/2006", fmt.Sprintf("%s/%d", value, defaultYear)); err == nil {
+			return t.UTC(), nil
+		}
+	}
+
+	return time.Time{}, ErrInvalidOccurrence
+}
+
+// OccurrenceMigration reports the outcome of MigrateOccurrences.
+type OccurrenceMigration struct {
+	Migrated int      `json:"migrated"`
+	Failed   []string `json:"failed,omitempty"` // _ids whose occurrence_string could NOT be parsed
+}
+
+// MigrateOccurrences sets the occurrence date of every transaction that only has an occurrence_string.
+// Values without a year, e.g. "3/1", are placed in defaultYear.
+// Transactions that already have an occurrence are left alone so the migration can be run more than once.
+func MigrateOccurrences(ctx context.Context, db *mongo.Collection, defaultYear int) (*OccurrenceMigration, error) {
+
+	filter := bson.M{
+		"occurrence":        bson.M{"$exists": false},
+		"occurrence_string": bson.M{"$exists": true, "$ne": ""},
+	}
+
+	cursor, err := db.Find(ctx, filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cursor from transaction collection. migrating occurrences")
+	}
+	defer cursor.Close(ctx)
+
+	var result OccurrenceMigration
+
+	for cursor.Next(ctx) {
+		var tranx struct {
+			ID               primitive.ObjectID `bson:"_id"`
+			OccurrenceString string             `bson:"occurrence_string"`
+		}
+		if err := cursor.Decode(&tranx); err != nil {
+			return nil, errors.Wrap(err, "decoding transaction. migrating occurrences")
+		}
+
+		occurrence, err := ParseOccurrence(tranx.OccurrenceString, defaultYear)
+		if err != nil {
+			result.Failed = append(result.Failed, tranx.ID.Hex())
+			continue
+		}
+
+		update := bson.M{"$set": bson.M{"occurrence": occurrence}}
+		if _, err := db.UpdateOne(ctx, bson.M{"_id": tranx.ID}, update); err != nil {
+			return nil, errors.Wrapf(err, "migrating occurrence of transaction %s", tranx.ID.Hex())
+		}
+		result.Migrated++
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, errors.Wrap(err, "migrating occurrences")
+	}
+
+	return &result, nil
+}
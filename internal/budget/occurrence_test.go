@@ -0,0 +1,38 @@
+package budget_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+)
+
+func TestParseOccurrence(t *testing.T) {
+	march1 := time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value       string
+		defaultYear int
+		want        time.Time
+		ok          bool
+	}{
+		{"2020-03-01", 0, march1, true},
+		{"2020-03-01T00:00:00Z", 0, march1, true},
+		{"3/1/2020", 0, march1, true},
+		{" 3/1/20 ", 0, march1, true},
+		{"3/1", 2020, march1, true},
+		{"3/1", 0, time.Time{}, false},
+		{"13/1", 2020, time.Time{}, false},
+		{"movies", 2020, time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		got, err := budget.ParseOccurrence(tt.value, tt.defaultYear)
+		if tt.ok != (err == nil) {
+			t.Fatalf("%q: expected ok %v, got error %v", tt.value, tt.ok, err)
+		}
+		if !got.Equal(tt.want) {
+			t.Fatalf("%q: expected %v, got %v", tt.value, tt.want, got)
+		}
+	}
+}
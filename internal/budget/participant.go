@@ -0,0 +1,292 @@
+package budget
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ListParticipants gets the participants from the db.
+// Results are returned one page at a time.
+func ListParticipants(ctx context.Context, db *mongo.Collection, page database.Page) ([]Participant, *database.PageInfo, error) {
+
+	list := []Participant{}
+
+	info, err := database.FindPage(ctx, db, bson.M{}, page, &list)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "retrieving participant list")
+	}
+
+	return list, info, nil
+}
+
+// RetrieveParticipant finds a single participant by _id.
+func RetrieveParticipant(ctx context.Context, db *mongo.Collection, _id string) (*Participant, error) {
+
+	var participant Participant
+
+	id, err := primitive.ObjectIDFromHex(_id)
+	if err != nil {
+		return nil, apierror.ErrInvalidID
+	}
+
+	if err := db.FindOne(ctx, bson.M{"_id": id}).Decode(&participant); err != nil {
+		return nil, apierror.ErrNotFound
+	}
+
+	return &participant, nil
+}
+
+// CreateParticipant takes data from the client to create a participant in the db.
+// The user it is linked to must exist and can NOT be linked to another participant.
+func CreateParticipant(ctx context.Context, db *mongo.Database, user auth.Claims, newParticipant NewParticipant, now time.Time) (*Participant, error) {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return nil, apierror.ErrForbidden
+	}
+
+	participant := Participant{
+		ID:        primitive.NewObjectID(),
+		Name:      strings.TrimSpace(newParticipant.Name),
+		Email:     strings.TrimSpace(newParticipant.Email),
+		UserID:    newParticipant.UserID,
+		CreatedAt: now.UTC(),
+		UpdatedAt: now.UTC(),
+	}
+
+	if err := checkParticipant(ctx, db, participant); err != nil {
+		return nil, err
+	}
+
+	pResult, err := db.Collection(ParticipantCollection).InsertOne(ctx, participant)
+	if err != nil {
+		return nil, errors.Wrapf(err, "inserting participant : %v", participant)
+	}
+
+	fmt.Println("pResult : ", pResult)
+
+	return &participant, nil
+}
+
+// UpdateOneParticipant modifies data about a participant.
+// It will error if the specified _id is invalid or does NOT reference an existing participant.
+func UpdateOneParticipant(ctx context.Context, db *mongo.Database, user auth.Claims, participantID string, updateParticipant UpdateParticipant, now time.Time) error {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return apierror.ErrForbidden
+	}
+
+	foundParticipant, err := RetrieveParticipant(ctx, db.Collection(ParticipantCollection), participantID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("participant to update found %+v : \n", foundParticipant)
+
+	participant := *foundParticipant
+	set := bson.M{"updated_at": now}
+	unset := bson.M{}
+
+	if updateParticipant.Name != nil {
+		participant.Name = strings.TrimSpace(*updateParticipant.Name)
+		set["name"] = participant.Name
+	}
+
+	if updateParticipant.Email != nil {
+		participant.Email = strings.TrimSpace(*updateParticipant.Email)
+		set["email"] = participant.Email
+		if participant.Email == "" {
+			delete(set, "email")
+			unset["email"] = ""
+		}
+	}
+
+	if updateParticipant.UserID != nil {
+		participant.UserID = *updateParticipant.UserID
+		set["user_id"] = participant.UserID
+		if participant.UserID == "" {
+			delete(set, "user_id")
+			unset["user_id"] = ""
+		}
+	}
+
+	if err := checkParticipant(ctx, db, participant); err != nil {
+		return err
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	pResult, err := db.Collection(ParticipantCollection).UpdateOne(ctx, bson.M{"_id": participant.ID}, update)
+	if err != nil {
+		return errors.Wrap(err, "updating participant")
+	}
+
+	fmt.Printf("pResult updated %v : \n", pResult)
+
+	return nil
+}
+
+// DeleteParticipant removes the participant identified by a given _id.
+// Transactions the participant takes part in block the delete unless the rule cascades or reassigns them.
+// Reassigning moves the payments and shares of the participant too.
+func DeleteParticipant(ctx context.Context, db *mongo.Database, user auth.Claims, participantID string, rule DeleteRule, now time.Time) error {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return apierror.ErrForbidden
+	}
+
+	pObjectID, err := primitive.ObjectIDFromHex(participantID)
+	if err != nil {
+		return apierror.ErrInvalidID
+	}
+
+	foundParticipant, err := RetrieveParticipant(ctx, db.Collection(ParticipantCollection), participantID)
+	if err != nil {
+		return apierror.ErrNotFound
+	}
+
+	fmt.Printf("participant to delelete found %+v : \n", foundParticipant)
+
+	return withTransaction(ctx, db, func(sc mongo.SessionContext) error {
+
+		if err := resolveDependents(sc, db, participantRef, participantID, rule, now); err != nil {
+			return err
+		}
+
+		result, err := db.Collection(ParticipantCollection).DeleteOne(sc, bson.M{"_id": pObjectID})
+		if err != nil {
+			return errors.Wrapf(err, "deleting participant %s", participantID)
+		}
+
+		fmt.Print("result of deleting : ", result)
+
+		return nil
+	})
+}
+
+// checkParticipant returns a ValidationError when the participant has no name or is linked to a user
+// that does NOT exist or already has a participant.
+func checkParticipant(ctx context.Context, db *mongo.Database, participant Participant) error {
+
+	verr := apierror.ValidationError{}
+
+	if participant.Name == "" {
+		verr.Add("name", "name is a required field")
+	}
+
+	if participant.UserID != "" {
+
+		missing, err := missingIDs(ctx, db, UserCollection, []string{participant.UserID})
+		if err != nil {
+			return err
+		}
+
+		if len(missing) > 0 {
+			verr.Add("user_id", fmt.Sprintf("%q does NOT reference an existing user", participant.UserID))
+		} else {
+			filter := bson.M{"user_id": participant.UserID, "_id": bson.M{"$ne": participant.ID}}
+			count, err := db.Collection(ParticipantCollection).CountDocuments(ctx, filter)
+			if err != nil {
+				return errors.Wrap(err, "counting participants of user")
+			}
+			if count > 0 {
+				verr.Add("user_id", fmt.Sprintf("user %s is already linked to another participant", participant.UserID))
+			}
+		}
+	}
+
+	return verr.Err()
+}
+
+// ParticipantMigration reports the outcome of MigrateParticipants.
+type ParticipantMigration struct {
+	Created int      `json:"created"`
+	Unknown []string `json:"unknown,omitempty"` // participant_id values that are neither a participant nor a user
+}
+
+// MigrateParticipants creates a participant for every user the transactions name as a participant.
+// Transactions used to refer to users directly, so each participant keeps the _id of its user and is linked to it;
+// the transactions are NOT changed.
+// Users that already have a participant are skipped so the migration can be run more than once.
+func MigrateParticipants(ctx context.Context, db *mongo.Database, now time.Time) (*ParticipantMigration, error) {
+
+	values, err := db.Collection(TransactionCollection).Distinct(ctx, "participant_id", bson.M{})
+	if err != nil {
+		return nil, errors.Wrap(err, "finding participants of transactions")
+	}
+
+	var ids []string
+	for _, v := range values {
+		if id, ok := v.(string); ok && id != "" {
+			ids = append(ids, id)
+		}
+	}
+
+	missing, err := missingIDs(ctx, db, ParticipantCollection, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	result := ParticipantMigration{}
+
+	for _, id := range missing {
+
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			result.Unknown = append(result.Unknown, id)
+			continue
+		}
+
+		var u struct {
+			Name  string `bson:"name"`
+			Email string `bson:"email"`
+		}
+		if err := db.Collection(UserCollection).FindOne(ctx, bson.M{"_id": objectID}).Decode(&u); err != nil {
+			if err == mongo.ErrNoDocuments {
+				result.Unknown = append(result.Unknown, id)
+				continue
+			}
+			return nil, errors.Wrapf(err, "retrieving user %s", id)
+		}
+
+		name := u.Name
+		if name == "" {
+			name = u.Email
+		}
+
+		participant := Participant{
+			ID:        objectID,
+			Name:      name,
+			Email:     u.Email,
+			UserID:    id,
+			CreatedAt: now.UTC(),
+			UpdatedAt: now.UTC(),
+		}
+
+		if _, err := db.Collection(ParticipantCollection).InsertOne(ctx, participant); err != nil {
+			return nil, errors.Wrapf(err, "inserting participant for user %s", id)
+		}
+
+		result.Created++
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,278 @@
+package budget
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/pkg/errors"
+)
+
+// Kinds of budget periods. A budget without a period has a single value for all time.
+const (
+	PeriodMonthly   = "monthly"
+	PeriodQuarterly = "quarterly"
+	PeriodYearly    = "yearly"
+	PeriodCustom    = "custom" // one period from the start to the end of the budget
+)
+
+// BudgetPeriod is one period of a Budget. End is the last day of the period.
+type BudgetPeriod struct {
+	Name  string    `json:"period"` // YYYY-MM, YYYY-Qn, YYYY, or YYYY-MM-DD/YYYY-MM-DD for a custom period
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// PeriodSummary compares what was allocated to a budget for a period with the transactions of that period.
+type PeriodSummary struct {
+	BudgetPeriod
+	Allocated   Money   `json:"allocated"`    // the allocation of the period, or the budget value
+	RolledOver  Money   `json:"rolled_over"`  // left over from the period before, when the budget rolls over
+	Available   Money   `json:"available"`    // allocated plus rolled over
+	Spent       Money   `json:"spent"`        // sum of debits
+	Received    Money   `json:"received"`     // sum of credits
+	Remaining   Money   `json:"remaining"`    // available less what was spent, plus what was received
+	PercentUsed float64 `json:"percent_used"` // net spending as a percentage of what was available
+}
+
+// periodOf returns the period of the given kind that the day t falls in.
+func periodOf(kind string, t time.Time) BudgetPeriod {
+
+	t = day(t)
+
+	var start, next time.Time
+	var name string
+
+	switch kind {
+	case PeriodQuarterly:
+		q := (int(t.Month()) - 1) / 3
+		start = time.Date(t.Year(), time.Month(q*3+1), 1, 0, 0, 0, 0, time.UTC)
+		next = start.AddDate(0, 3, 0)
+		name = fmt.Sprintf("%d-Q%d", t.Year(), q+1)
+	case PeriodYearly:
+		start = time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+		next = start.AddDate(1, 0, 0)
+		name = start.Format("2006")
+	default:
+		start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		next = start.AddDate(0, 1, 0)
+		name = start.Format("2006-01")
+	}
+
+	return BudgetPeriod{Name: name, Start: start, End: next.AddDate(0, 0, -1)}
+}
+
+// currentPeriodStart is where a budget of the given kind starts when no start is given: the first day of the period of now.
+// A custom period starts on the day of now.
+func currentPeriodStart(kind string, now time.Time) time.Time {
+
+	if kind == PeriodCustom {
+		return day(now)
+	}
+
+	return periodOf(kind, now).Start
+}
+
+// parsePeriodName reads the name of a period of the given kind, as used by allocations.
+func parsePeriodName(kind, name string) (BudgetPeriod, error) {
+
+	var (
+		t   time.Time
+		err error
+	)
+
+	switch kind {
+	case PeriodMonthly:
+		t, err = time.Parse("2006-01", name)
+	case PeriodYearly:
+		t, err = time.Parse("2006", name)
+	case PeriodQuarterly:
+		parts := strings.Split(name, "-Q")
+		if len(parts) != 2 {
+			return BudgetPeriod{}, errors.New("period must be YYYY-Qn")
+		}
+		q, qerr := strconv.Atoi(parts[1])
+		if qerr != nil || len(parts[1]) != 1 || q < 1 || q > 4 {
+			return BudgetPeriod{}, errors.New("period must be YYYY-Qn")
+		}
+		t, err = time.Parse("2006-01", fmt.Sprintf("%s-%02d", parts[0], (q-1)*3+1))
+	default:
+		return BudgetPeriod{}, errors.Errorf("allocations are NOT given by name for %q budgets", kind)
+	}
+
+	if err != nil {
+		return BudgetPeriod{}, errors.Errorf("%q is NOT the name of a %s period", name, kind)
+	}
+
+	return periodOf(kind, t), nil
+}
+
+// Periods returns the periods of the budget that overlap the days from and to, in order.
+// Periods never begin before the start of the budget or end after its end.
+// A budget without a period has none.
+func (b Budget) Periods(from, to time.Time) []BudgetPeriod {
+
+	start, end := b.bounds()
+
+	if b.Period == "" || start.IsZero() {
+		return nil
+	}
+
+	from, to = day(from), day(to)
+	if from.Before(start) {
+		from = start
+	}
+	if !end.IsZero() && to.After(end) {
+		to = end
+	}
+
+	if to.Before(from) {
+		return nil
+	}
+
+	if b.Period == PeriodCustom {
+		return []BudgetPeriod{{Name: start.Format("2006-01-02") + "/" + end.Format("2006-01-02"), Start: start, End: end}}
+	}
+
+	var periods []BudgetPeriod
+	for p := periodOf(b.Period, from); !p.Start.After(to); p = periodOf(b.Period, p.End.AddDate(0, 0, 1)) {
+		periods = append(periods, p)
+	}
+
+	return periods
+}
+
+// bounds returns the first and last day of the budget. The last day is zero when the budget has no end.
+func (b Budget) bounds() (time.Time, time.Time) {
+
+	var start, end time.Time
+
+	if b.Start != nil {
+		start = day(*b.Start)
+	}
+
+	if b.End != nil {
+		end = day(*b.End)
+	}
+
+	return start, end
+}
+
+// allocation returns what is allocated to the budget for the named period.
+func (b Budget) allocation(name string) Money {
+
+	value := b.BudgetValue
+	for _, a := range b.Allocations {
+		if a.Period == name {
+			value = a.Amount
+		}
+	}
+
+	if value.CurrencyID == "" {
+		value.CurrencyID = b.CurrencyID
+	}
+
+	return value
+}
+
+// Balance works out what is available and left in each period of a history, given what was allocated, spent and received.
+// When the budget rolls over whatever is left of a period is added to the next one; overspending is NOT carried.
+func (b Budget) Balance(periods []PeriodSummary) {
+
+	var carry Money
+
+	for i := range periods {
+		p := &periods[i]
+
+		p.RolledOver = Money{CurrencyID: p.Allocated.CurrencyID}
+		if b.Rollover && carry.Sign() > 0 {
+			p.RolledOver = carry
+		}
+
+		p.Available = p.Allocated.Add(p.RolledOver)
+		p.Remaining = p.Available.Sub(p.Spent).Add(p.Received)
+
+		p.PercentUsed = 0
+		if !p.Available.IsZero() {
+			p.PercentUsed = p.Spent.Sub(p.Received).Float64() / p.Available.Float64() * 100
+		}
+
+		carry = p.Remaining
+	}
+}
+
+// checkBudgetPeriod records a validation problem for every way the period settings of the budget do NOT fit together.
+func checkBudgetPeriod(b Budget, verr *apierror.ValidationError) {
+
+	start, end := b.bounds()
+
+	switch b.Period {
+	case "":
+		if len(b.Allocations) > 0 {
+			verr.Add("allocations", "a budget needs a period to have allocations")
+		}
+		if b.Rollover {
+			verr.Add("rollover", "a budget needs a period to roll over")
+		}
+		return
+	case PeriodMonthly, PeriodQuarterly, PeriodYearly:
+	case PeriodCustom:
+		if end.IsZero() {
+			verr.Add("end", "a custom period needs an end")
+		}
+	default:
+		verr.Add("period", "period must be monthly, quarterly, yearly or custom")
+		return
+	}
+
+	if start.IsZero() {
+		verr.Add("start", "a budget with a period needs a start")
+	}
+
+	if !start.IsZero() && !end.IsZero() && end.Before(start) {
+		verr.Add("end", "end must NOT be before start")
+	}
+
+	seen := map[string]bool{}
+	for i, a := range b.Allocations {
+
+		field := fmt.Sprintf("allocations[%d]", i)
+
+		name := a.Period
+		if b.Period == PeriodCustom {
+			if periods := b.Periods(start, end); len(periods) == 1 && name != periods[0].Name {
+				verr.Add(field+".period", fmt.Sprintf("the period of the budget is %s", periods[0].Name))
+			}
+		} else if p, err := parsePeriodName(b.Period, name); err != nil {
+			verr.Add(field+".period", err.Error())
+		} else {
+			name = p.Name
+		}
+
+		if seen[name] {
+			verr.Add(field+".period", fmt.Sprintf("period %s is allocated more than once", name))
+		}
+		seen[name] = true
+
+		if a.Amount.Sign() < 0 {
+			verr.Add(field+".amount", "amount must NOT be negative")
+		}
+	}
+}
+
+// withAllocationCurrency returns a copy of the allocations with their amounts in the currency identified by currencyID.
+func withAllocationCurrency(allocations []Allocation, currencyID string) []Allocation {
+
+	if allocations == nil {
+		return nil
+	}
+
+	out := make([]Allocation, len(allocations))
+	for i, a := range allocations {
+		out[i] = Allocation{Period: a.Period, Amount: Money{Amount: a.Amount.Amount, CurrencyID: currencyID}}
+	}
+
+	return out
+}
@@ -0,0 +1,103 @@
+package budget_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+)
+
+// at returns a pointer to a date, as budgets hold their start and end.
+func at(t *testing.T, value string) *time.Time {
+	t.Helper()
+
+	d := date(t, value)
+	return &d
+}
+
+func TestBudgetPeriods(t *testing.T) {
+	tests := []struct {
+		name     string
+		budget   budget.Budget
+		from, to string
+		want     string
+	}{
+		{"monthly", budget.Budget{Period: budget.PeriodMonthly, Start: at(t, "2020-01-15")}, "2020-01-01", "2020-03-31", "2020-01 2020-02 2020-03"},
+		{"monthly before start", budget.Budget{Period: budget.PeriodMonthly, Start: at(t, "2020-03-01")}, "2020-01-01", "2020-04-10", "2020-03 2020-04"},
+		{"monthly after end", budget.Budget{Period: budget.PeriodMonthly, Start: at(t, "2020-01-01"), End: at(t, "2020-02-29")}, "2020-01-01", "2020-06-30", "2020-01 2020-02"},
+		{"quarterly", budget.Budget{Period: budget.PeriodQuarterly, Start: at(t, "2020-01-01")}, "2020-02-10", "2020-08-01", "2020-Q1 2020-Q2 2020-Q3"},
+		{"yearly", budget.Budget{Period: budget.PeriodYearly, Start: at(t, "2019-01-01")}, "2019-06-01", "2020-01-01", "2019 2020"},
+		{"custom", budget.Budget{Period: budget.PeriodCustom, Start: at(t, "2020-09-01"), End: at(t, "2020-10-15")}, "2020-01-01", "2020-12-31", "2020-09-01/2020-10-15"},
+		{"custom outside", budget.Budget{Period: budget.PeriodCustom, Start: at(t, "2020-09-01"), End: at(t, "2020-10-15")}, "2020-11-01", "2020-12-31", ""},
+		{"no period", budget.Budget{Start: at(t, "2020-01-01")}, "2020-01-01", "2020-12-31", ""},
+	}
+
+	for _, tt := range tests {
+		periods := tt.budget.Periods(date(t, tt.from), date(t, tt.to))
+
+		names := make([]string, len(periods))
+		for i, p := range periods {
+			names[i] = p.Name
+		}
+
+		if got := strings.Join(names, " "); got != tt.want {
+			t.Fatalf("%s: expected periods %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestBudgetPeriodBounds(t *testing.T) {
+	b := budget.Budget{Period: budget.PeriodQuarterly, Start: at(t, "2020-01-01")}
+
+	periods := b.Periods(date(t, "2020-05-05"), date(t, "2020-05-05"))
+	if len(periods) != 1 {
+		t.Fatalf("expected one period, got %d", len(periods))
+	}
+
+	if got := periods[0].Start.Format("2006-01-02") + " " + periods[0].End.Format("2006-01-02"); got != "2020-04-01 2020-06-30" {
+		t.Fatalf("expected the second quarter, got %s", got)
+	}
+}
+
+func TestBudgetBalance(t *testing.T) {
+	period := func(allocated, spent, received string) budget.PeriodSummary {
+		return budget.PeriodSummary{Allocated: money(t, allocated), Spent: money(t, spent), Received: money(t, received)}
+	}
+
+	tests := []struct {
+		name      string
+		rollover  bool
+		remaining []string
+	}{
+		{"without rollover", false, []string{"20", "-10", "50"}},
+		{"with rollover", true, []string{"20", "10", "60"}},
+	}
+
+	for _, tt := range tests {
+		periods := []budget.PeriodSummary{
+			period("100", "80", "0"),
+			period("100", "110", "0"),
+			period("100", "60", "10"),
+		}
+
+		budget.Budget{Rollover: tt.rollover}.Balance(periods)
+
+		for i, p := range periods {
+			if want := money(t, tt.remaining[i]); p.Remaining.Cmp(want) != 0 {
+				t.Fatalf("%s: expected %s remaining in period %d, got %s", tt.name, want, i, p.Remaining)
+			}
+		}
+	}
+
+	periods := []budget.PeriodSummary{period("100", "80", "0"), period("100", "30", "0")}
+	budget.Budget{Rollover: true}.Balance(periods)
+
+	if want := money(t, "20"); periods[1].RolledOver.Cmp(want) != 0 {
+		t.Fatalf("expected %s to roll over, got %s", want, periods[1].RolledOver)
+	}
+
+	if periods[1].PercentUsed != 25 {
+		t.Fatalf("expected 25 percent used of what was available, got %v", periods[1].PercentUsed)
+	}
+}
@@ -0,0 +1,430 @@
+package budget
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Statuses of a Reconciliation.
+const (
+	ReconciliationOpen     = "open"
+	ReconciliationFinished = "finished"
+)
+
+// alreadyOpen is the validation problem of a reconciliation started while another one of its account is open.
+const alreadyOpen = "the financial account already has an open reconciliation, finish or delete it first"
+
+// CreateReconciliation starts reconciling the financial account identified by faID against a statement.
+// An account has at most one open reconciliation at a time.
+func CreateReconciliation(ctx context.Context, db *mongo.Database, user auth.Claims, faID string, newRec NewReconciliation, now time.Time) (*Reconciliation, error) {
+
+	fa, err := reconciledAccount(ctx, db, user, faID)
+	if err != nil {
+		return nil, err
+	}
+
+	verr := apierror.ValidationError{}
+
+	statementDate, err := ParseOccurrence(newRec.StatementDate, 0)
+	if err != nil {
+		verr.Add("statement_date", err.Error())
+	}
+
+	open, err := db.Collection(ReconciliationCollection).CountDocuments(ctx, bson.M{"fin_acc_id": faID, "status": ReconciliationOpen})
+	if err != nil {
+		return nil, errors.Wrap(err, "counting open reconciliations")
+	}
+	if open > 0 {
+		verr.Add("fin_acc_id", alreadyOpen)
+	}
+
+	if err := verr.Err(); err != nil {
+		return nil, err
+	}
+
+	rec := Reconciliation{
+		ID:                 primitive.NewObjectID(),
+		FinancialAccountID: faID,
+		StatementDate:      day(statementDate),
+		StatementBalance:   Money{Amount: newRec.StatementBalance.Amount, CurrencyID: fa.CurrencyID},
+		Status:             ReconciliationOpen,
+		CreatedAt:          now.UTC(),
+		UpdatedAt:          now.UTC(),
+	}
+
+	if err := balanceReconciliation(ctx, db, *fa, &rec); err != nil {
+		return nil, err
+	}
+
+	// The count above misses a reconciliation started at the same time, the unique index of open ones does NOT.
+	if _, err := db.Collection(ReconciliationCollection).InsertOne(ctx, rec); err != nil {
+		if isDuplicateKey(err) {
+			verr.Add("fin_acc_id", alreadyOpen)
+			return nil, verr.Err()
+		}
+		return nil, errors.Wrapf(err, "inserting reconciliation : %v", rec)
+	}
+
+	fmt.Printf("reconciliation %s of financial account %s started\n", rec.ID.Hex(), faID)
+
+	return &rec, nil
+}
+
+// ListReconciliations gets the reconciliations of the financial account identified by faID, latest statement first
+// unless another order is asked for. Results are returned one page at a time.
+func ListReconciliations(ctx context.Context, db *mongo.Database, faID string, page database.Page) ([]Reconciliation, *database.PageInfo, error) {
+
+	if _, err := RetrieveFinancialAccount(ctx, db.Collection(FinancialAccountCollection), faID); err != nil {
+		return nil, nil, err
+	}
+
+	if page.Sort == "" {
+		page.Sort = "statement_date"
+		page.Desc = true
+	}
+
+	list := []Reconciliation{}
+
+	info, err := database.FindPage(ctx, db.Collection(ReconciliationCollection), bson.M{"fin_acc_id": faID}, page, &list)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "retrieving reconciliations of financial account %s", faID)
+	}
+
+	return list, info, nil
+}
+
+// RetrieveReconciliation finds a reconciliation of the financial account identified by faID.
+// The balances of an open reconciliation are worked out again, so they follow the transactions cleared since.
+func RetrieveReconciliation(ctx context.Context, db *mongo.Database, faID, recID string) (*Reconciliation, error) {
+
+	fa, err := RetrieveFinancialAccount(ctx, db.Collection(FinancialAccountCollection), faID)
+	if err != nil {
+		return nil, err
+	}
+
+	rec, err := findReconciliation(ctx, db, faID, recID)
+	if err != nil {
+		return nil, err
+	}
+
+	if rec.Status == ReconciliationOpen {
+		if err := balanceReconciliation(ctx, db, *fa, rec); err != nil {
+			return nil, err
+		}
+	}
+
+	return rec, nil
+}
+
+// UnclearedTransactions gets the transactions of the account that are NOT cleared yet and occur
+// up to the statement date of the reconciliation, oldest first.
+func UnclearedTransactions(ctx context.Context, db *mongo.Database, faID, recID string, page database.Page) ([]Transaction, *database.PageInfo, error) {
+
+	if _, err := RetrieveFinancialAccount(ctx, db.Collection(FinancialAccountCollection), faID); err != nil {
+		return nil, nil, err
+	}
+
+	rec, err := findReconciliation(ctx, db, faID, recID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter := bson.M{
+		"fin_acc_id": faID,
+		"cleared_in": bson.M{"$ne": faID},
+		"occurrence": bson.M{"$lt": rec.StatementDate.AddDate(0, 0, 1)},
+	}
+
+	if page.Sort == "" {
+		page.Sort = "occurrence"
+	}
+
+	list := []Transaction{}
+
+	info, err := database.FindPage(ctx, db.Collection(TransactionCollection), filter, page, &list)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "retrieving uncleared transactions")
+	}
+
+	return list, info, nil
+}
+
+// ClearReconciliationTransactions marks transactions of the account as found on the statement of an open reconciliation,
+// or NOT found when clear.Cleared is false. Transactions that are locked can NOT be uncleared.
+// It returns the reconciliation with its balances worked out again.
+func ClearReconciliationTransactions(ctx context.Context, db *mongo.Database, user auth.Claims, faID, recID string, clear ClearTransactions, now time.Time) (*Reconciliation, error) {
+
+	fa, err := reconciledAccount(ctx, db, user, faID)
+	if err != nil {
+		return nil, err
+	}
+
+	rec, err := findReconciliation(ctx, db, faID, recID)
+	if err != nil {
+		return nil, err
+	}
+
+	if rec.Status != ReconciliationOpen {
+		return nil, &apierror.ValidationError{Fields: []apierror.FieldError{{Field: "status", Error: "the reconciliation is already finished"}}}
+	}
+
+	var ids []primitive.ObjectID
+	for _, id := range clear.TransactionIDs {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, &apierror.ValidationError{Fields: []apierror.FieldError{{Field: "tranx_id", Error: fmt.Sprintf("%q is NOT a valid _id", id)}}}
+		}
+		ids = append(ids, objectID)
+	}
+
+	tranxCollection := db.Collection(TransactionCollection)
+
+	cursor, err := tranxCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "fin_acc_id": faID})
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cursor from transaction collection")
+	}
+
+	var tranxs []Transaction
+	if err := cursor.All(ctx, &tranxs); err != nil {
+		return nil, errors.Wrap(err, "retrieving transactions to clear")
+	}
+
+	found := map[string]bool{}
+	for _, tranx := range tranxs {
+		found[tranx.ID.Hex()] = true
+	}
+
+	verr := apierror.ValidationError{}
+	for _, id := range clear.TransactionIDs {
+		if !found[id] {
+			verr.Add("tranx_id", fmt.Sprintf("%q is NOT a transaction of the financial account", id))
+		}
+	}
+	if err := verr.Err(); err != nil {
+		return nil, err
+	}
+
+	cleared := clear.Cleared == nil || *clear.Cleared
+
+	update := bson.M{"$addToSet": bson.M{"cleared_in": faID}, "$set": bson.M{"updated_at": now.UTC()}}
+	if !cleared {
+		for _, tranx := range tranxs {
+			if contains(tranx.ReconciledIn, faID) {
+				return nil, apierror.ErrLocked
+			}
+		}
+		update = bson.M{"$pull": bson.M{"cleared_in": faID}, "$set": bson.M{"updated_at": now.UTC()}}
+	}
+
+	if _, err := tranxCollection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, update); err != nil {
+		return nil, errors.Wrap(err, "clearing transactions")
+	}
+
+	if err := balanceReconciliation(ctx, db, *fa, rec); err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// FinishReconciliation closes an open reconciliation once the cleared balance matches the statement balance.
+// Every transaction cleared in the account that is NOT locked yet is locked against changes.
+func FinishReconciliation(ctx context.Context, db *mongo.Database, user auth.Claims, faID, recID string, now time.Time) (*Reconciliation, error) {
+
+	fa, err := reconciledAccount(ctx, db, user, faID)
+	if err != nil {
+		return nil, err
+	}
+
+	var rec *Reconciliation
+
+	err = withTransaction(ctx, db, func(sc mongo.SessionContext) error {
+
+		rec, err = findReconciliation(sc, db, faID, recID)
+		if err != nil {
+			return err
+		}
+
+		if rec.Status != ReconciliationOpen {
+			return &apierror.ValidationError{Fields: []apierror.FieldError{{Field: "status", Error: "the reconciliation is already finished"}}}
+		}
+
+		if err := balanceReconciliation(sc, db, *fa, rec); err != nil {
+			return err
+		}
+
+		if !rec.Difference.IsZero() {
+			return &apierror.ValidationError{Fields: []apierror.FieldError{{Field: "difference", Error: fmt.Sprintf("the cleared balance is %s away from the statement balance", rec.Difference)}}}
+		}
+
+		filter := bson.M{"fin_acc_id": faID, "cleared_in": faID, "reconciled_in": bson.M{"$ne": faID}}
+
+		values, err := db.Collection(TransactionCollection).Distinct(sc, "_id", filter)
+		if err != nil {
+			return errors.Wrap(err, "finding cleared transactions")
+		}
+
+		rec.TransactionIDs = nil
+		for _, v := range values {
+			if id, ok := v.(primitive.ObjectID); ok {
+				rec.TransactionIDs = append(rec.TransactionIDs, id.Hex())
+			}
+		}
+
+		lock := bson.M{"$addToSet": bson.M{"reconciled_in": faID}, "$set": bson.M{"updated_at": now.UTC()}}
+		if _, err := db.Collection(TransactionCollection).UpdateMany(sc, filter, lock); err != nil {
+			return errors.Wrap(err, "locking cleared transactions")
+		}
+
+		finishedAt := now.UTC()
+		rec.Status = ReconciliationFinished
+		rec.FinishedAt = &finishedAt
+		rec.UpdatedAt = finishedAt
+
+		if _, err := db.Collection(ReconciliationCollection).ReplaceOne(sc, bson.M{"_id": rec.ID}, rec); err != nil {
+			return errors.Wrapf(err, "finishing reconciliation %s", recID)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("reconciliation %s finished : %d transactions locked\n", recID, len(rec.TransactionIDs))
+
+	return rec, nil
+}
+
+// DeleteReconciliation removes an open reconciliation. Transactions stay cleared.
+// A finished reconciliation is kept as the record of what was locked.
+func DeleteReconciliation(ctx context.Context, db *mongo.Database, user auth.Claims, faID, recID string) error {
+
+	if _, err := reconciledAccount(ctx, db, user, faID); err != nil {
+		return err
+	}
+
+	rec, err := findReconciliation(ctx, db, faID, recID)
+	if err != nil {
+		return err
+	}
+
+	if rec.Status != ReconciliationOpen {
+		return &apierror.ValidationError{Fields: []apierror.FieldError{{Field: "status", Error: "a finished reconciliation can NOT be deleted"}}}
+	}
+
+	if _, err := db.Collection(ReconciliationCollection).DeleteOne(ctx, bson.M{"_id": rec.ID}); err != nil {
+		return errors.Wrapf(err, "deleting reconciliation %s", recID)
+	}
+
+	return nil
+}
+
+// UnlockTransaction lets a reconciled transaction be changed or deleted again.
+// It stays cleared, so it is locked again by the next reconciliation of its accounts.
+// Unlocking either transaction of a transfer unlocks both.
+func UnlockTransaction(ctx context.Context, db *mongo.Database, user auth.Claims, tranxID string, now time.Time) (*Transaction, error) {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return nil, apierror.ErrForbidden
+	}
+
+	tranxCollection := db.Collection(TransactionCollection)
+
+	tranx, err := RetrieveTransaction(ctx, tranxCollection, tranxID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []primitive.ObjectID{tranx.ID}
+	if objectID, err := primitive.ObjectIDFromHex(tranx.TransferID); err == nil {
+		ids = append(ids, objectID)
+	}
+
+	update := bson.M{"$unset": bson.M{"reconciled_in": ""}, "$set": bson.M{"updated_at": now.UTC()}}
+	if _, err := tranxCollection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, update); err != nil {
+		return nil, errors.Wrapf(err, "unlocking transaction %s", tranxID)
+	}
+
+	fmt.Printf("transaction %s unlocked\n", tranxID)
+
+	return RetrieveTransaction(ctx, tranxCollection, tranxID)
+}
+
+// checkUnlocked returns apierror.ErrLocked when any of the transactions was reconciled and NOT unlocked since.
+func checkUnlocked(tranxs ...Transaction) error {
+
+	for _, tranx := range tranxs {
+		if len(tranx.ReconciledIn) > 0 {
+			return apierror.ErrLocked
+		}
+	}
+
+	return nil
+}
+
+// reconciledAccount finds the financial account identified by faID, which the user must be allowed to reconcile.
+func reconciledAccount(ctx context.Context, db *mongo.Database, user auth.Claims, faID string) (*FinancialAccount, error) {
+
+	fa, err := RetrieveFinancialAccount(ctx, db.Collection(FinancialAccountCollection), faID)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		isAdmin = user.HasRole(auth.RoleAdmin)
+		isOwner = fa.MangerID == user.Subject
+		canEdit = isAdmin || isOwner
+	)
+
+	if !canEdit {
+		return nil, apierror.ErrForbidden
+	}
+
+	return fa, nil
+}
+
+// findReconciliation finds the reconciliation identified by recID of the financial account identified by faID.
+func findReconciliation(ctx context.Context, db *mongo.Database, faID, recID string) (*Reconciliation, error) {
+
+	id, err := primitive.ObjectIDFromHex(recID)
+	if err != nil {
+		return nil, apierror.ErrInvalidID
+	}
+
+	var rec Reconciliation
+	if err := db.Collection(ReconciliationCollection).FindOne(ctx, bson.M{"_id": id, "fin_acc_id": faID}).Decode(&rec); err != nil {
+		return nil, apierror.ErrNotFound
+	}
+
+	return &rec, nil
+}
+
+// balanceReconciliation sets the cleared balance of rec to the opening value of the account plus its cleared transactions,
+// and the difference to what is left to match the statement balance.
+func balanceReconciliation(ctx context.Context, db *mongo.Database, fa FinancialAccount, rec *Reconciliation) error {
+
+	faID := fa.ID.Hex()
+
+	net, err := netOf(ctx, db, fa, bson.M{"fin_acc_id": faID, "cleared_in": faID})
+	if err != nil {
+		return err
+	}
+
+	rec.ClearedBalance = accountValue(fa, net)
+	rec.Difference = rec.StatementBalance.Sub(rec.ClearedBalance)
+	rec.Difference.CurrencyID = fa.CurrencyID
+
+	return nil
+}
@@ -0,0 +1,463 @@
+package budget
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
+	"github.com/dapperAuteur/dashboard-go-api/internal/utility"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MaxPreview is the most occurrences of a recurring transaction that can be previewed at once.
+const MaxPreview = 100
+
+// errAlreadyPosted is used when another process posted an occurrence of a recurring transaction first.
+var errAlreadyPosted = errors.New("occurrence already posted")
+
+// ListRecurringTransactions gets the recurring transactions from the db.
+// Results are returned one page at a time.
+func ListRecurringTransactions(ctx context.Context, db *mongo.Collection, page database.Page) ([]RecurringTransaction, *database.PageInfo, error) {
+
+	list := []RecurringTransaction{}
+
+	info, err := database.FindPage(ctx, db, bson.M{}, page, &list)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "retrieving recurring transaction list")
+	}
+
+	return list, info, nil
+}
+
+// RetrieveRecurringTransaction finds the recurring transaction identified by a given _id.
+func RetrieveRecurringTransaction(ctx context.Context, db *mongo.Collection, _id string) (*RecurringTransaction, error) {
+
+	var rt RecurringTransaction
+
+	id, err := primitive.ObjectIDFromHex(_id)
+	if err != nil {
+		return nil, apierror.ErrInvalidID
+	}
+
+	if err := db.FindOne(ctx, bson.M{"_id": id}).Decode(&rt); err != nil {
+		return nil, apierror.ErrNotFound
+	}
+
+	return &rt, nil
+}
+
+// CreateRecurringTransaction stores a template for a transaction that repeats.
+// Occurrences from the start date that are already due are posted by the next run of PostDueTransactions.
+func CreateRecurringTransaction(ctx context.Context, db *mongo.Database, user auth.Claims, newRT NewRecurringTransaction, now time.Time) (*RecurringTransaction, error) {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return nil, apierror.ErrForbidden
+	}
+
+	verr := apierror.ValidationError{}
+
+	if _, err := ParseRule(newRT.Rule); err != nil {
+		verr.Add("rule", err.Error())
+	}
+
+	start, err := parseRecurringDate(newRT.Start)
+	if err != nil {
+		verr.Add("start", err.Error())
+	}
+
+	var end *time.Time
+	if newRT.End != nil && *newRT.End != "" {
+		t, err := parseRecurringDate(*newRT.End)
+		if err != nil {
+			verr.Add("end", err.Error())
+		} else if t.Before(start) {
+			verr.Add("end", "end must NOT be before start")
+		}
+		end = &t
+	}
+
+	var finAcctIDs []string
+	if newRT.FinancialAccountID != nil {
+		finAcctIDs = utility.RemoveDuplicateStringValues(*newRT.FinancialAccountID)
+	}
+
+	rt := RecurringTransaction{
+		ID:                 primitive.NewObjectID(),
+		Rule:               newRT.Rule,
+		Start:              start,
+		End:                end,
+		BudgetID:           newRT.BudgetID,
+		CurrencyID:         newRT.CurrencyID,
+		FinancialAccountID: finAcctIDs,
+		TransactionEvent:   newRT.TransactionEvent,
+		TransactionCredit:  Money{Amount: newRT.TransactionCredit.Amount, CurrencyID: newRT.CurrencyID},
+		TransactionDebit:   Money{Amount: newRT.TransactionDebit.Amount, CurrencyID: newRT.CurrencyID},
+		VendorID:           newRT.VendorID,
+		CreatedAt:          now.UTC(),
+		UpdatedAt:          now.UTC(),
+	}
+
+	if err := checkReferences(ctx, db, rt.transaction(start, now), &verr); err != nil {
+		return nil, err
+	}
+
+	if err := verr.Err(); err != nil {
+		return nil, err
+	}
+
+	rtResult, err := db.Collection(RecurringCollection).InsertOne(ctx, rt)
+	if err != nil {
+		return nil, errors.Wrapf(err, "inserting recurring transaction : %v", rt)
+	}
+
+	fmt.Println("rtResult : ", rtResult)
+
+	return &rt, nil
+}
+
+// UpdateOneRecurringTransaction modifies a recurring transaction.
+// It will error if the specified _id is invalid or does NOT reference an existing recurring transaction.
+// Transactions already posted are NOT changed.
+func UpdateOneRecurringTransaction(ctx context.Context, db *mongo.Database, user auth.Claims, rtID string, updateRT UpdateRecurringTransaction, now time.Time) error {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return apierror.ErrForbidden
+	}
+
+	rtCollection := db.Collection(RecurringCollection)
+
+	foundRT, err := RetrieveRecurringTransaction(ctx, rtCollection, rtID)
+	if err != nil {
+		return err
+	}
+
+	rt := RecurringTransaction{}
+	refs := Transaction{}
+	unset := bson.M{}
+
+	verr := apierror.ValidationError{}
+
+	if updateRT.Rule != nil {
+		if _, err := ParseRule(*updateRT.Rule); err != nil {
+			verr.Add("rule", err.Error())
+		}
+		rt.Rule = *updateRT.Rule
+	}
+
+	if updateRT.End != nil {
+		if *updateRT.End == "" {
+			unset["end"] = ""
+		} else {
+			end, err := parseRecurringDate(*updateRT.End)
+			if err != nil {
+				verr.Add("end", err.Error())
+			} else if end.Before(foundRT.Start) {
+				verr.Add("end", "end must NOT be before start")
+			}
+			rt.End = &end
+		}
+	}
+
+	if updateRT.BudgetID != nil {
+		rt.BudgetID = *updateRT.BudgetID
+		refs.BudgetID = rt.BudgetID
+	}
+
+	currencyID := foundRT.CurrencyID
+	if updateRT.CurrencyID != nil {
+		currencyID = *updateRT.CurrencyID
+		rt.CurrencyID = currencyID
+		refs.CurrencyID = currencyID
+		rt.TransactionCredit = Money{Amount: foundRT.TransactionCredit.Amount, CurrencyID: currencyID}
+		rt.TransactionDebit = Money{Amount: foundRT.TransactionDebit.Amount, CurrencyID: currencyID}
+	}
+
+	if updateRT.FinancialAccountID != nil {
+		rt.FinancialAccountID = utility.RemoveDuplicateStringValues(*updateRT.FinancialAccountID)
+		refs.FinancialAccountID = rt.FinancialAccountID
+		if len(rt.FinancialAccountID) == 0 {
+			unset["fin_acc_id"] = ""
+		}
+	}
+
+	if updateRT.TransactionEvent != nil {
+		rt.TransactionEvent = *updateRT.TransactionEvent
+	}
+
+	if updateRT.TransactionCredit != nil {
+		rt.TransactionCredit = Money{Amount: updateRT.TransactionCredit.Amount, CurrencyID: currencyID}
+	}
+
+	if updateRT.TransactionDebit != nil {
+		rt.TransactionDebit = Money{Amount: updateRT.TransactionDebit.Amount, CurrencyID: currencyID}
+	}
+
+	if updateRT.VendorID != nil {
+		rt.VendorID = *updateRT.VendorID
+		refs.VendorID = rt.VendorID
+	}
+
+	if updateRT.FinancialAccountID != nil || updateRT.CurrencyID != nil {
+		// the accounts and the currency are checked together, as they must match
+		refs.CurrencyID = currencyID
+		refs.FinancialAccountID = foundRT.FinancialAccountID
+		if updateRT.FinancialAccountID != nil {
+			refs.FinancialAccountID = rt.FinancialAccountID
+		}
+	}
+
+	if err := checkReferences(ctx, db, refs, &verr); err != nil {
+		return err
+	}
+
+	if err := verr.Err(); err != nil {
+		return err
+	}
+
+	rt.UpdatedAt = now.UTC()
+
+	update := bson.M{"$set": rt}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	rtResult, err := rtCollection.UpdateOne(ctx, bson.M{"_id": foundRT.ID}, update)
+	if err != nil {
+		return errors.Wrap(err, "updating recurring transaction")
+	}
+
+	fmt.Printf("rtResult updated %v : \n", rtResult)
+
+	return nil
+}
+
+// DeleteRecurringTransaction removes the recurring transaction identified by a given _id.
+// Transactions it already posted are kept.
+func DeleteRecurringTransaction(ctx context.Context, db *mongo.Collection, user auth.Claims, rtID string) error {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return apierror.ErrForbidden
+	}
+
+	rtObjectID, err := primitive.ObjectIDFromHex(rtID)
+	if err != nil {
+		return apierror.ErrInvalidID
+	}
+
+	result, err := db.DeleteOne(ctx, bson.M{"_id": rtObjectID})
+	if err != nil {
+		return errors.Wrapf(err, "deleting recurring transaction %s", rtID)
+	}
+
+	if result.DeletedCount == 0 {
+		return apierror.ErrNotFound
+	}
+
+	return nil
+}
+
+// PreviewRecurringTransaction returns the next n transactions the recurring transaction will post, without storing them.
+// n must be between 1 and MaxPreview.
+func PreviewRecurringTransaction(ctx context.Context, db *mongo.Collection, rtID string, n int, now time.Time) ([]Transaction, error) {
+
+	if n < 1 || n > MaxPreview {
+		return nil, &apierror.ValidationError{Fields: []apierror.FieldError{{Field: "count", Error: fmt.Sprintf("count must be between 1 and %d", MaxPreview)}}}
+	}
+
+	rt, err := RetrieveRecurringTransaction(ctx, db, rtID)
+	if err != nil {
+		return nil, err
+	}
+
+	rule, err := rt.rule()
+	if err != nil {
+		return nil, err
+	}
+
+	list := []Transaction{}
+	for _, d := range rule.Next(rt.Start, rt.postedThrough(), n) {
+		list = append(list, rt.transaction(d, now))
+	}
+
+	return list, nil
+}
+
+// PostDueTransactions posts a transaction for every occurrence of every recurring transaction that is due by now.
+// It can be run any number of times, from any number of processes: each occurrence is posted once.
+// A recurring transaction that fails is skipped until the next run. It returns the number of transactions posted.
+func PostDueTransactions(ctx context.Context, db *mongo.Database, now time.Time) (int, error) {
+
+	today := day(now)
+
+	cursor, err := db.Collection(RecurringCollection).Find(ctx, bson.M{"start": bson.M{"$lte": today}})
+	if err != nil {
+		return 0, errors.Wrap(err, "getting cursor from recurring transaction collection")
+	}
+
+	var templates []RecurringTransaction
+	if err := cursor.All(ctx, &templates); err != nil {
+		return 0, errors.Wrap(err, "retrieving recurring transactions")
+	}
+
+	posted := 0
+	var failed error
+
+	for _, rt := range templates {
+		n, err := postDue(ctx, db, rt, today, now)
+		posted += n
+		if err != nil && failed == nil {
+			failed = errors.Wrapf(err, "posting recurring transaction %s", rt.ID.Hex())
+		}
+	}
+
+	return posted, failed
+}
+
+// postDue posts the occurrences of one recurring transaction that are due by today, in order.
+func postDue(ctx context.Context, db *mongo.Database, rt RecurringTransaction, today, now time.Time) (int, error) {
+
+	rule, err := rt.rule()
+	if err != nil {
+		return 0, err
+	}
+
+	dates := rule.Between(rt.Start, rt.postedThrough().AddDate(0, 0, 1), today)
+	if len(dates) == 0 {
+		return 0, nil
+	}
+
+	// References may have been deleted since the template was saved.
+	verr := apierror.ValidationError{}
+	if err := checkReferences(ctx, db, rt.transaction(dates[0], now), &verr); err != nil {
+		return 0, err
+	}
+	if err := verr.Err(); err != nil {
+		return 0, err
+	}
+
+	posted := 0
+	prev := rt.LastPosted
+
+	for _, d := range dates {
+
+		err := withTransaction(ctx, db, func(sc mongo.SessionContext) error {
+			return postOccurrence(sc, db, rt, prev, d, now)
+		})
+		if err == errAlreadyPosted {
+			break
+		}
+		if err != nil {
+			return posted, err
+		}
+
+		posted++
+		d := d
+		prev = &d
+	}
+
+	if posted > 0 {
+		alertBudgets(ctx, db, now, rt.transaction(dates[0], now))
+	}
+
+	return posted, nil
+}
+
+// postOccurrence posts the transaction of one occurrence of a recurring transaction.
+// The template is claimed first by moving last_posted from prev to the occurrence, so
+// two processes can NOT post the same occurrence. It must be called inside a MongoDB transaction.
+func postOccurrence(sc mongo.SessionContext, db *mongo.Database, rt RecurringTransaction, prev *time.Time, occurrence, now time.Time) error {
+
+	var lastPosted interface{}
+	if prev != nil {
+		lastPosted = *prev
+	}
+
+	claim := bson.M{"$set": bson.M{"last_posted": occurrence, "updated_at": now.UTC()}}
+
+	result, err := db.Collection(RecurringCollection).UpdateOne(sc, bson.M{"_id": rt.ID, "last_posted": lastPosted}, claim)
+	if err != nil {
+		return errors.Wrap(err, "claiming recurring transaction")
+	}
+
+	if result.MatchedCount == 0 {
+		return errAlreadyPosted
+	}
+
+	// Transactions posted before last_posted was stored must NOT be posted again.
+	count, err := db.Collection(TransactionCollection).CountDocuments(sc, bson.M{"recurring_id": rt.ID.Hex(), "occurrence": occurrence})
+	if err != nil {
+		return errors.Wrap(err, "looking for posted occurrence")
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	return insertTransaction(sc, db, rt.transaction(occurrence, now), now)
+}
+
+// rule reads the recurrence rule of the template. The end of the template ends the rule.
+func (rt RecurringTransaction) rule() (RecurrenceRule, error) {
+
+	rule, err := ParseRule(rt.Rule)
+	if err != nil {
+		return rule, err
+	}
+
+	if rt.End != nil && (rule.Until.IsZero() || rt.End.Before(rule.Until)) {
+		rule.Until = *rt.End
+	}
+
+	return rule, nil
+}
+
+// postedThrough is the date of the latest occurrence posted, or the day before the start when none was.
+func (rt RecurringTransaction) postedThrough() time.Time {
+
+	if rt.LastPosted != nil {
+		return *rt.LastPosted
+	}
+
+	return day(rt.Start).AddDate(0, 0, -1)
+}
+
+// transaction builds the transaction the template posts for an occurrence.
+func (rt RecurringTransaction) transaction(occurrence, now time.Time) Transaction {
+	return Transaction{
+		ID:                 primitive.NewObjectID(),
+		BudgetID:           rt.BudgetID,
+		CurrencyID:         rt.CurrencyID,
+		FinancialAccountID: rt.FinancialAccountID,
+		Occurrence:         occurrence,
+		TransactionEvent:   rt.TransactionEvent,
+		TransactionCredit:  rt.TransactionCredit,
+		TransactionDebit:   rt.TransactionDebit,
+		VendorID:           rt.VendorID,
+		RecurringID:        rt.ID.Hex(),
+		CreatedAt:          now.UTC(),
+		UpdatedAt:          now.UTC(),
+	}
+}
+
+// parseRecurringDate reads the start or end date of a recurring transaction. Any time of day is dropped.
+func parseRecurringDate(value string) (time.Time, error) {
+
+	t, err := ParseOccurrence(value, 0)
+	if err != nil {
+		return time.Time{}, errors.New("date must be YYYY-MM-DD")
+	}
+
+	return day(t), nil
+}
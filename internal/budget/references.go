@@ -0,0 +1,435 @@
+package budget
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxDependents is the most _ids of dependent transactions listed when a delete is blocked.
+const maxDependents = 20
+
+// DeleteRule says what happens to the transactions that refer to a document being deleted.
+// Without a rule a document that is still referenced is NOT deleted.
+type DeleteRule struct {
+	Cascade    bool   // delete the transactions too
+	ReassignTo string // _id of a document of the same kind the transactions are moved to
+}
+
+// reference describes a field of a transaction that holds the _id of another document.
+type reference struct {
+	field      string
+	collection string
+	name       string // what the document is called in error messages
+}
+
+// Fields of a transaction that refer to other documents.
+var (
+	budgetRef      = reference{"budget_id", BudgetCollection, "budget"}
+	splitRef       = reference{"splits.budget_id", BudgetCollection, "budget"}
+	currencyRef    = reference{"currency_id", CurrencyCollection, "currency"}
+	vendorRef      = reference{"vendor_id", VendorCollection, "vendor"}
+	accountRef     = reference{"fin_acc_id", FinancialAccountCollection, "financial account"}
+	participantRef = reference{"participant_id", ParticipantCollection, "participant"}
+	paidByRef      = reference{"paid_by", ParticipantCollection, "participant"}
+	shareRef       = reference{"shares.participant_id", ParticipantCollection, "participant"}
+)
+
+// checkReferences records a validation problem for every reference of the transaction to a document that does NOT exist,
+// and for every financial account in another currency than the transaction. Empty references are NOT checked.
+func checkReferences(ctx context.Context, db *mongo.Database, tranx Transaction, verr *apierror.ValidationError) error {
+
+	refs := []struct {
+		reference
+		ids []string
+	}{
+		{budgetRef, []string{tranx.BudgetID}},
+		{currencyRef, []string{tranx.CurrencyID}},
+		{vendorRef, []string{tranx.VendorID}},
+		{accountRef, tranx.FinancialAccountID},
+		{participantRef, tranx.ParticipantID},
+		{splitRef, splitBudgetIDs(tranx)},
+		{paidByRef, []string{tranx.PaidBy}},
+		{shareRef, shareParticipantIDs(tranx)},
+	}
+
+	for _, ref := range refs {
+
+		missing, err := missingIDs(ctx, db, ref.collection, ref.ids)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range missing {
+			verr.Add(ref.field, fmt.Sprintf("%q does NOT reference an existing %s", id, ref.name))
+		}
+	}
+
+	return checkAccountCurrencies(ctx, db, tranx, verr)
+}
+
+// checkAccountCurrencies records a validation problem for every financial account of the transaction in another currency,
+// as its amounts are added to the balance of each account as they are.
+func checkAccountCurrencies(ctx context.Context, db *mongo.Database, tranx Transaction, verr *apierror.ValidationError) error {
+
+	if tranx.CurrencyID == "" || len(tranx.FinancialAccountID) == 0 {
+		return nil
+	}
+
+	accounts, err := retrieveAccounts(ctx, db, tranx.FinancialAccountID)
+	if err != nil {
+		return err
+	}
+
+	for _, fa := range currencyMismatches(tranx.CurrencyID, accounts) {
+		verr.Add(accountRef.field, fmt.Sprintf("%q is in currency %q, NOT %q", fa.ID.Hex(), fa.CurrencyID, tranx.CurrencyID))
+	}
+
+	return nil
+}
+
+// currencyMismatches returns the accounts in another currency than the one identified by currencyID.
+// Accounts without a currency fit any.
+func currencyMismatches(currencyID string, accounts []FinancialAccount) []FinancialAccount {
+
+	var mismatches []FinancialAccount
+	for _, fa := range accounts {
+		if fa.CurrencyID != "" && fa.CurrencyID != currencyID {
+			mismatches = append(mismatches, fa)
+		}
+	}
+
+	return mismatches
+}
+
+// retrieveAccounts finds the financial accounts identified by accountIDs. IDs that do NOT reference an account are skipped.
+func retrieveAccounts(ctx context.Context, db *mongo.Database, accountIDs []string) ([]FinancialAccount, error) {
+
+	var ids []primitive.ObjectID
+	for _, accountID := range accountIDs {
+		if id, err := primitive.ObjectIDFromHex(accountID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	cursor, err := db.Collection(FinancialAccountCollection).Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cursor from financial account collection")
+	}
+
+	var accounts []FinancialAccount
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, errors.Wrap(err, "retrieving financial accounts")
+	}
+
+	return accounts, nil
+}
+
+// missingIDs returns the ids that are NOT the _id of a document in the collection.
+// Empty ids are ignored.
+func missingIDs(ctx context.Context, db *mongo.Database, collection string, ids []string) ([]string, error) {
+
+	var objectIDs []primitive.ObjectID
+	var missing []string
+
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			missing = append(missing, id)
+			continue
+		}
+		objectIDs = append(objectIDs, objectID)
+	}
+
+	if len(objectIDs) == 0 {
+		return missing, nil
+	}
+
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+
+	cursor, err := db.Collection(collection).Find(ctx, bson.M{"_id": bson.M{"$in": objectIDs}}, opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting cursor from %s collection", collection)
+	}
+
+	var found []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, errors.Wrapf(err, "retrieving %s", collection)
+	}
+
+	exists := make(map[primitive.ObjectID]bool, len(found))
+	for _, f := range found {
+		exists[f.ID] = true
+	}
+
+	for _, objectID := range objectIDs {
+		if !exists[objectID] {
+			missing = append(missing, objectID.Hex())
+		}
+	}
+
+	return missing, nil
+}
+
+// resolveDependents applies the delete rule to the transactions that refer to the document being deleted.
+// Without a rule it returns a ConflictError listing the transactions, if there are any.
+// It must be called inside the MongoDB transaction that deletes the document.
+func resolveDependents(sc mongo.SessionContext, db *mongo.Database, ref reference, id string, rule DeleteRule, now time.Time) error {
+
+	tranxCollection := db.Collection(TransactionCollection)
+	filter := ref.filter(id)
+
+	if err := rule.check(ref, id); err != nil {
+		return err
+	}
+
+	switch {
+	case rule.ReassignTo != "":
+		missing, err := missingIDs(sc, db, ref.collection, []string{rule.ReassignTo})
+		if err != nil {
+			return err
+		}
+		if len(missing) > 0 {
+			return &apierror.ValidationError{Fields: []apierror.FieldError{{Field: "reassign_to", Error: fmt.Sprintf("%q does NOT reference an existing %s", rule.ReassignTo, ref.name)}}}
+		}
+
+		return reassignTransactions(sc, db, ref, id, rule.ReassignTo, now)
+
+	case rule.Cascade:
+		return deleteTransactions(sc, db, filter, now)
+	}
+
+	count, err := tranxCollection.CountDocuments(sc, filter)
+	if err != nil {
+		return errors.Wrap(err, "counting dependent transactions")
+	}
+
+	if count == 0 {
+		return nil
+	}
+
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(maxDependents)
+
+	cursor, err := tranxCollection.Find(sc, filter, opts)
+	if err != nil {
+		return errors.Wrap(err, "getting cursor from transaction collection")
+	}
+
+	var dependents []Transaction
+	if err := cursor.All(sc, &dependents); err != nil {
+		return errors.Wrap(err, "retrieving dependent transactions")
+	}
+
+	cerr := apierror.ConflictError{Collection: TransactionCollection, Count: count}
+	for _, tranx := range dependents {
+		cerr.IDs = append(cerr.IDs, tranx.ID.Hex())
+	}
+
+	return &cerr
+}
+
+// check returns a ValidationError when the rule can NOT be applied to the deletion of the document identified by id,
+// whatever the documents that are stored.
+func (rule DeleteRule) check(ref reference, id string) error {
+
+	switch {
+	case rule.Cascade && rule.ReassignTo != "":
+		return &apierror.ValidationError{Fields: []apierror.FieldError{{Field: "reassign_to", Error: "can NOT be combined with cascade"}}}
+	case rule.ReassignTo != "" && rule.ReassignTo == id:
+		return &apierror.ValidationError{Fields: []apierror.FieldError{{Field: "reassign_to", Error: fmt.Sprintf("must be another %s", ref.name)}}}
+	}
+
+	return nil
+}
+
+// filter matches the transactions that refer to the document identified by id.
+// A budget is also referred to by the splits of a transaction.
+func (ref reference) filter(id string) bson.M {
+
+	if ref == budgetRef {
+		return bson.M{"$or": []bson.M{{ref.field: id}, {splitRef.field: id}}}
+	}
+
+	// The payer and the shares of a transaction are always among its participants, see withShareParticipants.
+
+	return bson.M{ref.field: id}
+}
+
+// reassignTransactions moves the transactions that refer to one document to another of the same kind.
+// Amounts are kept as they are when the currency changes.
+// Transactions moved to another financial account change its balance.
+// Nothing is moved and apierror.ErrLocked is returned when any of the transactions is reconciled.
+func reassignTransactions(sc mongo.SessionContext, db *mongo.Database, ref reference, from, to string, now time.Time) error {
+
+	if err := checkUnlockedDependents(sc, db, ref.filter(from)); err != nil {
+		return err
+	}
+
+	tranxCollection := db.Collection(TransactionCollection)
+	filter := bson.M{ref.field: from}
+
+	switch ref {
+	case accountRef:
+		// fin_acc_id holds a set of accounts, so add the new account before removing the old one.
+		if _, err := tranxCollection.UpdateMany(sc, filter, bson.M{"$addToSet": bson.M{ref.field: to}}); err != nil {
+			return errors.Wrap(err, "adding financial account to transactions")
+		}
+
+		update := bson.M{"$pull": bson.M{ref.field: from}, "$set": bson.M{"updated_at": now}}
+		if _, err := tranxCollection.UpdateMany(sc, filter, update); err != nil {
+			return errors.Wrap(err, "removing financial account from transactions")
+		}
+
+		toObjectID, err := primitive.ObjectIDFromHex(to)
+		if err != nil {
+			return apierror.ErrInvalidID
+		}
+
+		return recomputeBalance(sc, db, toObjectID, now)
+
+	case budgetRef:
+		opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"s.budget_id": from}}})
+		update := bson.M{"$set": bson.M{"splits.$[s].budget_id": to, "updated_at": now}}
+		if _, err := tranxCollection.UpdateMany(sc, bson.M{splitRef.field: from}, update, opts); err != nil {
+			return errors.Wrap(err, "reassigning splits of transactions")
+		}
+
+	case participantRef:
+		// participant_id holds a set of participants like fin_acc_id, and the payer and shares refer to them too.
+		if _, err := tranxCollection.UpdateMany(sc, filter, bson.M{"$addToSet": bson.M{ref.field: to}}); err != nil {
+			return errors.Wrap(err, "adding participant to transactions")
+		}
+
+		update := bson.M{"$pull": bson.M{ref.field: from}, "$set": bson.M{"updated_at": now}}
+		if _, err := tranxCollection.UpdateMany(sc, filter, update); err != nil {
+			return errors.Wrap(err, "removing participant from transactions")
+		}
+
+		if _, err := tranxCollection.UpdateMany(sc, bson.M{paidByRef.field: from}, bson.M{"$set": bson.M{paidByRef.field: to}}); err != nil {
+			return errors.Wrap(err, "reassigning payer of transactions")
+		}
+
+		opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"s.participant_id": from}}})
+		if _, err := tranxCollection.UpdateMany(sc, bson.M{shareRef.field: from}, bson.M{"$set": bson.M{"shares.$[s].participant_id": to}}, opts); err != nil {
+			return errors.Wrap(err, "reassigning shares of transactions")
+		}
+
+		return nil
+
+	case currencyRef:
+		// The amounts carry the currency too, unless they are still stored as plain numbers.
+		for _, amount := range []string{"tranx_credit", "tranx_debit"} {
+			amountFilter := bson.M{ref.field: from, amount: bson.M{"$type": "object"}}
+			if _, err := tranxCollection.UpdateMany(sc, amountFilter, bson.M{"$set": bson.M{amount + ".currency_id": to}}); err != nil {
+				return errors.Wrapf(err, "restating %s of transactions", amount)
+			}
+		}
+
+		splitFilter := bson.M{ref.field: from, "splits.0": bson.M{"$exists": true}}
+		if _, err := tranxCollection.UpdateMany(sc, splitFilter, bson.M{"$set": bson.M{"splits.$[].amount.currency_id": to}}); err != nil {
+			return errors.Wrap(err, "restating splits of transactions")
+		}
+	}
+
+	update := bson.M{"$set": bson.M{ref.field: to, "updated_at": now}}
+	if _, err := tranxCollection.UpdateMany(sc, filter, update); err != nil {
+		return errors.Wrapf(err, "reassigning %s of transactions", ref.field)
+	}
+
+	return nil
+}
+
+// deleteTransactions removes the transactions that fit the filter, reversing their effect on their financial accounts.
+// The other transaction of a transfer is removed with it.
+// Nothing is removed and apierror.ErrLocked is returned when any of the transactions is reconciled.
+func deleteTransactions(sc mongo.SessionContext, db *mongo.Database, filter bson.M, now time.Time) error {
+
+	tranxCollection := db.Collection(TransactionCollection)
+
+	transferIDs, err := tranxCollection.Distinct(sc, "transfer_id", filter)
+	if err != nil {
+		return errors.Wrap(err, "finding transfers of transactions")
+	}
+
+	var others []primitive.ObjectID
+	for _, v := range transferIDs {
+		if id, ok := v.(string); ok {
+			if objectID, err := primitive.ObjectIDFromHex(id); err == nil {
+				others = append(others, objectID)
+			}
+		}
+	}
+
+	if len(others) > 0 {
+		filter = bson.M{"$or": []bson.M{filter, {"_id": bson.M{"$in": others}}}}
+	}
+
+	if err := checkUnlockedDependents(sc, db, filter); err != nil {
+		return err
+	}
+
+	cursor, err := tranxCollection.Find(sc, filter)
+	if err != nil {
+		return errors.Wrap(err, "getting cursor from transaction collection")
+	}
+	defer cursor.Close(sc)
+
+	for cursor.Next(sc) {
+
+		var tranx Transaction
+		if err := cursor.Decode(&tranx); err != nil {
+			return errors.Wrap(err, "decoding transaction")
+		}
+
+		if err := applyBalance(sc, db, tranx.FinancialAccountID, balanceDelta(tranx).Neg(), now); err != nil {
+			return err
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return errors.Wrap(err, "reading transactions")
+	}
+
+	result, err := tranxCollection.DeleteMany(sc, filter)
+	if err != nil {
+		return errors.Wrap(err, "deleting transactions")
+	}
+
+	fmt.Println("dependent transactions deleted : ", result.DeletedCount)
+
+	return nil
+}
+
+// checkUnlockedDependents returns apierror.ErrLocked when any transaction that fits the filter is reconciled.
+func checkUnlockedDependents(sc mongo.SessionContext, db *mongo.Database, filter bson.M) error {
+
+	locked := true
+	query := bson.M{"$and": []bson.M{filter, FilterTransaction{Locked: &locked}.Query()}}
+
+	count, err := db.Collection(TransactionCollection).CountDocuments(sc, query, options.Count().SetLimit(1))
+	if err != nil {
+		return errors.Wrap(err, "counting reconciled transactions")
+	}
+
+	if count > 0 {
+		return apierror.ErrLocked
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package budget_test
+
+import (
+	"testing"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/google/go-cmp/cmp"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestReferenceFilter(t *testing.T) {
+	id := "5f3e189bd95d06627dc8e932"
+
+	tests := []struct {
+		field string
+		want  bson.M
+	}{
+		{"budget_id", bson.M{"$or": []bson.M{{"budget_id": id}, {"splits.budget_id": id}}}},
+		{"currency_id", bson.M{"currency_id": id}},
+		{"vendor_id", bson.M{"vendor_id": id}},
+		{"fin_acc_id", bson.M{"fin_acc_id": id}},
+		{"participant_id", bson.M{"participant_id": id}},
+	}
+
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.want, budget.ReferenceFilter(tt.field, id)); diff != "" {
+			t.Fatalf("%s: filter did not match expected. Diff:\n%s", tt.field, diff)
+		}
+	}
+}
+
+func TestCheckDeleteRule(t *testing.T) {
+	id, other := "5f3e18f8d95d06627dc8e990", "5f3e18f8d95d06627dc8e94e"
+
+	tests := []struct {
+		name string
+		rule budget.DeleteRule
+		want []apierror.FieldError
+	}{
+		{"no rule", budget.DeleteRule{}, nil},
+		{"cascade", budget.DeleteRule{Cascade: true}, nil},
+		{"reassign", budget.DeleteRule{ReassignTo: other}, nil},
+		{"cascade and reassign", budget.DeleteRule{Cascade: true, ReassignTo: other}, []apierror.FieldError{{Field: "reassign_to", Error: "can NOT be combined with cascade"}}},
+		{"reassign to itself", budget.DeleteRule{ReassignTo: id}, []apierror.FieldError{{Field: "reassign_to", Error: "must be another vendor"}}},
+	}
+
+	for _, tt := range tests {
+		var got []apierror.FieldError
+		if err := budget.CheckDeleteRule("vendor_id", id, tt.rule); err != nil {
+			verr, ok := err.(*apierror.ValidationError)
+			if !ok {
+				t.Fatalf("%s: expected a validation error, got %v", tt.name, err)
+			}
+			got = verr.Fields
+		}
+
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Fatalf("%s: validation problems did not match expected. Diff:\n%s", tt.name, diff)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package budget
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Frequencies of a RecurrenceRule.
+const (
+	FreqDaily   = "DAILY"
+	FreqWeekly  = "WEEKLY"
+	FreqMonthly = "MONTHLY"
+	FreqYearly  = "YEARLY"
+)
+
+// ErrInvalidRule is used when a recurrence rule can NOT be read.
+var ErrInvalidRule = errors.New("recurrence rule is NOT in its proper form, e.g. \"FREQ=MONTHLY;BYMONTHDAY=1\"")
+
+// weekdays maps the RRULE names of the days of the week.
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// RecurrenceRule is the part of an iCalendar RRULE (RFC 5545) needed to repeat transactions.
+// FREQ, INTERVAL, COUNT, UNTIL, BYDAY (weekly rules) and BYMONTHDAY (monthly rules) are understood.
+// Unlike RFC 5545 a day that does NOT exist in a month, e.g. the 31st, falls on the last day of the month instead of being skipped.
+type RecurrenceRule struct {
+	Freq       string
+	Interval   int
+	Count      int       // most occurrences, 0 for no limit
+	Until      time.Time // last possible date, zero for no limit
+	ByDay      []time.Weekday
+	ByMonthDay []int // negative days count back from the end of the month, -1 is the last day
+}
+
+// ParseRule reads a rule such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=FR". A leading "RRULE:" is allowed.
+func ParseRule(value string) (RecurrenceRule, error) {
+
+	rule := RecurrenceRule{Interval: 1}
+
+	value = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value)), "RRULE:")
+	if value == "" {
+		return rule, ErrInvalidRule
+	}
+
+	for _, part := range strings.Split(value, ";") {
+
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return rule, errors.Wrapf(ErrInvalidRule, "%q is NOT a NAME=VALUE pair", part)
+		}
+
+		name, v := kv[0], kv[1]
+
+		switch name {
+		case "FREQ":
+			switch v {
+			case FreqDaily, FreqWeekly, FreqMonthly, FreqYearly:
+				rule.Freq = v
+			default:
+				return rule, errors.Wrapf(ErrInvalidRule, "FREQ must be DAILY, WEEKLY, MONTHLY or YEARLY, got %q", v)
+			}
+
+		case "INTERVAL":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return rule, errors.Wrapf(ErrInvalidRule, "INTERVAL must be a positive number, got %q", v)
+			}
+			rule.Interval = n
+
+		case "COUNT":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return rule, errors.Wrapf(ErrInvalidRule, "COUNT must be a positive number, got %q", v)
+			}
+			rule.Count = n
+
+		case "UNTIL":
+			if len(v) < 8 {
+				return rule, errors.Wrapf(ErrInvalidRule, "UNTIL must be a date such as 20201231, got %q", v)
+			}
+			until, err := time.Parse("20060102", v[:8])
+			if err != nil {
+				return rule, errors.Wrapf(ErrInvalidRule, "UNTIL must be a date such as 20201231, got %q", v)
+			}
+			rule.Until = until
+
+		case "BYDAY":
+			for _, d := range strings.Split(v, ",") {
+				wd, ok := weekdays[d]
+				if !ok {
+					return rule, errors.Wrapf(ErrInvalidRule, "BYDAY must list days such as MO,FR, got %q", d)
+				}
+				rule.ByDay = append(rule.ByDay, wd)
+			}
+
+		case "BYMONTHDAY":
+			for _, d := range strings.Split(v, ",") {
+				n, err := strconv.Atoi(d)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return rule, errors.Wrapf(ErrInvalidRule, "BYMONTHDAY must list days from 1 to 31 or -31 to -1, got %q", d)
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, n)
+			}
+
+		default:
+			return rule, errors.Wrapf(ErrInvalidRule, "%s is NOT supported", name)
+		}
+	}
+
+	switch {
+	case rule.Freq == "":
+		return rule, errors.Wrap(ErrInvalidRule, "FREQ is required")
+	case rule.Count > 0 && !rule.Until.IsZero():
+		return rule, errors.Wrap(ErrInvalidRule, "COUNT and UNTIL can NOT be combined")
+	case len(rule.ByDay) > 0 && rule.Freq != FreqWeekly:
+		return rule, errors.Wrap(ErrInvalidRule, "BYDAY is only supported with FREQ=WEEKLY")
+	case len(rule.ByMonthDay) > 0 && rule.Freq != FreqMonthly:
+		return rule, errors.Wrap(ErrInvalidRule, "BYMONTHDAY is only supported with FREQ=MONTHLY")
+	}
+
+	return rule, nil
+}
+
+// Between returns the dates of the occurrences of the rule starting on start that fall within from and to, inclusive.
+func (r RecurrenceRule) Between(start, from, to time.Time) []time.Time {
+
+	from, to = day(from), day(to)
+
+	var dates []time.Time
+	r.each(start, func(d time.Time) bool {
+		if d.After(to) {
+			return false
+		}
+		if !d.Before(from) {
+			dates = append(dates, d)
+		}
+		return true
+	})
+
+	return dates
+}
+
+// Next returns the dates of the first n occurrences of the rule starting on start that fall after the date of after.
+func (r RecurrenceRule) Next(start, after time.Time, n int) []time.Time {
+
+	after = day(after)
+
+	var dates []time.Time
+	r.each(start, func(d time.Time) bool {
+		if len(dates) == n {
+			return false
+		}
+		if d.After(after) {
+			dates = append(dates, d)
+		}
+		return len(dates) < n
+	})
+
+	return dates
+}
+
+// each calls fn with the date of every occurrence in order, until fn returns false or the rule ends.
+// The first occurrence is the first date on or after start that fits the rule.
+func (r RecurrenceRule) each(start time.Time, fn func(time.Time) bool) {
+
+	start = day(start)
+
+	interval := r.Interval
+	if interval < 1 {
+		interval = 1
+	}
+
+	count := 0
+	for period := 0; ; period += interval {
+
+		dates := r.period(start, period)
+
+		for _, d := range dates {
+			if d.Before(start) {
+				continue
+			}
+			if !r.Until.IsZero() && d.After(day(r.Until)) {
+				return
+			}
+			if !fn(d) {
+				return
+			}
+			count++
+			if r.Count > 0 && count == r.Count {
+				return
+			}
+		}
+	}
+}
+
+// period returns the sorted dates the rule gives in the period that is n days, weeks, months or years after the one holding start.
+func (r RecurrenceRule) period(start time.Time, n int) []time.Time {
+
+	switch r.Freq {
+	case FreqDaily:
+		return []time.Time{start.AddDate(0, 0, n)}
+
+	case FreqWeekly:
+		if len(r.ByDay) == 0 {
+			return []time.Time{start.AddDate(0, 0, 7*n)}
+		}
+		// Weeks start on Monday, as they do by default in RFC 5545.
+		monday := start.AddDate(0, 0, -((int(start.Weekday())+6)%7)+7*n)
+		var dates []time.Time
+		for _, wd := range r.ByDay {
+			dates = append(dates, monday.AddDate(0, 0, (int(wd)+6)%7))
+		}
+		return sortDates(dates)
+
+	case FreqMonthly:
+		first := time.Date(start.Year(), start.Month()+time.Month(n), 1, 0, 0, 0, 0, time.UTC)
+		days := r.ByMonthDay
+		if len(days) == 0 {
+			days = []int{start.Day()}
+		}
+		var dates []time.Time
+		for _, d := range days {
+			dates = append(dates, monthDay(first, d))
+		}
+		return sortDates(dates)
+
+	default: // FreqYearly
+		first := time.Date(start.Year()+n, start.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return []time.Time{monthDay(first, start.Day())}
+	}
+}
+
+// monthDay returns a day of the month beginning on first. Negative days count back from the end of the month.
+// Days past the end of the month fall on its last day.
+func monthDay(first time.Time, d int) time.Time {
+
+	last := first.AddDate(0, 1, -1).Day()
+
+	if d < 0 {
+		d = last + d + 1
+	}
+
+	switch {
+	case d < 1:
+		d = 1
+	case d > last:
+		d = last
+	}
+
+	return first.AddDate(0, 0, d-1)
+}
+
+// sortDates sorts dates and drops duplicates, e.g. BYMONTHDAY=30,31 in February.
+func sortDates(dates []time.Time) []time.Time {
+
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+
+	unique := dates[:0]
+	for i, d := range dates {
+		if i == 0 || !d.Equal(dates[i-1]) {
+			unique = append(unique, d)
+		}
+	}
+
+	return unique
+}
+
+// day is midnight UTC of the date of t.
+func day(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
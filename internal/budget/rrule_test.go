@@ -0,0 +1,107 @@
+package budget_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/pkg/errors"
+)
+
+func date(t *testing.T, value string) time.Time {
+	t.Helper()
+
+	d, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		t.Fatalf("parsing date %q: %v", value, err)
+	}
+
+	return d
+}
+
+func formatDates(dates []time.Time) string {
+
+	s := make([]string, len(dates))
+	for i, d := range dates {
+		s[i] = d.Format("2006-01-02")
+	}
+
+	return strings.Join(s, " ")
+}
+
+func TestRecurrenceRuleBetween(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  string
+		start string
+		from  string
+		to    string
+		want  string
+	}{
+		{"monthly rent", "FREQ=MONTHLY", "2020-01-01", "2020-01-01", "2020-04-30", "2020-01-01 2020-02-01 2020-03-01 2020-04-01"},
+		{"end of month clamps", "FREQ=MONTHLY", "2020-01-31", "2020-01-01", "2020-04-30", "2020-01-31 2020-02-29 2020-03-31 2020-04-30"},
+		{"last day of month", "FREQ=MONTHLY;BYMONTHDAY=-1", "2021-01-15", "2021-01-01", "2021-03-31", "2021-01-31 2021-02-28 2021-03-31"},
+		{"twice a month", "FREQ=MONTHLY;BYMONTHDAY=15,1", "2020-01-10", "2020-01-01", "2020-02-29", "2020-01-15 2020-02-01 2020-02-15"},
+		{"every other friday", "FREQ=WEEKLY;INTERVAL=2;BYDAY=FR", "2020-01-03", "2020-01-01", "2020-02-15", "2020-01-03 2020-01-17 2020-01-31 2020-02-14"},
+		{"weekdays from a wednesday", "FREQ=WEEKLY;BYDAY=MO,WE,FR", "2020-01-08", "2020-01-01", "2020-01-13", "2020-01-08 2020-01-10 2020-01-13"},
+		{"daily with count", "FREQ=DAILY;COUNT=3", "2020-01-01", "2020-01-01", "2020-12-31", "2020-01-01 2020-01-02 2020-01-03"},
+		{"until is inclusive", "RRULE:FREQ=WEEKLY;UNTIL=20200115", "2020-01-01", "2020-01-01", "2020-12-31", "2020-01-01 2020-01-08 2020-01-15"},
+		{"window after start", "FREQ=YEARLY", "2016-02-29", "2019-01-01", "2020-12-31", "2019-02-28 2020-02-29"},
+		{"count is counted from start", "FREQ=MONTHLY;COUNT=3", "2020-01-05", "2020-03-01", "2020-12-31", "2020-03-05"},
+	}
+
+	for _, tt := range tests {
+		rule, err := budget.ParseRule(tt.rule)
+		if err != nil {
+			t.Fatalf("%s: parsing %q: %v", tt.name, tt.rule, err)
+		}
+
+		got := formatDates(rule.Between(date(t, tt.start), date(t, tt.from), date(t, tt.to)))
+		if got != tt.want {
+			t.Fatalf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestRecurrenceRuleNext(t *testing.T) {
+
+	rule, err := budget.ParseRule("FREQ=MONTHLY;BYMONTHDAY=1")
+	if err != nil {
+		t.Fatalf("parsing rule: %v", err)
+	}
+
+	got := formatDates(rule.Next(date(t, "2020-01-01"), date(t, "2020-03-01"), 3))
+	if want := "2020-04-01 2020-05-01 2020-06-01"; got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+
+	ended, err := budget.ParseRule("FREQ=MONTHLY;COUNT=2")
+	if err != nil {
+		t.Fatalf("parsing rule: %v", err)
+	}
+
+	if dates := ended.Next(date(t, "2020-01-01"), date(t, "2020-06-01"), 3); len(dates) != 0 {
+		t.Fatalf("expected no occurrences after the rule ended, got %s", formatDates(dates))
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	rules := []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=2;UNTIL=20200101",
+		"FREQ=MONTHLY;BYDAY=MO",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=WEEKLY;BYSETPOS=1",
+	}
+
+	for _, r := range rules {
+		if _, err := budget.ParseRule(r); errors.Cause(err) != budget.ErrInvalidRule {
+			t.Fatalf("%q: expected an invalid rule, got %v", r, err)
+		}
+	}
+}
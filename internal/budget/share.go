@@ -0,0 +1,346 @@
+package budget
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/utility"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ParticipantBalance is where one participant stands on the shared expenses of a settle-up, in one currency.
+type ParticipantBalance struct {
+	ParticipantID string `json:"participant_id"`
+	Name          string `json:"name,omitempty"`
+	Paid          Money  `json:"paid"`    // what the participant paid for everybody
+	Share         Money  `json:"share"`   // the participant's own share of what was paid
+	Balance       Money  `json:"balance"` // paid less share; positive when the participant is owed money
+}
+
+// Payment settles a debt between two participants.
+type Payment struct {
+	From   string `json:"from"` // _id of the participant who pays
+	To     string `json:"to"`   // _id of the participant who is paid
+	Amount Money  `json:"amount"`
+}
+
+// Settlement reports who owes whom for the shared expenses of a date range.
+// When a currency was requested every amount is converted into it, otherwise each currency is settled on its own.
+type Settlement struct {
+	CurrencyID string               `json:"currency_id,omitempty"`
+	Window     SummaryWindow        `json:"window"`
+	Balances   []ParticipantBalance `json:"balances"`
+	Payments   []Payment            `json:"payments"`
+}
+
+// checkShares records a validation problem for every way the shares of the transaction do NOT fit it.
+// A transaction without shares is NOT checked, its participants share it equally.
+func checkShares(tranx Transaction, verr *apierror.ValidationError) {
+
+	if len(tranx.Shares) == 0 {
+		return
+	}
+
+	if tranx.PaidBy == "" {
+		verr.Add("paid_by", "a transaction with shares needs a paid_by")
+	}
+
+	if !tranx.TransactionCredit.IsZero() && !tranx.TransactionDebit.IsZero() {
+		verr.Add("shares", "a transaction with both a credit and a debit can NOT be shared")
+		return
+	}
+
+	var sum Money
+	seen := map[string]bool{}
+	for i, s := range tranx.Shares {
+		if s.ParticipantID == "" {
+			verr.Add(fmt.Sprintf("shares[%d].participant_id", i), "participant_id is a required field")
+		} else if seen[s.ParticipantID] {
+			verr.Add(fmt.Sprintf("shares[%d].participant_id", i), fmt.Sprintf("participant %s has more than one share", s.ParticipantID))
+		}
+		seen[s.ParticipantID] = true
+		if s.Amount.Sign() <= 0 {
+			verr.Add(fmt.Sprintf("shares[%d].amount", i), "amount must be more than 0")
+		}
+		sum = sum.Add(s.Amount)
+	}
+
+	if total := tranxAmount(tranx); sum.Cmp(total) != 0 {
+		verr.Add("shares", fmt.Sprintf("shares add up to %s, NOT the transaction amount of %s", sum, total))
+	}
+}
+
+// Shares returns what each participant owes of a shared transaction.
+// Without explicit shares the amount is split equally among the participants, the first ones taking any cent left over.
+// A transaction nobody paid for is NOT shared.
+func Shares(tranx Transaction) []Share {
+
+	if tranx.PaidBy == "" {
+		return nil
+	}
+
+	if len(tranx.Shares) > 0 {
+		return tranx.Shares
+	}
+
+	participants := tranx.ParticipantID
+	if len(participants) == 0 {
+		participants = []string{tranx.PaidBy}
+	}
+
+	parts := tranxAmount(tranx).Split(len(participants))
+
+	shares := make([]Share, len(participants))
+	for i, id := range participants {
+		shares[i] = Share{ParticipantID: id, Amount: parts[i]}
+	}
+
+	return shares
+}
+
+// Settle works out the payments that bring every balance to zero, settling each currency on its own.
+// The largest debts are paid to the largest creditors first, which keeps the number of payments small.
+func Settle(balances []ParticipantBalance) []Payment {
+
+	type party struct {
+		id     string
+		amount Money
+	}
+
+	owing := map[string][]party{}
+	owed := map[string][]party{}
+	var currencies []string
+
+	for _, b := range balances {
+		c := b.Balance.CurrencyID
+		if _, ok := owing[c]; !ok {
+			currencies = append(currencies, c)
+			owing[c], owed[c] = []party{}, []party{}
+		}
+		switch b.Balance.Sign() {
+		case -1:
+			owing[c] = append(owing[c], party{b.ParticipantID, b.Balance.Neg()})
+		case 1:
+			owed[c] = append(owed[c], party{b.ParticipantID, b.Balance})
+		}
+	}
+
+	largestFirst := func(parties []party) {
+		sort.SliceStable(parties, func(i, j int) bool {
+			if cmp := parties[i].amount.Cmp(parties[j].amount); cmp != 0 {
+				return cmp > 0
+			}
+			return parties[i].id < parties[j].id
+		})
+	}
+
+	payments := []Payment{}
+
+	for _, c := range currencies {
+
+		from, to := owing[c], owed[c]
+		largestFirst(from)
+		largestFirst(to)
+
+		for i, j := 0, 0; i < len(from) && j < len(to); {
+
+			amount := from[i].amount
+			if to[j].amount.Cmp(amount) < 0 {
+				amount = to[j].amount
+			}
+
+			payments = append(payments, Payment{From: from[i].id, To: to[j].id, Amount: amount})
+
+			from[i].amount = from[i].amount.Sub(amount)
+			to[j].amount = to[j].amount.Sub(amount)
+
+			if from[i].amount.Sign() <= 0 {
+				i++
+			}
+			if to[j].amount.Sign() <= 0 {
+				j++
+			}
+		}
+	}
+
+	return payments
+}
+
+// SettleUp works out who owes whom for the shared expenses that occurred within the window.
+// A debit the payer covered is owed back by the others in proportion to their shares; a shared credit, e.g. a refund,
+// works the other way. Transfers are left out.
+func SettleUp(ctx context.Context, db *mongo.Database, window SummaryWindow, currencyID string) (*Settlement, error) {
+
+	filter := bson.M{
+		"paid_by":     bson.M{"$exists": true, "$ne": ""},
+		"transfer_id": bson.M{"$exists": false},
+	}
+	if r := dateRangeQuery(window.From, window.To); r != nil {
+		filter["occurrence"] = r
+	}
+
+	cursor, err := db.Collection(TransactionCollection).Find(ctx, filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cursor from transaction collection")
+	}
+
+	var tranxs []Transaction
+	if err := cursor.All(ctx, &tranxs); err != nil {
+		return nil, errors.Wrap(err, "retrieving shared transactions")
+	}
+
+	type balanceKey struct{ participantID, currencyID string }
+	sums := map[balanceKey]*ParticipantBalance{}
+
+	balance := func(participantID, currencyID string) *ParticipantBalance {
+		k := balanceKey{participantID, currencyID}
+		if sums[k] == nil {
+			zero := Money{CurrencyID: currencyID}
+			sums[k] = &ParticipantBalance{ParticipantID: participantID, Paid: zero, Share: zero}
+		}
+		return sums[k]
+	}
+
+	conv := NewConverter(db, currencyID)
+
+	for _, tranx := range tranxs {
+
+		// A credit shared by everybody is money the payer received on their behalf.
+		sign := 1
+		if tranx.TransactionDebit.IsZero() {
+			sign = -1
+		}
+
+		var paid Money
+		for _, s := range Shares(tranx) {
+
+			amount, err := conv.Convert(ctx, s.Amount, tranx.Occurrence)
+			if err != nil {
+				return nil, errors.Wrapf(err, "converting share of transaction %s", tranx.ID.Hex())
+			}
+			if sign < 0 {
+				amount = amount.Neg()
+			}
+
+			b := balance(s.ParticipantID, amount.CurrencyID)
+			b.Share = b.Share.Add(amount)
+
+			// The payer paid the sum of the converted shares, so the balances of a currency always add up to zero.
+			paid = paid.Add(amount)
+		}
+
+		b := balance(tranx.PaidBy, paid.CurrencyID)
+		b.Paid = b.Paid.Add(paid)
+	}
+
+	var ids []string
+	for k := range sums {
+		ids = append(ids, k.participantID)
+	}
+
+	names, err := participantNames(ctx, db, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	settlement := Settlement{CurrencyID: currencyID, Window: window, Balances: []ParticipantBalance{}}
+
+	for _, b := range sums {
+		b.Name = names[b.ParticipantID]
+		b.Balance = b.Paid.Sub(b.Share)
+		settlement.Balances = append(settlement.Balances, *b)
+	}
+
+	sort.Slice(settlement.Balances, func(i, j int) bool {
+		bi, bj := settlement.Balances[i], settlement.Balances[j]
+		if bi.Balance.CurrencyID != bj.Balance.CurrencyID {
+			return bi.Balance.CurrencyID < bj.Balance.CurrencyID
+		}
+		return bi.ParticipantID < bj.ParticipantID
+	})
+
+	settlement.Payments = Settle(settlement.Balances)
+
+	return &settlement, nil
+}
+
+// participantNames maps the _id of each of the participants to their name.
+// Participants that no longer exist are left out.
+func participantNames(ctx context.Context, db *mongo.Database, ids []string) (map[string]string, error) {
+
+	var objectIDs []primitive.ObjectID
+	for _, id := range ids {
+		if objectID, err := primitive.ObjectIDFromHex(id); err == nil {
+			objectIDs = append(objectIDs, objectID)
+		}
+	}
+
+	names := map[string]string{}
+	if len(objectIDs) == 0 {
+		return names, nil
+	}
+
+	cursor, err := db.Collection(ParticipantCollection).Find(ctx, bson.M{"_id": bson.M{"$in": objectIDs}})
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cursor from participant collection")
+	}
+
+	var participants []Participant
+	if err := cursor.All(ctx, &participants); err != nil {
+		return nil, errors.Wrap(err, "retrieving participants")
+	}
+
+	for _, p := range participants {
+		names[p.ID.Hex()] = p.Name
+	}
+
+	return names, nil
+}
+
+// shareParticipantIDs returns the _id of the participant of each share of the transaction.
+func shareParticipantIDs(tranx Transaction) []string {
+
+	ids := make([]string, len(tranx.Shares))
+	for i, s := range tranx.Shares {
+		ids[i] = s.ParticipantID
+	}
+
+	return ids
+}
+
+// withShareParticipants adds the payer and the participants of the shares of the transaction to its participants,
+// so filtering transactions by participant finds every one a participant takes part in.
+func withShareParticipants(tranx *Transaction) {
+
+	ids := tranx.ParticipantID
+	if tranx.PaidBy != "" {
+		ids = append(ids, tranx.PaidBy)
+	}
+	for _, id := range shareParticipantIDs(*tranx) {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+
+	tranx.ParticipantID = utility.RemoveDuplicateStringValues(ids)
+}
+
+// withShareCurrency returns a copy of the shares with their amounts in the currency identified by currencyID.
+func withShareCurrency(shares []Share, currencyID string) []Share {
+
+	if shares == nil {
+		return nil
+	}
+
+	out := make([]Share, len(shares))
+	for i, s := range shares {
+		out[i] = Share{ParticipantID: s.ParticipantID, Amount: Money{Amount: s.Amount.Amount, CurrencyID: currencyID}}
+	}
+
+	return out
+}
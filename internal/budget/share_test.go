@@ -0,0 +1,80 @@
+package budget_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+)
+
+func TestShares(t *testing.T) {
+	tests := []struct {
+		name  string
+		tranx budget.Transaction
+		want  string
+	}{
+		{"not shared", budget.Transaction{TransactionDebit: money(t, "10"), ParticipantID: []string{"a", "b"}}, ""},
+		{"equal", budget.Transaction{TransactionDebit: money(t, "10"), PaidBy: "a", ParticipantID: []string{"a", "b", "c"}}, "a:3.34 b:3.33 c:3.33"},
+		{"credit", budget.Transaction{TransactionCredit: money(t, "5.00"), PaidBy: "b", ParticipantID: []string{"a", "b"}}, "a:2.50 b:2.50"},
+		{"payer only", budget.Transaction{TransactionDebit: money(t, "7"), PaidBy: "a"}, "a:7.00"},
+		{"explicit", budget.Transaction{
+			TransactionDebit: money(t, "90"),
+			PaidBy:           "a",
+			ParticipantID:    []string{"a", "b", "c"},
+			Shares:           []budget.Share{{ParticipantID: "a", Amount: money(t, "60")}, {ParticipantID: "b", Amount: money(t, "30")}},
+		}, "a:60 b:30"},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, s := range budget.Shares(tt.tranx) {
+			got = append(got, s.ParticipantID+":"+s.Amount.String())
+		}
+
+		if g := strings.Join(got, " "); g != tt.want {
+			t.Fatalf("%s: expected shares %q, got %q", tt.name, tt.want, g)
+		}
+	}
+}
+
+func TestSettle(t *testing.T) {
+	balance := func(id, amount, currencyID string) budget.ParticipantBalance {
+		m := money(t, amount)
+		m.CurrencyID = currencyID
+		return budget.ParticipantBalance{ParticipantID: id, Balance: m}
+	}
+
+	tests := []struct {
+		name     string
+		balances []budget.ParticipantBalance
+		want     string
+	}{
+		{"nothing owed", []budget.ParticipantBalance{balance("a", "0", "usd"), balance("b", "0", "usd")}, ""},
+		{"one debt", []budget.ParticipantBalance{balance("a", "45", "usd"), balance("b", "-45", "usd")}, "b>a:45"},
+		{"largest first", []budget.ParticipantBalance{
+			balance("a", "70", "usd"),
+			balance("b", "-20", "usd"),
+			balance("c", "-50", "usd"),
+			balance("d", "10", "usd"),
+			balance("e", "-10", "usd"),
+		}, "c>a:50 b>a:20 e>d:10"},
+		{"each currency on its own", []budget.ParticipantBalance{
+			balance("a", "10", "usd"),
+			balance("b", "-10", "usd"),
+			balance("a", "-5", "eur"),
+			balance("b", "5", "eur"),
+		}, "b>a:10 a>b:5"},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, p := range budget.Settle(tt.balances) {
+			got = append(got, fmt.Sprintf("%s>%s:%s", p.From, p.To, p.Amount))
+		}
+
+		if g := strings.Join(got, " "); g != tt.want {
+			t.Fatalf("%s: expected payments %q, got %q", tt.name, tt.want, g)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package budget
+
+import (
+	"fmt"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// tranxAmount is the amount of a transaction: its debit, or its credit when it has no debit.
+// It is what the splits of a transaction divide among budgets.
+func tranxAmount(tranx Transaction) Money {
+
+	if !tranx.TransactionDebit.IsZero() {
+		return tranx.TransactionDebit
+	}
+
+	return tranx.TransactionCredit
+}
+
+// checkSplits records a validation problem for every way the splits of the transaction do NOT fit it.
+// A transaction without splits is NOT checked.
+func checkSplits(tranx Transaction, verr *apierror.ValidationError) {
+
+	if len(tranx.Splits) == 0 {
+		return
+	}
+
+	if tranx.BudgetID != "" {
+		verr.Add("budget_id", "can NOT be combined with splits")
+	}
+
+	if !tranx.TransactionCredit.IsZero() && !tranx.TransactionDebit.IsZero() {
+		verr.Add("splits", "a transaction with both a credit and a debit can NOT be split")
+		return
+	}
+
+	var sum Money
+	for i, s := range tranx.Splits {
+		if s.BudgetID == "" {
+			verr.Add(fmt.Sprintf("splits[%d].budget_id", i), "budget_id is a required field")
+		}
+		if s.Amount.Sign() <= 0 {
+			verr.Add(fmt.Sprintf("splits[%d].amount", i), "amount must be more than 0")
+		}
+		sum = sum.Add(s.Amount)
+	}
+
+	if total := tranxAmount(tranx); sum.Cmp(total) != 0 {
+		verr.Add("splits", fmt.Sprintf("splits add up to %s, NOT the transaction amount of %s", sum, total))
+	}
+}
+
+// withSplitCurrency returns a copy of the splits with their amounts in the currency identified by currencyID.
+func withSplitCurrency(splits []Split, currencyID string) []Split {
+
+	if splits == nil {
+		return nil
+	}
+
+	out := make([]Split, len(splits))
+	for i, s := range splits {
+		out[i] = Split{BudgetID: s.BudgetID, Amount: Money{Amount: s.Amount.Amount, CurrencyID: currencyID}, Memo: s.Memo}
+	}
+
+	return out
+}
+
+// splitBudgetIDs returns the _id of the budget of each split of the transaction.
+func splitBudgetIDs(tranx Transaction) []string {
+
+	ids := make([]string, len(tranx.Splits))
+	for i, s := range tranx.Splits {
+		ids[i] = s.BudgetID
+	}
+
+	return ids
+}
+
+// budgetLines is the part of a budget pipeline that turns each transaction into one line per budget it belongs to,
+// with the credit and debit of that budget. A transaction without splits is one line for its budget_id.
+func budgetLines() []bson.D {
+
+	// A split is a debit when the transaction has a debit, otherwise it is a credit.
+	isDebit := bson.M{"$gt": []interface{}{"$tranx_debit.amount", 0}}
+
+	split := bson.M{
+		"budget_id": "$$s.budget_id",
+		"credit":    bson.M{"$cond": []interface{}{isDebit, 0, "$$s.amount.amount"}},
+		"debit":     bson.M{"$cond": []interface{}{isDebit, "$$s.amount.amount", 0}},
+	}
+
+	whole := bson.M{
+		"budget_id": "$budget_id",
+		"credit":    "$tranx_credit.amount",
+		"debit":     "$tranx_debit.amount",
+	}
+
+	hasSplits := bson.M{"$gt": []interface{}{bson.M{"$size": bson.M{"$ifNull": []interface{}{"$splits", []interface{}{}}}}, 0}}
+
+	return []bson.D{
+		{{Key: "$project", Value: bson.M{
+			"currency_id": 1,
+			"occurrence":  1,
+			"lines": bson.M{"$cond": []interface{}{
+				hasSplits,
+				bson.M{"$map": bson.M{"input": "$splits", "as": "s", "in": split}},
+				[]interface{}{whole},
+			}},
+		}}},
+		{{Key: "$unwind", Value: "$lines"}},
+	}
+}
@@ -0,0 +1,538 @@
+package budget
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrMixedCurrencies is used when amounts in more than one currency would be added up without a currency to convert them into.
+var ErrMixedCurrencies = errors.New("amounts are in more than one currency, a currency_id to convert them into is required")
+
+// SummaryWindow limits a summary to the transactions that occurred within a date range.
+// Either end may be left open. When a bound is set transactions without an occurrence date are left out.
+type SummaryWindow struct {
+	From *time.Time `json:"from,omitempty"`
+	To   *time.Time `json:"to,omitempty"`
+}
+
+// BudgetSummary compares the planned value of a Budget with the transactions recorded against it.
+// When a currency was requested every amount is converted into it.
+type BudgetSummary struct {
+	BudgetID    string          `json:"budget_id"`
+	BudgetName  string          `json:"budget_name,omitempty"`
+	CurrencyID  string          `json:"currency_id,omitempty"`
+	BudgetValue Money           `json:"budget_value"`
+	Spent       Money           `json:"spent"`        // sum of debits
+	Received    Money           `json:"received"`     // sum of credits
+	Remaining   Money           `json:"remaining"`    // budget value less what was spent, plus what was received
+	PercentUsed float64         `json:"percent_used"` // net spending as a percentage of the budget value
+	Window      SummaryWindow   `json:"window"`
+	Periods     []PeriodSummary `json:"periods,omitempty"` // how each period went, for a budget with a period
+}
+
+// AccountSummary totals the transactions recorded against a FinancialAccount.
+// When a currency was requested every amount is converted into it.
+type AccountSummary struct {
+	FinancialAccountID string        `json:"fin_acc_id"`
+	AccountName        string        `json:"account_name,omitempty"`
+	CurrencyID         string        `json:"currency_id,omitempty"`
+	CurrentValue       Money         `json:"current_value"` // at the latest rate
+	Spent              Money         `json:"spent"`         // sum of debits
+	Received           Money         `json:"received"`      // sum of credits
+	Window             SummaryWindow `json:"window"`
+}
+
+// VendorLedger is a page of the transactions of a Vendor with the totals of all its transactions within a window.
+// Without a requested currency there is one total for each currency the vendor was paid in.
+type VendorLedger struct {
+	VendorID     string          `json:"vendor_id"`
+	VendorName   string          `json:"vendor_name,omitempty"`
+	Totals       []CurrencyTotal `json:"totals"`
+	Transactions []Transaction   `json:"transactions"`
+	Window       SummaryWindow   `json:"window"`
+}
+
+// CurrencyTotal sums transactions in one currency.
+type CurrencyTotal struct {
+	CurrencyID string `json:"currency_id,omitempty"`
+	Spent      Money  `json:"spent"`    // sum of debits
+	Received   Money  `json:"received"` // sum of credits
+}
+
+// ledgerTotals holds the sums of one group of transactions.
+type ledgerTotals struct {
+	ID     string `bson:"_id"`
+	Credit Money  `bson:"credit"`
+	Debit  Money  `bson:"debit"`
+}
+
+// ledgerLine holds the sums of the transactions of one group in one currency on one day.
+type ledgerLine struct {
+	ID struct {
+		Key        string `bson:"key"`
+		CurrencyID string `bson:"currency_id"`
+		Day        string `bson:"day"` // YYYY-MM-DD, empty for transactions without an occurrence
+	} `bson:"_id"`
+	Credit Money `bson:"credit"`
+	Debit  Money `bson:"debit"`
+
+	at time.Time // the day of the line, zero for transactions without an occurrence
+}
+
+// Summarize totals the transactions of the budget identified by budgetID within the window.
+// Amounts are converted into the currency identified by currencyID unless it is empty,
+// in which case ErrMixedCurrencies is returned when they are in more than one currency.
+// A budget with a period is also summarized period by period, up to the period of now when the window is open.
+func Summarize(ctx context.Context, db *mongo.Database, budgetID string, window SummaryWindow, currencyID string, now time.Time) (*BudgetSummary, error) {
+
+	budget, err := Retrieve(ctx, db.Collection(BudgetCollection), budgetID)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := summarizeBudgets(ctx, db, []Budget{*budget}, window, NewConverter(db, currencyID), now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &list[0], nil
+}
+
+// SummarizeList totals the transactions of a page of budgets within the window.
+// Amounts are converted into the currency identified by currencyID unless it is empty, as Summarize does.
+// Budgets with a period are also summarized period by period, as Summarize does.
+func SummarizeList(ctx context.Context, db *mongo.Database, window SummaryWindow, currencyID string, page database.Page, now time.Time) ([]BudgetSummary, *database.PageInfo, error) {
+
+	budgets, info, err := List(ctx, db.Collection(BudgetCollection), page)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	list, err := summarizeBudgets(ctx, db, budgets, window, NewConverter(db, currencyID), now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return list, info, nil
+}
+
+// summarizeBudgets summarizes each budget within the window.
+// The transactions of budgets without a period are totaled together, those of a budget with a period are summed per period.
+func summarizeBudgets(ctx context.Context, db *mongo.Database, budgets []Budget, window SummaryWindow, conv *Converter, now time.Time) ([]BudgetSummary, error) {
+
+	var ids []string
+	for _, b := range budgets {
+		if b.Period == "" {
+			ids = append(ids, b.ID.Hex())
+		}
+	}
+
+	totals := map[string]ledgerTotals{}
+	if len(ids) > 0 {
+		var err error
+		if totals, err = budgetTotals(ctx, db, ids, window, conv); err != nil {
+			return nil, err
+		}
+	}
+
+	list := make([]BudgetSummary, len(budgets))
+	for i, b := range budgets {
+		var err error
+		if b.Period == "" {
+			list[i], err = newBudgetSummary(ctx, b, totals[b.ID.Hex()], window, conv)
+		} else {
+			list[i], err = newPeriodSummary(ctx, db, b, window, conv, now)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return list, nil
+}
+
+// SummarizeAccount totals the transactions of the financial account identified by faID within the window.
+// Amounts are converted into the currency identified by currencyID unless it is empty,
+// in which case ErrMixedCurrencies is returned when they are in more than one currency.
+func SummarizeAccount(ctx context.Context, db *mongo.Database, faID string, window SummaryWindow, currencyID string) (*AccountSummary, error) {
+
+	fa, err := RetrieveFinancialAccount(ctx, db.Collection(FinancialAccountCollection), faID)
+	if err != nil {
+		return nil, err
+	}
+
+	conv := NewConverter(db, currencyID)
+
+	totals, err := ledgerTotalsBy(ctx, db, bson.M{"fin_acc_id": faID}, faID, window, conv)
+	if err != nil {
+		return nil, err
+	}
+
+	currentValue := fa.CurrentValue
+	if currentValue.CurrencyID == "" {
+		currentValue.CurrencyID = fa.CurrencyID
+	}
+
+	if err := oneCurrency(conv, currentValue, totals[faID].Credit); err != nil {
+		return nil, err
+	}
+
+	if currentValue, err = conv.Convert(ctx, currentValue, time.Time{}); err != nil {
+		return nil, err
+	}
+
+	summary := AccountSummary{
+		FinancialAccountID: faID,
+		AccountName:        fa.AccountName,
+		CurrencyID:         currencyID,
+		CurrentValue:       currentValue,
+		Spent:              totals[faID].Debit,
+		Received:           totals[faID].Credit,
+		Window:             window,
+	}
+
+	if summary.CurrencyID == "" {
+		summary.CurrencyID = fa.CurrencyID
+	}
+
+	return &summary, nil
+}
+
+// VendorTransactions gets a page of the transactions of the vendor identified by vendorID within the window,
+// with the totals of all of them. Amounts are converted into the currency identified by currencyID unless it is empty.
+func VendorTransactions(ctx context.Context, db *mongo.Database, vendorID string, window SummaryWindow, currencyID string, page database.Page) (*VendorLedger, *database.PageInfo, error) {
+
+	vendor, err := RetrieveVendor(ctx, db.Collection(VendorCollection), vendorID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter := bson.M{"vendor_id": vendorID}
+	if r := dateRangeQuery(window.From, window.To); r != nil {
+		filter["occurrence"] = r
+	}
+
+	list := []Transaction{}
+
+	info, err := database.FindPage(ctx, db.Collection(TransactionCollection), filter, page, &list)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "retrieving transactions of vendor %s", vendorID)
+	}
+
+	conv := NewConverter(db, currencyID)
+
+	totals, err := ledgerTotalsBy(ctx, db, bson.M{"vendor_id": vendorID}, "$currency_id", window, conv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ledger := VendorLedger{
+		VendorID:     vendorID,
+		VendorName:   vendor.VendorName,
+		Totals:       []CurrencyTotal{},
+		Transactions: list,
+		Window:       window,
+	}
+
+	if currencyID != "" {
+		total := CurrencyTotal{CurrencyID: currencyID}
+		for _, t := range totals {
+			total.Spent = total.Spent.Add(t.Debit)
+			total.Received = total.Received.Add(t.Credit)
+		}
+		ledger.Totals = append(ledger.Totals, total)
+		return &ledger, info, nil
+	}
+
+	for id, t := range totals {
+		ledger.Totals = append(ledger.Totals, CurrencyTotal{CurrencyID: id, Spent: t.Debit, Received: t.Credit})
+	}
+
+	sort.Slice(ledger.Totals, func(i, j int) bool {
+		return ledger.Totals[i].CurrencyID < ledger.Totals[j].CurrencyID
+	})
+
+	return &ledger, info, nil
+}
+
+// budgetTotals sums the credits and debits of the transactions of each budget within the window.
+// A split transaction counts toward the budget of each split by the amount of that split.
+// The result is keyed by budget _id.
+func budgetTotals(ctx context.Context, db *mongo.Database, budgetIDs []string, window SummaryWindow, conv *Converter) (map[string]ledgerTotals, error) {
+	return sumLedger(ctx, db, budgetPipeline(budgetIDs, window), conv)
+}
+
+// budgetPipeline sums the transactions of each budget within the window per currency and day, see budgetTotals.
+func budgetPipeline(budgetIDs []string, window SummaryWindow) mongo.Pipeline {
+
+	in := bson.M{"$in": budgetIDs}
+
+	match := bson.M{"$or": []bson.M{{"budget_id": in}, {"splits.budget_id": in}}}
+	if r := dateRangeQuery(window.From, window.To); r != nil {
+		match["occurrence"] = r
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: match}}}
+	pipeline = append(pipeline, budgetLines()...)
+	pipeline = append(pipeline,
+		bson.D{{Key: "$match", Value: bson.M{"lines.budget_id": in}}},
+		ledgerGroup("$lines.budget_id", "$lines.credit", "$lines.debit"),
+	)
+
+	return pipeline
+}
+
+// ledgerTotalsBy sums the credits and debits of the transactions matching match within the window, grouped by key.
+func ledgerTotalsBy(ctx context.Context, db *mongo.Database, match bson.M, key interface{}, window SummaryWindow, conv *Converter) (map[string]ledgerTotals, error) {
+
+	if r := dateRangeQuery(window.From, window.To); r != nil {
+		match["occurrence"] = r
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		ledgerGroup(key, "$tranx_credit.amount", "$tranx_debit.amount"),
+	}
+
+	return sumLedger(ctx, db, pipeline, conv)
+}
+
+// ledgerGroup is the $group stage that sums credits and debits per key, currency and day, giving ledgerLines.
+func ledgerGroup(key interface{}, credit, debit string) bson.D {
+	return bson.D{{Key: "$group", Value: bson.M{
+		"_id": bson.M{
+			"key":         key,
+			"currency_id": "$currency_id",
+			"day":         bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$occurrence"}},
+		},
+		"credit": bson.M{"$sum": credit},
+		"debit":  bson.M{"$sum": debit},
+	}}}
+}
+
+// sumLedger runs a pipeline over the transactions that ends with a ledgerGroup stage.
+// The database sums each group per currency and day, then every line is converted at the rate of its day.
+// Without a currency to convert into, the lines of a group must all be in one currency.
+func sumLedger(ctx context.Context, db *mongo.Database, pipeline mongo.Pipeline, conv *Converter) (map[string]ledgerTotals, error) {
+
+	lines, err := ledgerLines(ctx, db, pipeline, conv)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := map[string]ledgerTotals{}
+
+	for _, line := range lines {
+		t := totals[line.ID.Key]
+		if err := oneCurrency(conv, t.Credit, line.Credit); err != nil {
+			return nil, err
+		}
+		t.ID = line.ID.Key
+		t.Credit = t.Credit.Add(line.Credit)
+		t.Debit = t.Debit.Add(line.Debit)
+		totals[line.ID.Key] = t
+	}
+
+	return totals, nil
+}
+
+// ledgerLines runs a pipeline over the transactions that ends with a ledgerGroup stage.
+// The credit and debit of every line are converted at the rate of its day.
+func ledgerLines(ctx context.Context, db *mongo.Database, pipeline mongo.Pipeline, conv *Converter) ([]ledgerLine, error) {
+
+	cursor, err := db.Collection(TransactionCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, errors.Wrap(err, "aggregating ledger totals")
+	}
+
+	var lines []ledgerLine
+	if err := cursor.All(ctx, &lines); err != nil {
+		return nil, errors.Wrap(err, "retrieving ledger totals")
+	}
+
+	for i := range lines {
+		line := &lines[i]
+
+		if line.ID.Day != "" {
+			if line.at, err = time.Parse("2006-01-02", line.ID.Day); err != nil {
+				return nil, errors.Wrapf(err, "reading ledger day %q", line.ID.Day)
+			}
+		}
+
+		line.Credit.CurrencyID = line.ID.CurrencyID
+		if line.Credit, err = conv.Convert(ctx, line.Credit, line.at); err != nil {
+			return nil, err
+		}
+
+		line.Debit.CurrencyID = line.ID.CurrencyID
+		if line.Debit, err = conv.Convert(ctx, line.Debit, line.at); err != nil {
+			return nil, err
+		}
+	}
+
+	return lines, nil
+}
+
+// newBudgetSummary works out what is left of a budget given the totals of its transactions.
+// The budget value is converted at the rate of the end of the window, or the latest rate when the window is open.
+func newBudgetSummary(ctx context.Context, b Budget, totals ledgerTotals, window SummaryWindow, conv *Converter) (BudgetSummary, error) {
+
+	var at time.Time
+	if window.To != nil {
+		at = *window.To
+	}
+
+	value := b.BudgetValue
+	if value.CurrencyID == "" {
+		value.CurrencyID = b.CurrencyID
+	}
+
+	if err := oneCurrency(conv, value, totals.Credit); err != nil {
+		return BudgetSummary{}, err
+	}
+
+	value, err := conv.Convert(ctx, value, at)
+	if err != nil {
+		return BudgetSummary{}, err
+	}
+
+	summary := BudgetSummary{
+		BudgetID:    b.ID.Hex(),
+		BudgetName:  b.BudgetName,
+		CurrencyID:  conv.to,
+		BudgetValue: value,
+		Spent:       totals.Debit,
+		Received:    totals.Credit,
+		Remaining:   value.Sub(totals.Debit).Add(totals.Credit),
+		Window:      window,
+	}
+
+	if summary.CurrencyID == "" {
+		summary.CurrencyID = b.CurrencyID
+	}
+
+	if !value.IsZero() {
+		summary.PercentUsed = totals.Debit.Sub(totals.Credit).Float64() / value.Float64() * 100
+	}
+
+	return summary, nil
+}
+
+// newPeriodSummary summarizes a budget with a period, period by period, within the window.
+// An open window ends with the period of now. The allocation of each period is converted at the rate of its last day.
+// When the budget rolls over, periods before the window are still worked out for what they carry into it.
+func newPeriodSummary(ctx context.Context, db *mongo.Database, b Budget, window SummaryWindow, conv *Converter, now time.Time) (BudgetSummary, error) {
+
+	var from time.Time
+	if window.From != nil && !b.Rollover {
+		from = *window.From
+	}
+
+	to := now
+	if window.To != nil {
+		to = *window.To
+	}
+
+	periods := b.Periods(from, to)
+
+	history := make([]PeriodSummary, len(periods))
+	for i, p := range periods {
+		if err := oneCurrency(conv, b.allocation(p.Name), b.allocation(periods[0].Name)); err != nil {
+			return BudgetSummary{}, err
+		}
+		allocated, err := conv.Convert(ctx, b.allocation(p.Name), p.End)
+		if err != nil {
+			return BudgetSummary{}, err
+		}
+		history[i] = PeriodSummary{BudgetPeriod: p, Allocated: allocated}
+	}
+
+	if len(periods) > 0 {
+		start, end := periods[0].Start, periods[len(periods)-1].End.AddDate(0, 0, 1).Add(-time.Nanosecond)
+
+		lines, err := ledgerLines(ctx, db, budgetPipeline([]string{b.ID.Hex()}, SummaryWindow{From: &start, To: &end}), conv)
+		if err != nil {
+			return BudgetSummary{}, err
+		}
+
+		for _, line := range lines {
+			if err := oneCurrency(conv, b.allocation(periods[0].Name), line.Credit); err != nil {
+				return BudgetSummary{}, err
+			}
+			i := sort.Search(len(periods), func(i int) bool { return !periods[i].End.Before(line.at) })
+			if i == len(periods) || line.at.Before(periods[i].Start) {
+				continue
+			}
+			history[i].Spent = history[i].Spent.Add(line.Debit)
+			history[i].Received = history[i].Received.Add(line.Credit)
+		}
+	}
+
+	b.Balance(history)
+
+	// Periods before the window were only needed for what they roll over.
+	for len(history) > 0 && window.From != nil && history[0].End.Before(day(*window.From)) {
+		history = history[1:]
+	}
+
+	summary := BudgetSummary{
+		BudgetID:    b.ID.Hex(),
+		BudgetName:  b.BudgetName,
+		CurrencyID:  conv.to,
+		BudgetValue: Money{CurrencyID: conv.to},
+		Window:      window,
+		Periods:     history,
+	}
+
+	if summary.CurrencyID == "" {
+		summary.CurrencyID = b.CurrencyID
+		summary.BudgetValue.CurrencyID = b.CurrencyID
+	}
+
+	// What rolled into the first period of the window is part of what the window had to spend.
+	if len(history) > 0 {
+		summary.BudgetValue = summary.BudgetValue.Add(history[0].RolledOver)
+	}
+
+	for _, p := range history {
+		summary.BudgetValue = summary.BudgetValue.Add(p.Allocated)
+		summary.Spent = summary.Spent.Add(p.Spent)
+		summary.Received = summary.Received.Add(p.Received)
+	}
+
+	summary.Remaining = summary.BudgetValue.Sub(summary.Spent).Add(summary.Received)
+
+	if !summary.BudgetValue.IsZero() {
+		summary.PercentUsed = summary.Spent.Sub(summary.Received).Float64() / summary.BudgetValue.Float64() * 100
+	}
+
+	return summary, nil
+}
+
+// oneCurrency returns ErrMixedCurrencies when conv does NOT convert and the amounts are in more than one currency.
+// Amounts without a currency fit any.
+func oneCurrency(conv *Converter, amounts ...Money) error {
+
+	if conv.to != "" {
+		return nil
+	}
+
+	var currencyID string
+	for _, m := range amounts {
+		if m.CurrencyID == "" {
+			continue
+		}
+		if currencyID != "" && m.CurrencyID != currencyID {
+			return ErrMixedCurrencies
+		}
+		currencyID = m.CurrencyID
+	}
+
+	return nil
+}
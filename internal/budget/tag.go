@@ -0,0 +1,110 @@
+package budget
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TagCount is how many transactions carry a tag.
+type TagCount struct {
+	Tag   string `bson:"_id" json:"tag"`
+	Count int    `bson:"count" json:"count"`
+}
+
+// ListTags counts the transactions fitting the filter criteria per tag, most used first.
+// Tags used as often are sorted by name.
+func ListTags(ctx context.Context, db *mongo.Collection, filterTranx FilterTransaction) ([]TagCount, error) {
+
+	match := filterTranx.Query()
+	if _, ok := match["tags"]; !ok {
+		match["tags"] = bson.M{"$exists": true}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+
+	cursor, err := db.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, errors.Wrap(err, "counting tags")
+	}
+
+	list := []TagCount{}
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, errors.Wrap(err, "retrieving tag counts")
+	}
+
+	return list, nil
+}
+
+// normalizeTags trims and lower cases tags and drops empty and repeated ones, keeping their order.
+func normalizeTags(tags []string) []string {
+
+	var out []string
+	seen := map[string]bool{}
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+
+	return out
+}
+
+// editTags adds the tags of add to tags, then takes out the tags of remove. The result is normalized.
+func editTags(tags, add, remove []string) []string {
+
+	removed := map[string]bool{}
+	for _, tag := range normalizeTags(remove) {
+		removed[tag] = true
+	}
+
+	var out []string
+	for _, tag := range normalizeTags(append(append([]string{}, tags...), add...)) {
+		if !removed[tag] {
+			out = append(out, tag)
+		}
+	}
+
+	return out
+}
+
+// tagsQuery builds the condition on the tags of a transaction that has every tag of all and at least one tag of any.
+// It returns nil when neither gives a tag.
+func tagsQuery(all, any []string) interface{} {
+
+	all = normalizeTags(all)
+	any = normalizeTags(any)
+
+	if len(all) == 0 && len(any) == 0 {
+		return nil
+	}
+
+	// tags is an array, an equality match finds any element.
+	if len(all) == 1 && len(any) == 0 {
+		return all[0]
+	}
+
+	r := bson.M{}
+
+	if len(all) > 0 {
+		r["$all"] = all
+	}
+
+	if len(any) > 0 {
+		r["$in"] = any
+	}
+
+	return r
+}
@@ -3,10 +3,13 @@ package budget
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"time"
 
 	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/blob"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
 	"github.com/dapperAuteur/dashboard-go-api/internal/utility"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,79 +18,170 @@ import (
 )
 
 // ListTransactions gets all the Transactions from the db then encodes them in a response client.
-func ListTransactions(ctx context.Context, db *mongo.Collection) ([]Transaction, error) {
+// Results are returned one page at a time.
+func ListTransactions(ctx context.Context, db *mongo.Collection, page database.Page) ([]Transaction, *database.PageInfo, error) {
 
 	list := []Transaction{}
 
-	cursor, err := db.Find(ctx, bson.M{})
-	if err = cursor.All(ctx, &list); err != nil {
-		return nil, errors.Wrapf(err, "retrieving transaction list")
+	info, err := database.FindPage(ctx, db, bson.M{}, page, &list)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "retrieving transaction list")
+	}
+
+	return list, info, nil
+}
+
+// FilterTransactions gets all the Transactions from the database that fit the filter criteria.
+// The filter is translated into a MongoDB query so the work is done by the database.
+// It returns an empty array if none fit the criteria.
+// Results are returned one page at a time.
+func FilterTransactions(ctx context.Context, db *mongo.Collection, filterTranx FilterTransaction, page database.Page) ([]Transaction, *database.PageInfo, error) {
+
+	list := []Transaction{}
+
+	info, err := database.FindPage(ctx, db, filterTranx.Query(), page, &list)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "filtering transactions")
+	}
+
+	return list, info, nil
+}
+
+// Query translates the filter into a MongoDB query document.
+// Criteria that were NOT provided are left out of the query.
+func (f FilterTransaction) Query() bson.M {
+
+	query := bson.M{}
+
+	// A split transaction belongs to the budget of each of its splits.
+	if f.BudgetID != "" {
+		query["$or"] = []bson.M{{"budget_id": f.BudgetID}, {"splits.budget_id": f.BudgetID}}
+	}
+
+	if f.CurrencyID != "" {
+		query["currency_id"] = f.CurrencyID
+	}
+
+	// fin_acc_id and participant_id are arrays, an equality match finds any element.
+	if f.FinancialAccountID != "" {
+		query["fin_acc_id"] = f.FinancialAccountID
+	}
+
+	if f.ParticipantID != "" {
+		query["participant_id"] = f.ParticipantID
+	}
+
+	if f.VendorID != "" {
+		query["vendor_id"] = f.VendorID
 	}
 
-	return list, nil
+	if r := tagsQuery(f.Tags, f.AnyTags); r != nil {
+		query["tags"] = r
+	}
+
+	if f.OccurrenceString != "" {
+		query["occurrence_string"] = f.OccurrenceString
+	}
+
+	if f.TransactionEvent != "" {
+		query["tranx_event"] = primitive.Regex{
+			Pattern: regexp.QuoteMeta(f.TransactionEvent),
+			Options: "i",
+		}
+	}
+
+	if r := rangeQuery(f.CreditMin, f.CreditMax); r != nil {
+		query["tranx_credit.amount"] = r
+	}
+
+	if r := rangeQuery(f.DebitMin, f.DebitMax); r != nil {
+		query["tranx_debit.amount"] = r
+	}
+
+	if r := beforeQuery(dateRangeQuery(f.OccurrenceFrom, f.OccurrenceTo), f.OccurrenceBefore); r != nil {
+		query["occurrence"] = r
+	}
+
+	if r := beforeQuery(dateRangeQuery(f.CreatedFrom, f.CreatedTo), f.CreatedBefore); r != nil {
+		query["created_at"] = r
+	}
+
+	if r := beforeQuery(dateRangeQuery(f.UpdatedFrom, f.UpdatedTo), f.UpdatedBefore); r != nil {
+		query["updated_at"] = r
+	}
+
+	if f.Locked != nil {
+		query["reconciled_in"] = bson.M{"$exists": *f.Locked}
+	}
+
+	return query
 }
 
-// FilterTransactions gets all the Transactions from the database that filter the filter criteria.
-// The encodes them in a response client. It returns an empty array if none fit the criteria.
-// func FilterTransactions(ctx context.Context, db *mongo.Collection, filterTranx FilterTransaction) ([]Transaction, error) {
+// rangeQuery builds an inclusive range condition from optional bounds.
+// It returns nil when neither bound is provided.
+func rangeQuery(min, max *Money) bson.M {
+
+	if min == nil && max == nil {
+		return nil
+	}
 
-// 	list := []Transaction{}
-// 	filter := bson.M{}
+	r := bson.M{}
+
+	if min != nil {
+		r["$gte"] = min.Amount
+	}
 
-// 	if filterTranx.BudgetID != nil {
-// 		filter.BudgetID = filterTranx.BudgetID
-// 	}
+	if max != nil {
+		r["$lte"] = max.Amount
+	}
 
-// 	if filterTranx.CurrencyID != nil {
-// 		filter.CurrencyID = filterTranx.CurrencyID
-// 	}
+	return r
+}
 
-// 	if filterTranx.FinancialAccountID != nil {
-// 		filter.FinancialAccountID = filterTranx.FinancialAccountID
-// 	}
+// dateRangeQuery builds an inclusive date range condition from optional bounds.
+// It returns nil when neither bound is provided.
+func dateRangeQuery(from, to *time.Time) bson.M {
 
-// 	if filterTranx.OccurrenceString != nil {
-// 		filter.OccurrenceString = filterTranx.OccurrenceString
-// 	}
+	if from == nil && to == nil {
+		return nil
+	}
 
-// 	if filterTranx.TransactionEvent != nil {
-// 		filter.TransactionEvent = filterTranx.TransactionEvent
-// 	}
+	r := bson.M{}
 
-// 	if filterTranx.TransactionCredit != nil {
-// 		filter.TransactionCredit = filterTranx.TransactionCredit
-// 	}
+	if from != nil {
+		r["$gte"] = from.UTC()
+	}
 
-// 	if filterTranx.TransactionDebit != nil {
-// 		filter.TransactionDebit = filterTranx.TransactionDebit
-// 	}
+	if to != nil {
+		r["$lte"] = to.UTC()
+	}
 
-// 	if filterTranx.VendorID != nil {
-// 		filter.VendorID = filterTranx.VendorID
-// 	}
+	return r
+}
 
-// 	if filterTranx.ParticipantID != nil {
-// 		filter.ParticipantID = filterTranx.ParticipantID
-// 	}
+// beforeQuery adds an exclusive upper bound to the date range condition r, which may be nil.
+func beforeQuery(r bson.M, before *time.Time) bson.M {
 
-// 	if filterTranx.CreatedAt != nil {
-// 		filter.CreatedAt = filterTranx.CreatedAt
-// 	}
+	if before == nil {
+		return r
+	}
 
-// 	if filterTranx.UpdatedAt != nil {
-// 		filter.UpdatedAt = filterTranx.UpdatedAt
-// 	}
+	if r == nil {
+		r = bson.M{}
+	}
 
-// 	cursor, err := db.Find(ctx, filter)
-// 	if err = cursor.All(ctx, &list); err != nil {
-// 		return nil, errors.Wrapf(err, "filtering transactions")
-// 	}
+	r["$lt"] = before.UTC()
 
-// 	return list, nil
-// }
+	return r
+}
 
 // CreateTransaction takes data from the client to create a transaction in the db
-func CreateTransaction(ctx context.Context, db *mongo.Collection, user auth.Claims, newTranx NewTransaction, now time.Time) (*Transaction, error) {
+// The value of each financial account of the transaction moves by its credit less its debit.
+// Every budget, currency, vendor, financial account and participant it refers to must exist.
+// Splits must add up to the amount of the transaction, and so must shares.
+// The payer and the participants of the shares are added to the participants.
+// The category rules fill in the budget, participants and tags the client did NOT send, see ApplyCategoryRules.
+func CreateTransaction(ctx context.Context, db *mongo.Database, user auth.Claims, newTranx NewTransaction, now time.Time) (*Transaction, error) {
 
 	var isAdmin = user.HasRole(auth.RoleAdmin)
 
@@ -97,6 +191,21 @@ func CreateTransaction(ctx context.Context, db *mongo.Collection, user auth.Clai
 
 	tranx := Transaction{}
 
+	verr := apierror.ValidationError{}
+
+	var occurrence time.Time
+	switch {
+	case newTranx.Occurrence != "":
+		t, err := ParseOccurrence(newTranx.Occurrence, 0)
+		if err != nil {
+			verr.Add("occurrence", err.Error())
+		}
+		occurrence = t
+	case newTranx.OccurrenceString != "":
+		// Older clients only send "M/D", read it as a day of the current year when possible.
+		occurrence, _ = ParseOccurrence(newTranx.OccurrenceString, now.Year())
+	}
+
 	var (
 		// finAcctObjectIDs, participantObjectIDs []primitive.ObjectID
 		finAcctIDsSlice, participantIDsSlice []string
@@ -126,30 +235,72 @@ func CreateTransaction(ctx context.Context, db *mongo.Collection, user auth.Clai
 	}
 
 	tranx = Transaction{
+		ID:                 primitive.NewObjectID(),
 		BudgetID:           newTranx.BudgetID,
 		CurrencyID:         newTranx.CurrencyID,
 		FinancialAccountID: finAcctIDsSlice,
-		// Occurrence:         now.UTC(),
-		OccurrenceString:  newTranx.OccurrenceString,
-		TransactionEvent:  newTranx.TransactionEvent,
-		TransactionCredit: newTranx.TransactionCredit,
-		TransactionDebit:  newTranx.TransactionDebit,
-		VendorID:          newTranx.VendorID,
-		ParticipantID:     participantIDsSlice,
-		CreatedAt:         now.UTC(),
-		UpdatedAt:         now.UTC(),
-	}
-
-	tranxResult, err := db.InsertOne(ctx, tranx)
+		Occurrence:         occurrence,
+		OccurrenceString:   newTranx.OccurrenceString,
+		TransactionEvent:   newTranx.TransactionEvent,
+		TransactionCredit:  Money{Amount: newTranx.TransactionCredit.Amount, CurrencyID: newTranx.CurrencyID},
+		TransactionDebit:   Money{Amount: newTranx.TransactionDebit.Amount, CurrencyID: newTranx.CurrencyID},
+		VendorID:           newTranx.VendorID,
+		ParticipantID:      participantIDsSlice,
+		ExternalID:         newTranx.ExternalID,
+		Splits:             withSplitCurrency(newTranx.Splits, newTranx.CurrencyID),
+		PaidBy:             newTranx.PaidBy,
+		Shares:             withShareCurrency(newTranx.Shares, newTranx.CurrencyID),
+		Tags:               normalizeTags(newTranx.Tags),
+		CreatedAt:          now.UTC(),
+		UpdatedAt:          now.UTC(),
+	}
+
+	rules, err := loadCategoryRules(ctx, db)
 	if err != nil {
-		return nil, errors.Wrapf(err, "inserting transaction : %v", tranx)
+		return nil, err
 	}
 
-	fmt.Println("tranxResult : ", tranxResult)
+	ApplyCategoryRules(rules, &tranx)
+
+	withShareParticipants(&tranx)
+
+	checkSplits(tranx, &verr)
+	checkShares(tranx, &verr)
+
+	if err := checkReferences(ctx, db, tranx, &verr); err != nil {
+		return nil, err
+	}
+
+	if err := verr.Err(); err != nil {
+		return nil, err
+	}
+
+	err = withTransaction(ctx, db, func(sc mongo.SessionContext) error {
+		return insertTransaction(sc, db, tranx, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	alertBudgets(ctx, db, now, tranx)
 
 	return &tranx, nil
 }
 
+// insertTransaction stores a new transaction and moves the value of each of its financial accounts.
+// It must be called inside a MongoDB transaction.
+func insertTransaction(sc mongo.SessionContext, db *mongo.Database, tranx Transaction, now time.Time) error {
+
+	tranxResult, err := db.Collection(TransactionCollection).InsertOne(sc, tranx)
+	if err != nil {
+		return errors.Wrapf(err, "inserting transaction : %v", tranx)
+	}
+
+	fmt.Println("tranxResult : ", tranxResult)
+
+	return applyBalance(sc, db, tranx.FinancialAccountID, balanceDelta(tranx), now)
+}
+
 // RetrieveTransaction finds a single Transaction by _id
 func RetrieveTransaction(ctx context.Context, db *mongo.Collection, _id string) (*Transaction, error) {
 
@@ -172,7 +323,13 @@ func RetrieveTransaction(ctx context.Context, db *mongo.Collection, _id string)
 
 // UpdateOneTransaction modifies data about a transaction.
 // It will error if the specified _id is invalid or does NOT reference an existing transaction.
-func UpdateOneTransaction(ctx context.Context, db *mongo.Collection, user auth.Claims, tranxID string, updateTranx UpdateTransaction, now time.Time) error {
+// The effect of the old transaction on its financial accounts is reversed and the effect of the modified one applied.
+// References that are changed must point to existing documents.
+// The financial accounts and participants sent replace those of the transaction.
+// Transactions of a transfer are NOT changed here, see UpdateOneTransfer.
+// Setting splits clears the budget_id, and the splits must still add up to the amount once the update is applied.
+// A reconciled transaction is locked until it is unlocked, see UnlockTransaction.
+func UpdateOneTransaction(ctx context.Context, db *mongo.Database, user auth.Claims, tranxID string, updateTranx UpdateTransaction, now time.Time) error {
 
 	var isAdmin = user.HasRole(auth.RoleAdmin)
 
@@ -180,95 +337,253 @@ func UpdateOneTransaction(ctx context.Context, db *mongo.Collection, user auth.C
 		return apierror.ErrForbidden
 	}
 
-	foundTranx, err := RetrieveTransaction(ctx, db, tranxID)
+	tranxCollection := db.Collection(TransactionCollection)
+
+	foundTranx, err := RetrieveTransaction(ctx, tranxCollection, tranxID)
 	if err != nil {
 		return apierror.ErrNotFound
 	}
 
 	fmt.Printf("transaction to update found %+v : \n", foundTranx)
 
-	tObjectID, err := primitive.ObjectIDFromHex(tranxID)
-	if err != nil {
+	if _, err := primitive.ObjectIDFromHex(tranxID); err != nil {
 		return apierror.ErrInvalidID
 	}
 
-	transaction := Transaction{}
+	// merged is the transaction as it will be after the update, to check its splits, shares and references.
+	// Fields are set and unset one by one, as a zero amount or an empty value would be dropped by omitempty.
+	merged := *foundTranx
+	set := bson.M{}
+	unset := bson.M{}
+
+	setOrUnset := func(field string, value interface{}, empty bool) {
+		if empty {
+			delete(set, field)
+			unset[field] = ""
+			return
+		}
+		delete(unset, field)
+		set[field] = value
+	}
 
 	if updateTranx.BudgetID != nil {
-		transaction.BudgetID = *updateTranx.BudgetID
+		merged.BudgetID = *updateTranx.BudgetID
+		setOrUnset("budget_id", merged.BudgetID, merged.BudgetID == "")
 	}
 
+	currencyID := foundTranx.CurrencyID
 	if updateTranx.CurrencyID != nil {
-		transaction.CurrencyID = *updateTranx.CurrencyID
+		currencyID = *updateTranx.CurrencyID
+		merged.CurrencyID = currencyID
+		set["currency_id"] = currencyID
+		// the amounts are restated in the new currency even when they are NOT changed
+		merged.TransactionCredit = Money{Amount: foundTranx.TransactionCredit.Amount, CurrencyID: currencyID}
+		merged.TransactionDebit = Money{Amount: foundTranx.TransactionDebit.Amount, CurrencyID: currencyID}
+		merged.Splits = withSplitCurrency(foundTranx.Splits, currencyID)
+		merged.Shares = withShareCurrency(foundTranx.Shares, currencyID)
+		setOrUnset("tranx_credit", merged.TransactionCredit, merged.TransactionCredit.IsZero())
+		setOrUnset("tranx_debit", merged.TransactionDebit, merged.TransactionDebit.IsZero())
+		setOrUnset("splits", merged.Splits, len(merged.Splits) == 0)
+		setOrUnset("shares", merged.Shares, len(merged.Shares) == 0)
 	}
 
-	if updateTranx.FinancialAccountID != nil {
-		// take *updateTranx.FinancialAccountID.
-		// convert to []primitive.ObjectID and return
-		// finAcctObjectIDs, err := utility.SliceStringsToObjectIDs(*updateTranx.FinancialAccountID)
-		objectIDs := append(*updateTranx.FinancialAccountID, foundTranx.FinancialAccountID...)
-		uniqueFinAccObjIDs := utility.RemoveDuplicateStringValues(objectIDs)
-		if err != nil {
-			return err
+	if updateTranx.Tags != nil || len(updateTranx.AddTags) > 0 || len(updateTranx.RemoveTags) > 0 {
+		tags := foundTranx.Tags
+		if updateTranx.Tags != nil {
+			tags = *updateTranx.Tags
 		}
+		merged.Tags = editTags(tags, updateTranx.AddTags, updateTranx.RemoveTags)
+		setOrUnset("tags", merged.Tags, len(merged.Tags) == 0)
+	}
+
+	if updateTranx.Splits != nil {
+		merged.Splits = withSplitCurrency(*updateTranx.Splits, currencyID)
+		setOrUnset("splits", merged.Splits, len(merged.Splits) == 0)
+		if len(merged.Splits) > 0 && updateTranx.BudgetID == nil {
+			merged.BudgetID = ""
+			setOrUnset("budget_id", "", true)
+		}
+	}
 
-		transaction.FinancialAccountID = uniqueFinAccObjIDs
+	if updateTranx.FinancialAccountID != nil {
+		// The accounts are replaced; the balances of the old ones are reversed and the new ones applied in applyTransactionUpdate.
+		merged.FinancialAccountID = utility.RemoveDuplicateStringValues(*updateTranx.FinancialAccountID)
+		setOrUnset("fin_acc_id", merged.FinancialAccountID, len(merged.FinancialAccountID) == 0)
 	}
 
-	// if updateTranx.Occurrence != nil {
-	// 	transaction.Occurrence = *updateTranx.Occurrence
-	// }
+	if updateTranx.Occurrence != nil {
+		occurrence, err := ParseOccurrence(*updateTranx.Occurrence, 0)
+		if err != nil {
+			return &apierror.ValidationError{Fields: []apierror.FieldError{{Field: "occurrence", Error: err.Error()}}}
+		}
+		merged.Occurrence = occurrence
+		set["occurrence"] = occurrence
+	}
 
 	if updateTranx.OccurrenceString != nil {
-		transaction.OccurrenceString = *updateTranx.OccurrenceString
+		merged.OccurrenceString = *updateTranx.OccurrenceString
+		setOrUnset("occurrence_string", merged.OccurrenceString, merged.OccurrenceString == "")
 	}
 
 	if updateTranx.TransactionEvent != nil {
-		transaction.TransactionEvent = *updateTranx.TransactionEvent
+		merged.TransactionEvent = *updateTranx.TransactionEvent
+		setOrUnset("tranx_event", merged.TransactionEvent, merged.TransactionEvent == "")
 	}
 
 	if updateTranx.TransactionCredit != nil {
-		transaction.TransactionCredit = *updateTranx.TransactionCredit
+		merged.TransactionCredit = Money{Amount: updateTranx.TransactionCredit.Amount, CurrencyID: currencyID}
+		setOrUnset("tranx_credit", merged.TransactionCredit, merged.TransactionCredit.IsZero())
 	}
 
 	if updateTranx.TransactionDebit != nil {
-		transaction.TransactionDebit = *updateTranx.TransactionDebit
+		merged.TransactionDebit = Money{Amount: updateTranx.TransactionDebit.Amount, CurrencyID: currencyID}
+		setOrUnset("tranx_debit", merged.TransactionDebit, merged.TransactionDebit.IsZero())
 	}
 
 	if updateTranx.VendorID != nil {
-		transaction.VendorID = *updateTranx.VendorID
+		merged.VendorID = *updateTranx.VendorID
+		setOrUnset("vendor_id", merged.VendorID, merged.VendorID == "")
 	}
 
 	if updateTranx.ParticipantID != nil {
-		// participantObjectIDs, err := utility.SliceStringsToObjectIDs(*updateTranx.ParticipantID)
-		objectIDs := append(*updateTranx.ParticipantID, foundTranx.ParticipantID...)
-		uniquePartObjIDs := utility.RemoveDuplicateStringValues(objectIDs)
+		merged.ParticipantID = utility.RemoveDuplicateStringValues(*updateTranx.ParticipantID)
+	}
+
+	if updateTranx.PaidBy != nil {
+		merged.PaidBy = *updateTranx.PaidBy
+	}
+
+	if updateTranx.Shares != nil {
+		merged.Shares = withShareCurrency(*updateTranx.Shares, currencyID)
+	}
+
+	if merged.PaidBy == "" {
+		// an expense nobody paid for is NOT shared
+		merged.Shares = nil
+	}
+
+	// the payer and the participants of the shares stay among the participants
+	withShareParticipants(&merged)
+
+	if updateTranx.PaidBy != nil || updateTranx.Shares != nil || updateTranx.ParticipantID != nil {
+		setOrUnset("paid_by", merged.PaidBy, merged.PaidBy == "")
+		setOrUnset("shares", merged.Shares, len(merged.Shares) == 0)
+		setOrUnset("participant_id", merged.ParticipantID, len(merged.ParticipantID) == 0)
+	}
+
+	// Only the references sent by the client are checked, so older transactions with dangling references can still be edited.
+	refs := Transaction{}
+	if updateTranx.BudgetID != nil {
+		refs.BudgetID = merged.BudgetID
+	}
+	if updateTranx.VendorID != nil {
+		refs.VendorID = merged.VendorID
+	}
+	if updateTranx.FinancialAccountID != nil || updateTranx.CurrencyID != nil {
+		// the accounts and the currency are checked together, as they must match
+		refs.CurrencyID = merged.CurrencyID
+		refs.FinancialAccountID = merged.FinancialAccountID
+	}
+	if updateTranx.ParticipantID != nil {
+		refs.ParticipantID = merged.ParticipantID
+	}
+	if updateTranx.Splits != nil {
+		refs.Splits = merged.Splits
+	}
+	if updateTranx.PaidBy != nil || updateTranx.Shares != nil {
+		refs.PaidBy = merged.PaidBy
+		refs.Shares = merged.Shares
+	}
+
+	verr := apierror.ValidationError{}
+
+	checkSplits(merged, &verr)
+	checkShares(merged, &verr)
+
+	if err := checkReferences(ctx, db, refs, &verr); err != nil {
+		return err
+	}
+
+	if err := verr.Err(); err != nil {
+		return err
+	}
+
+	set["updated_at"] = now
+
+	updateTransaction := bson.M{
+		"$set": set,
+	}
+	if len(unset) > 0 {
+		updateTransaction["$unset"] = unset
+	}
+
+	err = withTransaction(ctx, db, func(sc mongo.SessionContext) error {
+
+		// The transaction is read again inside the session, it may have been reconciled since it was found.
+		oldTranx, err := RetrieveTransaction(sc, tranxCollection, tranxID)
 		if err != nil {
 			return err
 		}
-		transaction.ParticipantID = uniquePartObjIDs
+
+		if oldTranx.TransferID != "" {
+			return &apierror.ValidationError{Fields: []apierror.FieldError{{Field: "transfer_id", Error: "both transactions of a transfer are changed together with PUT /v1/transfers/{_id}"}}}
+		}
+
+		return applyTransactionUpdate(sc, db, tranxID, updateTransaction, now)
+	})
+	if err != nil {
+		return err
 	}
 
-	transaction.ID = tObjectID
+	// The budgets the transaction left are checked too, as a credit leaving a budget uses more of it.
+	if updatedTranx, err := RetrieveTransaction(ctx, tranxCollection, tranxID); err == nil {
+		alertBudgets(ctx, db, now, *foundTranx, *updatedTranx)
+	}
 
-	transaction.UpdatedAt = now
+	return nil
+}
 
-	updateTransaction := bson.M{
-		"$set": transaction,
+// applyTransactionUpdate applies an update document to the transaction identified by tranxID.
+// The effect of the old transaction on its financial accounts is reversed and the effect of the modified one applied.
+// A reconciled transaction is NOT changed and apierror.ErrLocked is returned. It must be called inside a MongoDB transaction.
+func applyTransactionUpdate(sc mongo.SessionContext, db *mongo.Database, tranxID string, update bson.M, now time.Time) error {
+
+	tranxCollection := db.Collection(TransactionCollection)
+
+	// Read the transaction again inside the session so a concurrent write can NOT be reversed twice.
+	oldTranx, err := RetrieveTransaction(sc, tranxCollection, tranxID)
+	if err != nil {
+		return err
+	}
+
+	if err := checkUnlocked(*oldTranx); err != nil {
+		return err
 	}
 
-	tranxResult, err := db.UpdateOne(ctx, bson.M{"_id": tObjectID}, updateTransaction)
+	tranxResult, err := tranxCollection.UpdateOne(sc, bson.M{"_id": oldTranx.ID}, update)
 	if err != nil {
 		return errors.Wrap(err, "updating transaction")
 	}
 
 	fmt.Printf("tranxResult updated %v : \n", tranxResult)
 
-	return nil
+	newTranx, err := RetrieveTransaction(sc, tranxCollection, tranxID)
+	if err != nil {
+		return err
+	}
+
+	if err := applyBalance(sc, db, oldTranx.FinancialAccountID, balanceDelta(*oldTranx).Neg(), now); err != nil {
+		return err
+	}
+
+	return applyBalance(sc, db, newTranx.FinancialAccountID, balanceDelta(*newTranx), now)
 }
 
 // DeleteTransaction removes the transaction identified by a given _id
-func DeleteTransaction(ctx context.Context, db *mongo.Collection, user auth.Claims, tranxID string) error {
+// The effect of the transaction on its financial accounts is reversed.
+// Deleting either transaction of a transfer deletes both. A reconciled transaction is locked until it is unlocked.
+// Once the delete is committed, the attachments of the deleted transactions are removed from store.
+func DeleteTransaction(ctx context.Context, db *mongo.Database, store blob.Store, user auth.Claims, tranxID string, now time.Time) error {
 
 	var isAdmin = user.HasRole(auth.RoleAdmin)
 
@@ -276,24 +591,82 @@ func DeleteTransaction(ctx context.Context, db *mongo.Collection, user auth.Clai
 		return apierror.ErrForbidden
 	}
 
-	tranxObjectID, err := primitive.ObjectIDFromHex(tranxID)
-	if err != nil {
+	if _, err := primitive.ObjectIDFromHex(tranxID); err != nil {
 		return apierror.ErrInvalidID
 	}
 
-	foundTranx, err := RetrieveTransaction(ctx, db, tranxID)
+	tranxCollection := db.Collection(TransactionCollection)
+
+	foundTranx, err := RetrieveTransaction(ctx, tranxCollection, tranxID)
 	if err != nil {
 		return apierror.ErrNotFound
 	}
 
 	fmt.Printf("transaction to delelete found %+v : \n", foundTranx)
 
-	result, err := db.DeleteOne(ctx, bson.M{"_id": tranxObjectID})
+	var deleted []string
+
+	err = withTransaction(ctx, db, func(sc mongo.SessionContext) error {
+
+		// The session may run more than once, so only the last run counts.
+		deleted = nil
+
+		oldTranx, err := RetrieveTransaction(sc, tranxCollection, tranxID)
+		if err != nil {
+			return err
+		}
+
+		if err := checkUnlocked(*oldTranx); err != nil {
+			return err
+		}
+
+		if err := removeTransaction(sc, db, *oldTranx, now); err != nil {
+			return err
+		}
+		deleted = append(deleted, tranxID)
+
+		if oldTranx.TransferID == "" {
+			return nil
+		}
+
+		// The other transaction of a transfer goes too, unless it is already gone.
+		otherTranx, err := RetrieveTransaction(sc, tranxCollection, oldTranx.TransferID)
+		if err != nil {
+			return nil
+		}
+
+		if err := checkUnlocked(*otherTranx); err != nil {
+			return err
+		}
+
+		if err := removeTransaction(sc, db, *otherTranx, now); err != nil {
+			return err
+		}
+		deleted = append(deleted, oldTranx.TransferID)
+
+		return nil
+	})
 	if err != nil {
-		return errors.Wrapf(err, "deleting transaction %s", tranxID)
+		return err
 	}
 
-	fmt.Print("result of deleting : ", result)
+	removeTransactionAttachments(ctx, db, store, deleted...)
+
+	alertBudgets(ctx, db, now, *foundTranx)
 
 	return nil
 }
+
+// removeTransaction deletes a transaction and reverses its effect on its financial accounts.
+// It must be called inside a MongoDB transaction.
+func removeTransaction(sc mongo.SessionContext, db *mongo.Database, tranx Transaction, now time.Time) error {
+
+	result, err := db.Collection(TransactionCollection).DeleteOne(sc, bson.M{"_id": tranx.ID})
+	if err != nil {
+		return errors.Wrapf(err, "deleting transaction %s", tranx.ID.Hex())
+	}
+
+	fmt.Print("result of deleting : ", result)
+
+	return applyBalance(sc, db, tranx.FinancialAccountID, balanceDelta(tranx).Neg(), now)
+}
@@ -0,0 +1,130 @@
+package budget_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/google/go-cmp/cmp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFilterTransactionQuery(t *testing.T) {
+	min, err := budget.ParseMoney("10.00", "")
+	if err != nil {
+		t.Fatalf("parsing amount: %v", err)
+	}
+	from := time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	filter := budget.FilterTransaction{
+		BudgetID:           "5f3e189bd95d06627dc8e931",
+		FinancialAccountID: "5f3e16a8d95d06627dc8e928",
+		TransactionEvent:   "movies (2020)",
+		DebitMin:           &min,
+		CreatedFrom:        &from,
+	}
+
+	want := bson.M{
+		"$or":                []bson.M{{"budget_id": "5f3e189bd95d06627dc8e931"}, {"splits.budget_id": "5f3e189bd95d06627dc8e931"}},
+		"fin_acc_id":         "5f3e16a8d95d06627dc8e928",
+		"tranx_event":        primitive.Regex{Pattern: `movies \(2020\)`, Options: "i"},
+		"tranx_debit.amount": bson.M{"$gte": min.Amount},
+		"created_at":         bson.M{"$gte": from},
+	}
+
+	decimals := cmp.Comparer(func(a, b primitive.Decimal128) bool { return a.String() == b.String() })
+
+	if diff := cmp.Diff(want, filter.Query(), decimals); diff != "" {
+		t.Fatalf("query did not match expected. Diff:\n%s", diff)
+	}
+
+	if got := (budget.FilterTransaction{}).Query(); len(got) != 0 {
+		t.Fatalf("expected empty filter to match everything, got %v", got)
+	}
+}
+
+func TestFilterTransactionBefore(t *testing.T) {
+	from := time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2020, time.March, 31, 12, 0, 0, 0, time.UTC)
+	before := time.Date(2020, time.April, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		filter budget.FilterTransaction
+		want   bson.M
+	}{
+		{"before only", budget.FilterTransaction{CreatedBefore: &before}, bson.M{"$lt": before}},
+		{"from and before", budget.FilterTransaction{CreatedFrom: &from, CreatedBefore: &before}, bson.M{"$gte": from, "$lt": before}},
+		{"to", budget.FilterTransaction{CreatedFrom: &from, CreatedTo: &to}, bson.M{"$gte": from, "$lte": to}},
+	}
+
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.want, tt.filter.Query()["created_at"]); diff != "" {
+			t.Fatalf("%s: created_at condition did not match expected. Diff:\n%s", tt.name, diff)
+		}
+	}
+
+	filter := budget.FilterTransaction{OccurrenceBefore: &before, UpdatedBefore: &before}
+	for _, field := range []string{"occurrence", "updated_at"} {
+		if diff := cmp.Diff(bson.M{"$lt": before}, filter.Query()[field]); diff != "" {
+			t.Fatalf("%s condition did not match expected. Diff:\n%s", field, diff)
+		}
+	}
+}
+
+func TestFilterTransactionLocked(t *testing.T) {
+	locked, unlocked := true, false
+
+	tests := []struct {
+		name   string
+		filter budget.FilterTransaction
+		want   interface{}
+	}{
+		{"reconciled only", budget.FilterTransaction{Locked: &locked}, bson.M{"$exists": true}},
+		{"not reconciled", budget.FilterTransaction{VendorID: "5f3e18f8d95d06627dc8e990", Locked: &unlocked}, bson.M{"$exists": false}},
+	}
+
+	for _, tt := range tests {
+		got, ok := tt.filter.Query()["reconciled_in"]
+		if !ok {
+			t.Fatalf("%s: expected a reconciled_in condition", tt.name)
+		}
+
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Fatalf("%s: reconciled_in condition did not match expected. Diff:\n%s", tt.name, diff)
+		}
+	}
+
+	if _, ok := (budget.FilterTransaction{}).Query()["reconciled_in"]; ok {
+		t.Fatalf("expected reconciled and unreconciled transactions to match without locked")
+	}
+}
+
+func TestFilterTransactionTags(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter budget.FilterTransaction
+		want   interface{}
+	}{
+		{"one tag", budget.FilterTransaction{Tags: []string{" Vacation-2026 "}}, "vacation-2026"},
+		{"every tag", budget.FilterTransaction{Tags: []string{"food", "vacation-2026", "FOOD"}}, bson.M{"$all": []string{"food", "vacation-2026"}}},
+		{"any tag", budget.FilterTransaction{AnyTags: []string{"gift", "", "travel"}}, bson.M{"$in": []string{"gift", "travel"}}},
+		{"every and any", budget.FilterTransaction{Tags: []string{"food"}, AnyTags: []string{"gift"}}, bson.M{"$all": []string{"food"}, "$in": []string{"gift"}}},
+	}
+
+	for _, tt := range tests {
+		got, ok := tt.filter.Query()["tags"]
+		if !ok {
+			t.Fatalf("%s: expected a tags condition", tt.name)
+		}
+
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Fatalf("%s: tags condition did not match expected. Diff:\n%s", tt.name, diff)
+		}
+	}
+
+	if _, ok := (budget.FilterTransaction{Tags: []string{" "}}).Query()["tags"]; ok {
+		t.Fatalf("expected blank tags to be left out of the query")
+	}
+}
@@ -0,0 +1,279 @@
+package budget
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/blob"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CreateTransfer stores the two transactions of a transfer in one MongoDB transaction.
+// The from account is debited and the to account credited by the amount, so their balances move together.
+func CreateTransfer(ctx context.Context, db *mongo.Database, user auth.Claims, newTransfer NewTransfer, now time.Time) (*Transfer, error) {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return nil, apierror.ErrForbidden
+	}
+
+	verr := apierror.ValidationError{}
+
+	if newTransfer.FromAccountID == newTransfer.ToAccountID {
+		verr.Add("to_fin_acc_id", "must be another financial account than from_fin_acc_id")
+	}
+
+	if newTransfer.Amount.Sign() <= 0 {
+		verr.Add("amount", "amount must be more than 0")
+	}
+
+	occurrence := now.UTC()
+	if newTransfer.Occurrence != "" {
+		t, err := ParseOccurrence(newTransfer.Occurrence, 0)
+		if err != nil {
+			verr.Add("occurrence", err.Error())
+		}
+		occurrence = t
+	}
+
+	amount := Money{Amount: newTransfer.Amount.Amount, CurrencyID: newTransfer.CurrencyID}
+
+	from := Transaction{
+		ID:                 primitive.NewObjectID(),
+		CurrencyID:         newTransfer.CurrencyID,
+		FinancialAccountID: []string{newTransfer.FromAccountID},
+		Occurrence:         occurrence,
+		TransactionEvent:   newTransfer.TransactionEvent,
+		TransactionDebit:   amount,
+		CreatedAt:          now.UTC(),
+		UpdatedAt:          now.UTC(),
+	}
+
+	to := Transaction{
+		ID:                 primitive.NewObjectID(),
+		CurrencyID:         newTransfer.CurrencyID,
+		FinancialAccountID: []string{newTransfer.ToAccountID},
+		Occurrence:         occurrence,
+		TransactionEvent:   newTransfer.TransactionEvent,
+		TransactionCredit:  amount,
+		CreatedAt:          now.UTC(),
+		UpdatedAt:          now.UTC(),
+	}
+
+	from.TransferID = to.ID.Hex()
+	to.TransferID = from.ID.Hex()
+
+	// the accounts are checked against the transfer below, so errors name the account they are about
+	if err := checkReferences(ctx, db, Transaction{CurrencyID: newTransfer.CurrencyID}, &verr); err != nil {
+		return nil, err
+	}
+
+	accounts, err := retrieveAccounts(ctx, db, []string{newTransfer.FromAccountID, newTransfer.ToAccountID})
+	if err != nil {
+		return nil, err
+	}
+
+	checkTransferAccounts(newTransfer, accounts, &verr)
+
+	if err := verr.Err(); err != nil {
+		return nil, err
+	}
+
+	err = withTransaction(ctx, db, func(sc mongo.SessionContext) error {
+
+		if err := insertTransaction(sc, db, from, now); err != nil {
+			return err
+		}
+
+		return insertTransaction(sc, db, to, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Transfer{From: from, To: to}, nil
+}
+
+// checkTransferAccounts records a validation problem for an account of the transfer that is NOT one of the accounts found,
+// or that is in another currency than the transfer. Without a currency the accounts must be in the same one.
+func checkTransferAccounts(newTransfer NewTransfer, accounts []FinancialAccount, verr *apierror.ValidationError) {
+
+	byID := map[string]FinancialAccount{}
+	for _, fa := range accounts {
+		byID[fa.ID.Hex()] = fa
+	}
+
+	sides := []struct {
+		field string
+		id    string
+	}{
+		{"from_fin_acc_id", newTransfer.FromAccountID},
+		{"to_fin_acc_id", newTransfer.ToAccountID},
+	}
+
+	for _, side := range sides {
+
+		fa, ok := byID[side.id]
+		if !ok {
+			verr.Add(side.field, fmt.Sprintf("%q does NOT reference an existing financial account", side.id))
+			continue
+		}
+
+		if newTransfer.CurrencyID != "" && len(currencyMismatches(newTransfer.CurrencyID, []FinancialAccount{fa})) > 0 {
+			verr.Add(side.field, fmt.Sprintf("%q is in currency %q, NOT %q", side.id, fa.CurrencyID, newTransfer.CurrencyID))
+		}
+	}
+
+	from, to := byID[newTransfer.FromAccountID], byID[newTransfer.ToAccountID]
+	if newTransfer.CurrencyID == "" && from.CurrencyID != "" && to.CurrencyID != "" && from.CurrencyID != to.CurrencyID {
+		verr.Add("to_fin_acc_id", fmt.Sprintf("%q is in currency %q, NOT %q like from_fin_acc_id", newTransfer.ToAccountID, to.CurrencyID, from.CurrencyID))
+	}
+}
+
+// RetrieveTransfer finds the transfer that the transaction identified by tranxID is part of.
+// Either transaction of the transfer may be given.
+func RetrieveTransfer(ctx context.Context, db *mongo.Collection, tranxID string) (*Transfer, error) {
+
+	tranx, err := RetrieveTransaction(ctx, db, tranxID)
+	if err != nil {
+		return nil, err
+	}
+
+	if tranx.TransferID == "" {
+		return nil, apierror.ErrNotFound
+	}
+
+	other, err := RetrieveTransaction(ctx, db, tranx.TransferID)
+	if err != nil {
+		return nil, apierror.ErrNotFound
+	}
+
+	if tranx.TransactionDebit.IsZero() {
+		return &Transfer{From: *other, To: *tranx}, nil
+	}
+
+	return &Transfer{From: *tranx, To: *other}, nil
+}
+
+// UpdateOneTransfer changes both transactions of the transfer that the transaction identified by tranxID is part of.
+// The balances of both accounts follow the new amount.
+func UpdateOneTransfer(ctx context.Context, db *mongo.Database, user auth.Claims, tranxID string, updateTransfer UpdateTransfer, now time.Time) error {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return apierror.ErrForbidden
+	}
+
+	transfer, err := RetrieveTransfer(ctx, db.Collection(TransactionCollection), tranxID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("transfer to update found %+v : \n", transfer)
+
+	if err := checkUnlocked(transfer.From, transfer.To); err != nil {
+		return err
+	}
+
+	verr := apierror.ValidationError{}
+
+	common := bson.M{"updated_at": now.UTC()}
+
+	if updateTransfer.Occurrence != nil {
+		occurrence, err := ParseOccurrence(*updateTransfer.Occurrence, 0)
+		if err != nil {
+			verr.Add("occurrence", err.Error())
+		}
+		common["occurrence"] = occurrence
+	}
+
+	if updateTransfer.TransactionEvent != nil {
+		common["tranx_event"] = *updateTransfer.TransactionEvent
+	}
+
+	fromSet := bson.M{}
+	toSet := bson.M{}
+	for k, v := range common {
+		fromSet[k] = v
+		toSet[k] = v
+	}
+
+	if updateTransfer.Amount != nil {
+		if updateTransfer.Amount.Sign() <= 0 {
+			verr.Add("amount", "amount must be more than 0")
+		}
+		fromSet["tranx_debit"] = Money{Amount: updateTransfer.Amount.Amount, CurrencyID: transfer.From.CurrencyID}
+		toSet["tranx_credit"] = Money{Amount: updateTransfer.Amount.Amount, CurrencyID: transfer.To.CurrencyID}
+	}
+
+	if err := verr.Err(); err != nil {
+		return err
+	}
+
+	return withTransaction(ctx, db, func(sc mongo.SessionContext) error {
+
+		if err := applyTransactionUpdate(sc, db, transfer.From.ID.Hex(), bson.M{"$set": fromSet}, now); err != nil {
+			return err
+		}
+
+		return applyTransactionUpdate(sc, db, transfer.To.ID.Hex(), bson.M{"$set": toSet}, now)
+	})
+}
+
+// DeleteTransfer removes both transactions of the transfer that the transaction identified by tranxID is part of,
+// reversing their effect on the balances of both accounts. The attachments of both transactions are removed from store afterwards.
+func DeleteTransfer(ctx context.Context, db *mongo.Database, store blob.Store, user auth.Claims, tranxID string, now time.Time) error {
+
+	var isAdmin = user.HasRole(auth.RoleAdmin)
+
+	if !isAdmin {
+		return apierror.ErrForbidden
+	}
+
+	tranxCollection := db.Collection(TransactionCollection)
+
+	if _, err := RetrieveTransfer(ctx, tranxCollection, tranxID); err != nil {
+		return err
+	}
+
+	var deleted []string
+
+	err := withTransaction(ctx, db, func(sc mongo.SessionContext) error {
+
+		// Read the transfer again inside the session so a concurrent delete can NOT be reversed twice.
+		transfer, err := RetrieveTransfer(sc, tranxCollection, tranxID)
+		if err != nil {
+			return err
+		}
+
+		if err := checkUnlocked(transfer.From, transfer.To); err != nil {
+			return err
+		}
+
+		if err := removeTransaction(sc, db, transfer.From, now); err != nil {
+			return err
+		}
+
+		if err := removeTransaction(sc, db, transfer.To, now); err != nil {
+			return err
+		}
+
+		deleted = []string{transfer.From.ID.Hex(), transfer.To.ID.Hex()}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	removeTransactionAttachments(ctx, db, store, deleted...)
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package budget_test
+
+import (
+	"testing"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+	"github.com/google/go-cmp/cmp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCheckTransferAccounts(t *testing.T) {
+	usd, eur := "5f3e1d5cd95d06627dc8e950", "5f3e1d5cd95d06627dc8e951"
+
+	checking := budget.FinancialAccount{ID: primitive.NewObjectID(), CurrencyID: usd}
+	savings := budget.FinancialAccount{ID: primitive.NewObjectID(), CurrencyID: usd}
+	euro := budget.FinancialAccount{ID: primitive.NewObjectID(), CurrencyID: eur}
+	cash := budget.FinancialAccount{ID: primitive.NewObjectID()}
+	accounts := []budget.FinancialAccount{checking, savings, euro, cash}
+
+	missing := primitive.NewObjectID().Hex()
+
+	tests := []struct {
+		name     string
+		transfer budget.NewTransfer
+		want     []string // fields with a problem
+	}{
+		{"same currency", budget.NewTransfer{FromAccountID: checking.ID.Hex(), ToAccountID: savings.ID.Hex(), CurrencyID: usd}, nil},
+		{"account without a currency", budget.NewTransfer{FromAccountID: checking.ID.Hex(), ToAccountID: cash.ID.Hex(), CurrencyID: usd}, nil},
+		{"to in another currency", budget.NewTransfer{FromAccountID: checking.ID.Hex(), ToAccountID: euro.ID.Hex(), CurrencyID: usd}, []string{"to_fin_acc_id"}},
+		{"from in another currency", budget.NewTransfer{FromAccountID: euro.ID.Hex(), ToAccountID: savings.ID.Hex(), CurrencyID: usd}, []string{"from_fin_acc_id"}},
+		{"both in another currency", budget.NewTransfer{FromAccountID: checking.ID.Hex(), ToAccountID: savings.ID.Hex(), CurrencyID: eur}, []string{"from_fin_acc_id", "to_fin_acc_id"}},
+		{"no currency, same account currency", budget.NewTransfer{FromAccountID: checking.ID.Hex(), ToAccountID: savings.ID.Hex()}, nil},
+		{"no currency, accounts differ", budget.NewTransfer{FromAccountID: checking.ID.Hex(), ToAccountID: euro.ID.Hex()}, []string{"to_fin_acc_id"}},
+		{"missing account", budget.NewTransfer{FromAccountID: missing, ToAccountID: savings.ID.Hex(), CurrencyID: usd}, []string{"from_fin_acc_id"}},
+	}
+
+	for _, tt := range tests {
+		verr := apierror.ValidationError{}
+		budget.CheckTransferAccounts(tt.transfer, accounts, &verr)
+
+		var got []string
+		for _, f := range verr.Fields {
+			got = append(got, f.Field)
+		}
+
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Fatalf("%s: fields with a problem did not match expected. Diff:\n%s", tt.name, diff)
+		}
+	}
+}
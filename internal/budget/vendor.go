@@ -3,10 +3,12 @@ package budget
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
 	"github.com/dapperAuteur/dashboard-go-api/internal/utility"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,23 +17,25 @@ import (
 )
 
 // ListVendors gets all the Vendors from the db then encodes them in a response client
-func ListVendors(ctx context.Context, db *mongo.Collection) ([]Vendor, error) {
+// Results are returned one page at a time.
+func ListVendors(ctx context.Context, db *mongo.Collection, page database.Page) ([]Vendor, *database.PageInfo, error) {
 
 	list := []Vendor{}
 
-	cursor, err := db.Find(ctx, bson.M{})
+	info, err := database.FindPage(ctx, db, bson.M{}, page, &list)
 	if err != nil {
-		return nil, errors.Wrapf(err, "getting cursor from vendor collection.")
+		return nil, nil, errors.Wrapf(err, "retrieving vendor list")
 	}
 
-	if err = cursor.All(ctx, &list); err != nil {
-		return nil, errors.Wrapf(err, "retrieving vendor list")
+	if err := setTransactionIDs(ctx, db.Database(), list); err != nil {
+		return nil, nil, err
 	}
 
-	return list, nil
+	return list, info, nil
 }
 
-// CreateVendor takes data from the client to create a vendor in the db
+// CreateVendor takes data from the client to create a vendor in the db.
+// Transactions in tranx_id are assigned to the vendor; ErrLocked is returned when any of them is reconciled.
 func CreateVendor(ctx context.Context, db *mongo.Collection, user auth.Claims, newVendor NewVendor, now time.Time) (*Vendor, error) {
 
 	var isAdmin = user.HasRole(auth.RoleAdmin)
@@ -54,20 +58,33 @@ func CreateVendor(ctx context.Context, db *mongo.Collection, user auth.Claims, n
 		tranxStringIDs = utility.RemoveDuplicateStringValues(tranxIDs)
 	}
 
+	if err := checkTransactionIDs(ctx, db.Database(), tranxStringIDs); err != nil {
+		return nil, err
+	}
+
 	vendor := Vendor{
+		ID:             primitive.NewObjectID(),
 		VendorName:     newVendor.VendorName,
 		TransactionIDs: tranxStringIDs,
 		CreatedAt:      now.UTC(),
 		UpdatedAt:      now.UTC(),
 	}
 
-	vResult, err := db.InsertOne(ctx, vendor)
+	err := withTransaction(ctx, db.Database(), func(sc mongo.SessionContext) error {
+
+		vResult, err := db.InsertOne(sc, vendor)
+		if err != nil {
+			return errors.Wrapf(err, "inserting vendor : %v", vendor)
+		}
+
+		fmt.Println("vResult : ", vResult)
+
+		return assignTransactions(sc, db.Database(), vendor.ID.Hex(), tranxStringIDs, now)
+	})
 	if err != nil {
-		return nil, errors.Wrapf(err, "inserting vendor : %v", vendor)
+		return nil, err
 	}
 
-	fmt.Println("vResult : ", vResult)
-
 	return &vendor, nil
 }
 
@@ -85,11 +102,17 @@ func RetrieveVendor(ctx context.Context, db *mongo.Collection, _id string) (*Ven
 		return nil, apierror.ErrNotFound
 	}
 
-	return &vendor, nil
+	list := []Vendor{vendor}
+	if err := setTransactionIDs(ctx, db.Database(), list); err != nil {
+		return nil, err
+	}
+
+	return &list[0], nil
 }
 
 // UpdateOneVendor modifies data about a vendor.
 // It will error if the specified _id is invalid or does NOT reference an existing vendor.
+// Transactions in tranx_id are assigned to the vendor; ErrLocked is returned when any of them is reconciled.
 func UpdateOneVendor(ctx context.Context, db *mongo.Collection, user auth.Claims, vID string, updateVendor UpdateVendor, now time.Time) error {
 
 	var isAdmin = user.HasRole(auth.RoleAdmin)
@@ -116,16 +139,14 @@ func UpdateOneVendor(ctx context.Context, db *mongo.Collection, user auth.Claims
 		vendor.VendorName = *updateVendor.VendorName
 	}
 
+	var assigned []string
 	if updateVendor.TransactionIDs != nil {
-		// take *updateVendor.TransactionIDs
-		// convert to []primitive.ObjectID and return
-		// vendorObjIDs, err := utility.SliceStringsToObjectIDs(*updateVendor.TransactionIDs)
-		// if err != nil {
-		// 	return err
-		// }
-		stringIDs := append(*updateVendor.TransactionIDs, foundVendor.TransactionIDs...)
-		uniqueVendorTranxStringIDs := utility.RemoveDuplicateStringValues(stringIDs)
-		vendor.TransactionIDs = uniqueVendorTranxStringIDs
+		// Transactions already assigned to the vendor keep their vendor_id, so only the new ones are set.
+		assigned = utility.RemoveDuplicateStringValues(*updateVendor.TransactionIDs)
+	}
+
+	if err := checkTransactionIDs(ctx, db.Database(), assigned); err != nil {
+		return err
 	}
 
 	vendor.ID = vObjectID
@@ -136,18 +157,22 @@ func UpdateOneVendor(ctx context.Context, db *mongo.Collection, user auth.Claims
 		"$set": vendor,
 	}
 
-	vResult, err := db.UpdateOne(ctx, bson.M{"_id": vObjectID}, updateV)
-	if err != nil {
-		return errors.Wrap(err, "updating vendor")
-	}
+	return withTransaction(ctx, db.Database(), func(sc mongo.SessionContext) error {
 
-	fmt.Printf("vResult updated %v : \n", vResult)
+		vResult, err := db.UpdateOne(sc, bson.M{"_id": vObjectID}, updateV)
+		if err != nil {
+			return errors.Wrap(err, "updating vendor")
+		}
 
-	return nil
+		fmt.Printf("vResult updated %v : \n", vResult)
+
+		return assignTransactions(sc, db.Database(), vID, assigned, now)
+	})
 }
 
 // DeleteVendor removes the vendor identified by a given _id
-func DeleteVendor(ctx context.Context, db *mongo.Collection, user auth.Claims, vendorID string) error {
+// Transactions of the vendor block the delete unless the rule cascades or reassigns them.
+func DeleteVendor(ctx context.Context, db *mongo.Database, user auth.Claims, vendorID string, rule DeleteRule, now time.Time) error {
 
 	var isAdmin = user.HasRole(auth.RoleAdmin)
 
@@ -160,19 +185,124 @@ func DeleteVendor(ctx context.Context, db *mongo.Collection, user auth.Claims, v
 		return apierror.ErrInvalidID
 	}
 
-	foundVendor, err := RetrieveVendor(ctx, db, vendorID)
+	foundVendor, err := RetrieveVendor(ctx, db.Collection(VendorCollection), vendorID)
 	if err != nil {
 		return apierror.ErrNotFound
 	}
 
 	fmt.Printf("vendor to delelete found %+v : \n", foundVendor)
 
-	result, err := db.DeleteOne(ctx, bson.M{"_id": vObjectID})
+	return withTransaction(ctx, db, func(sc mongo.SessionContext) error {
+
+		if err := resolveDependents(sc, db, vendorRef, vendorID, rule, now); err != nil {
+			return err
+		}
+
+		result, err := db.Collection(VendorCollection).DeleteOne(sc, bson.M{"_id": vObjectID})
+		if err != nil {
+			return errors.Wrapf(err, "deleting vendor %s", vendorID)
+		}
+
+		fmt.Print("result of deleting : ", result)
+
+		return nil
+	})
+}
+
+// setTransactionIDs fills in the TransactionIDs of each vendor from the vendor_id of the transactions.
+func setTransactionIDs(ctx context.Context, db *mongo.Database, vendors []Vendor) error {
+
+	if len(vendors) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(vendors))
+	for i, v := range vendors {
+		ids[i] = v.ID.Hex()
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"vendor_id": bson.M{"$in": ids}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      "$vendor_id",
+			"tranx_id": bson.M{"$push": bson.M{"$toString": "$_id"}},
+		}}},
+	}
+
+	cursor, err := db.Collection(TransactionCollection).Aggregate(ctx, pipeline)
 	if err != nil {
-		return errors.Wrapf(err, "deleting vendor %s", vendorID)
+		return errors.Wrap(err, "aggregating vendor transactions")
 	}
 
-	fmt.Print("result of deleting : ", result)
+	var groups []struct {
+		VendorID       string   `bson:"_id"`
+		TransactionIDs []string `bson:"tranx_id"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return errors.Wrap(err, "retrieving vendor transactions")
+	}
+
+	byVendor := make(map[string][]string, len(groups))
+	for _, g := range groups {
+		byVendor[g.VendorID] = g.TransactionIDs
+	}
+
+	for i := range vendors {
+		vendors[i].TransactionIDs = byVendor[vendors[i].ID.Hex()]
+	}
 
 	return nil
 }
+
+// checkTransactionIDs returns a ValidationError when any of the ids is NOT the _id of a transaction.
+func checkTransactionIDs(ctx context.Context, db *mongo.Database, ids []string) error {
+
+	missing, err := missingIDs(ctx, db, TransactionCollection, ids)
+	if err != nil {
+		return err
+	}
+
+	verr := apierror.ValidationError{}
+	for _, id := range missing {
+		verr.Add("tranx_id", fmt.Sprintf("%q does NOT reference an existing transaction", id))
+	}
+
+	return verr.Err()
+}
+
+// assignTransactions sets the vendor of the transactions identified by tranxIDs.
+// ErrLocked is returned when any of them is reconciled.
+func assignTransactions(sc mongo.SessionContext, db *mongo.Database, vendorID string, tranxIDs []string, now time.Time) error {
+
+	if len(tranxIDs) == 0 {
+		return nil
+	}
+
+	objectIDs, err := utility.SliceStringsToObjectIDs(tranxIDs)
+	if err != nil {
+		return apierror.ErrInvalidID
+	}
+
+	filter := bson.M{"_id": bson.M{"$in": objectIDs}}
+	if err := checkUnlockedDependents(sc, db, filter); err != nil {
+		return err
+	}
+
+	update := bson.M{"$set": bson.M{"vendor_id": vendorID, "updated_at": now}}
+
+	result, err := db.Collection(TransactionCollection).UpdateMany(sc, filter, update)
+	if err != nil {
+		return errors.Wrap(err, "assigning transactions to vendor")
+	}
+
+	fmt.Printf("transactions assigned to vendor %s : %d\n", vendorID, result.ModifiedCount)
+
+	return nil
+}
+
+// vendorKey is the form of a vendor name that names are matched on: upper case, with spacing collapsed,
+// so "Whole  Foods " on a statement finds the vendor "WHOLE FOODS".
+func vendorKey(name string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(name), " "))
+}
@@ -0,0 +1,27 @@
+package budget_test
+
+import (
+	"testing"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/budget"
+)
+
+func TestVendorKey(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Whole Foods", "WHOLE FOODS"},
+		{"  whole foods\t", "WHOLE FOODS"},
+		{"Whole   Foods", "WHOLE FOODS"},
+		{"AMC", "AMC"},
+		{"Café Nero", "CAFÉ NERO"},
+		{"   ", ""},
+	}
+
+	for _, tt := range tests {
+		if got := budget.VendorKey(tt.name); got != tt.want {
+			t.Fatalf("%q: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
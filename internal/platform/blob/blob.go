@@ -0,0 +1,26 @@
+// Package blob stores files, like receipts, apart from the documents that describe them.
+package blob
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is used when no blob is stored under an id.
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps blobs under the ids it makes up for them.
+type Store interface {
+
+	// Put stores everything read from r and returns the id of the new blob.
+	// Nothing is kept when reading r fails.
+	Put(ctx context.Context, name string, r io.Reader) (string, error)
+
+	// Open returns a reader of the blob stored under id. It must be closed.
+	Open(ctx context.Context, id string) (io.ReadCloser, error)
+
+	// Delete removes the blob stored under id.
+	Delete(ctx context.Context, id string) error
+}
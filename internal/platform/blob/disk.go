@@ -0,0 +1,106 @@
+package blob
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Disk keeps each blob in a file of a local directory, named by its id.
+type Disk struct {
+	dir string
+}
+
+// NewDisk returns a Store keeping its blobs in dir, which is created when missing.
+func NewDisk(dir string) (*Disk, error) {
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "creating blob directory %q", dir)
+	}
+
+	return &Disk{dir: dir}, nil
+}
+
+// Put stores everything read from r in a new file. The name is NOT used, files are named by id.
+// The content is written to a temporary file first, so a blob is never seen half written.
+func (d *Disk) Put(ctx context.Context, name string, r io.Reader) (string, error) {
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating blob id")
+	}
+	id := hex.EncodeToString(b)
+
+	tmp, err := ioutil.TempFile(d.dir, ".upload-")
+	if err != nil {
+		return "", errors.Wrap(err, "creating blob file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return "", errors.Wrap(err, "writing blob file")
+	}
+
+	if err := tmp.Close(); err != nil {
+		return "", errors.Wrap(err, "writing blob file")
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(d.dir, id)); err != nil {
+		return "", errors.Wrap(err, "storing blob file")
+	}
+
+	return id, nil
+}
+
+// Open returns the file of the blob stored under id.
+func (d *Disk) Open(ctx context.Context, id string) (io.ReadCloser, error) {
+
+	path, err := d.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening blob %s", id)
+	}
+
+	return f, nil
+}
+
+// Delete removes the file of the blob stored under id.
+func (d *Disk) Delete(ctx context.Context, id string) error {
+
+	path, err := d.path(id)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+
+	return errors.Wrapf(err, "deleting blob %s", id)
+}
+
+// path returns the file of the blob stored under id.
+// Only ids made by Put are accepted, so an id can NOT name a file outside of the directory.
+func (d *Disk) path(id string) (string, error) {
+
+	if b, err := hex.DecodeString(id); err != nil || len(b) != 16 {
+		return "", ErrNotFound
+	}
+
+	return filepath.Join(d.dir, id), nil
+}
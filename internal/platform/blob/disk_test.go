@@ -0,0 +1,102 @@
+package blob_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/blob"
+	"github.com/pkg/errors"
+)
+
+// disk returns a Disk store in a new directory that is removed when the test ends.
+func disk(t *testing.T) (*blob.Disk, string) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "blob")
+	if err != nil {
+		t.Fatalf("creating directory: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	d, err := blob.NewDisk(dir)
+	if err != nil {
+		t.Fatalf("opening disk store: %v", err)
+	}
+
+	return d, dir
+}
+
+func TestDisk(t *testing.T) {
+	ctx := context.Background()
+	d, _ := disk(t)
+
+	id, err := d.Put(ctx, "receipt.txt", strings.NewReader("milk 2.50"))
+	if err != nil {
+		t.Fatalf("storing blob: %v", err)
+	}
+
+	rc, err := d.Open(ctx, id)
+	if err != nil {
+		t.Fatalf("opening blob: %v", err)
+	}
+	content, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("reading blob: %v", err)
+	}
+	if string(content) != "milk 2.50" {
+		t.Fatalf("expected the content stored, got %q", content)
+	}
+
+	if err := d.Delete(ctx, id); err != nil {
+		t.Fatalf("deleting blob: %v", err)
+	}
+	if _, err := d.Open(ctx, id); err != blob.ErrNotFound {
+		t.Fatalf("expected ErrNotFound once deleted, got %v", err)
+	}
+	if err := d.Delete(ctx, id); err != blob.ErrNotFound {
+		t.Fatalf("expected ErrNotFound deleting twice, got %v", err)
+	}
+}
+
+func TestDiskRejectsOtherFiles(t *testing.T) {
+	ctx := context.Background()
+	d, _ := disk(t)
+
+	for _, id := range []string{"", "../secret", "/etc/passwd", "0123456789abcdef"} {
+		if _, err := d.Open(ctx, id); err != blob.ErrNotFound {
+			t.Fatalf("%q: expected ErrNotFound, got %v", id, err)
+		}
+	}
+}
+
+// failingReader returns some content and then an error.
+type failingReader struct{ read bool }
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.read {
+		return 0, errors.New("connection reset")
+	}
+	r.read = true
+	return copy(p, "partial"), nil
+}
+
+func TestDiskPutKeepsNothingOnError(t *testing.T) {
+	ctx := context.Background()
+	d, dir := disk(t)
+
+	if _, err := d.Put(ctx, "receipt.txt", &failingReader{}); err == nil {
+		t.Fatalf("expected the error of the reader")
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("reading directory: %v", err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected no files to be left, got %d", len(files))
+	}
+}
@@ -0,0 +1,99 @@
+package blob
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GridFS keeps blobs in a MongoDB GridFS bucket, next to the documents that describe them.
+type GridFS struct {
+	bucket *gridfs.Bucket
+}
+
+// NewGridFS returns a Store using the GridFS bucket of the database with the given name.
+func NewGridFS(db *mongo.Database, bucketName string) (*GridFS, error) {
+
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(bucketName))
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening gridfs bucket %q", bucketName)
+	}
+
+	return &GridFS{bucket: bucket}, nil
+}
+
+// Put stores everything read from r as a GridFS file with the given name.
+func (g *GridFS) Put(ctx context.Context, name string, r io.Reader) (string, error) {
+
+	id := primitive.NewObjectID()
+
+	us, err := g.bucket.OpenUploadStreamWithID(id, name)
+	if err != nil {
+		return "", errors.Wrap(err, "opening gridfs upload")
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := us.SetWriteDeadline(deadline); err != nil {
+			_ = us.Abort()
+			return "", errors.Wrap(err, "setting gridfs upload deadline")
+		}
+	}
+
+	if _, err := io.Copy(us, r); err != nil {
+		_ = us.Abort()
+		return "", errors.Wrap(err, "uploading to gridfs")
+	}
+
+	if err := us.Close(); err != nil {
+		return "", errors.Wrap(err, "finishing gridfs upload")
+	}
+
+	return id.Hex(), nil
+}
+
+// Open returns a reader of the GridFS file stored under id.
+func (g *GridFS) Open(ctx context.Context, id string) (io.ReadCloser, error) {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	ds, err := g.bucket.OpenDownloadStream(objectID)
+	if err == gridfs.ErrFileNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening gridfs file %s", id)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := ds.SetReadDeadline(deadline); err != nil {
+			ds.Close()
+			return nil, errors.Wrap(err, "setting gridfs download deadline")
+		}
+	}
+
+	return ds, nil
+}
+
+// Delete removes the GridFS file stored under id and its chunks.
+func (g *GridFS) Delete(ctx context.Context, id string) error {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	err = g.bucket.Delete(objectID)
+	if err == gridfs.ErrFileNotFound {
+		return ErrNotFound
+	}
+
+	return errors.Wrapf(err, "deleting gridfs file %s", id)
+}
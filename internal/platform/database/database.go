@@ -16,7 +16,8 @@ type Config struct {
 
 // Open knows how to open a database connection
 func Open(cfg Config) (*mongo.Client, error) {
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
 	// formats the client
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.AtlasURI))
@@ -0,0 +1,293 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Page limits used when the client does not ask for a size or asks for too much.
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+var (
+	// ErrInvalidPage is used when the limit, sort or order of a page request is malformed.
+	ErrInvalidPage = errors.New("page request is NOT in its proper form")
+
+	// ErrInvalidCursor is used when a cursor token is malformed or was issued for a different sort.
+	ErrInvalidCursor = errors.New("cursor is NOT valid for this query")
+)
+
+// sortFieldPattern restricts sort keys to plain (optionally dotted) field names.
+var sortFieldPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// Page describes which slice of a collection a list query should return.
+// The zero value asks for the first DefaultLimit documents ordered by _id.
+type Page struct {
+	Limit  int64
+	Cursor string
+	Sort   string
+	Desc   bool
+}
+
+// PageInfo describes the slice of a collection a list query returned.
+type PageInfo struct {
+	Next  string // opaque token for the following page, empty on the last page
+	Total int64  // number of documents matching the query across all pages
+}
+
+// cursorToken is the decoded form of an opaque cursor.
+// It holds the sort key and _id of the last document of the previous page.
+type cursorToken struct {
+	Sort  string        `bson:"s"`
+	Desc  bool          `bson:"d"`
+	Value bson.RawValue `bson:"v"`
+	ID    bson.RawValue `bson:"i"`
+}
+
+// ParsePage reads the limit, cursor, sort and order parameters from a query string.
+// Order is either "asc" (the default) or "desc".
+func ParsePage(q url.Values) (Page, error) {
+
+	page := Page{
+		Limit:  DefaultLimit,
+		Cursor: q.Get("cursor"),
+		Sort:   q.Get("sort"),
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || limit < 1 {
+			return page, errors.Wrap(ErrInvalidPage, "limit must be a positive whole number")
+		}
+		page.Limit = limit
+	}
+
+	switch strings.ToLower(q.Get("order")) {
+	case "", "asc":
+	case "desc":
+		page.Desc = true
+	default:
+		return page, errors.Wrap(ErrInvalidPage, "order must be asc or desc")
+	}
+
+	if page.Sort != "" && !sortFieldPattern.MatchString(page.Sort) {
+		return page, errors.Wrap(ErrInvalidPage, "sort must be a field name")
+	}
+
+	return page, nil
+}
+
+// FindPage runs filter against the collection and decodes one page of matching
+// documents into results, which must be a pointer to a slice.
+// Pages are keyed on the sort field with _id as a tie breaker so results stay
+// stable while documents are inserted between requests.
+func FindPage(ctx context.Context, db *mongo.Collection, filter bson.M, page Page, results interface{}) (*PageInfo, error) {
+
+	rv := reflect.ValueOf(results)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return nil, errors.New("results must be a pointer to a slice")
+	}
+
+	if filter == nil {
+		filter = bson.M{}
+	}
+
+	if page.Sort == "" {
+		page.Sort = "_id"
+	}
+
+	if page.Limit < 1 {
+		page.Limit = DefaultLimit
+	}
+
+	if page.Limit > MaxLimit {
+		page.Limit = MaxLimit
+	}
+
+	total, err := db.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "counting documents for page")
+	}
+
+	query := filter
+	if page.Cursor != "" {
+		after, err := afterCursor(page)
+		if err != nil {
+			return nil, err
+		}
+		query = bson.M{"$and": bson.A{filter, after}}
+	}
+
+	dir := 1
+	if page.Desc {
+		dir = -1
+	}
+
+	sort := bson.D{{Key: page.Sort, Value: dir}}
+	if page.Sort != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: dir})
+	}
+
+	// Ask for one extra document to learn whether another page follows.
+	opts := options.Find().SetSort(sort).SetLimit(page.Limit + 1)
+
+	cursor, err := db.Find(ctx, query, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cursor for page")
+	}
+
+	var raws []bson.Raw
+	if err := cursor.All(ctx, &raws); err != nil {
+		return nil, errors.Wrap(err, "retrieving page")
+	}
+
+	info := PageInfo{Total: total}
+
+	if int64(len(raws)) > page.Limit {
+		raws = raws[:page.Limit]
+		next, err := newCursor(page, raws[len(raws)-1])
+		if err != nil {
+			return nil, err
+		}
+		info.Next = next
+	}
+
+	list := reflect.MakeSlice(rv.Elem().Type(), 0, len(raws))
+	for _, raw := range raws {
+		item := reflect.New(list.Type().Elem())
+		if err := bson.Unmarshal(raw, item.Interface()); err != nil {
+			return nil, errors.Wrap(err, "decoding page document")
+		}
+		list = reflect.Append(list, item.Elem())
+	}
+	rv.Elem().Set(list)
+
+	return &info, nil
+}
+
+// newCursor encodes the position of the last document of a page.
+func newCursor(page Page, last bson.Raw) (string, error) {
+
+	token := cursorToken{
+		Sort:  page.Sort,
+		Desc:  page.Desc,
+		Value: last.Lookup(strings.Split(page.Sort, ".")...),
+		ID:    last.Lookup("_id"),
+	}
+
+	// A document missing the sort key sorts as null.
+	if token.Value.Type == 0 {
+		token.Value = bson.RawValue{Type: bsontype.Null}
+	}
+
+	data, err := bson.Marshal(token)
+	if err != nil {
+		return "", errors.Wrap(err, "encoding page cursor")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// afterCursor decodes a cursor and returns the condition that selects the
+// documents following it in the requested order.
+func afterCursor(page Page) (bson.M, error) {
+
+	data, err := base64.RawURLEncoding.DecodeString(page.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var token cursorToken
+	if err := bson.Unmarshal(data, &token); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if token.Sort != page.Sort || token.Desc != page.Desc || token.ID.Type == 0 {
+		return nil, ErrInvalidCursor
+	}
+
+	op := "$gt"
+	if page.Desc {
+		op = "$lt"
+	}
+
+	if page.Sort == "_id" {
+		return bson.M{"_id": bson.M{op: token.ID}}, nil
+	}
+
+	// Null and missing values sort before every other value,
+	// so they come first ascending and last descending.
+	if token.Value.Type == bsontype.Null {
+		tie := bson.M{page.Sort: nil, "_id": bson.M{op: token.ID}}
+		if page.Desc {
+			return tie, nil
+		}
+		return bson.M{"$or": bson.A{bson.M{page.Sort: bson.M{"$ne": nil}}, tie}}, nil
+	}
+
+	or := bson.A{
+		bson.M{page.Sort: bson.M{op: token.Value}},
+		bson.M{page.Sort: token.Value, "_id": bson.M{op: token.ID}},
+	}
+	if page.Desc {
+		or = append(or, bson.M{page.Sort: nil})
+	}
+
+	return bson.M{"$or": or}, nil
+}
+
+// offsetCursorPrefix marks the cursors of SlicePage, so they are NOT taken for the cursors of FindPage.
+const offsetCursorPrefix = "offset:"
+
+// SlicePage selects one page of a list of n results worked out in memory, which have no stored order to key pages on.
+// The list keeps its own order, so the sort and order of the page are ignored, and its cursor holds the position
+// of the next result. It returns the bounds of the page in the list.
+func SlicePage(n int, page Page) (start, end int, info *PageInfo, err error) {
+
+	if page.Limit < 1 {
+		page.Limit = DefaultLimit
+	}
+
+	if page.Limit > MaxLimit {
+		page.Limit = MaxLimit
+	}
+
+	if page.Cursor != "" {
+		data, err := base64.RawURLEncoding.DecodeString(page.Cursor)
+		if err != nil || !strings.HasPrefix(string(data), offsetCursorPrefix) {
+			return 0, 0, nil, ErrInvalidCursor
+		}
+		if start, err = strconv.Atoi(strings.TrimPrefix(string(data), offsetCursorPrefix)); err != nil || start < 0 {
+			return 0, 0, nil, ErrInvalidCursor
+		}
+	}
+
+	if start > n {
+		start = n
+	}
+
+	end = start + int(page.Limit)
+	if end > n {
+		end = n
+	}
+
+	info = &PageInfo{Total: int64(n)}
+	if end < n {
+		info.Next = base64.RawURLEncoding.EncodeToString([]byte(offsetCursorPrefix + strconv.Itoa(end)))
+	}
+
+	return start, end, info, nil
+}
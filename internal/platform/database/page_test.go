@@ -0,0 +1,83 @@
+package database_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
+)
+
+func TestParsePage(t *testing.T) {
+	tests := []struct {
+		query string
+		want  database.Page
+		err   error
+	}{
+		{"", database.Page{Limit: database.DefaultLimit}, nil},
+		{"limit=10&sort=created_at&order=desc&cursor=abc", database.Page{Limit: 10, Sort: "created_at", Desc: true, Cursor: "abc"}, nil},
+		{"sort=tranx_debit.amount", database.Page{Limit: database.DefaultLimit, Sort: "tranx_debit.amount"}, nil},
+		{"limit=0", database.Page{}, database.ErrInvalidPage},
+		{"limit=ten", database.Page{}, database.ErrInvalidPage},
+		{"order=sideways", database.Page{}, database.ErrInvalidPage},
+		{"sort=$where", database.Page{}, database.ErrInvalidPage},
+	}
+
+	for _, tt := range tests {
+		q, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatalf("parsing query %q: %s", tt.query, err)
+		}
+
+		page, err := database.ParsePage(q)
+		if errors.Cause(err) != tt.err {
+			t.Fatalf("%q: expected error %v, got %v", tt.query, tt.err, err)
+		}
+		if tt.err != nil {
+			continue
+		}
+
+		if diff := cmp.Diff(tt.want, page); diff != "" {
+			t.Fatalf("%q: page did not match expected. Diff:\n%s", tt.query, diff)
+		}
+	}
+}
+
+func TestSlicePage(t *testing.T) {
+	first := database.Page{Limit: 2}
+
+	start, end, info, err := database.SlicePage(5, first)
+	if err != nil {
+		t.Fatalf("first page: %v", err)
+	}
+	if start != 0 || end != 2 || info.Total != 5 || info.Next == "" {
+		t.Fatalf("first page: got [%d:%d] of %d, next %q", start, end, info.Total, info.Next)
+	}
+
+	var seen int
+	for page := first; ; {
+		start, end, info, err := database.SlicePage(5, page)
+		if err != nil {
+			t.Fatalf("page after %d results: %v", seen, err)
+		}
+		if start != seen {
+			t.Fatalf("expected a page starting at %d, got %d", seen, start)
+		}
+		seen = end
+		if info.Next == "" {
+			break
+		}
+		page.Cursor = info.Next
+	}
+	if seen != 5 {
+		t.Fatalf("expected every result paged through, got %d of 5", seen)
+	}
+
+	// NOT an offset cursor, a negative offset and an offset that is NOT a number
+	for _, cursor := range []string{"abc", "b2Zmc2V0Oi0x", "b2Zmc2V0OnR3bw"} {
+		if _, _, _, err := database.SlicePage(5, database.Page{Limit: 2, Cursor: cursor}); err != database.ErrInvalidCursor {
+			t.Fatalf("cursor %q: expected %v, got %v", cursor, database.ErrInvalidCursor, err)
+		}
+	}
+}
@@ -12,15 +12,17 @@ type FieldError struct {
 
 // ErrorResponse is the form used for API responses from failures in the API.
 type ErrorResponse struct {
-	Error  string       `json:"error"`
-	Fields []FieldError `json:"fields,omitempty"`
+	Error   string       `json:"error"`
+	Fields  []FieldError `json:"fields,omitempty"`
+	Details interface{}  `json:"details,omitempty"`
 }
 
 // Error is used to add web information to request error.
 type Error struct {
-	Err    error
-	Status int
-	Fields []FieldError
+	Err     error
+	Status  int
+	Fields  []FieldError
+	Details interface{} // sent to the client as it is, e.g. the documents blocking a request
 }
 
 // NewRequestError wraps a provided error with an HTTP status code. This
@@ -36,6 +36,22 @@ func Respond(ctx context.Context, w http.ResponseWriter, val interface{}, status
 	return nil
 }
 
+// RespondStream sends the status and content type of a response whose body is written by the caller,
+// e.g. a file streamed from a database cursor. Once it is called, errors can NOT be sent to the client.
+func RespondStream(ctx context.Context, w http.ResponseWriter, contentType string, statusCode int) error {
+
+	v, ok := ctx.Value(KeyValues).(*Values)
+	if !ok {
+		return errors.New("web values missing from context")
+	}
+
+	v.StatusCode = statusCode
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+	return nil
+}
+
 // RespondError knows how to handle errors going out to the client.
 func RespondError(ctx context.Context, w http.ResponseWriter, err error) error {
 
@@ -43,8 +59,9 @@ func RespondError(ctx context.Context, w http.ResponseWriter, err error) error {
 	// a specific status code and error to return.
 	if webErr, ok := errors.Cause(err).(*Error); ok {
 		er := ErrorResponse{
-			Error:  webErr.Err.Error(),
-			Fields: webErr.Fields,
+			Error:   webErr.Err.Error(),
+			Fields:  webErr.Fields,
+			Details: webErr.Details,
 		}
 
 		if err := Respond(ctx, w, er, webErr.Status); err != nil {
@@ -55,7 +55,7 @@ func NewApp(shutdown chan os.Signal, logger *log.Logger, mw ...Middleware) *App
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		ExposedHeaders:   []string{"Link", "X-Next-Cursor", "X-Total-Count"},
 		AllowCredentials: false,
 	}))
 
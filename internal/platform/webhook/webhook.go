@@ -0,0 +1,137 @@
+// Package webhook delivers signed event payloads to URLs registered by clients.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Headers sent with every delivery.
+const (
+	SignatureHeader = "X-Dashboard-Signature" // sha256=<hex HMAC-SHA256 of the body keyed with the webhook secret>
+	EventHeader     = "X-Dashboard-Event"
+	DeliveryHeader  = "X-Dashboard-Delivery" // _id of the delivery, the same on every attempt
+)
+
+// Message is one event to deliver.
+type Message struct {
+	ID    string
+	Event string
+	Body  []byte // JSON
+}
+
+// Result describes how a delivery went.
+type Result struct {
+	Attempts int   // number of requests made
+	Status   int   // HTTP status of the last response, 0 when there was none
+	Err      error // nil when the receiver accepted the message
+	Retry    bool  // the failure may pass when the message is sent again later
+}
+
+// Sender posts messages, trying again with backoff while the receiver fails in a way that may pass.
+type Sender struct {
+	Client   *http.Client
+	Attempts int           // requests made at most for one message, at least 1
+	Backoff  time.Duration // wait before the second request, doubled before each one after it
+}
+
+// Sign returns the value of the SignatureHeader for body.
+func Sign(secret string, body []byte) string {
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the value of the SignatureHeader for body.
+// Receivers use it to check a delivery came from us.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Send posts the message to url, signed with secret.
+// A network error, a 429 or a 5xx response is tried again after the backoff until the attempts run out or ctx is done.
+// Any other response that is NOT 2xx fails at once. A failure that may still pass is reported with Retry.
+func (s Sender) Send(ctx context.Context, url, secret string, msg Message) Result {
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	attempts := s.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var result Result
+	wait := s.Backoff
+
+	for result.Attempts < attempts {
+
+		if result.Attempts > 0 {
+			select {
+			case <-ctx.Done():
+				result.Err = errors.Wrap(ctx.Err(), "waiting to deliver again")
+				result.Retry = true
+				return result
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+
+		result.Attempts++
+
+		result.Status, result.Retry, result.Err = post(ctx, client, url, secret, msg)
+		if result.Err == nil || !result.Retry {
+			return result
+		}
+	}
+
+	return result
+}
+
+// post makes one request. It reports whether a failure may pass when tried again.
+func post(ctx context.Context, client *http.Client, url, secret string, msg Message) (int, bool, error) {
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(msg.Body))
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "building request to %s", url)
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(secret, msg.Body))
+	req.Header.Set(EventHeader, msg.Event)
+	req.Header.Set(DeliveryHeader, msg.ID)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, true, errors.Wrapf(err, "posting to %s", url)
+	}
+	defer resp.Body.Close()
+
+	// Read a little of the body for the error and let the connection be reused.
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, false, nil
+	}
+
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	err = fmt.Errorf("receiver answered %d %s", resp.StatusCode, strings.TrimSpace(string(body)))
+
+	return resp.StatusCode, retry, err
+}
@@ -0,0 +1,120 @@
+package webhook_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/webhook"
+)
+
+const secret = "s3cr3t"
+
+var msg = webhook.Message{ID: "5f3e18f8d95d06627dc8e990", Event: "budget.threshold_crossed", Body: []byte(`{"threshold":80}`)}
+
+// receiver answers each request with the next of the statuses, repeating the last one.
+// It fails the test when a request is NOT signed with secret.
+func receiver(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+	t.Helper()
+
+	var calls int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading body: %v", err)
+		}
+
+		if !webhook.Verify(secret, body, r.Header.Get(webhook.SignatureHeader)) {
+			t.Errorf("signature %q does NOT match the body", r.Header.Get(webhook.SignatureHeader))
+		}
+		if got := r.Header.Get(webhook.EventHeader); got != msg.Event {
+			t.Errorf("expected event %q, got %q", msg.Event, got)
+		}
+		if got := r.Header.Get(webhook.DeliveryHeader); got != msg.ID {
+			t.Errorf("expected delivery %q, got %q", msg.ID, got)
+		}
+
+		n := int(atomic.AddInt32(&calls, 1))
+		if n > len(statuses) {
+			n = len(statuses)
+		}
+		w.WriteHeader(statuses[n-1])
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return srv, &calls
+}
+
+func TestSend(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		status   int
+		ok       bool
+		retry    bool
+	}{
+		{"accepted", []int{http.StatusNoContent}, 1, http.StatusNoContent, true, false},
+		{"accepted after server errors", []int{http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusOK}, 3, http.StatusOK, true, false},
+		{"rejected", []int{http.StatusBadRequest}, 1, http.StatusBadRequest, false, false},
+		{"attempts run out", []int{http.StatusServiceUnavailable}, 4, http.StatusServiceUnavailable, false, true},
+	}
+
+	for _, tt := range tests {
+		srv, calls := receiver(t, tt.statuses...)
+
+		s := webhook.Sender{Client: srv.Client(), Attempts: 4, Backoff: time.Millisecond}
+		result := s.Send(context.Background(), srv.URL, secret, msg)
+
+		if (result.Err == nil) != tt.ok {
+			t.Fatalf("%s: expected ok %v, got error %v", tt.name, tt.ok, result.Err)
+		}
+		if result.Attempts != tt.attempts || int(atomic.LoadInt32(calls)) != tt.attempts {
+			t.Fatalf("%s: expected %d attempts, got %d with %d requests", tt.name, tt.attempts, result.Attempts, *calls)
+		}
+		if result.Status != tt.status {
+			t.Fatalf("%s: expected status %d, got %d", tt.name, tt.status, result.Status)
+		}
+		if result.Retry != tt.retry {
+			t.Fatalf("%s: expected retry %v, got %v", tt.name, tt.retry, result.Retry)
+		}
+	}
+}
+
+func TestSendStopsWithContext(t *testing.T) {
+	srv, calls := receiver(t, http.StatusBadGateway)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s := webhook.Sender{Client: srv.Client(), Attempts: 5, Backoff: time.Hour}
+	result := s.Send(ctx, srv.URL, secret, msg)
+
+	if result.Err == nil || !result.Retry {
+		t.Fatalf("expected an error to retry once the context is done, got %+v", result)
+	}
+	if n := atomic.LoadInt32(calls); n > 1 {
+		t.Fatalf("expected no request after the context is done, got %d", n)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	sig := webhook.Sign(secret, msg.Body)
+
+	if !webhook.Verify(secret, msg.Body, sig) {
+		t.Fatalf("expected the signature to verify")
+	}
+	if webhook.Verify("other", msg.Body, sig) {
+		t.Fatalf("expected a signature with another secret NOT to verify")
+	}
+	if webhook.Verify(secret, []byte(`{"threshold":100}`), sig) {
+		t.Fatalf("expected a signature of another body NOT to verify")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -13,42 +14,37 @@ import (
 )
 
 // EpisodeList gets all the Episodes for a specific Podcast from the db then encodes them in a response client
-func EpisodeList(ctx context.Context, db *mongo.Collection) ([]Episode, error) {
+// Results are returned one page at a time.
+func EpisodeList(ctx context.Context, db *mongo.Collection, page database.Page) ([]Episode, *database.PageInfo, error) {
+
 	episodeList := []Episode{}
 
-	episodeCursor, err := db.Find(ctx, bson.M{})
+	info, err := database.FindPage(ctx, db, bson.M{}, page, &episodeList)
 	if err != nil {
-		return nil, errors.Wrapf(err, "getting episodeCursor. retrieving episode list")
-	}
-
-	if err = episodeCursor.All(ctx, &episodeList); err != nil {
-		return nil, errors.Wrapf(err, "retrieving episode list")
+		return nil, nil, errors.Wrapf(err, "retrieving episode list")
 	}
 
-	return episodeList, nil
+	return episodeList, info, nil
 }
 
 // PodcastEpisodeList gets all the Episodes for a specific Podcast from the db then encodes them in a response client
-func PodcastEpisodeList(ctx context.Context, db *mongo.Collection, podcastID string) ([]Episode, error) {
+// Results are returned one page at a time.
+func PodcastEpisodeList(ctx context.Context, db *mongo.Collection, podcastID string, page database.Page) ([]Episode, *database.PageInfo, error) {
 
 	episodeList := []Episode{}
 
 	// convert podcastID string from url var to podcastObjectID
 	podcastObjectID, err := primitive.ObjectIDFromHex(podcastID)
 	if err != nil {
-		return nil, apierror.ErrInvalidID
+		return nil, nil, apierror.ErrInvalidID
 	}
 
-	episodeCursor, err := db.Find(ctx, bson.M{"podcastID": podcastObjectID})
+	info, err := database.FindPage(ctx, db, bson.M{"podcastID": podcastObjectID}, page, &episodeList)
 	if err != nil {
-		return nil, errors.Wrapf(err, "getting episodeCursor. retrieving episode list")
-	}
-
-	if err = episodeCursor.All(ctx, &episodeList); err != nil {
-		return nil, errors.Wrapf(err, "retrieving episode list")
+		return nil, nil, errors.Wrapf(err, "retrieving episode list")
 	}
 
-	return episodeList, nil
+	return episodeList, info, nil
 }
 
 // RetrieveEpisode gets the first Episode in the db with the provided episodeID
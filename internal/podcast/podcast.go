@@ -8,6 +8,7 @@ import (
 
 	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive" // for BSON ObjectID
@@ -15,19 +16,17 @@ import (
 )
 
 // List gets all the Podcasts from the db then encodes them in a response client
-func List(ctx context.Context, db *mongo.Collection) ([]Podcast, error) {
+// Results are returned one page at a time.
+func List(ctx context.Context, db *mongo.Collection, page database.Page) ([]Podcast, *database.PageInfo, error) {
+
 	podcastList := []Podcast{}
 
-	podcastCursor, err := db.Find(ctx, bson.M{})
+	info, err := database.FindPage(ctx, db, bson.M{}, page, &podcastList)
 	if err != nil {
-		return nil, errors.Wrapf(err, "getting podcastCursor retrieving podcast list")
-	}
-
-	if err = podcastCursor.All(ctx, &podcastList); err != nil {
-		return nil, errors.Wrapf(err, "retrieving podcast list")
+		return nil, nil, errors.Wrapf(err, "retrieving podcast list")
 	}
 
-	return podcastList, nil
+	return podcastList, info, nil
 }
 
 // Retrieve gets the first Podcast in the db with the provided _id
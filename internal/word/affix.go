@@ -7,6 +7,7 @@ import (
 
 	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
 	"github.com/dapperAuteur/dashboard-go-api/internal/utility"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,20 +16,17 @@ import (
 )
 
 // AffixList gets all the Affixes from the db then encodes them in a response client.
-func AffixList(ctx context.Context, db *mongo.Collection) ([]Affix, error) {
+// Results are returned one page at a time.
+func AffixList(ctx context.Context, db *mongo.Collection, page database.Page) ([]Affix, *database.PageInfo, error) {
 
 	affixList := []Affix{}
 
-	affixCursor, err := db.Find(ctx, bson.M{})
+	info, err := database.FindPage(ctx, db, bson.M{}, page, &affixList)
 	if err != nil {
-		return nil, errors.Wrapf(err, "getting affixCursor retrieving affix list")
+		return nil, nil, errors.Wrapf(err, "retrieving affix list")
 	}
 
-	if err = affixCursor.All(ctx, &affixList); err != nil {
-		return nil, errors.Wrapf(err, "retrieving affix list")
-	}
-
-	return affixList, nil
+	return affixList, info, nil
 }
 
 // RetrieveAffixByID gets the first Affix in the db with the provided _id
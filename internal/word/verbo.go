@@ -6,6 +6,7 @@ import (
 
 	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -14,20 +15,17 @@ import (
 )
 
 // VerboList gets all the Verbos from the database then encodes them in a response client.
-func VerboList(ctx context.Context, db *mongo.Collection) ([]Verbo, error) {
+// Results are returned one page at a time.
+func VerboList(ctx context.Context, db *mongo.Collection, page database.Page) ([]Verbo, *database.PageInfo, error) {
 
 	verboList := []Verbo{}
 
-	verboCursor, err := db.Find(ctx, bson.M{})
+	info, err := database.FindPage(ctx, db, bson.M{}, page, &verboList)
 	if err != nil {
-		return nil, errors.Wrapf(err, "getting verboCursor retrieving verbo list")
+		return nil, nil, errors.Wrapf(err, "retrieving verbo list")
 	}
 
-	if err = verboCursor.All(ctx, &verboList); err != nil {
-		return nil, errors.Wrapf(err, "retrieving verbo list")
-	}
-
-	return verboList, nil
+	return verboList, info, nil
 }
 
 // RetrieveVerboByID gets the first Verbo in the db with the provided ID.
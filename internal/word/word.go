@@ -7,6 +7,7 @@ import (
 
 	"github.com/dapperAuteur/dashboard-go-api/internal/apierror"
 	"github.com/dapperAuteur/dashboard-go-api/internal/platform/auth"
+	"github.com/dapperAuteur/dashboard-go-api/internal/platform/database"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -14,20 +15,17 @@ import (
 )
 
 // WordList gets all the Words from the db then encodes them in a response client.
-func WordList(ctx context.Context, db *mongo.Collection) ([]Word, error) {
+// Results are returned one page at a time.
+func WordList(ctx context.Context, db *mongo.Collection, page database.Page) ([]Word, *database.PageInfo, error) {
 
 	wordList := []Word{}
 
-	wordCursor, err := db.Find(ctx, bson.M{})
+	info, err := database.FindPage(ctx, db, bson.M{}, page, &wordList)
 	if err != nil {
-		return nil, errors.Wrapf(err, "getting wordCursor retrieving word list")
+		return nil, nil, errors.Wrapf(err, "retrieving word list")
 	}
 
-	if err = wordCursor.All(ctx, &wordList); err != nil {
-		return nil, errors.Wrapf(err, "retrieving word list")
-	}
-
-	return wordList, nil
+	return wordList, info, nil
 }
 
 // RetrieveWordByID gets the first Word in the db with the provided _id